@@ -32,7 +32,7 @@ def change_priority_by_family(family: str, new_priority: int) -> dict:
     Returns:
         JSON dict of the API response (ok:true).
     """
-    resp = _http.post("/reprioritize/family", json={
+    resp = _http.post("/v1/reprioritize/family", json={
         "family": family, "newPriority": new_priority
     })
     resp.raise_for_status()
@@ -45,7 +45,7 @@ def reprioritize_by_age(age: str, new_priority: int) -> dict:
         age: Go-style duration string, e.g. "10m", "5s", "1h30m".
         new_priority: Target priority.
     """
-    resp = _http.post("/reprioritize/age", json={
+    resp = _http.post("/v1/reprioritize/age", json={
         "age": age, "newPriority": new_priority
     })
     resp.raise_for_status()
@@ -57,31 +57,31 @@ def set_anti_starvation(enable: bool) -> dict:
     Args:
         enable: True to enable anti-starvation; False to disable (aka 'starvation mode').
     """
-    resp = _http.post("/settings/antiStarvation", json={"enable": enable})
+    resp = _http.post("/v1/settings/antiStarvation", json={"enable": enable})
     resp.raise_for_status()
     return resp.json()
 
 def set_maximum_wait(seconds: int) -> dict:
     """Set global maximum wait time cap (seconds) for all ads."""
-    resp = _http.post("/settings/maximumWait", json={"maximumWait": seconds})
+    resp = _http.post("/v1/settings/maximumWait", json={"maximumWait": seconds})
     resp.raise_for_status()
     return resp.json()
 
 def peek_next(n: int) -> List[Dict[str, Any]]:
     """Preview the next N ads in the order Dequeue would process them."""
-    resp = _http.get("/peek", params={"n": n})
+    resp = _http.get("/v1/peek", params={"n": n})
     resp.raise_for_status()
     return resp.json()
 
 def list_waiting_longer_than(age: str) -> List[Dict[str, Any]]:
     """List ads waiting longer than a duration string (e.g., '5m')."""
-    resp = _http.get("/waiting", params={"age": age})
+    resp = _http.get("/v1/waiting", params={"age": age})
     resp.raise_for_status()
     return resp.json()
 
 def distribution_by_priority() -> Dict[str, Any]:
     """Get distribution of items by priority."""
-    resp = _http.get("/distribution")
+    resp = _http.get("/v1/distribution")
     resp.raise_for_status()
     return resp.json()
 
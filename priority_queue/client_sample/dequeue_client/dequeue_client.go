@@ -1,22 +1,16 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"sync"
 	"time"
-)
 
-type Ad struct {
-	AdID       string `json:"adId"`
-	GameFamily string `json:"gameFamily"`
-	Priority   int    `json:"priority"`
-	CreatedAt  string `json:"createdAt"`
-}
+	"icetea/priority_queue/pkg/client"
+)
 
 // Simple shared rate limiter (stdlib only).
 // Allows ~rate tokens per second with a burst capacity.
@@ -67,16 +61,17 @@ func (l *limiter) Stop() { close(l.stop) }
 func main() {
 	// Flags
 	base := flag.String("base", "http://localhost:8080", "Queue server base URL")
+	key := flag.String("key", "", "API key, if the server requires one")
 	workers := flag.Int("workers", 1, "Concurrent dequeue workers")
 	rate := flag.Int("rate", 5, "Dequeue rate (ads per second, shared across all workers)")
 	burst := flag.Int("burst", 5, "Burst capacity tokens")
 	flag.Parse()
 
-	dequeueURL := *base + "/dequeue"
-	client := &http.Client{Timeout: 5 * time.Second}
+	c := client.New(client.Config{BaseURL: *base, APIKey: *key})
+	ctx := context.Background()
 
 	log.Printf("Dequeue target: %s | workers=%d | rate=%d ads/s | burst=%d",
-		dequeueURL, *workers, *rate, *burst)
+		*base, *workers, *rate, *burst)
 
 	var lim *limiter
 	if *rate > 0 {
@@ -93,33 +88,15 @@ func main() {
 			defer wg.Done()
 			for {
 				lim.Wait()
-				req, err := http.NewRequest("POST", dequeueURL, nil)
-				if err != nil {
-					log.Printf("[w%d] new request error: %v", id, err)
+				ad, err := c.Dequeue(ctx)
+				if errors.Is(err, client.ErrQueueEmpty) {
 					continue
 				}
-				resp, err := client.Do(req)
 				if err != nil {
-					log.Printf("[w%d] http error: %v", id, err)
+					log.Printf("[w%d] dequeue error: %v", id, err)
 					continue
 				}
-				func() {
-					defer resp.Body.Close()
-					if resp.StatusCode == http.StatusOK {
-						b, _ := io.ReadAll(resp.Body)
-						var ad Ad
-						if err := json.Unmarshal(b, &ad); err != nil {
-							log.Printf("[w%d] JSON error: %v body=%s", id, err, string(b))
-							return
-						}
-						fmt.Printf("[w%d][priority=%d][created_at=%s] dequeued: id=%s family=%s\n", id, ad.Priority, ad.CreatedAt, ad.AdID, ad.GameFamily)
-						return
-					}
-					if resp.StatusCode != http.StatusNotFound {
-						b, _ := io.ReadAll(resp.Body)
-						log.Printf("[w%d] status %d: %s", id, resp.StatusCode, string(b))
-					}
-				}()
+				fmt.Printf("[w%d][priority=%d][created_at=%s] dequeued: id=%s family=%s\n", id, ad.Priority, ad.CreatedAt, ad.AdID, ad.GameFamily)
 			}
 		}()
 	}
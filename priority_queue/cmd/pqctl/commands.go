@@ -0,0 +1,626 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"icetea/priority_queue/pkg/client"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func runEnqueue(ctx context.Context, args []string) error {
+	fs, g := flags("enqueue")
+	file := fs.String("f", "", "read ads as JSON objects from this file (- for stdin)")
+	id := fs.String("id", "", "ad ID")
+	title := fs.String("title", "", "title (default is the ID)")
+	family := fs.String("family", "", "game family")
+	priority := fs.String("priority", "", "priority level or class name")
+	maxWait := fs.Int("max-wait", 0, "maximum wait in seconds (0 uses the class or server default)")
+	audience := fs.String("audience", "", "comma-separated target audience")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	c, p, err := g.client()
+	if err != nil {
+		return err
+	}
+
+	var reqs []client.EnqueueRequest
+	switch {
+	case *file != "":
+		if reqs, err = readAds(*file); err != nil {
+			return err
+		}
+	case *id != "" && *family != "" && *priority != "":
+		ad := client.Ad{AdID: *id, Title: *title, GameFamily: *family, MaxWaitTime: *maxWait}
+		if ad.Title == "" {
+			ad.Title = ad.AdID
+		}
+		if *audience != "" {
+			ad.TargetAudience = strings.Split(*audience, ",")
+		}
+		reqs = []client.EnqueueRequest{{Ad: ad, Class: *priority}}
+	default:
+		return usagef("give -f, or -id, -family and -priority")
+	}
+
+	var queued []client.Queued
+	for _, req := range reqs {
+		q, err := c.Enqueue(ctx, req)
+		if err != nil {
+			if len(queued) > 0 {
+				err = fmt.Errorf("%s (after enqueuing %d ads): %w", req.Ad.AdID, len(queued), err)
+			}
+			return err
+		}
+		queued = append(queued, *q)
+	}
+	return p.print(queued, queuedTable(queued))
+}
+
+func runPosition(ctx context.Context, args []string) error {
+	fs, g := flags("position")
+	args, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usagef("want one ad ID")
+	}
+	c, p, err := g.client()
+	if err != nil {
+		return err
+	}
+	q, err := c.Position(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return p.print(q, queuedTable([]client.Queued{*q}))
+}
+
+// readAds decodes a stream of ads. priority may be a level or a class name.
+func readAds(path string) ([]client.EnqueueRequest, error) {
+	r, closeFn, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var reqs []client.EnqueueRequest
+	for {
+		var in struct {
+			client.Ad
+			Priority json.RawMessage `json:"priority"`
+		}
+		if err := dec.Decode(&in); errors.Is(err, io.EOF) {
+			return reqs, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: ad %d: %w", path, len(reqs)+1, err)
+		}
+		req := client.EnqueueRequest{Ad: in.Ad}
+		var class string
+		if json.Unmarshal(in.Priority, &req.Ad.Priority) != nil && json.Unmarshal(in.Priority, &class) == nil {
+			req.Class = class
+		}
+		reqs = append(reqs, req)
+	}
+}
+
+func runDequeue(ctx context.Context, args []string) error {
+	fs, g := flags("dequeue")
+	lease := fs.Duration("lease", 0, "take the ad on a lease of this length; ack or nack it later")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	c, p, err := g.client()
+	if err != nil {
+		return err
+	}
+	if *lease <= 0 {
+		ad, err := c.Dequeue(ctx)
+		if err != nil {
+			return err
+		}
+		return p.print(ad, adTable([]client.Ad{*ad}))
+	}
+
+	l, err := c.DequeueLease(ctx, *lease)
+	if err != nil {
+		return err
+	}
+	out := struct {
+		client.Ad
+		LeaseID        int64     `json:"leaseId"`
+		LeaseExpiresAt time.Time `json:"leaseExpiresAt"`
+	}{l.Ad, l.ID, l.ExpiresAt}
+	return p.print(out, func(t *table) {
+		adTable([]client.Ad{l.Ad})(t)
+		t.row()
+		t.row("LEASE", strconv.FormatInt(l.ID, 10), "expires "+l.ExpiresAt.Local().Format(time.TimeOnly))
+	})
+}
+
+func runAck(ctx context.Context, args []string) error {
+	return endLease(ctx, "ack", args, (*client.Client).Ack)
+}
+
+func runNack(ctx context.Context, args []string) error {
+	return endLease(ctx, "nack", args, (*client.Client).Nack)
+}
+
+func endLease(ctx context.Context, name string, args []string, end func(*client.Client, context.Context, int64) error) error {
+	fs, g := flags(name)
+	args, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usagef("want one lease ID")
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return usagef("bad lease ID %q", args[0])
+	}
+	c, p, err := g.client()
+	if err != nil {
+		return err
+	}
+	if err := end(c, ctx, id); err != nil {
+		return err
+	}
+	return p.done(fmt.Sprintf("lease %d %sed", id, name))
+}
+
+func runLeases(ctx context.Context, args []string) error {
+	fs, g := flags("leases")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	c, p, err := g.client()
+	if err != nil {
+		return err
+	}
+	leases, err := c.Leases(ctx)
+	if err != nil {
+		return err
+	}
+	return p.print(leases, func(t *table) {
+		t.header("LEASE", "ADID", "EXPIRES IN")
+		for _, l := range leases {
+			t.row(strconv.FormatInt(l.ID, 10), l.AdID, time.Until(l.ExpiresAt).Round(time.Second).String())
+		}
+	})
+}
+
+func runPeek(ctx context.Context, args []string) error {
+	fs, g := flags("peek")
+	n := fs.Int("n", 10, "number of ads")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	c, p, err := g.client()
+	if err != nil {
+		return err
+	}
+	ads, err := c.Peek(ctx, *n)
+	if err != nil {
+		return err
+	}
+	return p.print(ads, adTable(ads))
+}
+
+func runWaiting(ctx context.Context, args []string) error {
+	fs, g := flags("waiting")
+	age := fs.Duration("age", 0, "minimum time in the queue, like 10m")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	if *age <= 0 {
+		return usagef("-age is required")
+	}
+	c, p, err := g.client()
+	if err != nil {
+		return err
+	}
+	ads, err := c.Waiting(ctx, *age)
+	if err != nil {
+		return err
+	}
+	return p.print(ads, adTable(ads))
+}
+
+func runScores(ctx context.Context, args []string) error {
+	fs, g := flags("scores")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	c, p, err := g.client()
+	if err != nil {
+		return err
+	}
+	scores, err := c.Scores(ctx)
+	if err != nil {
+		return err
+	}
+	return p.print(scores, func(t *table) {
+		t.header("PRIORITY", "NAME", "HEAD", "WAITED", "MAX WAIT", "SCORE", "")
+		for _, s := range scores {
+			t.row(strconv.Itoa(s.Priority), s.Name, s.AdID,
+				time.Duration(s.WaitedSeconds*float64(time.Second)).Round(time.Second).String(), strconv.Itoa(s.MaxWaitSeconds)+"s",
+				strconv.FormatFloat(s.Score, 'f', 3, 64), mark(s.Selected, "next"))
+		}
+	})
+}
+
+func runReprioritize(ctx context.Context, args []string) error {
+	fs, g := flags("reprioritize")
+	priority := fs.String("priority", "", "new priority level or class name")
+	delta := fs.Int("delta", 0, "move by this many levels instead (+1 up, -1 down)")
+	confirm := fs.String("confirm", "", "confirm token from a refused run that affects many ads")
+	args, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return usagef("want family NAME or age AGE")
+	}
+	if (*priority == "") == (*delta == 0) {
+		return usagef("give one of -priority and -delta")
+	}
+	req := client.ReprioritizeRequest{NewPriority: client.Priority(*priority), Delta: *delta}
+	target := "to priority " + *priority
+	if *delta != 0 {
+		target = fmt.Sprintf("by %+d", *delta)
+	}
+
+	c, p, err := g.client()
+	if err != nil {
+		return err
+	}
+	if *confirm != "" {
+		ctx = client.WithConfirmToken(ctx, *confirm)
+	}
+	switch args[0] {
+	case "family":
+		skipped, err := c.ReprioritizeFamily(ctx, args[1], req)
+		if err != nil {
+			return confirmHint(err)
+		}
+		return p.done(fmt.Sprintf("moved family %s %s", args[1], target) + skippedNote(skipped))
+	case "age":
+		age, err := time.ParseDuration(args[1])
+		if err != nil {
+			return usagef("bad age %q", args[1])
+		}
+		skipped, err := c.ReprioritizeAge(ctx, age, req)
+		if err != nil {
+			return confirmHint(err)
+		}
+		return p.done(fmt.Sprintf("moved ads older than %s %s", age, target) + skippedNote(skipped))
+	}
+	return usagef("want family or age, not %q", args[0])
+}
+
+// skippedNote names the ads a change left in place at a full class level.
+func skippedNote(skipped []string) string {
+	if len(skipped) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (skipped %s: level at capacity)", strings.Join(skipped, ", "))
+}
+
+// confirmHint tells the user how to confirm a change the server's
+// guardrails held back.
+func confirmHint(err error) error {
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) && apiErr.ConfirmToken != "" {
+		return fmt.Errorf("%w\nto go ahead, run the same command with -confirm %s", err, apiErr.ConfirmToken)
+	}
+	return err
+}
+
+func runUndo(ctx context.Context, args []string) error {
+	fs, g := flags("undo")
+	args, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return usagef("takes no arguments")
+	}
+	c, p, err := g.client()
+	if err != nil {
+		return err
+	}
+	res, err := c.Undo(ctx)
+	if err != nil {
+		return err
+	}
+	return p.done(fmt.Sprintf("moved %d ads back", res.Count))
+}
+
+func runSettings(ctx context.Context, args []string) error {
+	fs, g := flags("settings")
+	strategy := fs.String("strategy", "", "how priorities shrink: clamp (default) or proportional")
+	args, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	c, p, err := g.client()
+	if err != nil {
+		return err
+	}
+
+	if len(args) > 0 {
+		if len(args) != 2 {
+			return usagef("want a setting and a value")
+		}
+		switch args[0] {
+		case "anti-starvation":
+			on, err := onOff(args[1])
+			if err != nil {
+				return err
+			}
+			err = c.SetAntiStarvation(ctx, on)
+		case "max-wait":
+			n, perr := strconv.Atoi(args[1])
+			if perr != nil || n <= 0 {
+				return usagef("max-wait takes a positive number of seconds")
+			}
+			err = c.SetMaximumWait(ctx, n)
+		case "priorities":
+			n, perr := strconv.Atoi(args[1])
+			if perr != nil || n <= 0 {
+				return usagef("priorities takes a positive number of levels")
+			}
+			err = c.SetPriorities(ctx, n, *strategy)
+		default:
+			return usagef("unknown setting %q", args[0])
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	s, err := c.Settings(ctx)
+	if err != nil {
+		return err
+	}
+	return p.print(s, func(t *table) {
+		t.row("anti-starvation", onOffString(s.EnableAntiStarvation))
+		t.row("max-wait", strconv.Itoa(s.MaximumWait)+"s")
+		t.row("priorities", strconv.Itoa(s.TotalPriority))
+	})
+}
+
+func runPause(ctx context.Context, args []string) error {
+	return pauseOrResume(ctx, "pause", args, (*client.Client).Pause)
+}
+
+func runResume(ctx context.Context, args []string) error {
+	return pauseOrResume(ctx, "resume", args, (*client.Client).Resume)
+}
+
+func pauseOrResume(ctx context.Context, name string, args []string,
+	op func(*client.Client, context.Context, client.PauseScope) (*client.PauseState, error)) error {
+	fs, g := flags(name)
+	args, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	var scope client.PauseScope
+	switch {
+	case len(args) == 1 && args[0] == "all":
+		scope = client.PauseAll()
+	case len(args) == 2 && args[0] == "priority":
+		scope = client.PausePriority(client.Priority(args[1]))
+	case len(args) == 2 && args[0] == "family":
+		scope = client.PauseFamily(args[1])
+	default:
+		return usagef("want all, priority P or family F")
+	}
+	c, p, err := g.client()
+	if err != nil {
+		return err
+	}
+	state, err := op(c, ctx, scope)
+	if err != nil {
+		return err
+	}
+	return p.print(state, pauseTable(state))
+}
+
+func pauseTable(s *client.PauseState) func(t *table) {
+	return func(t *table) {
+		levels := make([]string, len(s.Priorities))
+		for i, l := range s.Priorities {
+			levels[i] = strconv.Itoa(l)
+		}
+		t.row("all", onOffString(s.All))
+		t.row("priorities", strings.Join(levels, ", "))
+		t.row("families", strings.Join(s.Families, ", "))
+	}
+}
+
+func runDrain(ctx context.Context, args []string) error {
+	fs, g := flags("drain")
+	args, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		return usagef("want on, off or nothing")
+	}
+	c, p, err := g.client()
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		on, err := onOff(args[0])
+		if err != nil {
+			return err
+		}
+		if err := c.SetDrain(ctx, on); err != nil {
+			return err
+		}
+	}
+	s, err := c.DrainStatus(ctx)
+	if err != nil {
+		return err
+	}
+	return p.print(s, func(t *table) {
+		t.row("draining", onOffString(s.Draining))
+		t.row("remaining", strconv.Itoa(s.Remaining))
+		t.row("drained", onOffString(s.Drained))
+	})
+}
+
+// runExport always writes JSONL, the format import reads back.
+func runExport(ctx context.Context, args []string) error {
+	fs, g := flags("export")
+	file := fs.String("f", "-", "output file (- for stdout)")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	c, _, err := g.client()
+	if err != nil {
+		return err
+	}
+	recs, err := c.Export(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := stdout
+	if *file != "-" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if *file != "-" {
+		fmt.Fprintf(os.Stderr, "exported %d ads to %s\n", len(recs), *file)
+	}
+	return nil
+}
+
+func runImport(ctx context.Context, args []string) error {
+	fs, g := flags("import")
+	file := fs.String("f", "-", "JSONL export to load (- for stdin)")
+	keepSeq := fs.Bool("keep-seq", false, "reuse recorded sequence numbers where they do not collide")
+	skipDup := fs.Bool("skip-duplicates", false, "skip ads whose ID is already queued")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	r, closeFn, err := open(*file)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+	var recs []client.Record
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var rec client.Record
+		if err := dec.Decode(&rec); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("%s: record %d: %w", *file, len(recs)+1, err)
+		}
+		recs = append(recs, rec)
+	}
+
+	c, p, err := g.client()
+	if err != nil {
+		return err
+	}
+	res, err := c.Import(ctx, recs, client.ImportOptions{KeepSeq: *keepSeq, SkipDuplicates: *skipDup})
+	if err != nil {
+		return err
+	}
+	return p.print(res, func(t *table) {
+		t.row("imported", strconv.Itoa(res.Imported))
+		t.row("skipped", strconv.Itoa(res.Skipped))
+		t.row("rejected", strconv.Itoa(res.Rejected))
+	})
+}
+
+func adTable(ads []client.Ad) func(t *table) {
+	return func(t *table) {
+		t.header("ADID", "PRIORITY", "FAMILY", "MAX WAIT", "TITLE")
+		for _, ad := range ads {
+			t.row(ad.AdID, strconv.Itoa(ad.Priority), ad.GameFamily, strconv.Itoa(ad.MaxWaitTime)+"s", ad.Title)
+		}
+	}
+}
+
+func queuedTable(queued []client.Queued) func(t *table) {
+	return func(t *table) {
+		t.header("ADID", "PRIORITY", "MAX WAIT", "SEQ", "POSITION", "STARTS IN")
+		for _, q := range queued {
+			position, starts := strconv.Itoa(q.Position)+" of "+strconv.Itoa(q.Queued), "-"
+			if q.Paused {
+				position = "paused"
+			}
+			if q.EstimatedStartAt != nil {
+				starts = "~" + max(time.Until(*q.EstimatedStartAt), 0).Round(time.Second).String()
+			}
+			t.row(q.AdID, strconv.Itoa(q.Priority), strconv.Itoa(q.MaxWaitTime)+"s",
+				strconv.FormatInt(q.Seq, 10), position, starts)
+		}
+	}
+}
+
+// done reports a change that returns nothing but success.
+func (p *printer) done(msg string) error {
+	out := struct {
+		OK      bool   `json:"ok"`
+		Message string `json:"message"`
+	}{true, msg}
+	return p.print(out, func(t *table) { t.row(msg) })
+}
+
+func open(path string) (io.Reader, func() error, error) {
+	if path == "-" {
+		return os.Stdin, func() error { return nil }, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f.Close, nil
+}
+
+func onOff(s string) (bool, error) {
+	switch s {
+	case "on", "true", "enable":
+		return true, nil
+	case "off", "false", "disable":
+		return false, nil
+	}
+	return false, usagef("want on or off, not %q", s)
+}
+
+func onOffString(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
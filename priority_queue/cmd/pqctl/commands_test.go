@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"icetea/priority_queue/config"
+	"icetea/priority_queue/internal/httpapi"
+	"icetea/priority_queue/internal/queue"
+	"icetea/priority_queue/pkg/client"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testConfig = config.Config{
+	TotalPriority:      3,
+	MaximumWaitSeconds: 600,
+	BTreeDegree:        16,
+	PriorityClasses:    []config.PriorityClass{{Name: "urgent", Level: 3}},
+}
+
+// newServer starts a queue server and points $PQCTL_URL at it.
+func newServer(t *testing.T, cfg config.Config) {
+	t.Helper()
+	isolate(t)
+	srv := httptest.NewServer((&httpapi.Handler{Q: queue.NewFromConfig(cfg), Cfg: cfg}).Router())
+	t.Cleanup(srv.Close)
+	t.Setenv("PQCTL_URL", srv.URL)
+}
+
+func TestReadAds(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []client.EnqueueRequest
+		err  string
+	}{
+		{name: "empty"},
+		{name: "level", in: `{"adId":"a","gameFamily":"RPG","priority":2}`,
+			want: []client.EnqueueRequest{{Ad: client.Ad{AdID: "a", GameFamily: "RPG", Priority: 2}}}},
+		{name: "class", in: `{"adId":"a","priority":"urgent"}`,
+			want: []client.EnqueueRequest{{Ad: client.Ad{AdID: "a"}, Class: "urgent"}}},
+		{name: "quoted level is a class", in: `{"adId":"a","priority":"3"}`,
+			want: []client.EnqueueRequest{{Ad: client.Ad{AdID: "a"}, Class: "3"}}},
+		{name: "no priority", in: `{"adId":"a","maxWaitTime":30}`,
+			want: []client.EnqueueRequest{{Ad: client.Ad{AdID: "a", MaxWaitTime: 30}}}},
+		{name: "stream", in: "{\"adId\":\"a\",\"priority\":1}\n\n  {\"adId\":\"b\",\"priority\":\"urgent\"}{\"adId\":\"c\"}\n",
+			want: []client.EnqueueRequest{{Ad: client.Ad{AdID: "a", Priority: 1}}, {Ad: client.Ad{AdID: "b"}, Class: "urgent"}, {Ad: client.Ad{AdID: "c"}}}},
+		{name: "truncated", in: `{"adId":"a"} {"adId":`, err: "ad 2"},
+		{name: "bad field", in: `{"adId":"a","maxWaitTime":"soon"}`, err: "ad 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ads.json")
+			if err := os.WriteFile(path, []byte(tt.in), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readAds(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("readAds = %+v, %v; want an error with %q", got, err, tt.err)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("readAds = %+v, %v; want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommands(t *testing.T) {
+	newServer(t, testConfig)
+	file := filepath.Join(t.TempDir(), "ads.json")
+	if err := os.WriteFile(file, []byte(`{"adId":"b1","title":"B1","gameFamily":"Puzzle","priority":1}
+{"adId":"b2","title":"B2","gameFamily":"RPG","priority":"urgent"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each step runs against the queue the previous steps left.
+	steps := []struct {
+		name  string
+		args  []string
+		want  []string // in the output, in order
+		err   string
+		usage bool
+	}{
+		{name: "enqueue", args: []string{"-id", "a1", "-family", "RPG", "-priority", "urgent"}, want: []string{"a1", "3", "1 of 1"}},
+		{name: "enqueue", args: []string{"-f", file}, want: []string{"b1", "1", "2 of 2", "b2", "3", "2 of 3"}},
+		{name: "enqueue", args: []string{"-id", "c1", "-family", "RPG"}, err: "give -f, or -id, -family and -priority", usage: true},
+		{name: "peek", args: []string{"-n", "3"}, want: []string{"a1", "b2", "b1"}},
+		{name: "reprioritize", args: []string{"family", "Puzzle", "-priority", "urgent"}, want: []string{"moved family Puzzle to priority urgent"}},
+		{name: "reprioritize", args: []string{"age", "10m"}, err: "give one of -priority and -delta", usage: true},
+		{name: "reprioritize", args: []string{"size", "big", "-delta", "1"}, err: `want family or age, not "size"`, usage: true},
+		{name: "position", args: []string{"b1"}, want: []string{"b1", "2 of 3"}},
+		{name: "dequeue", args: []string{"-lease", "30s"}, want: []string{"a1", "LEASE", "1", "expires"}},
+		{name: "leases", want: []string{"1", "a1"}},
+		{name: "ack", args: []string{"one"}, err: `bad lease ID "one"`, usage: true},
+		{name: "nack", args: []string{"1", "-o", "json"}, want: []string{`"ok": true`, "lease 1 nacked"}},
+		{name: "dequeue", args: []string{"-o", "yaml"}, want: []string{"adId: a1"}},
+		{name: "settings", args: []string{"max-wait", "120"}, want: []string{"max-wait", "120s"}},
+		{name: "settings", args: []string{"max-wait", "0"}, err: "max-wait takes a positive number of seconds", usage: true},
+		{name: "pause", args: []string{"family", "RPG"}, want: []string{"families", "RPG"}},
+		{name: "peek", want: []string{"b1"}},
+		{name: "peek", args: []string{"-o", "xml"}, err: `unknown output format "xml"`, usage: true},
+	}
+	for _, st := range steps {
+		out, err := run(t, st.name, st.args...)
+		if st.err != "" {
+			var uerr usageError
+			if err == nil || !strings.Contains(err.Error(), st.err) || errors.As(err, &uerr) != st.usage {
+				t.Fatalf("%s %q = %v, want error %q (usage error: %v)", st.name, st.args, err, st.err, st.usage)
+			}
+			continue
+		}
+		if err != nil || !containsInOrder(out, st.want) {
+			t.Fatalf("%s %q = %v, printed:\n%s\nwant %q in order", st.name, st.args, err, out, st.want)
+		}
+	}
+	if out, _ := run(t, "peek"); strings.Contains(out, "b2") {
+		t.Fatalf("peek shows paused family RPG:\n%s", out)
+	}
+}
+
+func TestReprioritizeConfirm(t *testing.T) {
+	cfg := testConfig
+	cfg.Guardrails = config.Guardrails{MaxItems: 1}
+	newServer(t, cfg)
+	for _, id := range []string{"a", "b"} {
+		if _, err := run(t, "enqueue", "-id", id, "-family", "RPG", "-priority", "1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := run(t, "reprioritize", "family", "RPG", "-priority", "urgent")
+	if err == nil || !strings.Contains(err.Error(), "run the same command with -confirm ") {
+		t.Fatalf("reprioritize over the guardrail = %v, want a hint with the confirm token", err)
+	}
+	fields := strings.Fields(err.Error())
+	token := fields[len(fields)-1]
+	if _, err := run(t, "reprioritize", "family", "RPG", "-priority", "urgent", "-confirm", "bogus"); err == nil {
+		t.Fatal("reprioritize with a bogus token succeeded")
+	}
+	if out, err := run(t, "reprioritize", "family", "RPG", "-confirm", token, "-priority", "urgent"); err != nil || !strings.Contains(out, "moved family RPG") {
+		t.Fatalf("confirmed reprioritize = %s, %v", out, err)
+	}
+	if out, err := run(t, "undo"); err != nil || !strings.Contains(out, "moved 2 ads back") {
+		t.Fatalf("undo = %s, %v", out, err)
+	}
+}
@@ -0,0 +1,195 @@
+// Command pqctl operates a queue server from the terminal.
+//
+//	pqctl peek -n 5
+//	pqctl enqueue -id ad-1 -family RPG -priority urgent
+//	pqctl reprioritize family RPG -priority 3
+//	pqctl dist -watch
+//	pqctl top
+//	pqctl export > queue.jsonl
+//
+// The server URL and API key come from -url and -api-key, the PQCTL_URL and
+// PQCTL_API_KEY environment variables, or a profile in the config file (see
+// `pqctl profile`), in that order. Output is a table unless -o json or -o
+// yaml is given.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"icetea/priority_queue/pkg/client"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// Exit codes besides 0 and 1.
+const (
+	exitUsage = 2
+	exitEmpty = 3 // dequeue found the queue empty
+)
+
+type command struct {
+	usage string // arguments after the command name
+	help  string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"enqueue":      {"[-f file | -id ID -family F -priority P ...]", "add ads to the queue", runEnqueue},
+		"dequeue":      {"[-lease 30s]", "remove the next ad, optionally on a lease", runDequeue},
+		"ack":          {"LEASE_ID", "finish a leased ad", runAck},
+		"nack":         {"LEASE_ID", "return a leased ad to its old position", runNack},
+		"leases":       {"", "list outstanding leases", runLeases},
+		"peek":         {"[-n 10]", "show the next ads in dequeue order", runPeek},
+		"position":     {"AD_ID", "show where a queued ad stands and when it should start", runPosition},
+		"dist":         {"[-watch [-interval 2s]]", "show the priority distribution", runDist},
+		"watch":        {"[-interval 2s]", "refresh the distribution live (same as dist -watch)", runWatch},
+		"top":          {"[-interval 1s] [-n 10]", "live dashboard with key bindings to pause and reprioritize families", runTop},
+		"waiting":      {"-age 10m", "list ads waiting longer than age", runWaiting},
+		"scores":       {"", "show each priority head's anti-starvation score", runScores},
+		"reprioritize": {"family NAME | age AGE  -priority P | -delta N [-confirm TOKEN]", "move a family or old ads to another priority", runReprioritize},
+		"undo":         {"", "move the ads of the last reprioritize back", runUndo},
+		"settings":     {"[anti-starvation on|off | max-wait SECONDS | priorities N [-strategy S]]", "show or change settings", runSettings},
+		"pause":        {"all | priority P | family F", "pause dequeues for a scope", runPause},
+		"resume":       {"all | priority P | family F", "resume a paused scope", runResume},
+		"drain":        {"[on|off]", "show or change drain mode", runDrain},
+		"export":       {"[-f file]", "write every queued ad as JSONL", runExport},
+		"import":       {"[-f file] [-keep-seq] [-skip-duplicates]", "load a JSONL export", runImport},
+		"profile":      {"[list | use NAME | set NAME -url URL [-api-key KEY] | delete NAME]", "manage connection profiles", runProfile},
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "-help" || os.Args[1] == "help" {
+		usage()
+		if len(os.Args) < 2 {
+			os.Exit(exitUsage)
+		}
+		return
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "pqctl: unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(exitUsage)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := cmd.run(ctx, os.Args[2:])
+	stop()
+
+	var uerr usageError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errBadFlags):
+		os.Exit(exitUsage)
+	case errors.As(err, &uerr):
+		fmt.Fprintf(os.Stderr, "pqctl %s: %v\nusage: pqctl %s %s\n", os.Args[1], err, os.Args[1], cmd.usage)
+		os.Exit(exitUsage)
+	case errors.Is(err, client.ErrQueueEmpty):
+		fmt.Fprintln(os.Stderr, "pqctl: queue is empty")
+		os.Exit(exitEmpty)
+	default:
+		fmt.Fprintf(os.Stderr, "pqctl %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString("usage: pqctl COMMAND [flags] [args]\n\ncommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "  %-13s %s\n", name, commands[name].help)
+	}
+	b.WriteString("\nflags accepted by every command:\n")
+	b.WriteString("  -url URL         server URL\n")
+	b.WriteString("  -api-key KEY     API key\n")
+	b.WriteString("  -profile NAME    profile from the config file\n")
+	b.WriteString("  -o FORMAT        table, json or yaml\n")
+	b.WriteString("\nRun pqctl COMMAND -h for a command's flags.\n")
+	fmt.Fprint(os.Stderr, b.String())
+}
+
+var errBadFlags = errors.New("bad flags")
+
+// usageError is a bad command line, reported with the command's usage.
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+func usagef(format string, args ...any) error {
+	return usageError(fmt.Sprintf(format, args...))
+}
+
+// globals are the flags every command accepts.
+type globals struct {
+	url     string
+	apiKey  string
+	profile string
+	output  string
+}
+
+func (g *globals) register(fs *flag.FlagSet) {
+	fs.StringVar(&g.url, "url", "", "server URL (default from $PQCTL_URL or the profile)")
+	fs.StringVar(&g.apiKey, "api-key", "", "API key (default from $PQCTL_API_KEY or the profile)")
+	fs.StringVar(&g.profile, "profile", "", "profile name (default is the config file's current profile)")
+	fs.StringVar(&g.output, "o", "table", "output format: table, json or yaml")
+}
+
+// client resolves the connection settings and the output format.
+func (g *globals) client() (*client.Client, *printer, error) {
+	p, err := newPrinter(g.output)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := resolveConnection(g.url, g.apiKey, g.profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client.New(client.Config{BaseURL: conn.URL, APIKey: conn.APIKey}), p, nil
+}
+
+// flags starts a command's flag set with the global flags registered.
+func flags(name string) (*flag.FlagSet, *globals) {
+	fs := flag.NewFlagSet("pqctl "+name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: pqctl %s %s\n\n%s\n\nflags:\n", name, commands[name].usage, commands[name].help)
+		fs.PrintDefaults()
+	}
+	g := new(globals)
+	g.register(fs)
+	return fs, g
+}
+
+// parse parses flags placed anywhere after the command name, so
+// `pqctl reprioritize family RPG -priority 3` works, and returns the
+// positional arguments.
+func parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		} else if err != nil {
+			// The flag package has already printed the error and usage.
+			return nil, errBadFlags
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
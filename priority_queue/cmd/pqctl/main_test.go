@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// isolate points pqctl at an empty config file and clears the connection
+// environment variables.
+func isolate(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	t.Setenv("PQCTL_CONFIG", path)
+	t.Setenv("PQCTL_URL", "")
+	t.Setenv("PQCTL_API_KEY", "")
+	return path
+}
+
+// run runs a pqctl command and returns what it printed.
+func run(t *testing.T, name string, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	stdout = &out
+	t.Cleanup(func() { stdout = os.Stdout })
+	err := commands[name].run(context.Background(), args)
+	return out.String(), err
+}
+
+// containsInOrder reports whether out has every string of want, in order.
+func containsInOrder(out string, want []string) bool {
+	for _, w := range want {
+		i := strings.Index(out, w)
+		if i == -1 {
+			return false
+		}
+		out = out[i+len(w):]
+	}
+	return true
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		args     []string
+		want     []string
+		priority string
+		delta    int
+		url      string
+		err      error
+	}{
+		{args: nil},
+		{args: []string{"family", "RPG", "-priority", "3"}, want: []string{"family", "RPG"}, priority: "3"},
+		{args: []string{"-priority", "3", "family", "RPG"}, want: []string{"family", "RPG"}, priority: "3"},
+		{args: []string{"family", "-priority", "urgent", "RPG", "-url", "http://q:8080"}, want: []string{"family", "RPG"}, priority: "urgent", url: "http://q:8080"},
+		{args: []string{"age", "10m", "-delta", "-1"}, want: []string{"age", "10m"}, delta: -1},
+		{args: []string{"age", "10m", "--delta=2"}, want: []string{"age", "10m"}, delta: 2},
+		{args: []string{"family", "RPG", "-h"}, err: flag.ErrHelp},
+		{args: []string{"family", "-bogus", "RPG"}, err: errBadFlags},
+		{args: []string{"family", "RPG", "-priority"}, err: errBadFlags},
+		{args: []string{"-delta", "one"}, err: errBadFlags},
+	}
+	for _, tt := range tests {
+		fs, g := flags("reprioritize")
+		fs.SetOutput(io.Discard)
+		priority := fs.String("priority", "", "")
+		delta := fs.Int("delta", 0, "")
+		got, err := parse(fs, tt.args)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("parse(%q) error = %v, want %v", tt.args, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) || *priority != tt.priority || *delta != tt.delta || g.url != tt.url {
+			t.Errorf("parse(%q) = %q, %v with -priority %q -delta %d -url %q; want %q, -priority %q -delta %d -url %q",
+				tt.args, got, err, *priority, *delta, g.url, tt.want, tt.priority, tt.delta, tt.url)
+		}
+	}
+}
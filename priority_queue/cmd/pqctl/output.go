@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// stdout is where results go; tests swap it.
+var stdout io.Writer = os.Stdout
+
+// printer writes results as a table, JSON or YAML.
+type printer struct {
+	format string
+	w      io.Writer
+}
+
+func newPrinter(format string) (*printer, error) {
+	switch format {
+	case "table", "json", "yaml":
+		return &printer{format: format, w: stdout}, nil
+	}
+	return nil, usagef("unknown output format %q (want table, json or yaml)", format)
+}
+
+// print writes v as JSON or YAML, or calls fill to build the table.
+func (p *printer) print(v any, fill func(t *table)) error {
+	switch p.format {
+	case "json":
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "yaml":
+		return writeYAML(p.w, v)
+	}
+	t := &table{tw: tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)}
+	fill(t)
+	return t.tw.Flush()
+}
+
+// writeYAML goes through JSON so the keys match the API's JSON names and
+// keep their order.
+func writeYAML(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(b, &node); err != nil {
+		return err
+	}
+	blockStyle(&node)
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// blockStyle undoes the flow style and quoting that decoding JSON leaves on
+// every node; the encoder still quotes strings that need it.
+func blockStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
+
+type table struct {
+	tw *tabwriter.Writer
+}
+
+func (t *table) header(cols ...string) { t.row(cols...) }
+
+func (t *table) row(cols ...string) {
+	fmt.Fprintln(t.tw, strings.Join(cols, "\t"))
+}
+
+// bar is a horizontal bar of width cells for fraction f of the full width.
+func bar(f float64, width int) string {
+	n := int(f*float64(width) + 0.5)
+	n = max(0, min(n, width))
+	return strings.Repeat("█", n) + strings.Repeat("·", width-n)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultURL = "http://localhost:8080"
+
+// profileFile is pqctl's config file, by default
+// $XDG_CONFIG_HOME/pqctl/config.yaml (or its OS equivalent):
+//
+//	current: prod
+//	profiles:
+//	  local: {url: "http://localhost:8080"}
+//	  prod: {url: "https://queue.internal:8080", apiKey: "..."}
+type profileFile struct {
+	Current  string             `yaml:"current,omitempty"`
+	Profiles map[string]profile `yaml:"profiles,omitempty"`
+}
+
+type profile struct {
+	URL    string `yaml:"url"`
+	APIKey string `yaml:"apiKey,omitempty"`
+}
+
+// profilePath honours $PQCTL_CONFIG.
+func profilePath() (string, error) {
+	if p := os.Getenv("PQCTL_CONFIG"); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pqctl", "config.yaml"), nil
+}
+
+// loadProfiles returns an empty file if there is none yet.
+func loadProfiles() (*profileFile, string, error) {
+	path, err := profilePath()
+	if err != nil {
+		return nil, "", err
+	}
+	pf := &profileFile{}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return pf, path, nil
+	} else if err != nil {
+		return nil, "", err
+	}
+	if err := yaml.Unmarshal(b, pf); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", path, err)
+	}
+	return pf, path, nil
+}
+
+// save writes the file readable only by its owner, since it holds API keys.
+func (pf *profileFile) save(path string) error {
+	b, err := yaml.Marshal(pf)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o600)
+}
+
+// resolveConnection applies flags over the environment over the profile.
+func resolveConnection(url, apiKey, name string) (profile, error) {
+	var conn profile
+	pf, path, err := loadProfiles()
+	if err != nil {
+		return conn, err
+	}
+	if name == "" {
+		name = pf.Current
+	}
+	if name != "" {
+		p, ok := pf.Profiles[name]
+		if !ok {
+			return conn, fmt.Errorf("no profile %q in %s", name, path)
+		}
+		conn = p
+	}
+	if v := os.Getenv("PQCTL_URL"); v != "" {
+		conn.URL = v
+	}
+	if v := os.Getenv("PQCTL_API_KEY"); v != "" {
+		conn.APIKey = v
+	}
+	if url != "" {
+		conn.URL = url
+	}
+	if apiKey != "" {
+		conn.APIKey = apiKey
+	}
+	if conn.URL == "" {
+		conn.URL = defaultURL
+	}
+	return conn, nil
+}
+
+func runProfile(ctx context.Context, args []string) error {
+	fs, g := flags("profile")
+	args, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	pf, path, err := loadProfiles()
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	switch args[0] {
+	case "list":
+		p, err := newPrinter(g.output)
+		if err != nil {
+			return err
+		}
+		type row struct {
+			Name    string `json:"name"`
+			URL     string `json:"url"`
+			HasKey  bool   `json:"hasApiKey"`
+			Current bool   `json:"current"`
+		}
+		var rows []row
+		for name, prof := range pf.Profiles {
+			rows = append(rows, row{name, prof.URL, prof.APIKey != "", name == pf.Current})
+		}
+		sort.Slice(rows, func(i, j int) bool { return rows[i].Name < rows[j].Name })
+		return p.print(rows, func(t *table) {
+			t.header("", "NAME", "URL", "API KEY")
+			for _, r := range rows {
+				t.row(mark(r.Current, "*"), r.Name, r.URL, mark(r.HasKey, "yes"))
+			}
+		})
+
+	case "use":
+		if len(args) != 2 {
+			return usagef("use takes a profile name")
+		}
+		if _, ok := pf.Profiles[args[1]]; !ok {
+			return fmt.Errorf("no profile %q in %s", args[1], path)
+		}
+		pf.Current = args[1]
+		return pf.save(path)
+
+	case "set":
+		// The global -url and -api-key flags carry the profile's values.
+		if len(args) != 2 || g.url == "" {
+			return usagef("set takes a profile name and -url")
+		}
+		if pf.Profiles == nil {
+			pf.Profiles = make(map[string]profile)
+		}
+		pf.Profiles[args[1]] = profile{URL: g.url, APIKey: g.apiKey}
+		if pf.Current == "" {
+			pf.Current = args[1]
+		}
+		return pf.save(path)
+
+	case "delete":
+		if len(args) != 2 {
+			return usagef("delete takes a profile name")
+		}
+		if _, ok := pf.Profiles[args[1]]; !ok {
+			return fmt.Errorf("no profile %q in %s", args[1], path)
+		}
+		delete(pf.Profiles, args[1])
+		if pf.Current == args[1] {
+			pf.Current = ""
+		}
+		return pf.save(path)
+	}
+	return usagef("unknown profile action %q", args[0])
+}
+
+func mark(b bool, s string) string {
+	if b {
+		return s
+	}
+	return ""
+}
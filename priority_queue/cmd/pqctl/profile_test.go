@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testProfiles = `current: local
+profiles:
+  local: {url: "http://local:8080"}
+  prod: {url: "https://prod:8080", apiKey: prod-key}
+`
+
+func TestResolveConnection(t *testing.T) {
+	tests := []struct {
+		name           string
+		file           string // config file contents; none if empty
+		url, apiKey    string // flags
+		profile        string
+		envURL, envKey string
+		want           profile
+		err            string
+	}{
+		{name: "no config", want: profile{URL: defaultURL}},
+		{name: "no current profile", file: "profiles:\n  prod: {url: \"https://prod:8080\"}\n", want: profile{URL: defaultURL}},
+		{name: "current profile", file: testProfiles, want: profile{URL: "http://local:8080"}},
+		{name: "named profile", file: testProfiles, profile: "prod", want: profile{URL: "https://prod:8080", APIKey: "prod-key"}},
+		{name: "env over profile", file: testProfiles, profile: "prod", envURL: "http://env:8080",
+			want: profile{URL: "http://env:8080", APIKey: "prod-key"}},
+		{name: "env key over profile", file: testProfiles, profile: "prod", envKey: "env-key",
+			want: profile{URL: "https://prod:8080", APIKey: "env-key"}},
+		{name: "flags over env", file: testProfiles, url: "http://flag:8080", apiKey: "flag-key", envURL: "http://env:8080", envKey: "env-key",
+			want: profile{URL: "http://flag:8080", APIKey: "flag-key"}},
+		{name: "flag url keeps env key", url: "http://flag:8080", envKey: "env-key",
+			want: profile{URL: "http://flag:8080", APIKey: "env-key"}},
+		{name: "unknown profile", file: testProfiles, profile: "staging", url: "http://flag:8080", err: `no profile "staging"`},
+		{name: "bad config", file: "profiles: [", err: "config.yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := isolate(t)
+			if tt.file != "" {
+				if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Setenv("PQCTL_URL", tt.envURL)
+			t.Setenv("PQCTL_API_KEY", tt.envKey)
+
+			got, err := resolveConnection(tt.url, tt.apiKey, tt.profile)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("resolveConnection = %+v, %v; want an error with %q", got, err, tt.err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("resolveConnection = %+v, %v; want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestProfileCommand(t *testing.T) {
+	path := isolate(t)
+	local := profile{URL: "http://local:8080"}
+	prod := profile{URL: "https://prod:8080", APIKey: "k"}
+
+	// Each step runs on the file the previous steps left.
+	steps := []struct {
+		args     []string
+		current  string
+		profiles map[string]profile
+		err      string
+		usage    bool
+	}{
+		{args: []string{"set", "local", "-url", local.URL}, current: "local", profiles: map[string]profile{"local": local}},
+		{args: []string{"set", "prod", "-url", prod.URL, "-api-key", "k"}, current: "local", profiles: map[string]profile{"local": local, "prod": prod}},
+		{args: []string{"use", "prod"}, current: "prod", profiles: map[string]profile{"local": local, "prod": prod}},
+		{args: []string{"use", "staging"}, err: `no profile "staging"`},
+		{args: []string{"use"}, err: "use takes a profile name", usage: true},
+		{args: []string{"set", "staging"}, err: "set takes a profile name and -url", usage: true},
+		{args: []string{"set", "-url", "http://x"}, err: "set takes a profile name and -url", usage: true},
+		{args: []string{"delete", "staging"}, err: `no profile "staging"`},
+		{args: []string{"delete", "prod"}, current: "", profiles: map[string]profile{"local": local}},
+		{args: []string{"set", "prod", "-url", prod.URL, "-api-key", "k"}, current: "prod", profiles: map[string]profile{"local": local, "prod": prod}},
+		{args: []string{"delete", "local"}, current: "prod", profiles: map[string]profile{"prod": prod}},
+		{args: []string{"rename", "prod"}, err: `unknown profile action "rename"`, usage: true},
+	}
+	for _, st := range steps {
+		_, err := run(t, "profile", st.args...)
+		if st.err != "" {
+			var uerr usageError
+			if err == nil || !strings.Contains(err.Error(), st.err) || errors.As(err, &uerr) != st.usage {
+				t.Fatalf("profile %q = %v, want error %q (usage error: %v)", st.args, err, st.err, st.usage)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("profile %q: %v", st.args, err)
+		}
+		pf, _, err := loadProfiles()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pf.Current != st.current || !maps.Equal(pf.Profiles, st.profiles) {
+			t.Fatalf("after profile %q: current %q, profiles %+v; want %q, %+v", st.args, pf.Current, pf.Profiles, st.current, st.profiles)
+		}
+	}
+
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Fatalf("config file mode = %v, %v; want 0600", fi.Mode(), err)
+	}
+	out, err := run(t, "profile", "list", "-o", "json")
+	var rows []struct {
+		Name    string
+		URL     string
+		HasKey  bool `json:"hasApiKey"`
+		Current bool
+	}
+	if err != nil || json.Unmarshal([]byte(out), &rows) != nil || len(rows) != 1 ||
+		rows[0].Name != "prod" || rows[0].URL != prod.URL || !rows[0].HasKey || !rows[0].Current {
+		t.Fatalf("profile list = %s, %v", out, err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// terminal drives an interactive full-screen session. Key input is made
+// unbuffered with stty, so it works on any Unix terminal without extra
+// dependencies; ctrl-c still raises SIGINT.
+type terminal struct {
+	out   io.Writer
+	saved string // stty -g state to restore
+}
+
+func openTerminal(out io.Writer) (*terminal, error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("top needs an interactive terminal: %w", err)
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return nil, fmt.Errorf("stty: %w", err)
+	}
+	fmt.Fprint(out, "\033[?1049h\033[?25l") // alternate screen, hide cursor
+	return &terminal{out: out, saved: strings.TrimSpace(saved)}, nil
+}
+
+func (t *terminal) restore() {
+	fmt.Fprint(t.out, "\033[?25h\033[?1049l")
+	_, _ = stty(t.saved)
+}
+
+// size returns the terminal's rows and columns, or 24x80 if unknown.
+func (t *terminal) size() (rows, cols int) {
+	out, err := stty("size")
+	if err == nil {
+		if f := strings.Fields(out); len(f) == 2 {
+			r, err1 := strconv.Atoi(f[0])
+			c, err2 := strconv.Atoi(f[1])
+			if err1 == nil && err2 == nil && r > 0 && c > 0 {
+				return r, c
+			}
+		}
+	}
+	return 24, 80
+}
+
+// draw replaces the screen with frame.
+func (t *terminal) draw(frame string) {
+	frame = strings.ReplaceAll(frame, "\n", "\033[K\n")
+	fmt.Fprint(t.out, "\033[H"+frame+"\033[K\033[J")
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// Keys that are more than one byte.
+const (
+	keyUp   = "\033[A"
+	keyDown = "\033[B"
+)
+
+// readKeys delivers stdin in chunks as they arrive. The channel is closed
+// when stdin is.
+func readKeys(r io.Reader) <-chan string {
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		buf := make([]byte, 64)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				ch <- string(buf[:n])
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// splitKeys splits a chunk of input into keys: arrow escape sequences or
+// single characters.
+func splitKeys(s string) []string {
+	var keys []string
+	for s != "" {
+		if strings.HasPrefix(s, "\033[") && len(s) >= 3 {
+			keys, s = append(keys, s[:3]), s[3:]
+			continue
+		}
+		keys, s = append(keys, s[:1]), s[1:]
+	}
+	return keys
+}
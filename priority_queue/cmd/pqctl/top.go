@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"icetea/priority_queue/pkg/client"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runTop shows a live dashboard: priority bars, per-family counts, the next
+// ads with their score and deadline, and the enqueue/dequeue rate. Keys act
+// on the selected family or the whole queue.
+func runTop(ctx context.Context, args []string) error {
+	fs, g := flags("top")
+	interval := fs.Duration("interval", time.Second, "refresh interval")
+	n := fs.Int("n", 10, "number of upcoming ads to show")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	if *interval <= 0 || *n <= 0 {
+		return usagef("-interval and -n must be positive")
+	}
+	c, _, err := g.client()
+	if err != nil {
+		return err
+	}
+	conn, _ := resolveConnection(g.url, g.apiKey, g.profile)
+
+	term, err := openTerminal(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer term.restore()
+	keys := readKeys(os.Stdin)
+
+	m := &topModel{c: c, url: conn.URL, n: *n}
+	rows, cols := term.size()
+	m.refresh(ctx)
+	tick := time.NewTicker(*interval)
+	defer tick.Stop()
+	for {
+		term.draw(m.render(rows, cols, time.Now()))
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick.C:
+			rows, cols = term.size()
+			m.refresh(ctx)
+		case chunk, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			for _, k := range splitKeys(chunk) {
+				if m.key(ctx, k) {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+type topModel struct {
+	c   *client.Client
+	url string
+	n   int
+
+	dist   *client.Distribution
+	stats  *client.Stats
+	next   []client.ScoredAd
+	paused *client.PauseState
+	err    error // last refresh failure
+
+	// Rates come from the counters' change since the previous refresh.
+	prev             *client.Stats
+	prevAt           time.Time
+	enqRate, deqRate float64
+
+	families []string // sorted, so the selection does not jump around
+	selected string
+
+	prompt *string // new priority being typed for the selected family
+	status string  // result of the last action
+}
+
+func (m *topModel) refresh(ctx context.Context) {
+	dist, err := m.c.Distribution(ctx)
+	var stats *client.Stats
+	if err == nil {
+		stats, err = m.c.Stats(ctx)
+	}
+	var next []client.ScoredAd
+	if err == nil {
+		next, err = m.c.Next(ctx, m.n)
+	}
+	var paused *client.PauseState
+	if err == nil {
+		paused, err = m.c.PauseState(ctx)
+	}
+	if m.err = err; err != nil {
+		return
+	}
+
+	now := time.Now()
+	if m.prev != nil && stats.Enqueued >= m.prev.Enqueued && stats.Dequeued >= m.prev.Dequeued {
+		dt := now.Sub(m.prevAt).Seconds()
+		m.enqRate = float64(stats.Enqueued-m.prev.Enqueued) / dt
+		m.deqRate = float64(stats.Dequeued-m.prev.Dequeued) / dt
+	}
+	m.prev, m.prevAt = stats, now
+	m.dist, m.stats, m.next, m.paused = dist, stats, next, paused
+
+	// Paused families stay listed while empty so they can be resumed.
+	m.families = m.families[:0]
+	for f := range stats.Families {
+		m.families = append(m.families, f)
+	}
+	for _, f := range paused.Families {
+		if _, ok := stats.Families[f]; !ok {
+			m.families = append(m.families, f)
+		}
+	}
+	sort.Strings(m.families)
+	if !slices.Contains(m.families, m.selected) {
+		m.selected = ""
+		if len(m.families) > 0 {
+			m.selected = m.families[0]
+		}
+	}
+}
+
+// key handles one key press and reports whether to quit.
+func (m *topModel) key(ctx context.Context, k string) bool {
+	if m.prompt != nil {
+		switch k {
+		case "\n", "\r":
+			p := *m.prompt
+			m.prompt = nil
+			if p != "" {
+				m.act(ctx, fmt.Sprintf("moved %s to priority %s", m.selected, p), func() error {
+					_, err := m.c.ReprioritizeFamily(ctx, m.selected, client.ReprioritizeRequest{NewPriority: client.Priority(p)})
+					return err
+				})
+			}
+		case "\033":
+			m.prompt = nil
+		case "\x7f", "\b":
+			if s := *m.prompt; s != "" {
+				*m.prompt = s[:len(s)-1]
+			}
+		default:
+			if len(k) == 1 && k[0] > ' ' && k[0] < 0x7f {
+				*m.prompt += k
+			}
+		}
+		return false
+	}
+
+	switch k {
+	case "q":
+		return true
+	case keyUp, "k":
+		m.move(-1)
+	case keyDown, "j":
+		m.move(1)
+	case "a":
+		if m.dist != nil {
+			on := !m.dist.EnableAntiStarvation
+			m.act(ctx, "anti-starvation "+onOffString(on), func() error { return m.c.SetAntiStarvation(ctx, on) })
+		}
+	case "p":
+		if m.selected == "" {
+			break
+		}
+		f := m.selected
+		if m.paused != nil && slices.Contains(m.paused.Families, f) {
+			m.act(ctx, "resumed "+f, func() error { _, err := m.c.Resume(ctx, client.PauseFamily(f)); return err })
+		} else {
+			m.act(ctx, "paused "+f, func() error { _, err := m.c.Pause(ctx, client.PauseFamily(f)); return err })
+		}
+	case "+", "=", "-":
+		if m.selected == "" {
+			break
+		}
+		delta := 1
+		if k == "-" {
+			delta = -1
+		}
+		f := m.selected
+		m.act(ctx, fmt.Sprintf("moved %s by %+d", f, delta), func() error {
+			_, err := m.c.ReprioritizeFamily(ctx, f, client.ReprioritizeRequest{Delta: delta})
+			return err
+		})
+	case "r":
+		if m.selected != "" {
+			m.prompt = new(string)
+		}
+	}
+	return false
+}
+
+func (m *topModel) move(by int) {
+	i := slices.Index(m.families, m.selected) + by
+	if i >= 0 && i < len(m.families) {
+		m.selected = m.families[i]
+	}
+}
+
+// act runs a change, shows its outcome and refreshes at once.
+func (m *topModel) act(ctx context.Context, done string, fn func() error) {
+	if err := fn(); err != nil {
+		m.status = "error: " + err.Error()
+	} else {
+		m.status = done
+	}
+	m.refresh(ctx)
+}
+
+func (m *topModel) render(rows, cols int, now time.Time) string {
+	var lines []string
+	add := func(format string, args ...any) {
+		line := fmt.Sprintf(format, args...)
+		if r := []rune(line); len(r) > cols {
+			line = string(r[:cols])
+		}
+		lines = append(lines, line)
+	}
+
+	add("pqctl top  %s  %s", m.url, now.Format(time.TimeOnly))
+	if m.err != nil {
+		add("error: %v", m.err)
+	}
+	if m.dist == nil {
+		return strings.Join(lines, "\n")
+	}
+	s := m.stats
+	add("queued %d  leased %d  enqueue %.1f/s  dequeue %.1f/s  oldest %s  anti-starvation %s",
+		s.Queued, s.Leased, m.enqRate, m.deqRate, seconds(s.OldestWaitSeconds), onOffString(m.dist.EnableAntiStarvation))
+	add("")
+
+	barW := max(10, min(50, cols-40))
+	add("%-18s %7s %6s", "PRIORITY", "COUNT", "SHARE")
+	for _, l := range m.dist.Levels {
+		label := strconv.Itoa(l.Priority) + " " + l.Name
+		if m.paused != nil && (m.paused.All || slices.Contains(m.paused.Priorities, l.Priority)) {
+			label += " (paused)"
+		}
+		add("%-18s %7d %5.1f%%  %s", clip(label, 18), l.Count, l.Percent, bar(l.Percent/100, barW))
+	}
+	add("")
+
+	// The families and next lists share the rows left after the footer.
+	room := rows - len(lines) - 5
+	famRows := min(len(m.families), max(3, room/2))
+	nextRows := max(0, min(len(m.next), room-famRows-1))
+
+	add("%-22s %7s", "FAMILY", "QUEUED")
+	start := max(0, min(slices.Index(m.families, m.selected)-famRows/2, len(m.families)-famRows))
+	for _, f := range m.families[start : start+famRows] {
+		cursor := " "
+		if f == m.selected {
+			cursor = ">"
+		}
+		state := ""
+		if m.paused != nil && slices.Contains(m.paused.Families, f) {
+			state = "paused"
+		}
+		add("%s %-20s %7d  %s", cursor, clip(f, 20), s.Families[f], state)
+	}
+	add("")
+
+	add("%-20s %4s %8s %9s %12s", "NEXT", "PRI", "SCORE", "WAITED", "DEADLINE")
+	for _, a := range m.next[:nextRows] {
+		left := a.Deadline.Sub(now)
+		deadline := "in " + seconds(left.Seconds())
+		if left < 0 {
+			deadline = "late " + seconds(-left.Seconds())
+		}
+		score := strconv.FormatFloat(a.Score, 'f', 2, 64)
+		if a.Eligible {
+			score += "*"
+		}
+		add("%-20s %4d %8s %9s %12s", clip(a.Ad.AdID, 20), a.Ad.Priority, score, seconds(a.WaitedSeconds), deadline)
+	}
+
+	for len(lines) < rows-2 {
+		lines = append(lines, "")
+	}
+	switch {
+	case m.prompt != nil:
+		add("new priority for %s: %s_", m.selected, *m.prompt)
+	default:
+		add("%s", m.status)
+	}
+	add("↑/↓ family  p pause/resume  +/- move family  r set priority  a anti-starvation  q quit   (* aging)")
+	return strings.Join(lines, "\n")
+}
+
+func seconds(s float64) string {
+	return (time.Duration(s) * time.Second).String()
+}
+
+func clip(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
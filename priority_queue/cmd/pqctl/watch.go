@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"icetea/priority_queue/pkg/client"
+	"os"
+	"strconv"
+	"time"
+)
+
+const barWidth = 40
+
+func runDist(ctx context.Context, args []string) error {
+	fs, g := flags("dist")
+	watch := fs.Bool("watch", false, "refresh until interrupted")
+	interval := fs.Duration("interval", 2*time.Second, "refresh interval with -watch")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	c, p, err := g.client()
+	if err != nil {
+		return err
+	}
+	if *watch {
+		return watchDist(ctx, c, p, *interval)
+	}
+	d, err := c.Distribution(ctx)
+	if err != nil {
+		return err
+	}
+	return p.print(d, distTable(d))
+}
+
+func runWatch(ctx context.Context, args []string) error {
+	fs, g := flags("watch")
+	interval := fs.Duration("interval", 2*time.Second, "refresh interval")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	c, p, err := g.client()
+	if err != nil {
+		return err
+	}
+	return watchDist(ctx, c, p, *interval)
+}
+
+// watchDist redraws the distribution every interval until ctx is done. A
+// failed poll is shown in place of the table and retried on the next tick.
+// With -o json or yaml each poll is appended as one document instead.
+func watchDist(ctx context.Context, c *client.Client, p *printer, interval time.Duration) error {
+	if interval <= 0 {
+		return usagef("-interval must be positive")
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		d, err := c.Distribution(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		switch p.format {
+		case "table":
+			fmt.Fprint(p.w, "\033[H\033[2J") // home and clear
+			fmt.Fprintf(p.w, "every %s   %s   (ctrl-c to stop)\n\n", interval, time.Now().Format(time.TimeOnly))
+			if err != nil {
+				fmt.Fprintf(p.w, "error: %v\n", err)
+			} else if err := p.print(d, distTable(d)); err != nil {
+				return err
+			}
+		case "json":
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "pqctl watch: %v\n", err)
+			} else if err := json.NewEncoder(p.w).Encode(d); err != nil {
+				return err
+			}
+		default:
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "pqctl watch: %v\n", err)
+			} else {
+				fmt.Fprintln(p.w, "---")
+				if err := writeYAML(p.w, d); err != nil {
+					return err
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick.C:
+		}
+	}
+}
+
+func distTable(d *client.Distribution) func(t *table) {
+	return func(t *table) {
+		t.header("PRIORITY", "NAME", "COUNT", "SHARE", "")
+		for _, l := range d.Levels {
+			t.row(strconv.Itoa(l.Priority), l.Name, strconv.Itoa(l.Count),
+				strconv.FormatFloat(l.Percent, 'f', 1, 64)+"%", bar(l.Percent/100, barWidth))
+		}
+		t.row()
+		t.row("total", "", strconv.Itoa(d.Total))
+		t.row("anti-starvation", onOffString(d.EnableAntiStarvation))
+	}
+}
@@ -0,0 +1,157 @@
+// Command replay feeds a server trace (see traceDir in the config) into a
+// fresh queue on a virtual clock and diffs the resulting dequeue order
+// against the recorded one, or against a replay under another config.
+//
+//	go run ./cmd/replay -trace traces/trace-20250101T000000Z.jsonl
+//	go run ./cmd/replay -trace traces/trace-20250101T000000Z.jsonl -config config/strict.yaml
+//	go run ./cmd/replay -trace traces/trace-20250101T000000Z.jsonl -speed 1 -listen :8081
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"icetea/priority_queue/config"
+	"icetea/priority_queue/internal/httpapi"
+	"icetea/priority_queue/internal/queue"
+	"icetea/priority_queue/internal/trace"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func main() {
+	tracePath := flag.String("trace", "", "trace file to replay")
+	configPath := flag.String("config", "", "alternative config; diff its dequeue order against the recorded config's")
+	speed := flag.Float64("speed", 0, "replay speed relative to the recording (1 = real time, 0 = as fast as possible)")
+	listen := flag.String("listen", "", "serve the replayed queue's read API on this address while replaying")
+	limit := flag.Int("limit", 20, "maximum mismatches to list")
+	asJSON := flag.Bool("json", false, "print the result as JSON")
+	flag.Parse()
+
+	if *tracePath == "" {
+		log.Fatal("-trace is required")
+	}
+	events, err := trace.Load(*tracePath)
+	if err != nil {
+		log.Fatalf("trace: %v", err)
+	}
+
+	opts := trace.Options{Speed: *speed}
+	if *listen != "" {
+		opts.Started = serve(*listen)
+	}
+	recorded, err := trace.Replay(events, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	result := Result{
+		Commands:        recorded.Commands,
+		Reads:           recorded.Reads,
+		Span:            recorded.Span.String(),
+		Dequeues:        len(recorded.Dequeued),
+		ErrorMismatches: recorded.ErrorMismatches,
+		VsRecording:     trace.Compare(recorded.Recorded, recorded.Dequeued, *limit),
+	}
+
+	if *configPath != "" {
+		cfg, err := config.LoadConfig(*configPath)
+		if err != nil {
+			log.Fatalf("config %s: %v", *configPath, err)
+		}
+		// The comparison run needs no pacing; only the first run is watched.
+		alt, err := trace.Replay(events, trace.Options{Config: &cfg})
+		if err != nil {
+			log.Fatal(err)
+		}
+		d := trace.Compare(recorded.Dequeued, alt.Dequeued, *limit)
+		result.Config = filepath.Base(*configPath)
+		result.VsConfig = &d
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(result)
+	} else {
+		err = result.write(os.Stdout)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !result.VsRecording.Equal() || result.ErrorMismatches > 0 {
+		os.Exit(1)
+	}
+}
+
+// Result is what replay prints.
+type Result struct {
+	Commands        int         `json:"commands"`
+	Reads           int         `json:"reads"`
+	Span            string      `json:"span"`
+	Dequeues        int         `json:"dequeues"`
+	ErrorMismatches int         `json:"errorMismatches"`
+	VsRecording     trace.Diff  `json:"vsRecording"`
+	Config          string      `json:"config,omitempty"`
+	VsConfig        *trace.Diff `json:"vsConfig,omitempty"`
+}
+
+func (r Result) write(w io.Writer) error {
+	fmt.Fprintf(w, "replayed %d commands and %d reads covering %s\n", r.Commands, r.Reads, r.Span)
+	if r.ErrorMismatches > 0 {
+		fmt.Fprintf(w, "%d commands succeeded in one run and failed in the other\n", r.ErrorMismatches)
+	}
+	writeDiff(w, "recording", "replay", r.VsRecording)
+	if r.VsConfig != nil {
+		writeDiff(w, "recorded config", r.Config, *r.VsConfig)
+	}
+	return nil
+}
+
+func writeDiff(w io.Writer, a, b string, d trace.Diff) {
+	if d.Equal() {
+		fmt.Fprintf(w, "%s vs %s: all %d dequeues match\n", a, b, d.Compared)
+		return
+	}
+	fmt.Fprintf(w, "%s vs %s: %d of %d dequeues differ, first at #%d\n", a, b, d.Differ, d.Compared, d.First)
+	fmt.Fprintf(w, "  %d ads moved (mean %.1f, max %d positions); %d only in %s, %d only in %s\n",
+		d.Moved, d.MeanShift, d.MaxShift, d.OnlyA, a, d.OnlyB, b)
+	for _, m := range d.Mismatches {
+		fmt.Fprintf(w, "  #%-6d %-24s %s\n", m.Index, orEmpty(m.A), orEmpty(m.B))
+	}
+}
+
+func orEmpty(id string) string {
+	if id == "" {
+		return "(empty)"
+	}
+	return id
+}
+
+// serve returns a Started hook that exposes the replayed queue's queries.
+// Writes are refused so the replay stays faithful.
+func serve(addr string) func(*queue.VideoProcessingQueue) {
+	return func(q *queue.VideoProcessingQueue) {
+		api := (&httpapi.Handler{Q: q}).Router()
+		srv := &http.Server{
+			Addr: addr,
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodGet {
+					http.Error(w, "replay is read-only", http.StatusMethodNotAllowed)
+					return
+				}
+				api.ServeHTTP(w, r)
+			}),
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("listen: %v", err)
+			}
+		}()
+		log.Printf("serving the replayed queue on %s", addr)
+	}
+}
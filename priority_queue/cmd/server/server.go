@@ -3,19 +3,30 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"icetea/priority_queue/config"
+	"icetea/priority_queue/internal/cluster"
 	"icetea/priority_queue/internal/httpapi"
+	"icetea/priority_queue/internal/mcp"
 	"icetea/priority_queue/internal/queue"
+	"icetea/priority_queue/internal/raft"
+	"icetea/priority_queue/internal/replication"
+	"icetea/priority_queue/internal/trace"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 )
 
 func main() {
-	cfg, err := config.LoadConfig("config/config.yaml")
+	configPath := flag.String("config", "config/config.yaml", "path to the config file")
+	mcpStdio := flag.Bool("mcp-stdio", false, "also serve MCP on stdin/stdout, calling tools with the key in $PQ_MCP_API_KEY; exits when stdin closes")
+	flag.Parse()
+
+	cfg, err := config.LoadConfig(*configPath)
 	if err != nil {
 		panic(err)
 	}
@@ -27,12 +38,57 @@ func main() {
 		log.Printf("Loaded config (could not marshal): %+v", cfg)
 	}
 
-	q := queue.NewFromConfig(cfg)
+	// Replication, Raft and tracing work on a single queue; the config does
+	// not allow them with shards.
+	var q *queue.VideoProcessingQueue
+	var served queue.Queue
+	if cfg.Shards > 1 {
+		served = queue.NewSharded(cfg, cfg.Shards)
+		log.Printf("serving %d queue shards", cfg.Shards)
+	} else {
+		q = queue.NewFromConfig(cfg)
+		served = q
+	}
+
+	h := &httpapi.Handler{Q: served, Cfg: cfg}
+	if cfg.PersistSettings {
+		h.ConfigPath = *configPath
+	}
+
+	replCtx, stopRepl := context.WithCancel(context.Background())
+	defer stopRepl()
+	switch rc := cfg.Replication; rc.Role {
+	case "leader":
+		h.Replication = replication.NewLeader(q, rc.NodeID, rc.LogSize)
+	case "follower":
+		h.Replication = replication.NewFollower(q, rc.NodeID, rc.LeaderURL)
+		go h.Replication.Run(replCtx)
+		log.Printf("replicating from %s", rc.LeaderURL)
+	}
 
-	h := &httpapi.Handler{Q: q}
+	if cfg.Raft.NodeID != "" {
+		node, err := startCluster(q, cfg.Raft)
+		if err != nil {
+			log.Fatalf("raft: %v", err)
+		}
+		defer node.Stop()
+		h.Cluster = node
+	}
+
+	if cfg.TraceDir != "" {
+		rec, path, err := trace.Create(cfg.TraceDir, cfg, q)
+		if err != nil {
+			log.Fatalf("trace: %v", err)
+		}
+		defer rec.Close()
+		h.Trace = rec
+		log.Printf("recording API trace to %s", path)
+	}
+
+	api := h.Router()
 	srv := &http.Server{
-		Addr:              ":8080",
-		Handler:           h.Router(),
+		Addr:              cfg.ListenAddr,
+		Handler:           withUI(api),
 		ReadHeaderTimeout: 5 * time.Second,
 	}
 
@@ -47,8 +103,35 @@ func main() {
 	// Graceful shutdown
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	// stdout carries MCP messages; logs already go to stderr.
+	if *mcpStdio {
+		go func() {
+			s := &mcp.Server{API: api}
+			if err := s.ServeStdio(context.Background(), os.Stdin, os.Stdout, os.Getenv("PQ_MCP_API_KEY")); err != nil {
+				log.Printf("mcp: %v", err)
+			}
+			log.Println("mcp: stdin closed")
+			stop <- syscall.SIGTERM
+		}()
+	}
 	<-stop
 
+	stopRepl()
+	isFollower := h.Replication != nil && h.Replication.Role() == replication.RoleFollower
+	// In a cluster, drain mode is replicated: one node shutting down must
+	// not drain the others.
+	if cfg.DrainOnShutdown && !isFollower && h.Cluster == nil {
+		log.Println("draining queue before shutdown")
+		served.SetDraining(true)
+		drainCtx, cancelDrain := context.WithTimeout(context.Background(), time.Duration(cfg.DrainTimeoutSeconds)*time.Second)
+		if err := served.WaitDrained(drainCtx); err != nil {
+			_, remaining := served.DrainStatus()
+			log.Printf("drain incomplete (%d ads left): %v", remaining, err)
+		}
+		cancelDrain()
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
@@ -56,3 +139,42 @@ func main() {
 	}
 	log.Println("server stopped")
 }
+
+// startCluster joins q to a Raft group over HTTP.
+func startCluster(q *queue.VideoProcessingQueue, rc config.Raft) (*cluster.Node, error) {
+	dir := rc.DataDir
+	if dir == "" {
+		dir = filepath.Join("raft-data", rc.NodeID)
+	}
+	storage, err := raft.NewFileStorage(dir)
+	if err != nil {
+		return nil, err
+	}
+	node, err := cluster.New(q, raft.Config{
+		ID:                rc.NodeID,
+		Transport:         &raft.HTTPTransport{Client: &http.Client{Timeout: 10 * time.Second}},
+		Storage:           storage,
+		ElectionTimeout:   time.Duration(rc.ElectionTimeoutMs) * time.Millisecond,
+		HeartbeatInterval: time.Duration(rc.HeartbeatMs) * time.Millisecond,
+		SnapshotThreshold: uint64(rc.SnapshotThreshold),
+		OnLeaderChange: func(leader bool) {
+			log.Printf("raft: %s leader=%v", rc.NodeID, leader)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(rc.Peers) > 0 {
+		servers := make([]raft.Server, 0, len(rc.Peers))
+		for _, p := range rc.Peers {
+			servers = append(servers, raft.Server{ID: p.ID, Addr: p.Addr})
+		}
+		if err := node.Raft.Bootstrap(servers); err != nil {
+			return nil, err
+		}
+	} else {
+		log.Printf("raft: %s has no peers; add it with POST /cluster/members {\"id\":%q,\"addr\":%q}", rc.NodeID, rc.NodeID, rc.Addr)
+	}
+	node.Start()
+	return node, nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed ui
+var uiFiles embed.FS
+
+// withUI serves the dashboard under /ui/ and everything else with api. The
+// static files need no key; the dashboard sends the user's key on each API
+// call, so the usual roles apply to what it can see and change.
+func withUI(api http.Handler) http.Handler {
+	files, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", api)
+	mux.Handle("GET /ui/", http.StripPrefix("/ui/", http.FileServerFS(files)))
+	mux.Handle("GET /ui", http.RedirectHandler("/ui/", http.StatusMovedPermanently))
+	return mux
+}
@@ -0,0 +1,404 @@
+// Dashboard for the queue server. Everything goes through the public HTTP
+// API with the API key entered in the header, so the server's roles apply.
+"use strict";
+
+const $ = (id) => document.getElementById(id);
+const COLORS = ["#2f6fdb", "#e67e22", "#2e8b57", "#8e44ad", "#c0392b", "#16a085", "#7f8c8d", "#d4ac0d"];
+const HISTORY = 300; // points kept per chart series (5 minutes at 1s)
+
+let apiKey = localStorage.getItem("pq.apiKey") || "";
+
+class APIError extends Error {
+  constructor(status, message, data) {
+    super(message);
+    this.status = status;
+    this.data = data;
+  }
+}
+
+// api calls a /v1 route. Errors are envelopes with a code, a message and
+// details.
+async function api(method, path, body) {
+  const headers = {};
+  if (apiKey) headers.Authorization = "Bearer " + apiKey;
+  if (body !== undefined) headers["Content-Type"] = "application/json";
+  const resp = await fetch("/v1" + path, { method, headers, body: body === undefined ? undefined : JSON.stringify(body) });
+  const text = await resp.text();
+  let data = null;
+  try { data = text ? JSON.parse(text) : null; } catch { data = text; }
+  if (!resp.ok) {
+    throw new APIError(resp.status, (data && data.message) || text || resp.statusText, data);
+  }
+  return data;
+}
+
+// guarded sends a change and, if the server's guardrails ask for
+// confirmation (428), asks the user and sends it again with the token.
+async function guarded(path, body) {
+  try {
+    return await api("POST", path, body);
+  } catch (err) {
+    if (err.status !== 428 || !err.data?.details || !confirm(`This ${err.message.split(";")[0]}. Go ahead?`)) throw err;
+    return api("POST", path, { ...body, confirmToken: err.data.details.confirmToken });
+  }
+}
+
+function describe(err) {
+  if (err.status === 401) return "Enter a valid API key to continue.";
+  if (err.status === 403) return "Your API key cannot do this: " + err.message + ".";
+  return err.message;
+}
+
+function showBanner(msg) {
+  $("banner").textContent = msg || "";
+  $("banner").hidden = !msg;
+}
+
+function fmtDuration(sec) {
+  sec = Math.max(0, Math.round(sec));
+  if (sec < 60) return sec + "s";
+  if (sec < 3600) return Math.floor(sec / 60) + "m" + String(sec % 60).padStart(2, "0") + "s";
+  return Math.floor(sec / 3600) + "h" + String(Math.floor(sec % 3600 / 60)).padStart(2, "0") + "m";
+}
+
+function el(tag, text, cls) {
+  const e = document.createElement(tag);
+  if (text !== undefined) e.textContent = text;
+  if (cls) e.className = cls;
+  return e;
+}
+
+// ---- live stream ----------------------------------------------------------
+
+// EventSource cannot send an Authorization header, so the event stream is
+// read with fetch.
+let streamAbort = null;
+
+async function stream() {
+  streamAbort?.abort();
+  const ctrl = new AbortController();
+  streamAbort = ctrl;
+  while (!ctrl.signal.aborted) {
+    try {
+      const headers = apiKey ? { Authorization: "Bearer " + apiKey } : {};
+      const resp = await fetch("/v1/events?interval=1s", { headers, signal: ctrl.signal });
+      if (!resp.ok) {
+        const body = await resp.json().catch(() => ({}));
+        throw new APIError(resp.status, body.message || resp.statusText);
+      }
+      setLive(true);
+      showBanner("");
+      const reader = resp.body.pipeThrough(new TextDecoderStream()).getReader();
+      let buf = "";
+      for (;;) {
+        const { value, done } = await reader.read();
+        if (done) break;
+        buf += value;
+        let i;
+        while ((i = buf.indexOf("\n\n")) >= 0) {
+          const data = buf.slice(0, i).split("\n")
+            .filter((l) => l.startsWith("data: ")).map((l) => l.slice(6)).join("\n");
+          buf = buf.slice(i + 2);
+          if (data) onEvent(JSON.parse(data));
+        }
+      }
+    } catch (err) {
+      if (ctrl.signal.aborted) return;
+      if (err instanceof APIError) showBanner(describe(err));
+    }
+    setLive(false);
+    await new Promise((r) => setTimeout(r, 2000));
+  }
+}
+
+function setLive(ok) {
+  $("live").textContent = ok ? "live" : "disconnected";
+  $("live").className = "pill " + (ok ? "ok" : "bad");
+}
+
+let prevStats = null;
+const distSeries = new Map(); // level -> [[time, count]]
+const waitSeries = new Map(); // level -> [[time, seconds]]
+const levelNames = new Map();
+
+function push(series, key, t, v) {
+  if (!series.has(key)) series.set(key, []);
+  const pts = series.get(key);
+  pts.push([t, v]);
+  if (pts.length > HISTORY) pts.shift();
+}
+
+function onEvent(ev) {
+  const t = new Date(ev.time).getTime();
+  const s = ev.stats;
+  $("c-queued").textContent = s.queued;
+  $("c-leased").textContent = s.leased;
+  $("c-oldest").textContent = s.oldestEnqueueAt ? fmtDuration(s.oldestWaitSeconds) : "–";
+  $("c-as").textContent = ev.enableAntiStarvation ? "on" : "off";
+  if (prevStats && s.enqueued >= prevStats.enqueued && s.dequeued >= prevStats.dequeued) {
+    const dt = (t - prevStats.t) / 1000;
+    if (dt > 0) {
+      $("c-enq").textContent = ((s.enqueued - prevStats.enqueued) / dt).toFixed(1);
+      $("c-deq").textContent = ((s.dequeued - prevStats.dequeued) / dt).toFixed(1);
+    }
+  }
+  prevStats = { ...s, t };
+
+  const heads = new Map((ev.heads || []).map((h) => [h.priority, h]));
+  for (const d of ev.distribution) {
+    levelNames.set(d.Priority, d.Name);
+    push(distSeries, d.Priority, t, d.Count);
+    push(waitSeries, d.Priority, t, heads.has(d.Priority) ? heads.get(d.Priority).waitedSeconds : 0);
+  }
+  // Levels removed by a resize drop out of the charts.
+  const live = new Set(ev.distribution.map((d) => d.Priority));
+  for (const m of [distSeries, waitSeries]) {
+    for (const k of m.keys()) if (!live.has(k)) m.delete(k);
+  }
+
+  renderBars(ev.distribution);
+  drawChart($("dist-chart"), distSeries, (v) => String(Math.round(v)));
+  drawChart($("wait-chart"), waitSeries, fmtDuration);
+}
+
+function levelLabel(p) {
+  const name = levelNames.get(p);
+  return name ? p + " " + name : String(p);
+}
+
+function colorFor(p) {
+  return COLORS[(p - 1) % COLORS.length];
+}
+
+function renderBars(dist) {
+  const bars = $("bars");
+  bars.replaceChildren();
+  for (const d of dist) {
+    bars.append(el("span", levelLabel(d.Priority)));
+    const bar = el("div", undefined, "bar");
+    const fill = el("i");
+    fill.style.width = d.Percent.toFixed(1) + "%";
+    fill.style.background = colorFor(d.Priority);
+    bar.append(fill);
+    bars.append(bar);
+    bars.append(el("span", d.Count + " (" + d.Percent.toFixed(1) + "%)"));
+  }
+}
+
+// drawChart plots one line per level over the kept history.
+function drawChart(canvas, series, fmt) {
+  const dpr = window.devicePixelRatio || 1;
+  const w = canvas.clientWidth, h = canvas.clientHeight;
+  if (canvas.width !== w * dpr || canvas.height !== h * dpr) {
+    canvas.width = w * dpr;
+    canvas.height = h * dpr;
+  }
+  const ctx = canvas.getContext("2d");
+  ctx.setTransform(dpr, 0, 0, dpr, 0, 0);
+  ctx.clearRect(0, 0, w, h);
+
+  let tMin = Infinity, tMax = -Infinity, vMax = 1;
+  for (const pts of series.values()) {
+    for (const [t, v] of pts) {
+      tMin = Math.min(tMin, t);
+      tMax = Math.max(tMax, t);
+      vMax = Math.max(vMax, v);
+    }
+  }
+  if (!isFinite(tMin)) return;
+  const left = 56, right = 8, top = 8, bottom = 22;
+  const x = (t) => left + (tMax === tMin ? 1 : (t - tMin) / (tMax - tMin)) * (w - left - right);
+  const y = (v) => top + (1 - v / vMax) * (h - top - bottom);
+
+  ctx.font = "11px system-ui, sans-serif";
+  ctx.fillStyle = "#6b7385";
+  ctx.strokeStyle = "#dde1e8";
+  ctx.lineWidth = 1;
+  for (const f of [0, 0.5, 1]) {
+    const yy = y(vMax * f);
+    ctx.beginPath();
+    ctx.moveTo(left, yy);
+    ctx.lineTo(w - right, yy);
+    ctx.stroke();
+    ctx.fillText(fmt(vMax * f), 4, yy + 4);
+  }
+  ctx.fillText(fmtDuration((tMax - tMin) / 1000) + " ago", left, h - 6);
+  ctx.fillText("now", w - right - 22, h - 6);
+
+  let lx = left + 6;
+  for (const [p, pts] of [...series.entries()].sort((a, b) => b[0] - a[0])) {
+    ctx.strokeStyle = ctx.fillStyle = colorFor(p);
+    ctx.lineWidth = 2;
+    ctx.beginPath();
+    pts.forEach(([t, v], i) => (i ? ctx.lineTo(x(t), y(v)) : ctx.moveTo(x(t), y(v))));
+    ctx.stroke();
+    const label = levelLabel(p);
+    ctx.fillText(label, lx, top + 10);
+    lx += ctx.measureText(label).width + 14;
+  }
+}
+
+// ---- queued ads -----------------------------------------------------------
+
+const PAGE = 50;
+let adsOffset = 0;
+
+async function loadAds() {
+  const q = new URLSearchParams({ offset: adsOffset, limit: PAGE });
+  const search = $("ads-search").value.trim(), filter = $("ads-filter").value.trim();
+  if (search) q.set("search", search);
+  if (filter) q.set("filter", filter);
+  const body = $("ads");
+  try {
+    const page = await api("GET", "/ads?" + q);
+    const now = Date.now();
+    body.replaceChildren();
+    for (const rec of page.ads) {
+      const tr = el("tr");
+      tr.append(el("td", rec.ad.adId), el("td", rec.ad.title), el("td", rec.ad.gameFamily),
+        el("td", levelLabel(rec.ad.priority), "num"),
+        el("td", fmtDuration((now - new Date(rec.enqueueAt).getTime()) / 1000), "num"),
+        el("td", fmtDuration(rec.ad.maxWaitTime), "num"));
+      body.append(tr);
+    }
+    const last = Math.min(page.offset + page.ads.length, page.total);
+    $("ads-count").textContent = page.total ? `${page.offset + 1}–${last} of ${page.total}` : "no ads";
+    $("ads-prev").disabled = adsOffset === 0;
+    $("ads-next").disabled = last >= page.total;
+  } catch (err) {
+    body.replaceChildren();
+    $("ads-count").textContent = describe(err);
+  }
+}
+
+$("ads-form").addEventListener("submit", (e) => {
+  e.preventDefault();
+  adsOffset = 0;
+  loadAds();
+});
+$("ads-prev").addEventListener("click", () => { adsOffset = Math.max(0, adsOffset - PAGE); loadAds(); });
+$("ads-next").addEventListener("click", () => { adsOffset += PAGE; loadAds(); });
+let searchTimer;
+$("ads-search").addEventListener("input", () => {
+  clearTimeout(searchTimer);
+  searchTimer = setTimeout(() => { adsOffset = 0; loadAds(); }, 300);
+});
+
+// ---- forms ------------------------------------------------------------------
+
+function result(out, msg, bad) {
+  out.textContent = msg;
+  out.className = bad ? "bad" : "";
+}
+
+const targetHints = { family: "RPG", age: "10m", filter: "family = RPG and priority = 1" };
+$("repri-by").addEventListener("change", () => {
+  const by = $("repri-by").value;
+  $("repri-target").placeholder = targetHints[by];
+  $("repri-dry-label").hidden = by !== "filter";
+});
+
+$("repri-form").addEventListener("submit", async (e) => {
+  e.preventDefault();
+  const out = $("repri-result");
+  const by = $("repri-by").value, target = $("repri-target").value.trim();
+  const raw = $("repri-value").value.trim();
+  const move = {};
+  if ($("repri-mode").value === "delta") {
+    move.delta = parseInt(raw, 10);
+    if (!move.delta) return result(out, "Enter a non-zero number of levels, like 1 or -1.", true);
+  } else {
+    move.newPriority = /^\d+$/.test(raw) ? parseInt(raw, 10) : raw;
+  }
+  try {
+    if (by === "family") {
+      await guarded("/reprioritize/family", { family: target, ...move });
+      result(out, `Moved family ${target}.`);
+    } else if (by === "age") {
+      await guarded("/reprioritize/age", { age: target, ...move });
+      result(out, `Moved ads older than ${target}.`);
+    } else {
+      const dryRun = $("repri-dry").checked;
+      const res = await guarded("/bulk/reprioritize", { filter: target, dryRun, ...move });
+      const ids = res.adIds.slice(0, 20).join(", ") + (res.count > 20 ? ", …" : "");
+      result(out, (dryRun ? `Would move ${res.count} ads` : `Moved ${res.count} ads`) + (res.count ? ": " + ids : "."));
+    }
+    loadAds();
+  } catch (err) {
+    result(out, describe(err), true);
+  }
+});
+
+$("repri-undo").addEventListener("click", async () => {
+  const out = $("repri-result");
+  try {
+    const res = await api("POST", "/bulk/undo");
+    result(out, `Moved ${res.count} ads back.`);
+    loadAds();
+  } catch (err) {
+    result(out, err.status === 404 ? "Nothing to undo." : describe(err), true);
+  }
+});
+
+let settings = null;
+
+async function loadSettings() {
+  try {
+    settings = await api("GET", "/settings");
+    $("set-as").checked = settings.enableAntiStarvation;
+    $("set-maxwait").value = settings.maximumWait;
+    $("set-levels").value = settings.totalPriority;
+  } catch (err) {
+    result($("settings-result"), describe(err), true);
+  }
+}
+
+// Only the settings that changed are sent, each to its own endpoint.
+$("settings-form").addEventListener("submit", async (e) => {
+  e.preventDefault();
+  const out = $("settings-result");
+  if (!settings) return loadSettings();
+  const done = [];
+  try {
+    const as = $("set-as").checked;
+    if (as !== settings.enableAntiStarvation) {
+      await api("POST", "/settings/antiStarvation", { enable: as });
+      done.push("anti-starvation " + (as ? "on" : "off"));
+    }
+    const maxWait = parseInt($("set-maxwait").value, 10);
+    if (maxWait !== settings.maximumWait) {
+      await api("POST", "/settings/maximumWait", { maximumWait: maxWait });
+      done.push("maximum wait " + maxWait + "s");
+    }
+    const levels = parseInt($("set-levels").value, 10);
+    if (levels !== settings.totalPriority) {
+      await api("POST", "/settings/priorities", { totalPriority: levels, strategy: $("set-strategy").value });
+      done.push(levels + " priority levels");
+    }
+    result(out, done.length ? "Saved " + done.join(", ") + "." : "Nothing changed.");
+  } catch (err) {
+    result(out, (done.length ? "Saved " + done.join(", ") + "; then: " : "") + describe(err), true);
+  }
+  loadSettings();
+});
+
+// ---- start ------------------------------------------------------------------
+
+$("key").value = apiKey;
+$("key-form").addEventListener("submit", (e) => {
+  e.preventDefault();
+  apiKey = $("key").value.trim();
+  if (apiKey) localStorage.setItem("pq.apiKey", apiKey);
+  else localStorage.removeItem("pq.apiKey");
+  start();
+});
+
+function start() {
+  showBanner("");
+  stream();
+  loadAds();
+  loadSettings();
+}
+
+start();
+setInterval(loadAds, 10000);
@@ -1,7 +1,9 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strconv"
 
 	"gopkg.in/yaml.v3"
 )
@@ -12,8 +14,117 @@ type Config struct {
 	MaximumWaitSeconds   int     `yaml:"maximumWaitSeconds"`
 	BTreeDegree          int     `yaml:"btreeDegree"`
 	TimeBoost            float64 `yaml:"timeBoost"`
+	// PersistSettings writes runtime settings changes back to the config file.
+	PersistSettings bool            `yaml:"persistSettings"`
+	PriorityClasses []PriorityClass `yaml:"priorityClasses,omitempty"`
+	// DrainOnShutdown makes the server stop accepting enqueues on SIGTERM and
+	// wait up to DrainTimeoutSeconds for consumers to empty the queue.
+	DrainOnShutdown     bool `yaml:"drainOnShutdown"`
+	DrainTimeoutSeconds int  `yaml:"drainTimeoutSeconds"`
+	// PurgeExportDir receives a JSONL export of every purge.
+	PurgeExportDir string `yaml:"purgeExportDir"`
+	// TraceDir, if set, receives a JSONL trace of every API call for
+	// cmd/replay, one file per server start.
+	TraceDir string `yaml:"traceDir,omitempty"`
+	// Shards splits the queue into that many partitions by game family, so
+	// operations on different families do not wait for each other. It
+	// cannot be combined with replication, Raft or TraceDir, which log the
+	// commands of a single queue.
+	Shards int `yaml:"shards,omitempty"`
+
+	ListenAddr string `yaml:"listenAddr"`
+	// APIKeys turns on authentication; without keys the API is open.
+	APIKeys    []APIKey   `yaml:"apiKeys,omitempty"`
+	Guardrails Guardrails `yaml:"guardrails,omitempty"`
+
+	Replication Replication `yaml:"replication,omitempty"`
+	Raft        Raft        `yaml:"raft,omitempty"`
+}
+
+// APIKey grants its holder a role: reader (queries), operator (queue
+// operations) or admin (settings, maintenance and cluster changes too).
+type APIKey struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+	Role string `yaml:"role"`
+}
+
+const (
+	RoleReader   = "reader"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+// Guardrails hold back changes that touch much of the queue. A reprioritize,
+// bulk change or purge that would affect more than MaxItems ads or more than
+// MaxPercent of the queue is refused with a confirm token, and runs only when
+// repeated with it. A zero limit is off.
+type Guardrails struct {
+	MaxItems          int     `yaml:"maxItems,omitempty"`
+	MaxPercent        float64 `yaml:"maxPercent,omitempty"`        // 0..100
+	ConfirmTTLSeconds int     `yaml:"confirmTtlSeconds,omitempty"` // default 300
+	// OperatorSettings lists the settings operators may change too
+	// (antiStarvation, maximumWait, priorities); the rest are admin-only.
+	// Turning anti-starvation off always needs admin.
+	OperatorSettings []string `yaml:"operatorSettings,omitempty"`
+}
+
+// Settings that Guardrails.OperatorSettings can open up to operators, named
+// like their /settings/ routes.
+const (
+	SettingAntiStarvation = "antiStarvation"
+	SettingMaximumWait    = "maximumWait"
+	SettingPriorities     = "priorities"
+)
+
+// Raft configures consensus mode, enabled by setting NodeID. Founding
+// members list every member (including themselves) in Peers; a node started
+// with no Peers waits to be added through POST /cluster/members.
+type Raft struct {
+	NodeID            string     `yaml:"nodeId,omitempty"`
+	Addr              string     `yaml:"addr,omitempty"` // base URL other members use to reach this node
+	Peers             []RaftPeer `yaml:"peers,omitempty"`
+	DataDir           string     `yaml:"dataDir,omitempty"` // default raft-data/<nodeId>
+	ElectionTimeoutMs int        `yaml:"electionTimeoutMs,omitempty"`
+	HeartbeatMs       int        `yaml:"heartbeatMs,omitempty"`
+	SnapshotThreshold int        `yaml:"snapshotThreshold,omitempty"`
+}
+
+type RaftPeer struct {
+	ID   string `yaml:"id"`
+	Addr string `yaml:"addr"`
 }
 
+// Replication configures asynchronous leader/follower log shipping. An empty
+// Role runs a standalone server.
+type Replication struct {
+	Role      string `yaml:"role,omitempty"` // leader or follower
+	NodeID    string `yaml:"nodeId,omitempty"`
+	LeaderURL string `yaml:"leaderUrl,omitempty"` // followers only
+	LogSize   int    `yaml:"logSize,omitempty"`   // entries kept for followers to catch up
+}
+
+// PriorityClass carries per-level settings and optionally names the level.
+// Zero values fall back to the global settings.
+type PriorityClass struct {
+	Name           string  `yaml:"name,omitempty"`
+	Level          int     `yaml:"level"`
+	TimeBoost      float64 `yaml:"timeBoost,omitempty"`
+	MaxWaitSeconds int     `yaml:"maxWaitSeconds,omitempty"` // default for ads without maxWaitTime
+	Capacity       int     `yaml:"capacity,omitempty"`       // max queued ads at this level, 0 = unlimited
+
+	// Anti-starvation aging. A head starts aging once it has waited both its
+	// maxWaitTime and MinWaitSeconds; Curve shapes the boost over waited/maxWaitTime.
+	MinWaitSeconds int    `yaml:"minWaitSeconds,omitempty"`
+	Curve          string `yaml:"curve,omitempty"` // linear (default), exponential or step
+}
+
+const (
+	CurveLinear      = "linear"
+	CurveExponential = "exponential"
+	CurveStep        = "step"
+)
+
 // LoadConfig reads YAML from disk.
 func LoadConfig(path string) (Config, error) {
 	var cfg Config
@@ -31,5 +142,132 @@ func LoadConfig(path string) (Config, error) {
 	if cfg.BTreeDegree <= 0 {
 		cfg.BTreeDegree = 16
 	}
+	if cfg.DrainTimeoutSeconds <= 0 {
+		cfg.DrainTimeoutSeconds = 60
+	}
+	if cfg.PurgeExportDir == "" {
+		cfg.PurgeExportDir = "exports"
+	}
+	if cfg.ListenAddr == "" {
+		cfg.ListenAddr = ":8080"
+	}
+	switch cfg.Replication.Role {
+	case "", "leader":
+	case "follower":
+		if cfg.Replication.LeaderURL == "" {
+			return cfg, fmt.Errorf("replication: follower needs leaderUrl")
+		}
+	default:
+		return cfg, fmt.Errorf("replication: unknown role %q", cfg.Replication.Role)
+	}
+	if cfg.Shards > 1 && (cfg.Replication.Role != "" || cfg.Raft.NodeID != "" || cfg.TraceDir != "") {
+		return cfg, fmt.Errorf("shards: cannot be combined with replication, raft or traceDir")
+	}
+	if err := validateAPIKeys(cfg.APIKeys); err != nil {
+		return cfg, err
+	}
+	if err := validateRaft(cfg); err != nil {
+		return cfg, err
+	}
+	if err := validateGuardrails(cfg.Guardrails); err != nil {
+		return cfg, err
+	}
+	if err := ValidateClasses(cfg.PriorityClasses, cfg.TotalPriority); err != nil {
+		return cfg, err
+	}
 	return cfg, nil
 }
+
+func validateAPIKeys(keys []APIKey) error {
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if k.Key == "" {
+			return fmt.Errorf("api key %q: key required", k.Name)
+		}
+		if seen[k.Key] {
+			return fmt.Errorf("api key %q: duplicate key", k.Name)
+		}
+		switch k.Role {
+		case RoleReader, RoleOperator, RoleAdmin:
+		default:
+			return fmt.Errorf("api key %q: unknown role %q", k.Name, k.Role)
+		}
+		seen[k.Key] = true
+	}
+	return nil
+}
+
+func validateGuardrails(g Guardrails) error {
+	if g.MaxItems < 0 || g.MaxPercent < 0 || g.MaxPercent > 100 || g.ConfirmTTLSeconds < 0 {
+		return fmt.Errorf("guardrails: maxItems, maxPercent (0..100) and confirmTtlSeconds must not be negative")
+	}
+	for _, s := range g.OperatorSettings {
+		switch s {
+		case SettingAntiStarvation, SettingMaximumWait, SettingPriorities:
+		default:
+			return fmt.Errorf("guardrails: unknown setting %q", s)
+		}
+	}
+	return nil
+}
+
+func validateRaft(cfg Config) error {
+	r := cfg.Raft
+	if r.NodeID == "" {
+		return nil
+	}
+	if cfg.Replication.Role != "" {
+		return fmt.Errorf("raft: cannot be combined with replication")
+	}
+	if r.Addr == "" {
+		return fmt.Errorf("raft: addr required")
+	}
+	if len(r.Peers) == 0 {
+		return nil
+	}
+	for _, p := range r.Peers {
+		if p.ID == r.NodeID {
+			return nil
+		}
+	}
+	return fmt.Errorf("raft: peers must include this node (%s)", r.NodeID)
+}
+
+// ValidateClasses checks that class names are unique and non-numeric, that
+// classes map to distinct levels within 1..totalPriority and use a known curve.
+func ValidateClasses(classes []PriorityClass, totalPriority int) error {
+	names := make(map[string]bool, len(classes))
+	levels := make(map[int]bool, len(classes))
+	for _, c := range classes {
+		if _, err := strconv.Atoi(c.Name); err == nil {
+			return fmt.Errorf("priority class name %q must not be numeric", c.Name)
+		}
+		if c.Level < 1 || c.Level > totalPriority {
+			return fmt.Errorf("priority class %q level %d outside 1..%d", c.Name, c.Level, totalPriority)
+		}
+		if (c.Name != "" && names[c.Name]) || levels[c.Level] {
+			return fmt.Errorf("priority class %q duplicates a name or level", c.Name)
+		}
+		switch c.Curve {
+		case "", CurveLinear, CurveExponential, CurveStep:
+		default:
+			return fmt.Errorf("priority class %q has unknown curve %q", c.Name, c.Curve)
+		}
+		names[c.Name] = true
+		levels[c.Level] = true
+	}
+	return nil
+}
+
+// SaveConfig writes cfg to disk as YAML, replacing the file atomically.
+func SaveConfig(path string, cfg Config) error {
+	b, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
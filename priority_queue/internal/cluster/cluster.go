@@ -0,0 +1,197 @@
+// Package cluster runs a VideoProcessingQueue as the replicated state machine
+// of a Raft group. Every mutation is committed by a majority before it is
+// applied, so a dequeued ad is never handed out again after a failover.
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"icetea/priority_queue/internal/queue"
+	"icetea/priority_queue/internal/raft"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Node is one queue server in a Raft cluster.
+type Node struct {
+	Q    *queue.VideoProcessingQueue
+	Raft *raft.Node
+	// Timeout bounds each proposal, including waiting for a leader.
+	Timeout time.Duration
+}
+
+// New wires q to a Raft node built from cfg; cfg.StateMachine is set here.
+// q only applies committed commands from then on: its public mutations are
+// proposed through Raft, and boost timers run on the leader only.
+func New(q *queue.VideoProcessingQueue, cfg raft.Config) (*Node, error) {
+	n := &Node{Q: q, Timeout: 10 * time.Second}
+	q.SetReplica(true)
+	cfg.StateMachine = stateMachine{q}
+	onLeaderChange := cfg.OnLeaderChange
+	cfg.OnLeaderChange = func(leader bool) {
+		q.SetReplica(!leader)
+		if onLeaderChange != nil {
+			onLeaderChange(leader)
+		}
+	}
+	r, err := raft.NewNode(cfg)
+	if err != nil {
+		return nil, err
+	}
+	n.Raft = r
+	q.SetProposer(n.propose)
+	return n, nil
+}
+
+func (n *Node) Start() { n.Raft.Start() }
+
+func (n *Node) Stop() { n.Raft.Stop() }
+
+// propose replicates cmd and returns the result of applying it on the leader.
+func (n *Node) propose(cmd queue.Command) (queue.Result, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.Timeout)
+	defer cancel()
+
+	if cmd.Op == queue.OpPurge && cmd.Export != nil {
+		// The export callback cannot be replicated. Export what an up-to-date
+		// read finds, then purge exactly those items on every node; items
+		// dequeued in between are skipped by the purge but stay in the export.
+		if err := n.Raft.Barrier(ctx); err != nil {
+			return queue.Result{}, fmt.Errorf("%w: %v", queue.ErrUnavailable, err)
+		}
+		preview := cmd
+		preview.DryRun, preview.Export = true, nil
+		res, err := n.Q.Apply(preview)
+		if err != nil {
+			return res, err
+		}
+		if err := cmd.Export(res.Records); err != nil || len(res.Records) == 0 {
+			return queue.Result{Records: res.Records}, err
+		}
+		cmd.Records, cmd.Export = res.Records, nil
+	}
+
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return queue.Result{}, err
+	}
+	out, err := n.Raft.Apply(ctx, data)
+	if err != nil {
+		return queue.Result{}, fmt.Errorf("%w: %v", queue.ErrUnavailable, err)
+	}
+	var res appliedResult
+	if err := json.Unmarshal(out, &res); err != nil {
+		return queue.Result{}, err
+	}
+	return res.Result, decodeError(res.Error)
+}
+
+type appliedResult struct {
+	Result queue.Result `json:"result"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// stateMachine applies committed commands to the queue.
+type stateMachine struct {
+	q *queue.VideoProcessingQueue
+}
+
+func (s stateMachine) Apply(data []byte) []byte {
+	var out appliedResult
+	var cmd queue.Command
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		out.Error = err.Error()
+	} else if res, err := s.q.Apply(cmd); err != nil {
+		out.Error = err.Error()
+	} else {
+		out.Result = res
+	}
+	b, _ := json.Marshal(out)
+	return b
+}
+
+func (s stateMachine) Snapshot() ([]byte, error) {
+	return json.Marshal(s.q.Snapshot())
+}
+
+func (s stateMachine) Restore(data []byte) error {
+	var snap queue.Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+	s.q.Restore(snap)
+	return nil
+}
+
+// decodeError restores the queue's sentinel errors so callers can still use
+// errors.Is on results that crossed the network.
+func decodeError(msg string) error {
+	if msg == "" {
+		return nil
+	}
+	for _, sentinel := range []error{
+		queue.ErrCapacityExceeded,
+		queue.ErrUnknownPriority,
+		queue.ErrClassOutOfRange,
+		queue.ErrDraining,
+		queue.ErrUnknownOp,
+	} {
+		if rest, ok := strings.CutPrefix(msg, sentinel.Error()); ok {
+			return fmt.Errorf("%w%s", sentinel, rest)
+		}
+	}
+	return errors.New(msg)
+}
+
+// MemberRequest is the body of POST /cluster/members.
+type MemberRequest struct {
+	ID   string `json:"id"`
+	Addr string `json:"addr"`
+}
+
+func (n *Node) ServeStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, n.Raft.Status())
+}
+
+func (n *Node) ServeAddMember(w http.ResponseWriter, r *http.Request) {
+	var req MemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" || req.Addr == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "id and addr required"})
+		return
+	}
+	n.changeMembership(w, r, func(ctx context.Context) error {
+		return n.Raft.AddServer(ctx, raft.Server{ID: req.ID, Addr: req.Addr})
+	})
+}
+
+func (n *Node) ServeRemoveMember(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	n.changeMembership(w, r, func(ctx context.Context) error {
+		return n.Raft.RemoveServer(ctx, id)
+	})
+}
+
+func (n *Node) changeMembership(w http.ResponseWriter, r *http.Request, change func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(r.Context(), n.Timeout)
+	defer cancel()
+	err := change(ctx)
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, n.Raft.Status())
+	case errors.Is(err, raft.ErrServerExists), errors.Is(err, raft.ErrConfigChangePending):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, raft.ErrUnknownServer):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	default:
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,214 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"icetea/priority_queue/config"
+	"icetea/priority_queue/internal/ads"
+	"icetea/priority_queue/internal/queue"
+	"icetea/priority_queue/internal/raft"
+)
+
+var testConfig = config.Config{
+	TotalPriority:        3,
+	EnableAntiStarvation: true,
+	MaximumWaitSeconds:   600,
+	BTreeDegree:          16,
+	TimeBoost:            2,
+}
+
+func newAd(id, family string, prio int) *ads.Ad {
+	return &ads.Ad{AdID: id, Title: "t-" + id, GameFamily: family, Priority: prio, MaxWaitTime: 600}
+}
+
+// startCluster runs size queue nodes over an in-process network.
+func startCluster(t *testing.T, size int) (*raft.Network, []string, map[string]*Node) {
+	t.Helper()
+	nw := raft.NewNetwork()
+	nodes := make(map[string]*Node)
+	var ids []string
+	var servers []raft.Server
+	for i := 1; i <= size; i++ {
+		id := fmt.Sprintf("n%d", i)
+		ids = append(ids, id)
+		servers = append(servers, raft.Server{ID: id})
+	}
+	for _, id := range ids {
+		n, err := New(queue.NewFromConfig(testConfig), raft.Config{
+			ID:                id,
+			Transport:         nw.Transport(id),
+			ElectionTimeout:   100 * time.Millisecond,
+			HeartbeatInterval: 20 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.Timeout = 2 * time.Second
+		nw.Register(id, n.Raft)
+		if err := n.Raft.Bootstrap(servers); err != nil {
+			t.Fatal(err)
+		}
+		n.Start()
+		nodes[id] = n
+	}
+	t.Cleanup(func() {
+		for _, n := range nodes {
+			n.Stop()
+		}
+	})
+	return nw, ids, nodes
+}
+
+func leaderOf(t *testing.T, nodes map[string]*Node, ids ...string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, id := range ids {
+			if nodes[id].Raft.IsLeader() {
+				return id
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no leader among %v", ids)
+	return ""
+}
+
+func exportJSON(q *queue.VideoProcessingQueue) string {
+	b, _ := json.Marshal(q.Export())
+	return string(b)
+}
+
+// waitSame waits until every node in ids holds the same queue.
+func waitSame(t *testing.T, nodes map[string]*Node, ids ...string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		want := exportJSON(nodes[ids[0]].Q)
+		same := true
+		for _, id := range ids[1:] {
+			if exportJSON(nodes[id].Q) != want {
+				same = false
+			}
+		}
+		if same {
+			return want
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("queues did not converge")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCluster_FollowerWritesAreForwarded(t *testing.T) {
+	_, ids, nodes := startCluster(t, 3)
+	leader := leaderOf(t, nodes, ids...)
+	var follower string
+	for _, id := range ids {
+		if id != leader {
+			follower = id
+			break
+		}
+	}
+	fq := nodes[follower].Q
+
+	for i := 0; i < 6; i++ {
+		if err := fq.Enqueue(newAd(fmt.Sprintf("A%d", i), []string{"RPG", "Puzzle"}[i%2], i%3+1)); err != nil {
+			t.Fatalf("enqueue via follower: %v", err)
+		}
+	}
+	fq.ReprioritizeByGameFamily("RPG", 3)
+	if ad := fq.Dequeue(); ad == nil || ad.AdID != "A0" {
+		t.Fatalf("dequeue via follower: %+v", ad)
+	}
+	waitSame(t, nodes, ids...)
+
+	// Queue errors survive the trip to the leader and back.
+	fq.SetDraining(true)
+	if err := fq.Enqueue(newAd("X", "RPG", 1)); !errors.Is(err, queue.ErrDraining) {
+		t.Fatalf("want ErrDraining, got %v", err)
+	}
+	fq.SetDraining(false)
+
+	// Purge exports on the proposing node, then removes the same items everywhere.
+	var exported []queue.ItemRecord
+	recs, err := fq.Purge(queue.FamilyFilter("Puzzle"), func(r []queue.ItemRecord) error {
+		exported = r
+		return nil
+	})
+	if err != nil || len(recs) != 3 || len(exported) != 3 {
+		t.Fatalf("purge: %d removed, %d exported, %v", len(recs), len(exported), err)
+	}
+	waitSame(t, nodes, ids...)
+	if n := len(nodes[leader].Q.PeekNext(10)); n != 2 {
+		t.Fatalf("leader has %d ads after purge, want 2", n)
+	}
+}
+
+func TestCluster_NoDoubleDeliveryAcrossFailover(t *testing.T) {
+	nw, ids, nodes := startCluster(t, 5)
+	leader := leaderOf(t, nodes, ids...)
+
+	const total = 40
+	for i := 0; i < total; i++ {
+		if err := nodes[leader].Q.Enqueue(newAd(fmt.Sprintf("A%02d", i), "RPG", 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mu sync.Mutex
+	delivered := make(map[string]string)
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				res, err := nodes[id].Q.Submit(queue.Command{Op: queue.OpDequeue})
+				if err != nil || res.Ad == nil {
+					continue
+				}
+				mu.Lock()
+				if prev, dup := delivered[res.Ad.AdID]; dup {
+					t.Errorf("%s delivered by %s and %s", res.Ad.AdID, prev, id)
+				}
+				delivered[res.Ad.AdID] = id
+				mu.Unlock()
+			}
+		}(id)
+	}
+
+	// Cut the leader off with one follower while dequeues are in flight.
+	time.Sleep(20 * time.Millisecond)
+	var minority, majority []string
+	for _, id := range ids {
+		if id == leader || (len(minority) == 1 && id != leader) {
+			minority = append(minority, id)
+		} else {
+			majority = append(majority, id)
+		}
+	}
+	nw.Partition(minority, majority)
+	leaderOf(t, nodes, majority...)
+	time.Sleep(200 * time.Millisecond)
+	nw.Heal()
+	wg.Wait()
+
+	state := waitSame(t, nodes, ids...)
+	var remaining []queue.ItemRecord
+	_ = json.Unmarshal([]byte(state), &remaining)
+	for _, r := range remaining {
+		if by, ok := delivered[r.Ad.AdID]; ok {
+			t.Errorf("%s was delivered by %s but is still queued", r.Ad.AdID, by)
+		}
+	}
+	if len(delivered)+len(remaining) > total {
+		t.Fatalf("%d delivered + %d queued > %d enqueued", len(delivered), len(remaining), total)
+	}
+}
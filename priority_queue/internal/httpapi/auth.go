@@ -0,0 +1,132 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"icetea/priority_queue/config"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Role is what an API key may do. Each role includes the ones below it.
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleReader
+	RoleOperator
+	RoleAdmin
+)
+
+func ParseRole(s string) Role {
+	switch s {
+	case config.RoleReader:
+		return RoleReader
+	case config.RoleOperator:
+		return RoleOperator
+	case config.RoleAdmin:
+		return RoleAdmin
+	}
+	return RoleNone
+}
+
+func (r Role) String() string {
+	switch r {
+	case RoleReader:
+		return config.RoleReader
+	case RoleOperator:
+		return config.RoleOperator
+	case RoleAdmin:
+		return config.RoleAdmin
+	}
+	return "none"
+}
+
+type roleKey struct{}
+
+// RoleFrom returns the role the request was authenticated with. Without
+// configured API keys every request is admin.
+func RoleFrom(ctx context.Context) Role {
+	if r, ok := ctx.Value(roleKey{}).(Role); ok {
+		return r
+	}
+	return RoleAdmin
+}
+
+// requiredRole maps a request to the least role allowed to make it.
+// Settings changes, maintenance and membership changes are admin-only, and
+// so are maintenance reads such as export; other reads (GET /settings too)
+// need reader and everything else operator. operatorSettings opens some
+// settings up to operators. /mcp and /command need any valid key; what they
+// run is checked against its own route. Node-to-node traffic (Raft RPCs and
+// the replication stream) and health checks are not authenticated and should
+// stay on a private network; nor is the OpenAPI document.
+func requiredRole(r *http.Request, operatorSettings []string) Role {
+	path := r.URL.Path
+	switch {
+	case path == "/healthz", path == "/openapi.json", strings.HasPrefix(path, "/raft/"),
+		path == "/replication/log", path == "/replication/snapshot":
+		return RoleNone
+	case strings.HasPrefix(path, "/settings/"):
+		return settingRole(operatorSettings, strings.TrimPrefix(path, "/settings/"))
+	case strings.HasPrefix(path, "/admin/"),
+		strings.HasPrefix(path, "/cluster/"), strings.HasPrefix(path, "/replication/"):
+		if r.Method == http.MethodGet && (path == "/cluster/status" || path == "/replication/status" ||
+			path == "/admin/pause" || path == "/admin/drain") {
+			return RoleReader
+		}
+		return RoleAdmin
+	case r.Method == http.MethodGet, path == "/mcp", path == "/command":
+		return RoleReader
+	}
+	return RoleOperator
+}
+
+// settingRole is the role needed to change setting. Turning anti-starvation
+// off is admin-only even when operators may change the setting; the handler
+// checks that, as it depends on the body.
+func settingRole(operatorSettings []string, setting string) Role {
+	if slices.Contains(operatorSettings, setting) {
+		return RoleOperator
+	}
+	return RoleAdmin
+}
+
+// apiKey returns the key sent as a bearer token or in X-API-Key.
+func apiKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// authenticate checks the API key against keys and the role it grants
+// against the route, answering 401 for a missing or unknown key and 403 for
+// a role that is too low.
+func authenticate(keys []config.APIKey, operatorSettings []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		need := requiredRole(r, operatorSettings)
+		if need == RoleNone {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sent := apiKey(r)
+		role := RoleNone
+		for _, k := range keys {
+			// Compare every key so timing does not reveal which one matched.
+			if subtle.ConstantTimeCompare([]byte(sent), []byte(k.Key)) == 1 {
+				role = ParseRole(k.Role)
+			}
+		}
+		switch {
+		case role == RoleNone:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="priority_queue"`)
+			writeErr(w, http.StatusUnauthorized, "missing or invalid API key")
+		case role < need:
+			writeErr(w, http.StatusForbidden, "requires the "+need.String()+" role")
+		default:
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), roleKey{}, role)))
+		}
+	})
+}
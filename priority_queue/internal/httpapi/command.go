@@ -0,0 +1,149 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"icetea/priority_queue/config"
+	"icetea/priority_queue/internal/nlcmd"
+	"icetea/priority_queue/internal/queue"
+	"net/http"
+	"strings"
+)
+
+// Command runs a plain-English command parsed by nlcmd. Queries run at
+// once. A change is only previewed, with the ads it would move or the
+// current settings, until the text is sent again with "confirm": true; a
+// reprioritize over the guardrails also needs the confirm token from the
+// preview. Text the parser does not understand gets understood: false and
+// example phrasings, so the caller can rephrase or hand it to an LLM agent.
+func (h *Handler) Command(w http.ResponseWriter, r *http.Request) {
+	var req CommandRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if strings.TrimSpace(req.Text) == "" {
+		writeErr(w, http.StatusBadRequest, "text required")
+		return
+	}
+	cmd, err := nlcmd.Parse(req.Text)
+	if err != nil {
+		writeJSON(w, http.StatusOK, CommandResponse{
+			Text:     req.Text,
+			Message:  "not understood; rephrase like one of the examples or pass the text to an LLM agent",
+			Examples: nlcmd.Examples,
+		})
+		return
+	}
+	// /command itself only needs a reader key; the command needs what its
+	// REST route would.
+	if need := h.commandRole(cmd); RoleFrom(r.Context()) < need {
+		writeErr(w, http.StatusForbidden, "requires the "+need.String()+" role")
+		return
+	}
+
+	resp := CommandResponse{Understood: true, Text: req.Text, Command: &cmd, Summary: cmd.String()}
+	switch cmd.Action {
+	case nlcmd.Peek:
+		resp.Result = h.Q.PeekNext(cmd.N)
+	case nlcmd.Waiting:
+		resp.Result = h.Q.ListWaitingLongerThan(cmd.AgeDuration())
+	case nlcmd.Distribution:
+		resp.Result = h.distribution()
+	}
+	if cmd.ReadOnly() {
+		resp.Executed = true
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	change, ok := h.commandChange(w, cmd)
+	if !ok {
+		return
+	}
+	reprioritize := cmd.Action == nlcmd.ReprioritizeFamily || cmd.Action == nlcmd.ReprioritizeAge
+	if !req.Confirm {
+		if reprioritize {
+			res, ok := h.submit(w, preview(change))
+			if !ok {
+				return
+			}
+			resp.Affected = &BulkResponse{DryRun: true, Count: len(res.AdIDs), AdIDs: res.AdIDs, Skipped: res.Skipped}
+			// Over the guardrails the confirm needs a token; hand it out
+			// with the preview.
+			if _, over := h.overLimits(len(res.AdIDs)); over {
+				resp.ConfirmToken, _ = h.issueConfirm(change, len(res.AdIDs))
+			}
+		} else {
+			settings := h.settings()
+			resp.Settings = &settings
+		}
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	if reprioritize {
+		res, ok := h.submitGuarded(w, change, confirmToken(r, req.ConfirmToken))
+		if !ok {
+			return
+		}
+		resp.Affected = &BulkResponse{Count: len(res.AdIDs), AdIDs: res.AdIDs, Skipped: res.Skipped}
+	} else {
+		if _, ok := h.submit(w, change); !ok {
+			return
+		}
+		settings := h.settings() // as changed
+		resp.Settings = &settings
+	}
+	switch cmd.Action {
+	case nlcmd.SetAntiStarvation:
+		h.persistSettings(func(cfg *config.Config) { cfg.EnableAntiStarvation = *cmd.Enable })
+	case nlcmd.SetMaximumWait:
+		h.persistSettings(func(cfg *config.Config) { cfg.MaximumWaitSeconds = cmd.MaximumWait })
+	}
+	resp.Executed = true
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// commandRole is the role the REST route for cmd requires.
+func (h *Handler) commandRole(cmd nlcmd.Command) Role {
+	ops := h.Cfg.Guardrails.OperatorSettings
+	switch cmd.Action {
+	case nlcmd.SetAntiStarvation:
+		if !*cmd.Enable {
+			return RoleAdmin
+		}
+		return settingRole(ops, config.SettingAntiStarvation)
+	case nlcmd.SetMaximumWait:
+		return settingRole(ops, config.SettingMaximumWait)
+	case nlcmd.ReprioritizeFamily, nlcmd.ReprioritizeAge:
+		return RoleOperator
+	}
+	return RoleReader
+}
+
+// commandChange builds the queue command for a change, the same one its
+// REST handler submits. It writes a 400 for an unknown priority class.
+func (h *Handler) commandChange(w http.ResponseWriter, cmd nlcmd.Command) (queue.Command, bool) {
+	switch cmd.Action {
+	case nlcmd.SetAntiStarvation:
+		return queue.Command{Op: queue.OpSetAntiStarvation, Enable: *cmd.Enable}, true
+	case nlcmd.SetMaximumWait:
+		return queue.Command{Op: queue.OpSetMaximumWait, Value: cmd.MaximumWait}, true
+	}
+
+	if cmd.Delta != 0 {
+		f := queue.FamilyFilter(cmd.Family)
+		if cmd.Action == nlcmd.ReprioritizeAge {
+			f = queue.OlderThanFilter(cmd.AgeDuration())
+		}
+		return queue.Command{Op: queue.OpBulkAdjust, Filter: f, Delta: cmd.Delta}, true
+	}
+	p, ok := h.resolvePriority(w, PriorityRef(cmd.NewPriority))
+	if !ok {
+		return queue.Command{}, false
+	}
+	if cmd.Action == nlcmd.ReprioritizeAge {
+		return queue.Command{Op: queue.OpReprioritizeAge, Age: cmd.AgeDuration(), Priority: p}, true
+	}
+	return queue.Command{Op: queue.OpReprioritizeFamily, Family: cmd.Family, Priority: p}, true
+}
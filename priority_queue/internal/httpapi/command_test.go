@@ -0,0 +1,201 @@
+package httpapi_test
+
+import (
+	"encoding/json"
+	"icetea/priority_queue/config"
+	"icetea/priority_queue/internal/httpapi"
+	"icetea/priority_queue/internal/queue"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// commandServer serves h with ads from two families queued at priority 1:
+// three RPG and one Puzzle.
+func commandServer(t *testing.T, h *httpapi.Handler) (*httptest.Server, *queue.VideoProcessingQueue) {
+	t.Helper()
+	q := queue.NewFromConfig(h.Cfg)
+	h.Q = q
+	srv := httptest.NewServer(h.Router())
+	t.Cleanup(srv.Close)
+	enqueueAds(t, q, "RPG", 3)
+	enqueueAds(t, q, "Puzzle", 1)
+	return srv, q
+}
+
+// command posts body to /v1/command with key, if any, and decodes a 200.
+func command(t *testing.T, srv *httptest.Server, key, body string) (int, httpapi.CommandResponse) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/command", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set("X-API-Key", key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out httpapi.CommandResponse
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode, out
+}
+
+func levelCount(q *queue.VideoProcessingQueue, level int) int {
+	dist, _ := q.DistributionByPriority()
+	for _, d := range dist {
+		if d.Priority == level {
+			return d.Count
+		}
+	}
+	return 0
+}
+
+func TestCommand_Queries(t *testing.T) {
+	srv, _ := commandServer(t, &httpapi.Handler{Cfg: testConfig})
+
+	status, resp := command(t, srv, "", `{"text":"Show the next 2 ads"}`)
+	if ads, _ := resp.Result.([]any); status != http.StatusOK || !resp.Understood || !resp.Executed || len(ads) != 2 {
+		t.Fatalf("peek = %d %+v, want 2 ads", status, resp)
+	}
+	status, resp = command(t, srv, "", `{"text":"make me a sandwich"}`)
+	if status != http.StatusOK || resp.Understood || resp.Executed || len(resp.Examples) == 0 {
+		t.Fatalf("unknown text = %d %+v, want understood false with examples", status, resp)
+	}
+	if status, _ := command(t, srv, "", `{"text":"   "}`); status != http.StatusBadRequest {
+		t.Fatalf("blank text = %d, want 400", status)
+	}
+}
+
+func TestCommand_PreviewAndConfirm(t *testing.T) {
+	srv, q := commandServer(t, &httpapi.Handler{Cfg: testConfig})
+	text := "move the RPG family to priority 3"
+
+	status, resp := command(t, srv, "", `{"text":"`+text+`"}`)
+	if status != http.StatusOK || resp.Executed || resp.Affected == nil || !resp.Affected.DryRun || resp.Affected.Count != 3 {
+		t.Fatalf("preview = %d %+v, want a dry run moving 3 ads", status, resp)
+	}
+	if n := levelCount(q, 3); n != 0 {
+		t.Fatalf("preview moved %d ads", n)
+	}
+
+	status, resp = command(t, srv, "", `{"text":"`+text+`","confirm":true}`)
+	if status != http.StatusOK || !resp.Executed || resp.Affected == nil || resp.Affected.DryRun || resp.Affected.Count != 3 {
+		t.Fatalf("confirm = %d %+v, want 3 ads moved", status, resp)
+	}
+	if n := levelCount(q, 3); n != 3 {
+		t.Fatalf("%d ads at priority 3 after confirm, want 3", n)
+	}
+
+	// The command's moves can be undone like a REST reprioritize.
+	undo, err := http.Post(srv.URL+"/v1/bulk/undo", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	undo.Body.Close()
+	if undo.StatusCode != http.StatusOK || levelCount(q, 3) != 0 {
+		t.Fatalf("undo = %d with %d ads left at priority 3", undo.StatusCode, levelCount(q, 3))
+	}
+}
+
+func TestCommand_ConfirmTokenOverGuardrails(t *testing.T) {
+	cfg := testConfig
+	cfg.Guardrails = config.Guardrails{MaxItems: 2}
+	srv, q := commandServer(t, &httpapi.Handler{Cfg: cfg})
+
+	// Under the limit: no token needed.
+	status, resp := command(t, srv, "", `{"text":"move the Puzzle family to priority 2","confirm":true}`)
+	if status != http.StatusOK || !resp.Executed || resp.ConfirmToken != "" {
+		t.Fatalf("change under the limit = %d %+v", status, resp)
+	}
+
+	text := "move the RPG family to priority 3"
+	status, resp = command(t, srv, "", `{"text":"`+text+`"}`)
+	if status != http.StatusOK || resp.Executed || resp.ConfirmToken == "" {
+		t.Fatalf("preview over the limit = %d %+v, want a confirm token", status, resp)
+	}
+	token := resp.ConfirmToken
+	if status, _ := command(t, srv, "", `{"text":"`+text+`","confirm":true}`); status != http.StatusPreconditionRequired {
+		t.Fatalf("confirm without the token = %d, want 428", status)
+	}
+	if status, _ := command(t, srv, "", `{"text":"move the Puzzle family to priority 3","confirm":true,"confirmToken":"`+token+`"}`); status != http.StatusOK {
+		t.Fatalf("token used for another change under the limit = %d, want 200", status)
+	}
+	if n := levelCount(q, 3); n != 1 {
+		t.Fatalf("%d ads at priority 3, want only the Puzzle ad", n)
+	}
+	status, resp = command(t, srv, "", `{"text":"`+text+`","confirm":true,"confirmToken":"`+token+`"}`)
+	if status != http.StatusOK || !resp.Executed || resp.Affected.Count != 3 {
+		t.Fatalf("confirm with the token = %d %+v, want 3 ads moved", status, resp)
+	}
+}
+
+func TestCommand_Roles(t *testing.T) {
+	cfg := testConfig
+	cfg.APIKeys = []config.APIKey{
+		{Name: "r", Key: "reader-key", Role: config.RoleReader},
+		{Name: "o", Key: "operator-key", Role: config.RoleOperator},
+		{Name: "a", Key: "admin-key", Role: config.RoleAdmin},
+	}
+	cfg.Guardrails.OperatorSettings = []string{config.SettingMaximumWait}
+	srv, _ := commandServer(t, &httpapi.Handler{Cfg: cfg})
+
+	tests := []struct {
+		key, text string
+		status    int
+	}{
+		{"", "Show the next 5 ads", http.StatusUnauthorized},
+		{"reader-key", "Show the next 5 ads", http.StatusOK},
+		{"reader-key", "move the RPG family to priority 3", http.StatusForbidden},
+		{"operator-key", "move the RPG family to priority 3", http.StatusOK},
+		{"reader-key", "set max wait to 2 minutes", http.StatusForbidden},
+		{"operator-key", "set max wait to 2 minutes", http.StatusOK},
+		{"operator-key", "Disable starvation mode", http.StatusForbidden},
+		{"operator-key", "turn anti-starvation off", http.StatusForbidden},
+		{"admin-key", "turn anti-starvation off", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if status, resp := command(t, srv, tt.key, `{"text":"`+tt.text+`"}`); status != tt.status {
+			t.Errorf("%q with %q = %d %+v, want %d", tt.text, tt.key, status, resp, tt.status)
+		}
+	}
+}
+
+func TestCommand_Settings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	cfg := testConfig
+	cfg.EnableAntiStarvation = true
+	if err := config.SaveConfig(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+	srv, q := commandServer(t, &httpapi.Handler{Cfg: cfg, ConfigPath: path})
+
+	// A preview shows the settings as they are and changes nothing.
+	status, resp := command(t, srv, "", `{"text":"set max wait to 2 minutes"}`)
+	if status != http.StatusOK || resp.Executed || resp.Settings == nil || resp.Settings.MaximumWait != 600 || q.MaximumWaitTime() != 600 {
+		t.Fatalf("preview = %d %+v, want max wait still 600", status, resp)
+	}
+
+	// Once executed, the response and the saved config carry the new values.
+	status, resp = command(t, srv, "", `{"text":"set max wait to 2 minutes","confirm":true}`)
+	if status != http.StatusOK || !resp.Executed || resp.Settings == nil || resp.Settings.MaximumWait != 120 || q.MaximumWaitTime() != 120 {
+		t.Fatalf("set max wait = %d %+v, want 120", status, resp)
+	}
+	status, resp = command(t, srv, "", `{"text":"turn anti-starvation off","confirm":true}`)
+	if status != http.StatusOK || !resp.Executed || resp.Settings == nil || resp.Settings.EnableAntiStarvation || q.IsEnableAntiStarvation() {
+		t.Fatalf("anti-starvation off = %d %+v, want it off", status, resp)
+	}
+	saved, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.MaximumWaitSeconds != 120 || saved.EnableAntiStarvation {
+		t.Fatalf("saved config has maximumWait %d, antiStarvation %v; want 120, false", saved.MaximumWaitSeconds, saved.EnableAntiStarvation)
+	}
+}
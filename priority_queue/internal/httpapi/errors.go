@@ -0,0 +1,154 @@
+package httpapi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrorCode is the machine-readable reason for a /v1 error.
+type ErrorCode string
+
+const (
+	CodeInvalidRequest   ErrorCode = "INVALID_REQUEST"
+	CodeInvalidDuration  ErrorCode = "INVALID_DURATION"
+	CodeInvalidFilter    ErrorCode = "INVALID_FILTER"
+	CodeInvalidPriority  ErrorCode = "INVALID_PRIORITY"
+	CodeUnauthorized     ErrorCode = "UNAUTHORIZED"
+	CodeForbidden        ErrorCode = "FORBIDDEN"
+	CodeNotFound         ErrorCode = "NOT_FOUND"
+	CodeQueueEmpty       ErrorCode = "QUEUE_EMPTY" // unversioned routes only; /v1 answers 204
+	CodeMethodNotAllowed ErrorCode = "METHOD_NOT_ALLOWED"
+	CodeConflict         ErrorCode = "CONFLICT"
+	CodeBodyTooLarge     ErrorCode = "BODY_TOO_LARGE"
+	CodeConfirmRequired  ErrorCode = "CONFIRM_REQUIRED"
+	CodeRateLimited      ErrorCode = "RATE_LIMITED"
+	CodeUnavailable      ErrorCode = "UNAVAILABLE"
+	CodeInternal         ErrorCode = "INTERNAL"
+)
+
+// ErrorCodes lists every code, for the OpenAPI document.
+var ErrorCodes = []ErrorCode{
+	CodeInvalidRequest, CodeInvalidDuration, CodeInvalidFilter, CodeInvalidPriority,
+	CodeUnauthorized, CodeForbidden, CodeNotFound, CodeQueueEmpty, CodeMethodNotAllowed,
+	CodeConflict, CodeBodyTooLarge, CodeConfirmRequired, CodeRateLimited, CodeUnavailable, CodeInternal,
+}
+
+// codeFor is the code of an error that has no more specific one.
+func codeFor(status int) ErrorCode {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusRequestEntityTooLarge:
+		return CodeBodyTooLarge
+	case http.StatusPreconditionRequired:
+		return CodeConfirmRequired
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	}
+	return CodeInternal
+}
+
+// writeError answers with an error. Under /v1 the body is an ErrorEnvelope;
+// on the unversioned routes it keeps the shape those always had, with the
+// details of a validation or guardrail error flattened into it.
+func writeError(w http.ResponseWriter, status int, code ErrorCode, msg string, details any) {
+	if v, ok := w.(*v1Writer); ok {
+		writeJSON(w, status, ErrorEnvelope{Code: code, Message: msg, Details: details, RequestID: v.requestID})
+		return
+	}
+	switch d := details.(type) {
+	case ValidationDetails:
+		writeJSON(w, status, ErrorResponse{Error: msg, Fields: d.Fields})
+	case ConfirmDetails:
+		writeJSON(w, status, ConfirmResponse{Error: msg, ConfirmDetails: d})
+	default:
+		writeJSON(w, status, ErrorResponse{Error: msg})
+	}
+}
+
+// v1Writer marks a response to a /v1 request.
+type v1Writer struct {
+	http.ResponseWriter
+	requestID string
+}
+
+func (w *v1Writer) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *v1Writer) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// isV1 reports whether w answers a /v1 request.
+func isV1(w http.ResponseWriter) bool {
+	_, ok := w.(*v1Writer)
+	return ok
+}
+
+// versioned serves the API under /v1 by stripping the prefix, so the routes,
+// roles and validation are shared; errors there get an ErrorEnvelope and
+// every response an X-Request-ID. The unversioned routes still work but are
+// deprecated, except /healthz and /openapi.json. Other routes (MCP, Raft,
+// cluster and replication) are not versioned.
+func versioned(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, ok := strings.CutPrefix(r.URL.Path, "/v1")
+		if !ok || !strings.HasPrefix(path, "/") {
+			if op, _ := findOperation(r.Method, r.URL.Path); op != nil && op.path != "/healthz" && op.path != "/openapi.json" {
+				w.Header().Set("Deprecation", "true")
+				w.Header().Set("Link", `</v1`+r.URL.Path+`>; rel="successor-version"`)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		vw := &v1Writer{ResponseWriter: w, requestID: requestID(r)}
+		w.Header().Set("X-Request-ID", vw.requestID)
+		method := r.Method
+		if method == http.MethodHead {
+			method = http.MethodGet
+		}
+		if op, _ := findOperation(method, path); op == nil {
+			if hasPath(path) {
+				writeError(vw, http.StatusMethodNotAllowed, CodeMethodNotAllowed, r.Method+" not allowed on /v1"+path, nil)
+			} else {
+				writeError(vw, http.StatusNotFound, CodeNotFound, "no route /v1"+path, nil)
+			}
+			return
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = path
+		r2.URL.RawPath = ""
+		next.ServeHTTP(vw, r2)
+	})
+}
+
+// requestID is the caller's X-Request-ID if it sent a usable one, else a
+// new random one.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" && len(id) <= 128 && !strings.ContainsAny(id, "\r\n") {
+		return id
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
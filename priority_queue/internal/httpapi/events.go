@@ -0,0 +1,59 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Events streams a DashboardEvent as server-sent events right away and then
+// every ?interval= (default 1s, at least 250ms) until the client goes away.
+func (h *Handler) Events(w http.ResponseWriter, r *http.Request) {
+	interval := time.Second
+	if s := r.URL.Query().Get("interval"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 250*time.Millisecond {
+			writeError(w, http.StatusBadRequest, CodeInvalidDuration, "interval must be a duration of at least 250ms", nil)
+			return
+		}
+		interval = d
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // let proxies pass events through
+	w.WriteHeader(http.StatusOK)
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		b, err := json.Marshal(h.dashboardEvent())
+		if err != nil {
+			return
+		}
+		if _, err := w.Write([]byte("event: snapshot\ndata: " + string(b) + "\n\n")); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+func (h *Handler) dashboardEvent() DashboardEvent {
+	dist, total := h.Q.DistributionByPriority()
+	return DashboardEvent{
+		Time:                 h.Q.Now(),
+		Total:                total,
+		Distribution:         dist,
+		EnableAntiStarvation: h.Q.IsEnableAntiStarvation(),
+		Stats:                h.Q.Stats(),
+		Heads:                h.Q.HeadScores(),
+	}
+}
@@ -0,0 +1,215 @@
+package httpapi
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"icetea/priority_queue/internal/queue"
+	"net/http"
+	"time"
+)
+
+// confirmation is an outstanding confirm token. It runs the change it was
+// issued for once, affecting at most count ads.
+type confirmation struct {
+	change  [sha256.Size]byte
+	count   int
+	expires time.Time
+}
+
+// preview is a dry run of cmd that reports the ads it would affect.
+func preview(cmd queue.Command) queue.Command {
+	switch cmd.Op {
+	case queue.OpReprioritizeFamily:
+		cmd = queue.Command{Op: queue.OpBulkReprioritize, Filter: queue.FamilyFilter(cmd.Family), Priority: cmd.Priority}
+	case queue.OpReprioritizeAge:
+		cmd = queue.Command{Op: queue.OpBulkReprioritize, Filter: queue.OlderThanFilter(cmd.Age), Priority: cmd.Priority}
+	}
+	cmd.DryRun = true
+	cmd.Export = nil
+	return cmd
+}
+
+// overGuardrails counts the ads cmd would affect and reports whether that
+// is over the configured limits. It writes an error if the dry run fails.
+func (h *Handler) overGuardrails(w http.ResponseWriter, cmd queue.Command) (count, total int, over, ok bool) {
+	g := h.Cfg.Guardrails
+	if g.MaxItems <= 0 && g.MaxPercent <= 0 {
+		return 0, 0, false, true
+	}
+	res, ok := h.submit(w, preview(cmd))
+	if !ok {
+		return 0, 0, false, false
+	}
+	count = len(res.AdIDs) + len(res.Records)
+	total, over = h.overLimits(count)
+	return count, total, over, true
+}
+
+// limits are the guardrails as a queue command limit.
+func (h *Handler) limits() queue.Limit {
+	g := h.Cfg.Guardrails
+	return queue.Limit{MaxItems: g.MaxItems, MaxPercent: g.MaxPercent}
+}
+
+// overLimits reports whether affecting count ads is over the guardrails,
+// and the queue size it was measured against.
+func (h *Handler) overLimits(count int) (total int, over bool) {
+	_, total = h.Q.DistributionByPriority()
+	return total, h.limits().Exceeded(count, total)
+}
+
+// guard checks cmd against the guardrails with a dry run. If it is within
+// them, or token was issued for this exact change, guard returns cmd with
+// the limit the queue enforces when it runs: the guardrails, or the count
+// that was confirmed. The dry run is only a preview; the queue may change
+// before cmd runs. Otherwise guard answers 428 with a new token.
+func (h *Handler) guard(w http.ResponseWriter, cmd queue.Command, token string) (queue.Command, bool) {
+	count, total, over, ok := h.overGuardrails(w, cmd)
+	if !ok {
+		return cmd, false
+	}
+	limit := h.limits()
+	if over {
+		confirmed, ok := h.redeem(token, cmd)
+		if !ok {
+			h.confirmRequired(w, cmd, count, total)
+			return cmd, false
+		}
+		limit = queue.Limit{MaxItems: confirmed}
+	}
+	if limit != (queue.Limit{}) {
+		cmd.Limit = &limit
+	}
+	return cmd, true
+}
+
+// confirmRequired answers 428 with a token for cmd, affecting count ads.
+func (h *Handler) confirmRequired(w http.ResponseWriter, cmd queue.Command, count, total int) {
+	cmd.Limit = nil
+	token, expires := h.issueConfirm(cmd, count)
+	writeError(w, http.StatusPreconditionRequired, CodeConfirmRequired,
+		fmt.Sprintf("would affect %d of %d queued ads; send the request again with confirmToken to proceed", count, total),
+		ConfirmDetails{ConfirmToken: token, ExpiresAt: expires, Count: count, Total: total})
+}
+
+// overLimit answers 428 if err is the queue refusing a guarded command that
+// grew over its limit after the preview, and reports whether it did.
+func (h *Handler) overLimit(w http.ResponseWriter, cmd queue.Command, err error) bool {
+	var le *queue.LimitError
+	if !errors.As(err, &le) {
+		return false
+	}
+	h.confirmRequired(w, cmd, le.Count, le.Total)
+	return true
+}
+
+func fingerprint(cmd queue.Command) [sha256.Size]byte {
+	b, _ := json.Marshal(cmd)
+	return sha256.Sum256(b)
+}
+
+// issueConfirm creates a token for cmd, affecting count ads. Tokens are
+// kept by this node only and are lost on restart.
+func (h *Handler) issueConfirm(cmd queue.Command, count int) (string, time.Time) {
+	ttl := time.Duration(h.Cfg.Guardrails.ConfirmTTLSeconds) * time.Second
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+	now := time.Now()
+
+	h.guardMu.Lock()
+	defer h.guardMu.Unlock()
+	if h.confirms == nil {
+		h.confirms = make(map[string]confirmation)
+	}
+	for t, c := range h.confirms {
+		if now.After(c.expires) {
+			delete(h.confirms, t)
+		}
+	}
+	h.confirms[token] = confirmation{change: fingerprint(cmd), count: count, expires: now.Add(ttl)}
+	return token, now.Add(ttl)
+}
+
+// redeem uses up token if it is unexpired and was issued for cmd, and
+// returns the number of ads that was confirmed.
+func (h *Handler) redeem(token string, cmd queue.Command) (int, bool) {
+	if token == "" {
+		return 0, false
+	}
+	h.guardMu.Lock()
+	defer h.guardMu.Unlock()
+	c, ok := h.confirms[token]
+	if !ok || time.Now().After(c.expires) || c.change != fingerprint(cmd) {
+		return 0, false
+	}
+	delete(h.confirms, token)
+	return c.count, true
+}
+
+// confirmToken returns the token from the request body or, failing that,
+// the X-Confirm-Token header.
+func confirmToken(r *http.Request, body string) string {
+	if body != "" {
+		return body
+	}
+	return r.Header.Get("X-Confirm-Token")
+}
+
+// submitGuarded submits a reprioritize, bulk change or boost after checking it
+// against the guardrails. The moves of a reprioritize are kept for Undo.
+func (h *Handler) submitGuarded(w http.ResponseWriter, cmd queue.Command, token string) (queue.Result, bool) {
+	if cmd.DryRun {
+		return h.submit(w, cmd)
+	}
+	guarded, ok := h.guard(w, cmd, token)
+	if !ok {
+		return queue.Result{}, false
+	}
+	res, err := h.apply(guarded)
+	if err != nil {
+		if !h.overLimit(w, cmd, err) {
+			writeErr(w, queueErrStatus(err, http.StatusBadRequest), err.Error())
+		}
+		return res, false
+	}
+	switch cmd.Op {
+	case queue.OpReprioritizeFamily, queue.OpReprioritizeAge, queue.OpBulkReprioritize, queue.OpBulkAdjust:
+		h.guardMu.Lock()
+		h.undo = &res.Changes
+		h.guardMu.Unlock()
+	}
+	return res, true
+}
+
+// Undo moves the ads of the last reprioritize back to the priorities they
+// had before it. Ads that have left the queue or been moved again since are
+// left alone, and ads whose earlier level is at its class capacity are
+// skipped. Only one reprioritize is kept, so a second undo finds nothing.
+func (h *Handler) Undo(w http.ResponseWriter, r *http.Request) {
+	h.guardMu.Lock()
+	changes := h.undo
+	h.undo = nil
+	h.guardMu.Unlock()
+	if changes == nil {
+		writeErr(w, http.StatusNotFound, "no reprioritize to undo")
+		return
+	}
+	res, ok := h.submit(w, queue.Command{Op: queue.OpRestorePriorities, Changes: *changes})
+	if !ok {
+		h.guardMu.Lock()
+		if h.undo == nil {
+			h.undo = changes
+		}
+		h.guardMu.Unlock()
+		return
+	}
+	writeJSON(w, http.StatusOK, BulkResponse{Count: len(res.AdIDs), AdIDs: res.AdIDs, Skipped: res.Skipped})
+}
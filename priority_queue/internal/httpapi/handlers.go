@@ -1,16 +1,49 @@
 package httpapi
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"icetea/priority_queue/config"
 	"icetea/priority_queue/internal/ads"
+	"icetea/priority_queue/internal/cluster"
 	"icetea/priority_queue/internal/queue"
+	"icetea/priority_queue/internal/replication"
+	"icetea/priority_queue/internal/trace"
+	"log"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 type Handler struct {
-	Q *queue.VideoProcessingQueue
+	Q queue.Queue
+
+	// Optional. When ConfigPath is set, settings changes are saved to it.
+	Cfg        config.Config
+	ConfigPath string
+	cfgMu      sync.Mutex
+
+	// Optional. Serves /replication/* and makes the API read-only while
+	// the node is a follower.
+	Replication *replication.Node
+
+	// Optional. Serves /raft/* and /cluster/*; mutations are proposed
+	// through Raft by the queue itself.
+	Cluster *cluster.Node
+
+	// Optional. Records every API call for cmd/replay.
+	Trace *trace.Recorder
+
+	// Guardrail state: outstanding confirm tokens and the moves of the last
+	// reprioritize, for Undo.
+	guardMu  sync.Mutex
+	confirms map[string]confirmation
+	undo     *[]queue.PriorityChange
 }
 
 func writeJSON(w http.ResponseWriter, code int, v any) {
@@ -19,8 +52,67 @@ func writeJSON(w http.ResponseWriter, code int, v any) {
 	_ = json.NewEncoder(w).Encode(v)
 }
 
-func writeErr(w http.ResponseWriter, code int, msg string) {
-	writeJSON(w, code, ErrorResponse{Error: msg})
+func writeErr(w http.ResponseWriter, status int, msg string) {
+	writeError(w, status, codeFor(status), msg, nil)
+}
+
+// persistSettings applies update to the stored config and saves it when
+// persistence is enabled.
+func (h *Handler) persistSettings(update func(cfg *config.Config)) {
+	h.cfgMu.Lock()
+	defer h.cfgMu.Unlock()
+
+	update(&h.Cfg)
+	if h.ConfigPath == "" {
+		return
+	}
+	if err := config.SaveConfig(h.ConfigPath, h.Cfg); err != nil {
+		log.Printf("persist settings: %v", err)
+	}
+}
+
+// apply submits cmd to the queue, through the trace recorder if one is set.
+func (h *Handler) apply(cmd queue.Command) (queue.Result, error) {
+	if h.Trace != nil {
+		return h.Trace.Submit(h.Q, cmd)
+	}
+	return h.Q.Submit(cmd)
+}
+
+// submit runs a queue mutation, writing an error response if it fails.
+func (h *Handler) submit(w http.ResponseWriter, cmd queue.Command) (queue.Result, bool) {
+	res, err := h.apply(cmd)
+	if err != nil {
+		writeErr(w, queueErrStatus(err, http.StatusBadRequest), err.Error())
+		return res, false
+	}
+	return res, true
+}
+
+func queueErrStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, queue.ErrCapacityExceeded):
+		return http.StatusTooManyRequests
+	case errors.Is(err, queue.ErrDraining), errors.Is(err, queue.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, queue.ErrClassOutOfRange):
+		return http.StatusConflict
+	}
+	return fallback
+}
+
+// resolvePriority maps a PriorityRef to a level, writing a 400 on failure.
+// An empty ref resolves to 0, which the queue normalizes to the lowest level.
+func (h *Handler) resolvePriority(w http.ResponseWriter, ref PriorityRef) (int, bool) {
+	if ref.IsZero() {
+		return 0, true
+	}
+	p, err := h.Q.ResolvePriority(string(ref))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidPriority, err.Error(), nil)
+		return 0, false
+	}
+	return p, true
 }
 
 func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
@@ -33,33 +125,117 @@ func (h *Handler) Enqueue(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
 		return
 	}
+	priority, ok := h.resolvePriority(w, req.Ad.Priority)
+	if !ok {
+		return
+	}
 	ad := &ads.Ad{
 		AdID:           req.Ad.AdID,
 		Title:          req.Ad.Title,
 		GameFamily:     req.Ad.GameFamily,
 		TargetAudience: req.Ad.TargetAudience,
-		Priority:       req.Ad.Priority,
+		Priority:       priority,
 		CreatedAt:      req.Ad.CreatedAt,
 		MaxWaitTime:    req.Ad.MaxWaitTime,
 	}
-	if req.EnqueueAt != nil {
-		h.Q.EnqueueWithTime(ad, *req.EnqueueAt)
-	} else {
-		h.Q.Enqueue(ad)
+	res, ok := h.submit(w, queue.Command{Op: queue.OpEnqueue, Ad: ad, EnqueueAt: req.EnqueueAt})
+	if !ok {
+		return
+	}
+	// The full walk of PositionOf is O(queued ads); enqueues get the cheap
+	// strict-priority estimate and producers can poll /ads/{adId}/position.
+	pos, queued := h.Q.EstimatePosition(res.Seq)
+	if !queued {
+		pos = queue.Position{Seq: res.Seq} // already dequeued
+	}
+	writeJSON(w, http.StatusCreated, QueuedAd{Ad: res.Ad, Position: pos})
+}
+
+// AdPosition reports where a queued ad stands, for producers polling after
+// an enqueue. An ad queued more than once reports its first item.
+func (h *Handler) AdPosition(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("adId")
+	ad, pos, ok := h.Q.PositionOf(id, 0)
+	if !ok {
+		writeErr(w, http.StatusNotFound, "ad "+id+" is not queued")
+		return
 	}
-	writeJSON(w, http.StatusCreated, ad)
+	writeJSON(w, http.StatusOK, QueuedAd{Ad: ad, Position: pos})
 }
 
+// Dequeue hands out the next ad. With ?lease=30s the ad is only leased: it
+// returns to the queue unless acked through /leases/{id}/ack in time. An
+// empty queue is a 204 under /v1 and a 404 on the old route.
 func (h *Handler) Dequeue(w http.ResponseWriter, r *http.Request) {
-	ad := h.Q.Dequeue()
-	if ad == nil {
-		writeErr(w, http.StatusNotFound, "queue empty")
+	var leaseFor time.Duration
+	if s := r.URL.Query().Get("lease"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, CodeInvalidDuration, "invalid lease duration", nil)
+			return
+		}
+		leaseFor = d
+	}
+	res, ok := h.submit(w, queue.Command{Op: queue.OpDequeue, Duration: leaseFor})
+	if !ok {
+		return
+	}
+	ad := res.Ad
+	switch {
+	case ad == nil && isV1(w):
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case ad == nil:
+		writeError(w, http.StatusNotFound, CodeQueueEmpty, "queue empty", nil)
+		return
+	}
+	if leaseFor > 0 {
+		writeJSON(w, http.StatusOK, LeasedAd{Ad: ad, LeaseID: res.Lease.ID, LeaseExpiresAt: res.Lease.ExpiresAt})
 		return
 	}
 	writeJSON(w, http.StatusOK, ad)
 }
 
+func (h *Handler) ListLeases(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.Q.Leases())
+}
+
+func (h *Handler) Ack(w http.ResponseWriter, r *http.Request) {
+	h.endLease(w, r, queue.OpAck)
+}
+
+func (h *Handler) Nack(w http.ResponseWriter, r *http.Request) {
+	h.endLease(w, r, queue.OpNack)
+}
+
+func (h *Handler) endLease(w http.ResponseWriter, r *http.Request, op queue.Op) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid lease id")
+		return
+	}
+	res, ok := h.submit(w, queue.Command{Op: op, LeaseID: id})
+	if !ok {
+		return
+	}
+	if !res.OK {
+		writeErr(w, http.StatusNotFound, "lease not found or expired")
+		return
+	}
+	writeJSON(w, http.StatusOK, OKResponse{OK: true})
+}
+
 func (h *Handler) Peek(w http.ResponseWriter, r *http.Request) {
+	n, ok := peekCount(w, r)
+	if !ok {
+		return
+	}
+	ads := h.Q.PeekNext(n)
+	writeJSON(w, http.StatusOK, ads)
+}
+
+// peekCount reads ?n=, defaulting to 1.
+func peekCount(w http.ResponseWriter, r *http.Request) (int, bool) {
 	nStr := r.URL.Query().Get("n")
 	n := 1
 	if nStr != "" {
@@ -67,25 +243,67 @@ func (h *Handler) Peek(w http.ResponseWriter, r *http.Request) {
 			n = v
 		} else {
 			writeErr(w, http.StatusBadRequest, "invalid n")
-			return
+			return 0, false
 		}
 	}
-	ads := h.Q.PeekNext(n)
-	writeJSON(w, http.StatusOK, ads)
+	return n, true
 }
 
 func (h *Handler) Distribution(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.distribution())
+}
+
+func (h *Handler) distribution() DistributionResponse {
 	dist, total := h.Q.DistributionByPriority()
-	resp := struct {
-		Total                int                  `json:"total"`
-		Dist                 []queue.PriorityDist `json:"distribution"`
-		EnableAntiStarvation bool                 `json:"enable_anti_starvation"`
-	}{
+	return DistributionResponse{
 		Total:                total,
 		Dist:                 dist,
 		EnableAntiStarvation: h.Q.IsEnableAntiStarvation(),
+		TotalPriority:        len(dist),
+	}
+}
+
+func (h *Handler) DebugScores(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.Q.HeadScores())
+}
+
+// DebugNext lists the next n ads with their wait, deadline and score.
+func (h *Handler) DebugNext(w http.ResponseWriter, r *http.Request) {
+	n, ok := peekCount(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.Q.PeekScored(n))
+}
+
+func (h *Handler) Stats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.Q.Stats())
+}
+
+// ListAds pages through queued ads, oldest first:
+// ?search=dragon&filter=priority>=2&offset=0&limit=100.
+func (h *Handler) ListAds(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	f, err := queue.ParseFilter(qs.Get("filter"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidFilter, "invalid filter: "+err.Error(), nil)
+		return
+	}
+	offset, limit := 0, 100
+	if s := qs.Get("offset"); s != "" {
+		if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+			writeErr(w, http.StatusBadRequest, "invalid offset")
+			return
+		}
+	}
+	if s := qs.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 || limit > 1000 {
+			writeErr(w, http.StatusBadRequest, "limit must be 1..1000")
+			return
+		}
 	}
-	writeJSON(w, http.StatusOK, resp)
+	records, total := h.Q.ListAds(f, qs.Get("search"), offset, limit)
+	writeJSON(w, http.StatusOK, AdsResponse{Total: total, Offset: offset, Ads: records})
 }
 
 func (h *Handler) Waiting(w http.ResponseWriter, r *http.Request) {
@@ -100,7 +318,7 @@ func (h *Handler) Waiting(w http.ResponseWriter, r *http.Request) {
 	}
 	d, err := time.ParseDuration(ageStr)
 	if err != nil {
-		writeErr(w, http.StatusBadRequest, "invalid duration: "+err.Error())
+		writeError(w, http.StatusBadRequest, CodeInvalidDuration, "invalid duration: "+err.Error(), nil)
 		return
 	}
 	list := h.Q.ListWaitingLongerThan(d)
@@ -113,12 +331,23 @@ func (h *Handler) ReprioritizeFamily(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
 		return
 	}
-	if req.Family == "" || req.NewPriority == 0 {
-		writeErr(w, http.StatusBadRequest, "family and newPriority required")
+	if req.Family == "" || (req.NewPriority.IsZero() && req.Delta == 0) {
+		writeErr(w, http.StatusBadRequest, "family and newPriority or delta required")
 		return
 	}
-	h.Q.ReprioritizeByGameFamily(req.Family, req.NewPriority)
-	writeJSON(w, http.StatusOK, OKResponse{OK: true})
+	newPriority, ok := h.resolvePriority(w, req.NewPriority)
+	if !ok {
+		return
+	}
+	cmd := queue.Command{Op: queue.OpReprioritizeFamily, Family: req.Family, Priority: newPriority}
+	if req.Delta != 0 {
+		cmd = queue.Command{Op: queue.OpBulkAdjust, Filter: queue.FamilyFilter(req.Family), Delta: req.Delta}
+	}
+	res, ok := h.submitGuarded(w, cmd, confirmToken(r, req.ConfirmToken))
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, ReprioritizeResponse{OK: true, Skipped: res.Skipped})
 }
 
 func (h *Handler) ReprioritizeAge(w http.ResponseWriter, r *http.Request) {
@@ -127,17 +356,142 @@ func (h *Handler) ReprioritizeAge(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
 		return
 	}
-	if req.Age == "" || req.NewPriority == 0 {
-		writeErr(w, http.StatusBadRequest, "age and newPriority required")
+	if req.Age == "" || (req.NewPriority.IsZero() && req.Delta == 0) {
+		writeErr(w, http.StatusBadRequest, "age and newPriority or delta required")
+		return
+	}
+	newPriority, ok := h.resolvePriority(w, req.NewPriority)
+	if !ok {
 		return
 	}
 	d, err := time.ParseDuration(req.Age)
 	if err != nil {
-		writeErr(w, http.StatusBadRequest, "invalid duration: "+err.Error())
+		writeError(w, http.StatusBadRequest, CodeInvalidDuration, "invalid duration: "+err.Error(), nil)
 		return
 	}
-	h.Q.ReprioritizeByAgeOlderThan(d, req.NewPriority)
-	writeJSON(w, http.StatusOK, OKResponse{OK: true})
+	cmd := queue.Command{Op: queue.OpReprioritizeAge, Age: d, Priority: newPriority}
+	if req.Delta != 0 {
+		cmd = queue.Command{Op: queue.OpBulkAdjust, Filter: queue.OlderThanFilter(d), Delta: req.Delta}
+	}
+	res, ok := h.submitGuarded(w, cmd, confirmToken(r, req.ConfirmToken))
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, ReprioritizeResponse{OK: true, Skipped: res.Skipped})
+}
+
+func (h *Handler) BulkReprioritize(w http.ResponseWriter, r *http.Request) {
+	var req BulkReprioritizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if req.Filter == "" || (req.NewPriority.IsZero() && req.Delta == 0) {
+		writeErr(w, http.StatusBadRequest, "filter and newPriority or delta required")
+		return
+	}
+	newPriority, ok := h.resolvePriority(w, req.NewPriority)
+	if !ok {
+		return
+	}
+	f, err := queue.ParseFilter(req.Filter)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidFilter, "invalid filter: "+err.Error(), nil)
+		return
+	}
+	cmd := queue.Command{Op: queue.OpBulkReprioritize, Filter: f, Priority: newPriority, DryRun: req.DryRun}
+	if req.Delta != 0 {
+		cmd = queue.Command{Op: queue.OpBulkAdjust, Filter: f, Delta: req.Delta, DryRun: req.DryRun}
+	}
+	res, ok := h.submitGuarded(w, cmd, confirmToken(r, req.ConfirmToken))
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, BulkResponse{DryRun: req.DryRun, Count: len(res.AdIDs), AdIDs: res.AdIDs, Skipped: res.Skipped})
+}
+
+func (h *Handler) BulkRemove(w http.ResponseWriter, r *http.Request) {
+	var req BulkRemoveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if req.Filter == "" {
+		writeErr(w, http.StatusBadRequest, "filter required")
+		return
+	}
+	f, err := queue.ParseFilter(req.Filter)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidFilter, "invalid filter: "+err.Error(), nil)
+		return
+	}
+	res, ok := h.submitGuarded(w, queue.Command{Op: queue.OpBulkRemove, Filter: f, DryRun: req.DryRun}, confirmToken(r, req.ConfirmToken))
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, BulkResponse{DryRun: req.DryRun, Count: len(res.AdIDs), AdIDs: res.AdIDs})
+}
+
+func (h *Handler) CreateBoost(w http.ResponseWriter, r *http.Request) {
+	var req BoostRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if (req.Family == "") == (req.Filter == "") || req.Delta == 0 || req.Duration == "" {
+		writeErr(w, http.StatusBadRequest, "one of family or filter, delta and duration required")
+		return
+	}
+	d, err := time.ParseDuration(req.Duration)
+	if err != nil || d <= 0 {
+		writeError(w, http.StatusBadRequest, CodeInvalidDuration, "invalid duration", nil)
+		return
+	}
+	f := queue.FamilyFilter(req.Family)
+	if req.Filter != "" {
+		if f, err = queue.ParseFilter(req.Filter); err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidFilter, "invalid filter: "+err.Error(), nil)
+			return
+		}
+	}
+	res, ok := h.submitGuarded(w, queue.Command{Op: queue.OpBoost, Filter: f, Delta: req.Delta, Duration: d}, confirmToken(r, req.ConfirmToken))
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusCreated, res.Boost)
+}
+
+func (h *Handler) ListBoosts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.Q.ActiveBoosts())
+}
+
+func (h *Handler) CancelBoost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid boost id")
+		return
+	}
+	res, ok := h.submit(w, queue.Command{Op: queue.OpCancelBoost, BoostID: id})
+	if !ok {
+		return
+	}
+	if !res.OK {
+		writeErr(w, http.StatusNotFound, "boost not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, ReprioritizeResponse{OK: true, Skipped: res.Skipped})
+}
+
+func (h *Handler) Settings(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.settings())
+}
+
+func (h *Handler) settings() SettingsResponse {
+	return SettingsResponse{
+		EnableAntiStarvation: h.Q.IsEnableAntiStarvation(),
+		MaximumWait:          h.Q.MaximumWaitTime(),
+		TotalPriority:        h.Q.TotalPriority(),
+	}
 }
 
 func (h *Handler) SetAntiStarvation(w http.ResponseWriter, r *http.Request) {
@@ -146,7 +500,14 @@ func (h *Handler) SetAntiStarvation(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
 		return
 	}
-	h.Q.SetEnableAntiStarvation(req.Enable)
+	if !req.Enable && RoleFrom(r.Context()) < RoleAdmin {
+		writeErr(w, http.StatusForbidden, "turning anti-starvation off requires the admin role")
+		return
+	}
+	if _, ok := h.submit(w, queue.Command{Op: queue.OpSetAntiStarvation, Enable: req.Enable}); !ok {
+		return
+	}
+	h.persistSettings(func(cfg *config.Config) { cfg.EnableAntiStarvation = req.Enable })
 	writeJSON(w, http.StatusOK, OKResponse{OK: true})
 }
 
@@ -156,10 +517,231 @@ func (h *Handler) SetMaximumWait(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
 		return
 	}
-	if req.MaximumWait <= 0 {
-		writeErr(w, http.StatusBadRequest, "maximumWait must be > 0")
+	if _, ok := h.submit(w, queue.Command{Op: queue.OpSetMaximumWait, Value: req.MaximumWait}); !ok {
+		return
+	}
+	h.persistSettings(func(cfg *config.Config) { cfg.MaximumWaitSeconds = req.MaximumWait })
+	writeJSON(w, http.StatusOK, OKResponse{OK: true})
+}
+
+func (h *Handler) SetPriorities(w http.ResponseWriter, r *http.Request) {
+	var req PrioritiesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	strategy := queue.RemapStrategy(req.Strategy)
+	switch strategy {
+	case "":
+		strategy = queue.RemapClamp
+	case queue.RemapClamp, queue.RemapProportional:
+	default:
+		writeErr(w, http.StatusBadRequest, "strategy must be clamp or proportional")
+		return
+	}
+	if _, ok := h.submit(w, queue.Command{Op: queue.OpSetTotalPriority, Value: req.TotalPriority, Strategy: strategy}); !ok {
 		return
 	}
-	h.Q.SetMaximumWaitTime(req.MaximumWait)
+	h.persistSettings(func(cfg *config.Config) { cfg.TotalPriority = req.TotalPriority })
 	writeJSON(w, http.StatusOK, OKResponse{OK: true})
 }
+
+func (h *Handler) Pause(w http.ResponseWriter, r *http.Request) {
+	scope, ok := h.decodePauseScope(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := h.submit(w, queue.Command{Op: queue.OpPause, Scope: &scope}); !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.Q.Paused())
+}
+
+func (h *Handler) Resume(w http.ResponseWriter, r *http.Request) {
+	scope, ok := h.decodePauseScope(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := h.submit(w, queue.Command{Op: queue.OpResume, Scope: &scope}); !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.Q.Paused())
+}
+
+func (h *Handler) PauseState(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.Q.Paused())
+}
+
+func (h *Handler) decodePauseScope(w http.ResponseWriter, r *http.Request) (queue.PauseScope, bool) {
+	var req PauseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return queue.PauseScope{}, false
+	}
+	scope := queue.PauseScope{Kind: req.Scope, Family: req.Family}
+	switch req.Scope {
+	case queue.ScopeAll:
+	case queue.ScopePriority:
+		if req.Priority.IsZero() {
+			writeErr(w, http.StatusBadRequest, "priority required for scope priority")
+			return scope, false
+		}
+		p, ok := h.resolvePriority(w, req.Priority)
+		if !ok {
+			return scope, false
+		}
+		scope.Priority = p
+	case queue.ScopeFamily:
+		if req.Family == "" {
+			writeErr(w, http.StatusBadRequest, "family required for scope family")
+			return scope, false
+		}
+	default:
+		writeErr(w, http.StatusBadRequest, "scope must be all, priority or family")
+		return scope, false
+	}
+	return scope, true
+}
+
+func (h *Handler) SetDrain(w http.ResponseWriter, r *http.Request) {
+	var req DrainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if _, ok := h.submit(w, queue.Command{Op: queue.OpSetDraining, Enable: req.Enable}); !ok {
+		return
+	}
+	h.DrainStatus(w, r)
+}
+
+func (h *Handler) DrainStatus(w http.ResponseWriter, r *http.Request) {
+	draining, remaining := h.Q.DrainStatus()
+	writeJSON(w, http.StatusOK, DrainResponse{
+		Draining:  draining,
+		Remaining: remaining,
+		Drained:   draining && remaining == 0,
+	})
+}
+
+func (h *Handler) Purge(w http.ResponseWriter, r *http.Request) {
+	var req PurgeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	// Not left to the validation middleware: purging without the export
+	// the caller asked for must never happen, whatever the route.
+	if req.Export != "" && req.Export != "file" && req.Export != "response" {
+		writeErr(w, http.StatusBadRequest, "export must be file or response")
+		return
+	}
+	// An empty body must not wipe the queue by accident.
+	filtered := !req.Priority.IsZero() || req.Family != "" || req.OlderThan != "" || strings.TrimSpace(req.Filter) != ""
+	if filtered == req.All {
+		writeErr(w, http.StatusBadRequest, `give priority, family, olderThan or filter, or "all": true to purge everything`)
+		return
+	}
+	f, err := queue.ParseFilter(req.Filter)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidFilter, "invalid filter: "+err.Error(), nil)
+		return
+	}
+	if !req.Priority.IsZero() {
+		p, ok := h.resolvePriority(w, req.Priority)
+		if !ok {
+			return
+		}
+		f = f.And(queue.PriorityFilter(p))
+	}
+	if req.Family != "" {
+		f = f.And(queue.FamilyFilter(req.Family))
+	}
+	if req.OlderThan != "" {
+		d, err := time.ParseDuration(req.OlderThan)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidDuration, "invalid duration: "+err.Error(), nil)
+			return
+		}
+		f = f.And(queue.OlderThanFilter(d))
+	}
+	cmd, ok := h.guard(w, queue.Command{Op: queue.OpPurge, Filter: f}, confirmToken(r, req.ConfirmToken))
+	if !ok {
+		return
+	}
+
+	switch req.Export {
+	case "response":
+		var buf bytes.Buffer
+		cmd.Export = func(recs []queue.ItemRecord) error {
+			return queue.WriteRecords(&buf, recs)
+		}
+		if _, err := h.apply(cmd); err != nil {
+			if h.overLimit(w, cmd, err) {
+				return
+			}
+			writeErr(w, queueErrStatus(err, http.StatusInternalServerError), "purge failed: "+err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		_, _ = buf.WriteTo(w)
+	case "", "file":
+		dir := h.Cfg.PurgeExportDir
+		if dir == "" {
+			dir = "exports"
+		}
+		path := filepath.Join(dir, "purge-"+time.Now().UTC().Format("20060102T150405.000000000Z")+".jsonl")
+		cmd.Export = func(recs []queue.ItemRecord) error {
+			return writeExportFile(path, recs)
+		}
+		res, err := h.apply(cmd)
+		if err != nil {
+			if h.overLimit(w, cmd, err) {
+				return
+			}
+			writeErr(w, queueErrStatus(err, http.StatusInternalServerError), "purge failed, nothing purged: "+err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, PurgeResponse{Count: len(res.Records), ExportFile: path})
+	}
+}
+
+func writeExportFile(path string, recs []queue.ItemRecord) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := queue.WriteRecords(f, recs); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (h *Handler) Export(w http.ResponseWriter, r *http.Request) {
+	records := h.Q.Export()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	_ = queue.WriteRecords(w, records)
+}
+
+func (h *Handler) Import(w http.ResponseWriter, r *http.Request) {
+	opts := queue.ImportOptions{
+		KeepSeq:        r.URL.Query().Get("keepSeq") == "true",
+		SkipDuplicates: r.URL.Query().Get("skipDuplicates") == "true",
+	}
+	records, err := queue.ReadRecords(r.Body)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid JSONL: "+err.Error())
+		return
+	}
+	res, ok := h.submit(w, queue.Command{Op: queue.OpImport, Records: records, Import: &opts})
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, res.Import)
+}
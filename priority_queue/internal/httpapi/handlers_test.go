@@ -0,0 +1,142 @@
+package httpapi_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"icetea/priority_queue/config"
+	"icetea/priority_queue/internal/ads"
+	"icetea/priority_queue/internal/httpapi"
+	"icetea/priority_queue/internal/queue"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func enqueueAds(t *testing.T, q *queue.VideoProcessingQueue, family string, n int) {
+	t.Helper()
+	for i := range n {
+		id := fmt.Sprintf("%s-%d", family, i)
+		if err := q.Enqueue(&ads.Ad{AdID: id, Title: id, GameFamily: family, Priority: 1, MaxWaitTime: 600}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// The handler checks export itself, so callers that skip the validation
+// middleware cannot purge without the export they asked for.
+func TestPurge_UnknownExportDirect(t *testing.T) {
+	cfg := testConfig
+	cfg.PurgeExportDir = t.TempDir()
+	q := queue.NewFromConfig(cfg)
+	enqueueAds(t, q, "RPG", 2)
+	h := &httpapi.Handler{Q: q, Cfg: cfg}
+
+	rec := httptest.NewRecorder()
+	h.Purge(rec, httptest.NewRequest(http.MethodPost, "/admin/purge", strings.NewReader(`{"family":"RPG","export":"bogus"}`)))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "export must be file or response") {
+		t.Fatalf("purge with export bogus = %d %s, want 400", rec.Code, rec.Body)
+	}
+	if n := q.Stats().Queued; n != 2 {
+		t.Fatalf("%d ads left, want 2", n)
+	}
+}
+
+func TestPurge_RequiresFilterOrAll(t *testing.T) {
+	srv, q := newServer(t)
+	enqueueAds(t, q, "RPG", 2)
+	enqueueAds(t, q, "Puzzle", 1)
+
+	tests := []struct {
+		body   string
+		status int
+		left   int
+	}{
+		{`{}`, http.StatusBadRequest, 3},
+		{`{"filter":"  "}`, http.StatusBadRequest, 3},
+		{`{"all":true,"family":"RPG"}`, http.StatusBadRequest, 3},
+		{`{"family":"RPG"}`, http.StatusOK, 1},
+		{`{"all":true}`, http.StatusOK, 0},
+	}
+	for _, tt := range tests {
+		resp, err := http.Post(srv.URL+"/v1/admin/purge", "application/json", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Fatalf("purge %s = %d, want %d", tt.body, resp.StatusCode, tt.status)
+		}
+		if n := q.Stats().Queued; n != tt.left {
+			t.Fatalf("after purge %s: %d ads left, want %d", tt.body, n, tt.left)
+		}
+	}
+}
+
+func TestCreateBoost_Guardrails(t *testing.T) {
+	cfg := testConfig
+	cfg.Guardrails = config.Guardrails{MaxItems: 2}
+	q := queue.NewFromConfig(cfg)
+	srv := httptest.NewServer((&httpapi.Handler{Q: q, Cfg: cfg}).Router())
+	t.Cleanup(srv.Close)
+	enqueueAds(t, q, "RPG", 3)
+	enqueueAds(t, q, "Puzzle", 1)
+
+	post := func(body string) (int, map[string]any) {
+		t.Helper()
+		resp, err := http.Post(srv.URL+"/v1/boosts", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode, decode(t, resp.Body).(map[string]any)
+	}
+
+	if status, _ := post(`{"family":"Puzzle","delta":1,"duration":"1m"}`); status != http.StatusCreated {
+		t.Fatalf("boost under the limit = %d, want 201", status)
+	}
+	status, body := post(`{"filter":"priority >= 1","delta":1,"duration":"1m"}`)
+	details, _ := body["details"].(map[string]any)
+	if status != http.StatusPreconditionRequired || details["count"] != json.Number("4") {
+		t.Fatalf("boost of the whole queue = %d %v, want 428 for 4 ads", status, body)
+	}
+	if n := len(q.ActiveBoosts()); n != 1 {
+		t.Fatalf("%d active boosts after a refused boost, want 1", n)
+	}
+	token := details["confirmToken"].(string)
+	status, body = post(`{"filter":"priority >= 1","delta":1,"duration":"1m","confirmToken":"` + token + `"}`)
+	if status != http.StatusCreated || len(body["adIds"].([]any)) != 4 {
+		t.Fatalf("confirmed boost = %d %v, want 201 boosting 4 ads", status, body)
+	}
+}
+
+func TestReprioritize_ReportsSkipped(t *testing.T) {
+	cfg := testConfig
+	cfg.PriorityClasses = []config.PriorityClass{{Name: "urgent", Level: 3, Capacity: 2}}
+	q := queue.NewFromConfig(cfg)
+	srv := httptest.NewServer((&httpapi.Handler{Q: q, Cfg: cfg}).Router())
+	t.Cleanup(srv.Close)
+	enqueueAds(t, q, "RPG", 3)
+
+	post := func(path, body string) map[string]any {
+		t.Helper()
+		resp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST %s = %d", path, resp.StatusCode)
+		}
+		return decode(t, resp.Body).(map[string]any)
+	}
+
+	dry := post("/v1/bulk/reprioritize", `{"filter":"family = RPG","newPriority":"urgent","dryRun":true}`)
+	if fmt.Sprint(dry["adIds"], dry["skipped"]) != "[RPG-0 RPG-1] [RPG-2]" {
+		t.Fatalf("dry run = %v", dry)
+	}
+	body := post("/v1/reprioritize/family", `{"family":"RPG","newPriority":"urgent"}`)
+	if fmt.Sprint(body["skipped"]) != "[RPG-2]" || levelCount(q, 3) != 2 {
+		t.Fatalf("reprioritize into a full class = %v with %d ads at urgent", body, levelCount(q, 3))
+	}
+}
@@ -0,0 +1,424 @@
+package httpapi
+
+import (
+	"fmt"
+	"icetea/priority_queue/internal/ads"
+	"icetea/priority_queue/internal/queue"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// param is a query or path parameter of an operation.
+type param struct {
+	in    string // "query" or "path"
+	name  string
+	kind  string // "integer", "boolean" or "string"
+	rules rules
+	doc   string
+}
+
+func query(name, kind, tag, doc string) param {
+	return param{in: "query", name: name, kind: kind, rules: parseRules(tag), doc: doc}
+}
+
+// operation documents a route. The table below is the single description
+// of the public API: it is rendered as the OpenAPI document and drives
+// request validation, and the tests check it against the router and the
+// handlers.
+type operation struct {
+	id, method, path, summary string
+	query                     []param
+	request                   any    // JSON body, validated against its type
+	records                   bool   // body is JSONL of queue.ItemRecord
+	example                   any    // example request body
+	status                    int    // success status, 200 if zero
+	responses                 []any  // success bodies; several are oneOf
+	noContent                 string // when set, why the answer may be a 204
+	ndjson                    bool   // success may also be JSONL of queue.ItemRecord
+	stream                    bool   // success is a text/event-stream of DashboardEvent
+	errors                    []int  // error statuses besides validation and auth
+}
+
+var (
+	exampleFamily = "RPG-Fantasy"
+	exampleAd     = &ads.Ad{AdID: "ad-1", Title: "Dragon Quest", GameFamily: exampleFamily, TargetAudience: []string{"18-35"}, Priority: 3, CreatedAt: "2025-01-01T00:00:00Z", MaxWaitTime: 300}
+)
+
+var operations = []operation{
+	{id: "health", method: "GET", path: "/healthz", summary: "Liveness check", responses: []any{OKResponse{}}},
+	{id: "openapi", method: "GET", path: "/openapi.json", summary: "This OpenAPI document", responses: []any{map[string]any{}}},
+
+	{id: "enqueue", method: "POST", path: "/enqueue", summary: "Add an ad to the queue",
+		request: EnqueueRequest{}, example: map[string]any{"ad": map[string]any{
+			"adId": "ad-1", "title": "Dragon Quest", "gameFamily": exampleFamily,
+			"targetAudience": []string{"18-35"}, "priority": "urgent", "maxWaitTime": 300}},
+		status: http.StatusCreated, responses: []any{QueuedAd{}}, errors: []int{409, 429}},
+	{id: "dequeue", method: "POST", path: "/dequeue", summary: "Take the next ad, or lease it with ?lease",
+		query:     []param{query("lease", "string", "duration", "Lease the ad for this long; unacked ads return to the queue.")},
+		responses: []any{ads.Ad{}, LeasedAd{}}, noContent: "Queue empty"},
+	{id: "listLeases", method: "GET", path: "/leases", summary: "List outstanding leases", responses: []any{[]queue.LeaseInfo{}}},
+	{id: "ack", method: "POST", path: "/leases/{id}/ack", summary: "Acknowledge a leased ad", responses: []any{OKResponse{}}, errors: []int{404}},
+	{id: "nack", method: "POST", path: "/leases/{id}/nack", summary: "Return a leased ad to the queue", responses: []any{OKResponse{}}, errors: []int{404}},
+
+	{id: "peek", method: "GET", path: "/peek", summary: "The next ads in dequeue order",
+		query: []param{query("n", "integer", "min=1", "How many ads; default 1.")}, responses: []any{[]*ads.Ad{}}},
+	{id: "distribution", method: "GET", path: "/distribution", summary: "Queued ads per priority", responses: []any{DistributionResponse{}}},
+	{id: "waiting", method: "GET", path: "/waiting", summary: "Ads waiting longer than age",
+		query: []param{query("age", "string", "duration", "Duration like 5m; may be sent as {\"age\": \"5m\"} in the body instead.")}, responses: []any{[]*ads.Ad{}}},
+	{id: "listAds", method: "GET", path: "/ads", summary: "Page through queued ads, oldest first",
+		query: []param{
+			query("search", "string", "", "Substring of the ad id, title or family."),
+			query("filter", "string", "", "Filter expression like `priority >= 2 and age > 10m`."),
+			query("offset", "integer", "min=0", "Matches to skip."),
+			query("limit", "integer", "min=1,max=1000", "Page size; default 100."),
+		}, responses: []any{AdsResponse{}}},
+	{id: "adPosition", method: "GET", path: "/ads/{adId}/position", summary: "Where a queued ad stands in dequeue order, with an estimated start",
+		responses: []any{QueuedAd{}}, errors: []int{404}},
+	{id: "stats", method: "GET", path: "/stats", summary: "Queue counters", responses: []any{queue.Stats{}}},
+	{id: "events", method: "GET", path: "/events", summary: "Dashboard updates as server-sent events",
+		query: []param{query("interval", "string", "duration", "Time between events, at least 250ms; default 1s.")}, stream: true},
+	{id: "debugScores", method: "GET", path: "/debug/scores", summary: "Anti-starvation score of each level's head", responses: []any{[]queue.HeadScore{}}},
+	{id: "debugNext", method: "GET", path: "/debug/next", summary: "The next ads with their wait, deadline and score",
+		query: []param{query("n", "integer", "min=1", "How many ads; default 1.")}, responses: []any{[]queue.ScoredAd{}}},
+	{id: "command", method: "POST", path: "/command", summary: "Run a plain-English command",
+		request: CommandRequest{}, example: CommandRequest{Text: "Show the next 5 ads"},
+		responses: []any{CommandResponse{}}, errors: []int{428}},
+
+	{id: "reprioritizeFamily", method: "POST", path: "/reprioritize/family", summary: "Move a game family to a priority",
+		request: ReprioritizeFamilyRequest{}, example: ReprioritizeFamilyRequest{Family: exampleFamily, NewPriority: "2"},
+		responses: []any{ReprioritizeResponse{}}, errors: []int{428}},
+	{id: "reprioritizeAge", method: "POST", path: "/reprioritize/age", summary: "Move ads older than age to a priority",
+		request: ReprioritizeAgeRequest{}, example: ReprioritizeAgeRequest{Age: "10m", NewPriority: "urgent"},
+		responses: []any{ReprioritizeResponse{}}, errors: []int{428}},
+	{id: "bulkReprioritize", method: "POST", path: "/bulk/reprioritize", summary: "Move the ads matching a filter",
+		request: BulkReprioritizeRequest{}, example: BulkReprioritizeRequest{Filter: "family = " + exampleFamily, Delta: 1, DryRun: true},
+		responses: []any{BulkResponse{}}, errors: []int{428}},
+	{id: "bulkRemove", method: "POST", path: "/bulk/remove", summary: "Remove the ads matching a filter",
+		request: BulkRemoveRequest{}, example: BulkRemoveRequest{Filter: "age > 1h", DryRun: true},
+		responses: []any{BulkResponse{}}, errors: []int{428}},
+	{id: "undo", method: "POST", path: "/bulk/undo", summary: "Undo the last reprioritize", responses: []any{BulkResponse{}}, errors: []int{404}},
+	{id: "createBoost", method: "POST", path: "/boosts", summary: "Raise matching ads for a while",
+		request: BoostRequest{}, example: BoostRequest{Family: exampleFamily, Delta: 1, Duration: "30m"},
+		status: http.StatusCreated, responses: []any{queue.BoostInfo{}}},
+	{id: "listBoosts", method: "GET", path: "/boosts", summary: "List active boosts", responses: []any{[]queue.BoostInfo{}}},
+	{id: "cancelBoost", method: "DELETE", path: "/boosts/{id}", summary: "End a boost early", responses: []any{ReprioritizeResponse{}}, errors: []int{404}},
+
+	{id: "settings", method: "GET", path: "/settings", summary: "Current settings", responses: []any{SettingsResponse{}}},
+	{id: "setAntiStarvation", method: "POST", path: "/settings/antiStarvation", summary: "Turn anti-starvation on or off",
+		request: AntiStarvationRequest{}, example: AntiStarvationRequest{Enable: true}, responses: []any{OKResponse{}}},
+	{id: "setMaximumWait", method: "POST", path: "/settings/maximumWait", summary: "Set the default maximum wait",
+		request: MaximumWaitRequest{}, example: MaximumWaitRequest{MaximumWait: 600}, responses: []any{OKResponse{}}},
+	{id: "setPriorities", method: "POST", path: "/settings/priorities", summary: "Change the number of priority levels",
+		request: PrioritiesRequest{}, example: PrioritiesRequest{TotalPriority: 3, Strategy: "clamp"},
+		responses: []any{OKResponse{}}, errors: []int{409}},
+
+	{id: "pause", method: "POST", path: "/admin/pause", summary: "Pause dequeues for all ads, a priority or a family",
+		request: PauseRequest{}, example: PauseRequest{Scope: queue.ScopeFamily, Family: exampleFamily}, responses: []any{queue.PauseState{}}},
+	{id: "resume", method: "POST", path: "/admin/resume", summary: "Lift a pause",
+		request: PauseRequest{}, example: PauseRequest{Scope: queue.ScopeFamily, Family: exampleFamily}, responses: []any{queue.PauseState{}}},
+	{id: "pauseState", method: "GET", path: "/admin/pause", summary: "Current pauses", responses: []any{queue.PauseState{}}},
+	{id: "setDrain", method: "POST", path: "/admin/drain", summary: "Stop or resume accepting new ads",
+		request: DrainRequest{}, example: DrainRequest{Enable: false}, responses: []any{DrainResponse{}}},
+	{id: "drainStatus", method: "GET", path: "/admin/drain", summary: "Drain progress", responses: []any{DrainResponse{}}},
+	{id: "purge", method: "POST", path: "/admin/purge", summary: "Remove ads, exporting them first",
+		request: PurgeRequest{}, example: PurgeRequest{Family: "Retired", OlderThan: "24h"},
+		responses: []any{PurgeResponse{}}, ndjson: true, errors: []int{428, 500}},
+	{id: "export", method: "GET", path: "/admin/export", summary: "Every queued ad as JSONL", ndjson: true},
+	{id: "import", method: "POST", path: "/admin/import", summary: "Load ads from JSONL",
+		query: []param{
+			query("keepSeq", "boolean", "", "Keep the exported sequence numbers, and so the order."),
+			query("skipDuplicates", "boolean", "", "Skip ads already queued instead of replacing them."),
+		},
+		records: true, example: []queue.ItemRecord{{Ad: exampleAd, EnqueueAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), Seq: 1}},
+		responses: []any{queue.ImportResult{}}, errors: []int{429}},
+}
+
+// params returns the path parameters of op followed by its query
+// parameters. Path parameters named id are integer ids; others are strings.
+func (op *operation) params() []param {
+	var ps []param
+	for _, seg := range strings.Split(op.path, "/") {
+		if strings.HasPrefix(seg, "{") {
+			name, kind := strings.Trim(seg, "{}"), "string"
+			if name == "id" {
+				kind = "integer"
+			}
+			ps = append(ps, param{in: "path", name: name, kind: kind, rules: rules{required: true}})
+		}
+	}
+	return append(ps, op.query...)
+}
+
+// findOperation returns the operation for a request and its path values.
+func findOperation(method, path string) (*operation, map[string]string) {
+	for i := range operations {
+		if op := &operations[i]; op.method == method {
+			if values, ok := matchPath(op.path, path); ok {
+				return op, values
+			}
+		}
+	}
+	return nil, nil
+}
+
+// hasPath reports whether some operation has path, whatever its method.
+func hasPath(path string) bool {
+	for _, op := range operations {
+		if _, ok := matchPath(op.path, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath matches path against an operation path like /leases/{id}/ack.
+func matchPath(pattern, path string) (map[string]string, bool) {
+	want, segs := strings.Split(pattern, "/"), strings.Split(path, "/")
+	if len(want) != len(segs) {
+		return nil, false
+	}
+	var values map[string]string
+	for i, w := range want {
+		switch {
+		case strings.HasPrefix(w, "{"):
+			if values == nil {
+				values = map[string]string{}
+			}
+			values[strings.Trim(w, "{}")] = segs[i]
+		case w != segs[i]:
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+// OpenAPI serves the OpenAPI 3 document for the public API under /v1.
+// Node-to-node routes (/raft, /cluster, /replication), /mcp and the
+// deprecated unversioned aliases are left out.
+func (h *Handler) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPIDocument())
+}
+
+var openAPIDocument = sync.OnceValue(func() map[string]any {
+	s := &schemas{defs: map[string]any{}, types: map[string]reflect.Type{}}
+	paths := map[string]map[string]any{}
+	for i := range operations {
+		op := &operations[i]
+		if paths[op.path] == nil {
+			paths[op.path] = map[string]any{}
+		}
+		paths[op.path][strings.ToLower(op.method)] = s.operation(op)
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Video ad priority queue",
+			"version": "1.0",
+			"description": "Priority queue of video ads with anti-starvation. Request bodies and " +
+				"parameters are validated strictly: unknown fields and invalid values are a 400 " +
+				"listing each problem in `details.fields`. Every error is an ErrorEnvelope with a " +
+				"machine-readable `code`. With API keys configured every route but /healthz and " +
+				"/openapi.json needs a key of at least the role in x-required-role. The same routes " +
+				"without /v1 are deprecated aliases with the old error bodies.",
+		},
+		"servers": []any{map[string]any{"url": "/v1"}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": s.defs,
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+				"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+		"security": []any{map[string]any{"bearer": []string{}}, map[string]any{"apiKey": []string{}}},
+	}
+})
+
+// schemas collects the named component schemas while operations are
+// rendered.
+type schemas struct {
+	defs  map[string]any
+	types map[string]reflect.Type
+}
+
+func (s *schemas) operation(op *operation) map[string]any {
+	out := map[string]any{"operationId": op.id, "summary": op.summary}
+
+	var params []any
+	for _, p := range op.params() {
+		schema := map[string]any{"type": p.kind}
+		s.constrain(schema, p.rules)
+		params = append(params, map[string]any{
+			"name": p.name, "in": p.in, "required": p.in == "path" || p.rules.required,
+			"description": p.doc, "schema": schema,
+		})
+	}
+	if params != nil {
+		out["parameters"] = params
+	}
+
+	switch {
+	case op.request != nil:
+		media := map[string]any{"schema": s.schema(reflect.TypeOf(op.request))}
+		if op.example != nil {
+			media["example"] = op.example
+		}
+		out["requestBody"] = map[string]any{"required": true, "content": map[string]any{"application/json": media}}
+	case op.records:
+		out["requestBody"] = map[string]any{"required": true, "content": map[string]any{"application/x-ndjson": map[string]any{
+			"schema": s.schema(reflect.TypeOf(queue.ItemRecord{})), "example": op.example,
+		}}}
+	}
+
+	success := map[string]any{}
+	var alts []any
+	for _, resp := range op.responses {
+		alts = append(alts, s.schema(reflect.TypeOf(resp)))
+	}
+	switch len(alts) {
+	case 0:
+	case 1:
+		success["application/json"] = map[string]any{"schema": alts[0]}
+	default:
+		success["application/json"] = map[string]any{"schema": map[string]any{"oneOf": alts}}
+	}
+	if op.ndjson {
+		success["application/x-ndjson"] = map[string]any{"schema": s.schema(reflect.TypeOf(queue.ItemRecord{}))}
+	}
+	if op.stream {
+		success["text/event-stream"] = map[string]any{"schema": s.schema(reflect.TypeOf(DashboardEvent{}))}
+	}
+	status := op.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	responses := map[string]any{fmt.Sprint(status): map[string]any{"description": http.StatusText(status), "content": success}}
+	if op.noContent != "" {
+		responses["204"] = map[string]any{"description": op.noContent}
+	}
+
+	role := requiredRole(httptest.NewRequest(op.method, strings.NewReplacer("{id}", "1").Replace(op.path), nil), nil)
+	errs := op.errors
+	if op.request != nil || op.records || len(op.params()) > 0 {
+		errs = append(errs, http.StatusBadRequest)
+	}
+	if op.request != nil {
+		errs = append(errs, http.StatusRequestEntityTooLarge)
+	}
+	if role == RoleNone {
+		out["security"] = []any{}
+	} else {
+		out["x-required-role"] = role.String()
+		errs = append(errs, http.StatusUnauthorized, http.StatusForbidden)
+	}
+	if op.method != http.MethodGet {
+		// Followers, a draining queue and a lost raft quorum refuse writes.
+		errs = append(errs, http.StatusServiceUnavailable)
+	}
+	for _, code := range errs {
+		responses[fmt.Sprint(code)] = map[string]any{
+			"description": http.StatusText(code),
+			"content":     map[string]any{"application/json": map[string]any{"schema": s.schema(reflect.TypeOf(ErrorEnvelope{}))}},
+		}
+	}
+	out["responses"] = responses
+	return out
+}
+
+// schema returns the JSON schema of t, as a $ref for named structs.
+func (s *schemas) schema(t reflect.Type) map[string]any {
+	nullable := false
+	for t.Kind() == reflect.Pointer {
+		t, nullable = t.Elem(), true
+	}
+	var out map[string]any
+	switch {
+	case t == timeType:
+		out = map[string]any{"type": "string", "format": "date-time"}
+	case t == errorCodeType:
+		out = map[string]any{"type": "string", "enum": ErrorCodes}
+	case t == priorityType:
+		out = map[string]any{"description": "A level number or a priority class name.",
+			"oneOf": []any{map[string]any{"type": "integer"}, map[string]any{"type": "string"}}}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		name := t.Name()
+		if prev, ok := s.types[name]; ok && prev != t {
+			panic("httpapi: two schemas named " + name)
+		}
+		if _, ok := s.types[name]; !ok {
+			s.types[name] = t
+			s.defs[name] = s.object(t)
+		}
+		if nullable {
+			return map[string]any{"allOf": []any{map[string]any{"$ref": "#/components/schemas/" + name}}, "nullable": true}
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	case t.Kind() == reflect.Struct:
+		out = s.object(t)
+	case t.Kind() == reflect.Slice:
+		out = map[string]any{"type": "array", "items": s.schema(t.Elem()), "nullable": true}
+	case t.Kind() == reflect.Map:
+		out = map[string]any{"type": "object", "additionalProperties": s.schema(t.Elem()), "nullable": true}
+	case t.Kind() == reflect.String:
+		out = map[string]any{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		out = map[string]any{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Int64:
+		out = map[string]any{"type": "integer"}
+	case t.Kind() >= reflect.Uint && t.Kind() <= reflect.Uint64:
+		out = map[string]any{"type": "integer", "minimum": 0}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		out = map[string]any{"type": "number"}
+	default:
+		out = map[string]any{} // any
+	}
+	if nullable {
+		out["nullable"] = true
+	}
+	return out
+}
+
+func (s *schemas) object(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	var required []string
+	for _, f := range jsonFields(t) {
+		schema := s.schema(f.field.Type)
+		r := parseRules(f.field.Tag.Get("validate"))
+		if r.required {
+			required = append(required, f.name)
+		}
+		if _, isRef := schema["$ref"]; !isRef {
+			s.constrain(schema, r)
+		}
+		props[f.name] = schema
+	}
+	out := map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+	if required != nil {
+		out["required"] = required
+	}
+	return out
+}
+
+// constrain adds the validate rules to a scalar schema.
+func (s *schemas) constrain(schema map[string]any, r rules) {
+	if r.min != nil {
+		schema["minimum"] = *r.min
+	}
+	if r.max != nil {
+		schema["maximum"] = *r.max
+	}
+	if r.required && schema["type"] == "string" {
+		schema["minLength"] = 1
+	}
+	if r.duration {
+		schema["format"] = "duration"
+		schema["description"] = `Go duration like "90s", "10m" or "1h30m".`
+	}
+	if len(r.oneOf) > 0 {
+		schema["enum"] = r.oneOf
+	}
+}
@@ -0,0 +1,448 @@
+package httpapi_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"icetea/priority_queue/config"
+	"icetea/priority_queue/internal/ads"
+	"icetea/priority_queue/internal/httpapi"
+	"icetea/priority_queue/internal/queue"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testConfig = config.Config{
+	TotalPriority:      3,
+	MaximumWaitSeconds: 600,
+	BTreeDegree:        16,
+	PriorityClasses:    []config.PriorityClass{{Name: "urgent", Level: 3}},
+}
+
+func newServer(t *testing.T) (*httptest.Server, *queue.VideoProcessingQueue) {
+	t.Helper()
+	cfg := testConfig
+	cfg.PurgeExportDir = t.TempDir()
+	q := queue.NewFromConfig(cfg)
+	srv := httptest.NewServer((&httpapi.Handler{Q: q, Cfg: cfg}).Router())
+	t.Cleanup(srv.Close)
+	return srv, q
+}
+
+func decode(t *testing.T, r io.Reader) any {
+	t.Helper()
+	var v any
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func openAPI(t *testing.T, srv *httptest.Server) map[string]any {
+	t.Helper()
+	resp, err := http.Get(srv.URL + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /openapi.json: %d", resp.StatusCode)
+	}
+	return decode(t, resp.Body).(map[string]any)
+}
+
+// The document lists exactly the public routes of the router.
+func TestOpenAPI_CoversRouter(t *testing.T) {
+	srv, _ := newServer(t)
+	doc := openAPI(t, srv)
+
+	src, err := os.ReadFile("router.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var routes []string
+	for _, m := range regexp.MustCompile(`mux\.HandleFunc\("(\w+) ([^"]+)"`).FindAllStringSubmatch(string(src), -1) {
+		if !strings.HasPrefix(m[2], "/cluster/") && !strings.HasPrefix(m[2], "/replication/") {
+			routes = append(routes, m[1]+" "+m[2])
+		}
+	}
+	var documented []string
+	for path, item := range doc["paths"].(map[string]any) {
+		for method := range item.(map[string]any) {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(routes)
+	sort.Strings(documented)
+	if !slices.Equal(routes, documented) {
+		t.Fatalf("documented routes\n%v\nwant the router's\n%v", documented, routes)
+	}
+}
+
+// Every operation answers its example request with a documented status and
+// a body matching the documented schema.
+func TestOpenAPI_MatchesHandlers(t *testing.T) {
+	srv, q := newServer(t)
+	doc := openAPI(t, srv)
+	for i, family := range []string{"RPG-Fantasy", "Puzzle", "Retired"} {
+		ad := &ads.Ad{AdID: fmt.Sprint("seed-", i), Title: "Seed", GameFamily: family, Priority: i + 1}
+		if _, err := q.Submit(queue.Command{Op: queue.OpEnqueue, Ad: ad}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Nothing to act on for these with the example ids.
+	notFound := map[string]bool{"ack": true, "nack": true, "undo": true}
+	base := srv.URL + doc["servers"].([]any)[0].(map[string]any)["url"].(string)
+
+	paths := doc["paths"].(map[string]any)
+	var keys []string
+	for path := range paths {
+		keys = append(keys, path)
+	}
+	sort.Strings(keys)
+	for _, path := range keys {
+		methods := paths[path].(map[string]any)
+		for _, method := range []string{"post", "get", "delete"} {
+			op, ok := methods[method].(map[string]any)
+			if !ok {
+				continue
+			}
+			id := op["operationId"].(string)
+			t.Run(id, func(t *testing.T) {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				resp := send(t, ctx, base, strings.ToUpper(method), path, op)
+				defer resp.Body.Close()
+
+				documented, ok := op["responses"].(map[string]any)[fmt.Sprint(resp.StatusCode)].(map[string]any)
+				if !ok {
+					b, _ := io.ReadAll(resp.Body)
+					t.Fatalf("status %d is not documented: %s", resp.StatusCode, b)
+				}
+				if resp.StatusCode >= 300 && !(resp.StatusCode == http.StatusNotFound && notFound[id]) {
+					b, _ := io.ReadAll(resp.Body)
+					t.Fatalf("example request failed: %d %s", resp.StatusCode, b)
+				}
+				if resp.StatusCode == http.StatusNoContent {
+					return
+				}
+				mediaType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+				media, ok := documented["content"].(map[string]any)[mediaType].(map[string]any)
+				if !ok {
+					t.Fatalf("content type %q is not documented", mediaType)
+				}
+				schema := media["schema"].(map[string]any)
+
+				switch mediaType {
+				case "application/json":
+					conform(t, doc, schema, decode(t, resp.Body), "body")
+				case "application/x-ndjson":
+					for dec := json.NewDecoder(resp.Body); dec.More(); {
+						var v any
+						dec.UseNumber()
+						if err := dec.Decode(&v); err != nil {
+							t.Fatal(err)
+						}
+						conform(t, doc, schema, v, "record")
+					}
+				case "text/event-stream":
+					sc := bufio.NewScanner(resp.Body)
+					for sc.Scan() {
+						if data, ok := strings.CutPrefix(sc.Text(), "data: "); ok {
+							conform(t, doc, schema, decode(t, strings.NewReader(data)), "event")
+							return
+						}
+					}
+					t.Fatal("no event")
+				}
+			})
+		}
+	}
+}
+
+// send makes the example request of op: its example body and example
+// values for its parameters.
+func send(t *testing.T, ctx context.Context, base, method, path string, op map[string]any) *http.Response {
+	t.Helper()
+	query := []string{}
+	params, _ := op["parameters"].([]any)
+	for _, p := range params {
+		p := p.(map[string]any)
+		name, kind := p["name"].(string), p["schema"].(map[string]any)["type"]
+		value := "1"
+		switch {
+		case kind == "boolean":
+			value = "true"
+		case p["schema"].(map[string]any)["format"] == "duration":
+			value = "1s"
+		case kind == "string" && p["in"] == "path":
+			value = "seed-0" // an ad queued by TestOpenAPI_MatchesHandlers
+		case kind == "string":
+			continue
+		}
+		if p["in"] == "path" {
+			path = strings.Replace(path, "{"+name+"}", value, 1)
+		} else {
+			query = append(query, name+"="+value)
+		}
+	}
+	url := base + path
+	if len(query) > 0 {
+		url += "?" + strings.Join(query, "&")
+	}
+
+	var body io.Reader
+	if rb, ok := op["requestBody"].(map[string]any); ok {
+		for mediaType, media := range rb["content"].(map[string]any) {
+			example := media.(map[string]any)["example"]
+			var buf bytes.Buffer
+			if mediaType == "application/x-ndjson" {
+				for _, rec := range example.([]any) {
+					json.NewEncoder(&buf).Encode(rec)
+				}
+			} else {
+				json.NewEncoder(&buf).Encode(example)
+			}
+			body = &buf
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// conform fails t if v does not match schema.
+func conform(t *testing.T, doc, schema map[string]any, v any, path string) {
+	t.Helper()
+	for _, problem := range check(doc, schema, v, path) {
+		t.Error(problem)
+	}
+}
+
+// check lists how v does not match an OpenAPI schema, following $refs
+// into doc.
+func check(doc, schema map[string]any, v any, path string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		schema = doc["components"].(map[string]any)["schemas"].(map[string]any)[name].(map[string]any)
+	}
+	fail := func(format string, args ...any) []string {
+		return []string{path + ": " + fmt.Sprintf(format, args...)}
+	}
+	if v == nil {
+		if schema["nullable"] != true {
+			return fail("null is not allowed")
+		}
+		return nil
+	}
+	if all, ok := schema["allOf"].([]any); ok {
+		var problems []string
+		for _, s := range all {
+			problems = append(problems, check(doc, s.(map[string]any), v, path)...)
+		}
+		return problems
+	}
+	if alts, ok := schema["oneOf"].([]any); ok {
+		for _, s := range alts {
+			if check(doc, s.(map[string]any), v, path) == nil {
+				return nil
+			}
+		}
+		return fail("%v matches none of %v", v, alts)
+	}
+
+	var problems []string
+	switch schema["type"] {
+	case "object":
+		m, ok := v.(map[string]any)
+		if !ok {
+			return fail("%v is not an object", v)
+		}
+		props, _ := schema["properties"].(map[string]any)
+		for k, fv := range m {
+			if s, ok := props[k].(map[string]any); ok {
+				problems = append(problems, check(doc, s, fv, path+"."+k)...)
+			} else if extra, ok := schema["additionalProperties"].(map[string]any); ok {
+				problems = append(problems, check(doc, extra, fv, path+"."+k)...)
+			} else if schema["additionalProperties"] == false {
+				problems = append(problems, fail("undocumented field %q", k)...)
+			}
+		}
+		required, _ := schema["required"].([]any)
+		for _, k := range required {
+			if _, ok := m[k.(string)]; !ok {
+				problems = append(problems, fail("missing required field %q", k)...)
+			}
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			return fail("%v is not an array", v)
+		}
+		for i, item := range items {
+			problems = append(problems, check(doc, schema["items"].(map[string]any), item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			return fail("%v is not a string", v)
+		}
+	case "integer":
+		if n, ok := v.(json.Number); !ok || strings.ContainsAny(string(n), ".eE") {
+			return fail("%v is not an integer", v)
+		}
+	case "number":
+		if _, ok := v.(json.Number); !ok {
+			return fail("%v is not a number", v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fail("%v is not a boolean", v)
+		}
+	}
+	return problems
+}
+
+// Unknown fields and invalid values are refused before the handler runs,
+// with one entry per problem.
+func TestValidation(t *testing.T) {
+	srv, q := newServer(t)
+	type fieldError struct{ Field, Message string }
+	for _, tc := range []struct {
+		method, path, body string
+		want               []fieldError
+	}{
+		{"POST", "/enqueue", `{"ad": {"adId": "", "maxWaitTime": -5, "colour": "red"}, "extra": 1}`, []fieldError{
+			{"ad.adId", "must not be empty"},
+			{"ad.maxWaitTime", "must be at least 0"},
+			{"ad.colour", "unknown field"},
+			{"extra", "unknown field"},
+		}},
+		{"POST", "/enqueue", `{"ad": {"adId": 7, "targetAudience": ["a", 1], "priority": true}}`, []fieldError{
+			{"ad.adId", "must be a string"},
+			{"ad.targetAudience[1]", "must be a string"},
+			{"ad.priority", "must be an integer or a string"},
+		}},
+		{"POST", "/enqueue", `{}`, []fieldError{{"ad", "required"}}},
+		{"POST", "/reprioritize/age", `{"age": "soon", "newPriority": 1}`, []fieldError{{"age", `must be a duration like "10m"`}}},
+		{"POST", "/admin/pause", `{"scope": "everything"}`, []fieldError{{"scope", "must be one of all, priority, family"}}},
+		{"POST", "/settings/maximumWait", `{"maximumWait": 0}`, []fieldError{{"maximumWait", "must be at least 1"}}},
+		{"GET", "/peek?n=0", ``, []fieldError{{"n", "must be at least 1"}}},
+		{"GET", "/ads?limit=5000&offset=x", ``, []fieldError{{"offset", "must be an integer"}, {"limit", "must be at most 1000"}}},
+		{"POST", "/leases/abc/ack", ``, []fieldError{{"id", "must be an integer"}}},
+	} {
+		req, _ := http.NewRequest(tc.method, srv.URL+tc.path, strings.NewReader(tc.body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Error  string       `json:"error"`
+			Fields []fieldError `json:"fields"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest || !slices.Equal(body.Fields, tc.want) {
+			t.Errorf("%s %s %s = %d %+v, want 400 %+v", tc.method, tc.path, tc.body, resp.StatusCode, body.Fields, tc.want)
+		}
+		if !strings.HasPrefix(body.Error, "invalid request: ") {
+			t.Errorf("%s %s: error %q does not sum up the fields", tc.method, tc.path, body.Error)
+		}
+	}
+	if _, total := q.DistributionByPriority(); total != 0 {
+		t.Fatalf("%d ads queued by invalid requests", total)
+	}
+}
+
+// /v1 errors are envelopes with a code and request ID; the unversioned
+// routes are deprecated aliases with the old bodies.
+func TestVersioning(t *testing.T) {
+	srv, _ := newServer(t)
+	do := func(method, path, body string, header ...string) (*http.Response, map[string]any) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out map[string]any
+		json.NewDecoder(resp.Body).Decode(&out)
+		return resp, out
+	}
+
+	if resp, _ := do("POST", "/v1/dequeue", ""); resp.StatusCode != http.StatusNoContent || resp.Header.Get("Deprecation") != "" {
+		t.Fatalf("empty /v1 dequeue = %d, want 204 without Deprecation", resp.StatusCode)
+	}
+	resp, body := do("POST", "/dequeue", "")
+	if resp.StatusCode != http.StatusNotFound || body["error"] != "queue empty" ||
+		resp.Header.Get("Deprecation") != "true" || !strings.Contains(resp.Header.Get("Link"), "</v1/dequeue>") {
+		t.Fatalf("empty old dequeue = %d %v %v, want a deprecated 404", resp.StatusCode, body, resp.Header)
+	}
+	if resp, _ := do("GET", "/healthz", ""); resp.Header.Get("Deprecation") != "" {
+		t.Fatal("/healthz is not deprecated")
+	}
+
+	for _, tc := range []struct {
+		method, path, body string
+		status             int
+		code               string
+	}{
+		{"POST", "/v1/reprioritize/age", `{"age": "soon", "newPriority": 1}`, 400, "INVALID_DURATION"},
+		{"POST", "/v1/enqueue", `{"ad": {"adId": ""}}`, 400, "INVALID_REQUEST"},
+		{"POST", "/v1/bulk/remove", `{"filter": "family ~ x"}`, 400, "INVALID_FILTER"},
+		{"POST", "/v1/enqueue", `{"ad": {"adId": "a", "priority": "nope"}}`, 400, "INVALID_PRIORITY"},
+		{"POST", "/v1/leases/9/ack", ``, 404, "NOT_FOUND"},
+		{"GET", "/v1/nowhere", ``, 404, "NOT_FOUND"},
+		{"GET", "/v1/enqueue", ``, 405, "METHOD_NOT_ALLOWED"},
+	} {
+		resp, body := do(tc.method, tc.path, tc.body, "X-Request-ID", "req-42")
+		if resp.StatusCode != tc.status || body["code"] != tc.code || body["message"] == "" ||
+			body["requestId"] != "req-42" || resp.Header.Get("X-Request-ID") != "req-42" {
+			t.Errorf("%s %s = %d %v, want %d %s with the request ID", tc.method, tc.path, resp.StatusCode, body, tc.status, tc.code)
+		}
+	}
+	_, body = do("POST", "/v1/enqueue", `{"ad": {"adId": "", "colour": "red"}}`)
+	if fields, _ := body["details"].(map[string]any)["fields"].([]any); len(fields) != 2 {
+		t.Errorf("validation details = %v, want two fields", body["details"])
+	}
+
+	// Too many queued ads: RATE_LIMITED. Capacity is per class.
+	cfg := testConfig
+	cfg.PriorityClasses = []config.PriorityClass{{Name: "urgent", Level: 3, Capacity: 1}}
+	limited := httptest.NewServer((&httpapi.Handler{Q: queue.NewFromConfig(cfg), Cfg: cfg}).Router())
+	defer limited.Close()
+	for i, want := range []int{http.StatusCreated, http.StatusTooManyRequests} {
+		req, _ := http.NewRequest("POST", limited.URL+"/v1/enqueue", strings.NewReader(fmt.Sprintf(`{"ad": {"adId": "u%d", "priority": "urgent"}}`, i)))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]any
+		json.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		if resp.StatusCode != want || (want == http.StatusTooManyRequests && out["code"] != "RATE_LIMITED") {
+			t.Fatalf("enqueue %d = %d %v, want %d", i, resp.StatusCode, out, want)
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package httpapi
 
 import (
+	"icetea/priority_queue/internal/mcp"
+	"icetea/priority_queue/internal/replication"
 	"net/http"
+	"strings"
 )
 
 func (h *Handler) Router() http.Handler {
@@ -9,19 +12,120 @@ func (h *Handler) Router() http.Handler {
 
 	// Health
 	mux.HandleFunc("GET /healthz", h.Health)
+	mux.HandleFunc("GET /openapi.json", h.OpenAPI)
 
 	// Core queue operations
 	mux.HandleFunc("POST /enqueue", h.Enqueue)
 	mux.HandleFunc("POST /dequeue", h.Dequeue)
+	mux.HandleFunc("GET /leases", h.ListLeases)
+	mux.HandleFunc("POST /leases/{id}/ack", h.Ack)
+	mux.HandleFunc("POST /leases/{id}/nack", h.Nack)
 	mux.HandleFunc("GET /peek", h.Peek)
 	mux.HandleFunc("GET /distribution", h.Distribution)
 	mux.HandleFunc("GET /waiting", h.Waiting)
+	mux.HandleFunc("GET /ads", h.ListAds)
+	mux.HandleFunc("GET /ads/{adId}/position", h.AdPosition)
+	mux.HandleFunc("GET /stats", h.Stats)
+	mux.HandleFunc("GET /events", h.Events)
+	mux.HandleFunc("GET /debug/scores", h.DebugScores)
+	mux.HandleFunc("GET /debug/next", h.DebugNext)
+	mux.HandleFunc("POST /command", h.Command)
 
 	// Admin / maintenance
 	mux.HandleFunc("POST /reprioritize/family", h.ReprioritizeFamily)
 	mux.HandleFunc("POST /reprioritize/age", h.ReprioritizeAge)
+	mux.HandleFunc("POST /bulk/reprioritize", h.BulkReprioritize)
+	mux.HandleFunc("POST /bulk/remove", h.BulkRemove)
+	mux.HandleFunc("POST /bulk/undo", h.Undo)
+	mux.HandleFunc("POST /boosts", h.CreateBoost)
+	mux.HandleFunc("GET /boosts", h.ListBoosts)
+	mux.HandleFunc("DELETE /boosts/{id}", h.CancelBoost)
+	mux.HandleFunc("GET /settings", h.Settings)
 	mux.HandleFunc("POST /settings/antiStarvation", h.SetAntiStarvation)
 	mux.HandleFunc("POST /settings/maximumWait", h.SetMaximumWait)
+	mux.HandleFunc("POST /settings/priorities", h.SetPriorities)
+	mux.HandleFunc("POST /admin/pause", h.Pause)
+	mux.HandleFunc("POST /admin/resume", h.Resume)
+	mux.HandleFunc("GET /admin/pause", h.PauseState)
+	mux.HandleFunc("POST /admin/drain", h.SetDrain)
+	mux.HandleFunc("GET /admin/drain", h.DrainStatus)
+	mux.HandleFunc("POST /admin/purge", h.Purge)
+	mux.HandleFunc("GET /admin/export", h.Export)
+	mux.HandleFunc("POST /admin/import", h.Import)
 
-	return mux
+	// MCP over streamable HTTP. Its tools call back into the finished
+	// handler below, so each is authorized like the route it wraps.
+	mcpServer := &mcp.Server{}
+	mux.Handle("/mcp", mcpServer)
+
+	// Raft cluster
+	if h.Cluster != nil {
+		mux.Handle("/raft/", h.Cluster.Raft.HTTPHandler())
+		mux.HandleFunc("GET /cluster/status", h.Cluster.ServeStatus)
+		mux.HandleFunc("POST /cluster/members", h.Cluster.ServeAddMember)
+		mux.HandleFunc("DELETE /cluster/members/{id}", h.Cluster.ServeRemoveMember)
+	}
+
+	// Requests are checked against the OpenAPI document before any
+	// handler sees them.
+	var handler http.Handler = validateRequests(mux)
+
+	// Replication
+	if h.Replication != nil {
+		mux.HandleFunc("GET /replication/log", h.Replication.ServeLog)
+		mux.HandleFunc("GET /replication/snapshot", h.Replication.ServeSnapshot)
+		mux.HandleFunc("GET /replication/status", h.Replication.ServeStatus)
+		mux.HandleFunc("POST /replication/promote", h.Replication.ServePromote)
+		handler = h.readOnlyOnFollower(handler)
+	}
+
+	if h.Trace != nil {
+		handler = h.traceReads(handler)
+	}
+	if len(h.Cfg.APIKeys) > 0 {
+		handler = authenticate(h.Cfg.APIKeys, h.Cfg.Guardrails.OperatorSettings, handler)
+	}
+	// The routes above are served under /v1 too; the unversioned ones are
+	// deprecated aliases.
+	handler = versioned(handler)
+	mcpServer.API = handler
+	return handler
+}
+
+// traceReads records queries in the trace. Mutations are recorded with
+// their command as they are submitted; health checks and node-to-node
+// traffic are left out.
+func (h *Handler) traceReads(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path != "/healthz" && !internalPath(r.URL.Path) {
+			h.Trace.Read(h.Q.Now(), r.Method, r.URL.RequestURI())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func internalPath(path string) bool {
+	for _, prefix := range []string{"/replication/", "/raft/", "/cluster/"} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// readOnlyOnFollower rejects writes while the node replicates from a leader.
+// Reads are served from the local, possibly lagging, copy. MCP messages
+// pass; the writes among their tool calls are rejected here in turn.
+func (h *Handler) readOnlyOnFollower(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && !strings.HasPrefix(r.URL.Path, "/replication/") && r.URL.Path != "/mcp" &&
+			h.Replication.Role() == replication.RoleFollower {
+			if leader := h.Replication.LeaderURL(); leader != "" {
+				w.Header().Set("X-Leader", leader)
+			}
+			writeErr(w, http.StatusServiceUnavailable, "read-only follower; send writes to the leader")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
@@ -1,21 +1,47 @@
 package httpapi
 
 import (
+	"encoding/json"
+	"icetea/priority_queue/internal/ads"
+	"icetea/priority_queue/internal/nlcmd"
+	"icetea/priority_queue/internal/queue"
+	"strconv"
 	"time"
 )
 
+// PriorityRef is a priority given either as a level number (3) or as a
+// configured class name ("urgent").
+type PriorityRef string
+
+func (p *PriorityRef) UnmarshalJSON(b []byte) error {
+	var n int
+	if err := json.Unmarshal(b, &n); err == nil {
+		*p = PriorityRef(strconv.Itoa(n))
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	*p = PriorityRef(s)
+	return nil
+}
+
+// IsZero reports whether no priority was given.
+func (p PriorityRef) IsZero() bool { return p == "" || p == "0" }
+
 // Requests
 
 type EnqueueRequest struct {
 	Ad struct {
-		AdID           string   `json:"adId"`
-		Title          string   `json:"title"`
-		GameFamily     string   `json:"gameFamily"`
-		TargetAudience []string `json:"targetAudience"`
-		Priority       int      `json:"priority"`
-		CreatedAt      string   `json:"createdAt"`
-		MaxWaitTime    int      `json:"maxWaitTime"`
-	} `json:"ad"`
+		AdID           string      `json:"adId" validate:"required"`
+		Title          string      `json:"title"`
+		GameFamily     string      `json:"gameFamily"`
+		TargetAudience []string    `json:"targetAudience"`
+		Priority       PriorityRef `json:"priority"`
+		CreatedAt      string      `json:"createdAt"`
+		MaxWaitTime    int         `json:"maxWaitTime" validate:"min=0"`
+	} `json:"ad" validate:"required"`
 	// Optional. If set, server uses this time instead of Now.
 	EnqueueAt *time.Time `json:"enqueueAt,omitempty"`
 }
@@ -25,14 +51,51 @@ type PeekRequest struct {
 }
 
 type ReprioritizeFamilyRequest struct {
-	Family      string `json:"family"`
-	NewPriority int    `json:"newPriority"`
+	Family      string      `json:"family" validate:"required"`
+	NewPriority PriorityRef `json:"newPriority"`
+	// Optional. Relative move (+1/-1) used instead of newPriority.
+	Delta int `json:"delta,omitempty"`
+	// Token from a 428 response, to run a change over the guardrails.
+	ConfirmToken string `json:"confirmToken,omitempty"`
 }
 
 type ReprioritizeAgeRequest struct {
 	// Duration string like "5s", "3m", "1h"
-	Age         string `json:"age"`
-	NewPriority int    `json:"newPriority"`
+	Age         string      `json:"age" validate:"required,duration"`
+	NewPriority PriorityRef `json:"newPriority"`
+	// Optional. Relative move (+1/-1) used instead of newPriority.
+	Delta int `json:"delta,omitempty"`
+	// Token from a 428 response, to run a change over the guardrails.
+	ConfirmToken string `json:"confirmToken,omitempty"`
+}
+
+type BulkReprioritizeRequest struct {
+	// Filter expression like `family = RPG and age > 10m and priority = 1`
+	Filter      string      `json:"filter" validate:"required"`
+	NewPriority PriorityRef `json:"newPriority"`
+	// Optional. Relative move (+1/-1) used instead of newPriority.
+	Delta  int  `json:"delta,omitempty"`
+	DryRun bool `json:"dryRun"`
+	// Token from a 428 response, to run a change over the guardrails.
+	ConfirmToken string `json:"confirmToken,omitempty"`
+}
+
+type BulkRemoveRequest struct {
+	Filter string `json:"filter" validate:"required"`
+	DryRun bool   `json:"dryRun"`
+	// Token from a 428 response, to run a change over the guardrails.
+	ConfirmToken string `json:"confirmToken,omitempty"`
+}
+
+type BoostRequest struct {
+	// Either a family or a filter expression selects the boosted ads.
+	Family string `json:"family,omitempty"`
+	Filter string `json:"filter,omitempty"`
+	Delta  int    `json:"delta"`
+	// Duration string like "30m"
+	Duration string `json:"duration" validate:"required,duration"`
+	// Token from a 428 response, to run a change over the guardrails.
+	ConfirmToken string `json:"confirmToken,omitempty"`
 }
 
 type WaitingRequest struct {
@@ -45,15 +108,180 @@ type AntiStarvationRequest struct {
 }
 
 type MaximumWaitRequest struct {
-	MaximumWait int `json:"maximumWait"`
+	MaximumWait int `json:"maximumWait" validate:"required,min=1"`
+}
+
+type PrioritiesRequest struct {
+	TotalPriority int `json:"totalPriority" validate:"required,min=1"`
+	// "clamp" (default) or "proportional"; only used when shrinking.
+	Strategy string `json:"strategy,omitempty" validate:"oneof=clamp proportional"`
+}
+
+type PauseRequest struct {
+	// "all", "priority" or "family"
+	Scope    string      `json:"scope" validate:"required,oneof=all priority family"`
+	Priority PriorityRef `json:"priority,omitempty"`
+	Family   string      `json:"family,omitempty"`
+}
+
+type PurgeRequest struct {
+	// Filters; all given filters must match. At least one is required
+	// unless All is set.
+	Priority  PriorityRef `json:"priority,omitempty"`
+	Family    string      `json:"family,omitempty"`
+	OlderThan string      `json:"olderThan,omitempty" validate:"duration"` // like "10m"
+	Filter    string      `json:"filter,omitempty"`
+	// All purges the whole queue; it cannot be combined with filters.
+	All bool `json:"all,omitempty"`
+	// "file" (default) writes the export to purgeExportDir; "response"
+	// streams it back as JSONL instead.
+	Export string `json:"export,omitempty" validate:"oneof=file response"`
+	// Token from a 428 response, to run a change over the guardrails.
+	ConfirmToken string `json:"confirmToken,omitempty"`
+}
+
+type DrainRequest struct {
+	Enable bool `json:"enable"`
+}
+
+type CommandRequest struct {
+	// Plain English, e.g. "Show the next 5 ads".
+	Text string `json:"text" validate:"required"`
+	// Carry out a change; without it a change is only previewed. Queries
+	// always run.
+	Confirm bool `json:"confirm"`
+	// The token from the preview, for a change over the guardrails.
+	ConfirmToken string `json:"confirmToken,omitempty"`
 }
 
 // Responses
 
+// LeasedAd is a dequeued ad with its lease, flattened into one object.
+type LeasedAd struct {
+	*ads.Ad
+	LeaseID        int64     `json:"leaseId"`
+	LeaseExpiresAt time.Time `json:"leaseExpiresAt"`
+}
+
+// QueuedAd is an ad as queued, with its effective priority and capped
+// MaxWaitTime, and where it stands in dequeue order, flattened into one
+// object.
+type QueuedAd struct {
+	*ads.Ad
+	queue.Position
+}
+
+// ErrorEnvelope is the body of every /v1 error.
+type ErrorEnvelope struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	// ValidationDetails for a rejected request, ConfirmDetails for a change
+	// over the guardrails, else usually absent.
+	Details   any    `json:"details,omitempty"`
+	RequestID string `json:"requestId"` // also in the X-Request-ID header
+}
+
+type ValidationDetails struct {
+	Fields []FieldError `json:"fields"` // one entry per problem
+}
+
+// ConfirmDetails come with a change over the guardrails (428). Sending the
+// request again with ConfirmToken runs it.
+type ConfirmDetails struct {
+	ConfirmToken string    `json:"confirmToken"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	Count        int       `json:"count"` // ads the change would affect
+	Total        int       `json:"total"` // ads queued
+}
+
+// ErrorResponse is the error body of the deprecated unversioned routes.
 type ErrorResponse struct {
 	Error string `json:"error"`
+	// Set on a 400 from request validation, one entry per problem.
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// ConfirmResponse is a 428 on the deprecated unversioned routes.
+type ConfirmResponse struct {
+	Error string `json:"error"`
+	ConfirmDetails
 }
 
 type OKResponse struct {
 	OK bool `json:"ok"`
 }
+
+type BulkResponse struct {
+	DryRun bool     `json:"dryRun"`
+	Count  int      `json:"count"`
+	AdIDs  []string `json:"adIds"`
+	// Skipped lists the ads left where they are because the level they
+	// would move to is at its class capacity.
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// ReprioritizeResponse answers a reprioritize or a boost cancel; Skipped is
+// as in BulkResponse.
+type ReprioritizeResponse struct {
+	OK      bool     `json:"ok"`
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+type DistributionResponse struct {
+	Total                int                  `json:"total"`
+	Dist                 []queue.PriorityDist `json:"distribution"`
+	EnableAntiStarvation bool                 `json:"enable_anti_starvation"`
+	TotalPriority        int                  `json:"total_priority"`
+}
+
+type DrainResponse struct {
+	Draining  bool `json:"draining"`
+	Remaining int  `json:"remaining"`
+	Drained   bool `json:"drained"`
+}
+
+type AdsResponse struct {
+	Total  int                `json:"total"` // matches, not just this page
+	Offset int                `json:"offset"`
+	Ads    []queue.ItemRecord `json:"ads"`
+}
+
+// DashboardEvent is one update on /events.
+type DashboardEvent struct {
+	Time                 time.Time            `json:"time"`
+	Total                int                  `json:"total"`
+	Distribution         []queue.PriorityDist `json:"distribution"`
+	EnableAntiStarvation bool                 `json:"enableAntiStarvation"`
+	Stats                queue.Stats          `json:"stats"`
+	Heads                []queue.HeadScore    `json:"heads"` // oldest ad of each level
+}
+
+type SettingsResponse struct {
+	EnableAntiStarvation bool `json:"enableAntiStarvation"`
+	MaximumWait          int  `json:"maximumWait"` // seconds
+	TotalPriority        int  `json:"totalPriority"`
+}
+
+type CommandResponse struct {
+	Understood bool           `json:"understood"`
+	Text       string         `json:"text"`
+	Command    *nlcmd.Command `json:"command,omitempty"`
+	Summary    string         `json:"summary,omitempty"` // the command in a sentence
+	Executed   bool           `json:"executed"`
+	// Changes: the ads that move (or would, in a preview), or the settings,
+	// as they are in a preview and as changed once executed.
+	Affected *BulkResponse     `json:"affected,omitempty"`
+	Settings *SettingsResponse `json:"settings,omitempty"`
+	// Set on a preview over the guardrails; send it back with confirm.
+	ConfirmToken string `json:"confirmToken,omitempty"`
+	// Queries: what the matching GET route returns.
+	Result any `json:"result,omitempty"`
+	// Not understood: why, and phrasings that work.
+	Message  string   `json:"message,omitempty"`
+	Examples []string `json:"examples,omitempty"`
+}
+
+type PurgeResponse struct {
+	Count      int    `json:"count"`
+	ExportFile string `json:"exportFile"`
+}
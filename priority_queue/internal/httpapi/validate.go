@@ -0,0 +1,328 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldError names a request field and what is wrong with it. Field is a
+// dotted path into the JSON body ("ad.adId"), or a query or path parameter.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// rules are the constraints in a validate struct tag, such as
+// `validate:"required,min=0"`. The same tags shape the OpenAPI schemas.
+type rules struct {
+	required bool     // present, and not empty for strings
+	min, max *int     // integers
+	duration bool     // a Go duration string like "10m"
+	oneOf    []string // allowed strings; empty is allowed unless required
+}
+
+func parseRules(tag string) rules {
+	var r rules
+	for _, part := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(part, "=")
+		switch name {
+		case "required":
+			r.required = true
+		case "min", "max":
+			n, err := strconv.Atoi(arg)
+			if err != nil {
+				panic("httpapi: bad validate tag " + tag)
+			}
+			if name == "min" {
+				r.min = &n
+			} else {
+				r.max = &n
+			}
+		case "duration":
+			r.duration = true
+		case "oneof":
+			r.oneOf = strings.Fields(arg)
+		}
+	}
+	return r
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	priorityType  = reflect.TypeOf(PriorityRef(""))
+	errorCodeType = reflect.TypeOf(ErrorCode(""))
+)
+
+// jsonField is a struct field as it appears in JSON.
+type jsonField struct {
+	name  string
+	field reflect.StructField
+}
+
+// jsonFields lists the JSON fields of struct type t, flattening embedded
+// structs the way encoding/json does.
+func jsonFields(t reflect.Type) []jsonField {
+	var out []jsonField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				out = append(out, jsonFields(ft)...)
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+		out = append(out, jsonField{name: name, field: f})
+	}
+	return out
+}
+
+// checkValue checks v, decoded with json.Decoder.UseNumber, against what
+// encoding/json would accept for t, plus the validate tags. Unlike
+// encoding/json it rejects unknown fields and reports every problem with
+// its path rather than the first.
+func checkValue(t reflect.Type, v any, path string, errs *[]FieldError) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if v == nil {
+		return // null leaves the zero value
+	}
+	fail := func(msg string) { *errs = append(*errs, FieldError{Field: path, Message: msg}) }
+	switch {
+	case t == priorityType:
+		switch p := v.(type) {
+		case json.Number:
+			if _, err := strconv.Atoi(string(p)); err != nil {
+				fail("must be an integer or a string")
+			}
+		case string:
+		default:
+			fail("must be an integer or a string")
+		}
+		return
+	case t == timeType:
+		s, ok := v.(string)
+		if _, err := time.Parse(time.RFC3339, s); !ok || err != nil {
+			fail("must be an RFC 3339 time")
+		}
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := v.(map[string]any)
+		if !ok {
+			fail("must be an object")
+			return
+		}
+		known := map[string]bool{}
+		for _, f := range jsonFields(t) {
+			known[f.name] = true
+			fv, present := m[f.name]
+			r := parseRules(f.field.Tag.Get("validate"))
+			fpath := join(path, f.name)
+			if !present {
+				if r.required {
+					*errs = append(*errs, FieldError{Field: fpath, Message: "required"})
+				}
+				continue
+			}
+			checkValue(f.field.Type, fv, fpath, errs)
+			checkRules(r, fv, fpath, errs)
+		}
+		names := make([]string, 0, len(m))
+		for name := range m {
+			if !known[name] {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			*errs = append(*errs, FieldError{Field: join(path, name), Message: "unknown field"})
+		}
+	case reflect.Slice:
+		items, ok := v.([]any)
+		if !ok {
+			fail("must be an array")
+			return
+		}
+		for i, item := range items {
+			checkValue(t.Elem(), item, fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	case reflect.String:
+		if _, ok := v.(string); !ok {
+			fail("must be a string")
+		}
+	case reflect.Bool:
+		if _, ok := v.(bool); !ok {
+			fail("must be true or false")
+		}
+	case reflect.Int, reflect.Int64:
+		n, ok := v.(json.Number)
+		if _, err := strconv.ParseInt(string(n), 10, 64); !ok || err != nil {
+			fail("must be an integer")
+		}
+	}
+}
+
+// checkRules applies the validate tag rules to a value that has the right
+// JSON type; checkValue reports the others.
+func checkRules(r rules, v any, path string, errs *[]FieldError) {
+	fail := func(msg string) { *errs = append(*errs, FieldError{Field: path, Message: msg}) }
+	switch v := v.(type) {
+	case string:
+		switch {
+		case v == "" && r.required:
+			fail("must not be empty")
+		case v == "":
+		case r.duration:
+			if _, err := time.ParseDuration(v); err != nil {
+				fail(msgDuration)
+			}
+		case len(r.oneOf) > 0 && !slices.Contains(r.oneOf, v):
+			fail("must be one of " + strings.Join(r.oneOf, ", "))
+		}
+	case json.Number:
+		n, err := strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			return
+		}
+		if r.min != nil && n < int64(*r.min) {
+			fail(fmt.Sprintf("must be at least %d", *r.min))
+		}
+		if r.max != nil && n > int64(*r.max) {
+			fail(fmt.Sprintf("must be at most %d", *r.max))
+		}
+	}
+}
+
+// checkParam checks a query or path parameter.
+func checkParam(p param, raw string, errs *[]FieldError) {
+	fail := func(msg string) { *errs = append(*errs, FieldError{Field: p.name, Message: msg}) }
+	switch p.kind {
+	case "integer":
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			fail("must be an integer")
+			return
+		}
+		checkRules(p.rules, json.Number(strconv.Itoa(n)), p.name, errs)
+	case "boolean":
+		if raw != "true" && raw != "false" {
+			fail("must be true or false")
+		}
+	case "string":
+		checkRules(p.rules, raw, p.name, errs)
+	}
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+const msgDuration = `must be a duration like "10m"`
+
+// writeInvalid answers 400 with the field errors, which are also summed up
+// in the error message for clients that only read that. The code is
+// INVALID_DURATION when every problem is a malformed duration.
+func writeInvalid(w http.ResponseWriter, errs []FieldError) {
+	parts := make([]string, len(errs))
+	code := CodeInvalidDuration
+	for i, e := range errs {
+		parts[i] = e.Field + ": " + e.Message
+		if e.Message != msgDuration {
+			code = CodeInvalidRequest
+		}
+	}
+	writeError(w, http.StatusBadRequest, code, "invalid request: "+strings.Join(parts, "; "), ValidationDetails{Fields: errs})
+}
+
+// maxBody caps the JSON bodies the validator reads; imports are JSONL and
+// are not read here.
+const maxBody = 1 << 20
+
+// validateRequests checks the parameters and JSON body of every request
+// that matches an operation in the OpenAPI document, answering 400 with
+// field errors before the handler runs. Requests it has no operation for
+// pass through.
+func validateRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op, pathValues := findOperation(r.Method, r.URL.Path)
+		if op == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var errs []FieldError
+		query := r.URL.Query()
+		for _, p := range op.params() {
+			raw, present := pathValues[p.name], true
+			if p.in == "query" {
+				raw, present = query.Get(p.name), query.Has(p.name)
+			}
+			switch {
+			case !present || raw == "":
+				if p.rules.required {
+					errs = append(errs, FieldError{Field: p.name, Message: "required"})
+				}
+			default:
+				checkParam(p, raw, &errs)
+			}
+		}
+
+		if op.request != nil {
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+			if err != nil {
+				writeErr(w, http.StatusBadRequest, "reading body: "+err.Error())
+				return
+			}
+			if len(body) > maxBody {
+				writeErr(w, http.StatusRequestEntityTooLarge, "body larger than 1 MiB")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			var v any
+			dec := json.NewDecoder(bytes.NewReader(body))
+			dec.UseNumber()
+			if err := dec.Decode(&v); err != nil {
+				writeErr(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+				return
+			}
+			if dec.More() {
+				writeErr(w, http.StatusBadRequest, "invalid JSON: more than one value in the body")
+				return
+			}
+			checkValue(reflect.TypeOf(op.request), v, "", &errs)
+		}
+
+		if len(errs) > 0 {
+			writeInvalid(w, errs)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
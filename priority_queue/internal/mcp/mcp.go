@@ -0,0 +1,145 @@
+// Package mcp serves the queue API over the Model Context Protocol, so any
+// MCP-capable assistant can call queue operations as tools and read the
+// distribution and peek list as resources.
+//
+// Tools and resources are not a second implementation of the API: each call
+// becomes a request to the REST handler carrying the caller's API key, so
+// the REST roles, validation and follower rules apply unchanged.
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+)
+
+// Protocol versions this server speaks, newest first.
+var protocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// JSON-RPC error codes.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeServerError    = -32000 // the REST API refused the call
+	codeNotFound       = -32002 // unknown resource
+)
+
+const instructions = `Controls a priority queue of video ads. Higher priority levels are dequeued first; anti-starvation ages ads that wait past their maximum wait so low levels are still served.
+Durations are Go-style strings such as "10m", "5s" or "1h30m". Priorities are level numbers or configured class names.
+"Enable starvation mode" means turning anti-starvation off; "disable starvation mode" means turning it on.
+Prefer dryRun on bulk tools before changing many ads. A change that affects too much of the queue fails with 428 and a confirmToken: check with the user before repeating the call with that token. undo_reprioritize reverts the last reprioritize.`
+
+// Server answers MCP requests by calling API.
+type Server struct {
+	// API serves the REST routes, authentication included.
+	API http.Handler
+
+	// Reported to clients on initialize. Name defaults to "priority_queue".
+	Name    string
+	Version string
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"` // absent for notifications
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+func errorf(code int, msg string) *rpcError {
+	return &rpcError{Code: code, Message: msg}
+}
+
+// handle answers one JSON-RPC message. It returns nil for notifications and
+// for responses from the client, which need no answer. creds holds the
+// headers that authenticate the caller to the REST API.
+func (s *Server) handle(ctx context.Context, msg []byte, creds http.Header) *response {
+	var req request
+	if err := json.Unmarshal(msg, &req); err != nil {
+		return &response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: errorf(codeParseError, "parse error: "+err.Error())}
+	}
+	if req.Method == "" && len(req.ID) > 0 {
+		return nil // a response; this server sends no requests
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		id := req.ID
+		if len(id) == 0 {
+			id = json.RawMessage("null")
+		}
+		return &response{JSONRPC: "2.0", ID: id, Error: errorf(codeInvalidRequest, "not a JSON-RPC 2.0 request")}
+	}
+	if len(req.ID) == 0 {
+		return nil // notifications/initialized, notifications/cancelled, ...
+	}
+
+	result, rerr := s.call(ctx, req.Method, req.Params, creds)
+	if rerr != nil {
+		return &response{JSONRPC: "2.0", ID: req.ID, Error: rerr}
+	}
+	return &response{JSONRPC: "2.0", ID: req.ID, Result: result}
+}
+
+func (s *Server) call(ctx context.Context, method string, params json.RawMessage, creds http.Header) (any, *rpcError) {
+	switch method {
+	case "initialize":
+		return s.initialize(params)
+	case "ping":
+		return struct{}{}, nil
+	case "tools/list":
+		return map[string]any{"tools": toolList()}, nil
+	case "tools/call":
+		return s.callTool(ctx, params, creds)
+	case "resources/list":
+		return map[string]any{"resources": resources}, nil
+	case "resources/templates/list":
+		return map[string]any{"resourceTemplates": resourceTemplates}, nil
+	case "resources/read":
+		return s.readResource(ctx, params, creds)
+	}
+	return nil, errorf(codeMethodNotFound, "method not found: "+method)
+}
+
+func (s *Server) initialize(params json.RawMessage) (any, *rpcError) {
+	var p struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, errorf(codeInvalidParams, "invalid initialize params: "+err.Error())
+		}
+	}
+	// Answer with the client's version when we speak it, else our newest.
+	version := protocolVersions[0]
+	if slices.Contains(protocolVersions, p.ProtocolVersion) {
+		version = p.ProtocolVersion
+	}
+	name := s.Name
+	if name == "" {
+		name = "priority_queue"
+	}
+	return map[string]any{
+		"protocolVersion": version,
+		"capabilities": map[string]any{
+			"tools":     map[string]any{},
+			"resources": map[string]any{},
+		},
+		"serverInfo":   map[string]string{"name": name, "version": s.Version},
+		"instructions": instructions,
+	}, nil
+}
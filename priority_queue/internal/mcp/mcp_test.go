@@ -0,0 +1,233 @@
+package mcp_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"icetea/priority_queue/config"
+	"icetea/priority_queue/internal/httpapi"
+	"icetea/priority_queue/internal/mcp"
+	"icetea/priority_queue/internal/queue"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var testConfig = config.Config{
+	TotalPriority:      3,
+	MaximumWaitSeconds: 600,
+	BTreeDegree:        16,
+	PriorityClasses:    []config.PriorityClass{{Name: "urgent", Level: 3}},
+	APIKeys: []config.APIKey{
+		{Name: "dash", Key: "r-key", Role: config.RoleReader},
+		{Name: "svc", Key: "o-key", Role: config.RoleOperator},
+	},
+}
+
+type rpcResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type toolResult struct {
+	Content []struct {
+		Text string `json:"text"`
+	} `json:"content"`
+	StructuredContent map[string]any `json:"structuredContent"`
+	IsError           bool           `json:"isError"`
+}
+
+// session posts JSON-RPC requests to /mcp with an API key.
+type session struct {
+	t      *testing.T
+	url    string
+	key    string
+	nextID int
+}
+
+func (s *session) post(body string) *http.Response {
+	s.t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, s.url+"/mcp", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if s.key != "" {
+		req.Header.Set("Authorization", "Bearer "+s.key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return resp
+}
+
+func (s *session) call(method string, params any) rpcResponse {
+	s.t.Helper()
+	s.nextID++
+	b, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": s.nextID, "method": method, "params": params})
+	resp := s.post(string(b))
+	defer resp.Body.Close()
+	var out rpcResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		s.t.Fatalf("%s: status %d: %v", method, resp.StatusCode, err)
+	}
+	if out.ID != s.nextID {
+		s.t.Fatalf("%s: response id %d, want %d", method, out.ID, s.nextID)
+	}
+	return out
+}
+
+func (s *session) tool(name string, args any) toolResult {
+	s.t.Helper()
+	resp := s.call("tools/call", map[string]any{"name": name, "arguments": args})
+	if resp.Error != nil {
+		s.t.Fatalf("%s: %+v", name, resp.Error)
+	}
+	var res toolResult
+	if err := json.Unmarshal(resp.Result, &res); err != nil {
+		s.t.Fatal(err)
+	}
+	return res
+}
+
+func TestHTTPTransport(t *testing.T) {
+	q := queue.NewFromConfig(testConfig)
+	srv := httptest.NewServer((&httpapi.Handler{Q: q, Cfg: testConfig}).Router())
+	defer srv.Close()
+	op := &session{t: t, url: srv.URL, key: "o-key"}
+
+	init := op.call("initialize", map[string]any{"protocolVersion": "2025-03-26", "capabilities": map[string]any{}})
+	if !bytes.Contains(init.Result, []byte(`"protocolVersion":"2025-03-26"`)) {
+		t.Fatalf("initialize = %s, want the client's version back", init.Result)
+	}
+	if resp := op.post(`{"jsonrpc":"2.0","method":"notifications/initialized"}`); resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("notification status = %d, want 202", resp.StatusCode)
+	}
+
+	var list struct {
+		Tools []struct {
+			Name        string         `json:"name"`
+			InputSchema map[string]any `json:"inputSchema"`
+		} `json:"tools"`
+	}
+	if err := json.Unmarshal(op.call("tools/list", nil).Result, &list); err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, tl := range list.Tools {
+		names[tl.Name] = true
+		if tl.InputSchema["type"] != "object" {
+			t.Fatalf("%s schema = %v, want an object", tl.Name, tl.InputSchema)
+		}
+	}
+	for _, want := range []string{"enqueue", "dequeue", "peek", "reprioritize_family", "set_anti_starvation", "purge"} {
+		if !names[want] {
+			t.Fatalf("tools/list is missing %s", want)
+		}
+	}
+
+	if res := op.tool("enqueue", map[string]any{"ad": map[string]any{"adId": "a1", "gameFamily": "RPG", "priority": "urgent"}}); res.IsError || res.StructuredContent["priority"] != 3.0 {
+		t.Fatalf("enqueue = %+v", res)
+	}
+	op.tool("enqueue", map[string]any{"ad": map[string]any{"adId": "a2", "gameFamily": "Puzzle", "priority": 1}})
+	if res := op.tool("peek", map[string]any{"n": 5}); res.IsError || !strings.Contains(res.Content[0].Text, `"a1"`) {
+		t.Fatalf("peek = %+v", res)
+	}
+	if res := op.tool("reprioritize_family", map[string]any{"family": "Puzzle", "newPriority": 2}); res.IsError {
+		t.Fatalf("reprioritize_family = %+v", res)
+	}
+
+	// Bad arguments are reported as tool errors the model can act on.
+	for args, want := range map[string]string{
+		`{"ad":{"title":"x"}}`:                "ad.adId: required",
+		`{"ad":{"adId":"x","priority":true}}`: "ad.priority: must be an integer or a string",
+		`{"ad":{"adId":"x"},"extra":1}`:       "extra: unknown argument",
+	} {
+		var a map[string]any
+		json.Unmarshal([]byte(args), &a)
+		if res := op.tool("enqueue", a); !res.IsError || res.Content[0].Text != want {
+			t.Fatalf("enqueue(%s) = %+v, want error %q", args, res, want)
+		}
+	}
+	if resp := op.call("tools/call", map[string]any{"name": "nope"}); resp.Error == nil || resp.Error.Code != -32602 {
+		t.Fatalf("unknown tool = %+v, want invalid params", resp)
+	}
+	if resp := op.call("sampling/createMessage", nil); resp.Error == nil || resp.Error.Code != -32601 {
+		t.Fatalf("unknown method = %+v, want method not found", resp)
+	}
+
+	// The REST roles apply to each call.
+	if res := op.tool("set_anti_starvation", map[string]any{"enable": false}); !res.IsError || !strings.HasPrefix(res.Content[0].Text, "403") {
+		t.Fatalf("operator settings change = %+v, want 403", res)
+	}
+	reader := &session{t: t, url: srv.URL, key: "r-key"}
+	if res := reader.tool("dequeue", nil); !res.IsError || !strings.Contains(res.Content[0].Text, "requires the operator role") {
+		t.Fatalf("reader dequeue = %+v, want 403", res)
+	}
+	if resp := (&session{t: t, url: srv.URL}).post(`{"jsonrpc":"2.0","id":1,"method":"ping"}`); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("no key status = %d, want 401", resp.StatusCode)
+	}
+
+	var read struct {
+		Contents []struct {
+			URI  string `json:"uri"`
+			Text string `json:"text"`
+		} `json:"contents"`
+	}
+	if err := json.Unmarshal(reader.call("resources/read", map[string]string{"uri": "queue://distribution"}).Result, &read); err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Contents) != 1 || !strings.Contains(read.Contents[0].Text, `"total":2`) {
+		t.Fatalf("distribution resource = %+v", read)
+	}
+	if err := json.Unmarshal(reader.call("resources/read", map[string]string{"uri": "queue://peek/1"}).Result, &read); err != nil {
+		t.Fatal(err)
+	}
+	var peek []map[string]any
+	if json.Unmarshal([]byte(read.Contents[0].Text), &peek); len(peek) != 1 || peek[0]["adId"] != "a1" {
+		t.Fatalf("peek/1 resource = %+v", read)
+	}
+	if resp := reader.call("resources/read", map[string]string{"uri": "queue://nope"}); resp.Error == nil || resp.Error.Code != -32002 {
+		t.Fatalf("unknown resource = %+v, want -32002", resp)
+	}
+}
+
+func TestStdioTransport(t *testing.T) {
+	q := queue.NewFromConfig(testConfig)
+	s := &mcp.Server{API: (&httpapi.Handler{Q: q, Cfg: testConfig}).Router()}
+	in := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2099-01-01"}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"enqueue","arguments":{"ad":{"adId":"a1","priority":2}}}}`,
+		`not json`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"get_distribution"}}`,
+	}, "\n")
+	var out bytes.Buffer
+	if err := s.ServeStdio(context.Background(), strings.NewReader(in), &out, "o-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d responses, want 4 (none for the notification):\n%s", len(lines), out.String())
+	}
+	if !strings.Contains(lines[0], `"protocolVersion":"2025-06-18"`) {
+		t.Fatalf("initialize = %s, want our newest version for an unknown one", lines[0])
+	}
+	if !strings.Contains(lines[2], `"code":-32700`) {
+		t.Fatalf("bad line = %s, want a parse error", lines[2])
+	}
+	var resp rpcResponse
+	var res toolResult
+	json.Unmarshal([]byte(lines[3]), &resp)
+	json.Unmarshal(resp.Result, &res)
+	if res.IsError || res.StructuredContent["total"] != 1.0 {
+		t.Fatalf("get_distribution = %s", lines[3])
+	}
+	if _, total := q.DistributionByPriority(); total != 1 {
+		t.Fatalf("queued = %d, want 1", total)
+	}
+}
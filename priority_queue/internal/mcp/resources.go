@@ -0,0 +1,89 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type resource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	MIMEType    string `json:"mimeType"`
+	path        string // REST route that serves it
+}
+
+var resources = []resource{
+	{
+		URI:         "queue://distribution",
+		Name:        "distribution",
+		Description: "Count and share of queued ads per priority level, and whether anti-starvation is on.",
+		MIMEType:    "application/json",
+		path:        "/v1/distribution",
+	},
+	{
+		URI:         "queue://peek",
+		Name:        "peek",
+		Description: "The next 10 ads in the order dequeue would return them.",
+		MIMEType:    "application/json",
+		path:        "/v1/peek?n=10",
+	},
+}
+
+type resourceTemplate struct {
+	URITemplate string `json:"uriTemplate"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	MIMEType    string `json:"mimeType"`
+}
+
+var resourceTemplates = []resourceTemplate{{
+	URITemplate: "queue://peek/{n}",
+	Name:        "peek-n",
+	Description: "The next n ads in the order dequeue would return them.",
+	MIMEType:    "application/json",
+}}
+
+// resourcePath maps a resource URI to the REST route that serves it.
+func resourcePath(uri string) (string, bool) {
+	for _, r := range resources {
+		if r.URI == uri {
+			return r.path, true
+		}
+	}
+	if s, ok := strings.CutPrefix(uri, "queue://peek/"); ok {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 {
+			return "/v1/peek?n=" + s, true
+		}
+	}
+	return "", false
+}
+
+func (s *Server) readResource(ctx context.Context, params json.RawMessage, creds http.Header) (any, *rpcError) {
+	var p struct {
+		URI string `json:"uri"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, errorf(codeInvalidParams, "invalid resources/read params: "+err.Error())
+	}
+	path, ok := resourcePath(p.URI)
+	if !ok {
+		return nil, &rpcError{Code: codeNotFound, Message: "resource not found", Data: map[string]string{"uri": p.URI}}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, errorf(codeInvalidParams, err.Error())
+	}
+	status, body := s.do(req, creds)
+	if status >= 300 {
+		return nil, &rpcError{Code: codeServerError, Message: apiError(status, body), Data: map[string]int{"status": status}}
+	}
+	return map[string]any{"contents": []map[string]string{{
+		"uri":      p.URI,
+		"mimeType": "application/json",
+		"text":     strings.TrimSpace(string(body)),
+	}}}, nil
+}
@@ -0,0 +1,161 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// schema is a JSON Schema, limited to what the tools need: objects,
+// strings, integers, booleans, string arrays, anyOf and enum.
+type schema map[string]any
+
+func object(required []string, props map[string]schema) schema {
+	s := schema{"type": "object", "properties": props, "additionalProperties": false}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+func str(desc string) schema {
+	return schema{"type": "string", "description": desc}
+}
+
+func enum(desc string, values ...string) schema {
+	return schema{"type": "string", "description": desc, "enum": values}
+}
+
+func integer(desc string, min int) schema {
+	return schema{"type": "integer", "description": desc, "minimum": min}
+}
+
+func boolean(desc string) schema {
+	return schema{"type": "boolean", "description": desc}
+}
+
+func stringList(desc string) schema {
+	return schema{"type": "array", "description": desc, "items": schema{"type": "string"}}
+}
+
+// priority accepts a level number or a configured class name.
+func priority(desc string) schema {
+	return schema{
+		"description": desc,
+		"anyOf":       []schema{{"type": "integer", "minimum": 1}, {"type": "string", "minLength": 1}},
+	}
+}
+
+// validate checks v, decoded with json.Decoder.UseNumber, against s. The
+// error names the offending argument, like "ad.adId: required".
+func validate(s schema, v any, path string) error {
+	if alts, ok := s["anyOf"].([]schema); ok {
+		for _, alt := range alts {
+			if validate(alt, v, path) == nil {
+				return nil
+			}
+		}
+		return fieldErr(path, "must be "+describe(s))
+	}
+
+	switch s["type"] {
+	case "object":
+		m, ok := v.(map[string]any)
+		if !ok {
+			return fieldErr(path, "must be an object")
+		}
+		props, _ := s["properties"].(map[string]schema)
+		req, _ := s["required"].([]string)
+		for _, name := range req {
+			if _, ok := m[name]; !ok {
+				return fieldErr(join(path, name), "required")
+			}
+		}
+		// Sorted so the same bad call always reports the same field.
+		names := make([]string, 0, len(m))
+		for name := range m {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			p, ok := props[name]
+			if !ok {
+				return fieldErr(join(path, name), "unknown argument")
+			}
+			if err := validate(p, m[name], join(path, name)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		sv, ok := v.(string)
+		if !ok {
+			return fieldErr(path, "must be a string")
+		}
+		if values, ok := s["enum"].([]string); ok && !slices.Contains(values, sv) {
+			return fieldErr(path, fmt.Sprintf("must be one of %q", values))
+		}
+		if n, ok := s["minLength"].(int); ok && len(sv) < n {
+			return fieldErr(path, "must not be empty")
+		}
+	case "integer":
+		num, ok := v.(json.Number)
+		if !ok {
+			return fieldErr(path, "must be an integer")
+		}
+		n, err := strconv.ParseInt(string(num), 10, 64)
+		if err != nil {
+			return fieldErr(path, "must be an integer")
+		}
+		if min, ok := s["minimum"].(int); ok && n < int64(min) {
+			return fieldErr(path, fmt.Sprintf("must be at least %d", min))
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fieldErr(path, "must be true or false")
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			return fieldErr(path, "must be an array")
+		}
+		for i, item := range items {
+			if err := validate(s["items"].(schema), item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func describe(s schema) string {
+	if alts, ok := s["anyOf"].([]schema); ok {
+		out := ""
+		for i, alt := range alts {
+			if i > 0 {
+				out += " or "
+			}
+			out += describe(alt)
+		}
+		return out
+	}
+	if s["type"] == "integer" {
+		return "an integer"
+	}
+	return fmt.Sprintf("a %v", s["type"])
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func fieldErr(path, msg string) error {
+	if path == "" {
+		return fmt.Errorf("arguments: %s", msg)
+	}
+	return fmt.Errorf("%s: %s", path, msg)
+}
@@ -0,0 +1,433 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// tool is an MCP tool backed by one REST route. Arguments named in the
+// path ({id}) or listed in query fill those in, the one named by rawBody is
+// sent as the body verbatim, and the rest form the JSON body.
+type tool struct {
+	name        string
+	description string
+	schema      schema
+	method      string
+	path        string
+	query       []string
+	rawBody     string
+	readOnly    bool
+	destructive bool // may remove ads or settings that cannot be restored
+}
+
+var (
+	newPriority = priority("Target priority level, or a class name like \"urgent\". Leave out when using delta.")
+	delta       = schema{"type": "integer", "description": "Move by this many levels instead of to newPriority, e.g. 1 or -1."}
+	filterExpr  = str("Filter expression, e.g. `family = RPG and age > 10m and priority = 1`.")
+	dryRun      = boolean("List the matching ads without changing anything.")
+	confirm     = str("Token from a 428 error; repeat the call with it to make a change that affects many ads.")
+	leaseID     = object([]string{"id"}, map[string]schema{"id": integer("Lease ID returned by dequeue.", 1)})
+	boostID     = object([]string{"id"}, map[string]schema{"id": integer("Boost ID returned by create_boost.", 1)})
+	pauseScope  = object([]string{"scope"}, map[string]schema{
+		"scope":    enum("What to pause or resume.", "all", "priority", "family"),
+		"priority": priority("Level or class name, for scope priority."),
+		"family":   str("Game family, for scope family."),
+	})
+	noArgs = object(nil, map[string]schema{})
+)
+
+var tools = []tool{
+	{
+		name:        "enqueue",
+		description: "Add an ad to the queue. Returns it with its seq, effective priority, capped maxWaitTime, position and estimated start.",
+		schema: object([]string{"ad"}, map[string]schema{
+			"ad": object([]string{"adId"}, map[string]schema{
+				"adId":           schema{"type": "string", "description": "Unique ad ID.", "minLength": 1},
+				"title":          str("Ad title."),
+				"gameFamily":     str("Game family, e.g. \"RPG-Fantasy\"."),
+				"targetAudience": stringList("Audience tags."),
+				"priority":       priority("Priority level or class name; defaults to the lowest level."),
+				"createdAt":      str("Creation time as sent by the producer."),
+				"maxWaitTime":    integer("Seconds the ad may wait before anti-starvation ages it; capped by the maximum wait setting.", 0),
+			}),
+			"enqueueAt": str("RFC 3339 time to use as the enqueue time instead of now."),
+		}),
+		method: http.MethodPost, path: "/v1/enqueue",
+	},
+	{
+		name:        "dequeue",
+		description: "Remove and return the next ad. With lease, the ad comes back unless acked in time.",
+		schema:      object(nil, map[string]schema{"lease": str("Lease length such as \"30s\".")}),
+		method:      http.MethodPost, path: "/v1/dequeue", query: []string{"lease"},
+		destructive: true,
+	},
+	{
+		name:        "ack_lease",
+		description: "Finish a leased ad so it does not return to the queue.",
+		schema:      leaseID,
+		method:      http.MethodPost, path: "/v1/leases/{id}/ack",
+	},
+	{
+		name:        "nack_lease",
+		description: "Return a leased ad to its original position in the queue.",
+		schema:      leaseID,
+		method:      http.MethodPost, path: "/v1/leases/{id}/nack",
+	},
+	{
+		name:        "list_leases",
+		description: "List outstanding leases.",
+		schema:      noArgs,
+		method:      http.MethodGet, path: "/v1/leases", readOnly: true,
+	},
+	{
+		name:        "peek",
+		description: "Preview the next n ads in the order dequeue would return them.",
+		schema:      object(nil, map[string]schema{"n": integer("Number of ads, default 1.", 1)}),
+		method:      http.MethodGet, path: "/v1/peek", query: []string{"n"}, readOnly: true,
+	},
+	{
+		name:        "next_ads",
+		description: "Next n ads with their wait, deadline and anti-starvation score.",
+		schema:      object(nil, map[string]schema{"n": integer("Number of ads, default 1.", 1)}),
+		method:      http.MethodGet, path: "/v1/debug/next", query: []string{"n"}, readOnly: true,
+	},
+	{
+		name:        "get_distribution",
+		description: "Count and share of queued ads per priority level, and whether anti-starvation is on.",
+		schema:      noArgs,
+		method:      http.MethodGet, path: "/v1/distribution", readOnly: true,
+	},
+	{
+		name:        "get_stats",
+		description: "Queued and leased counts, enqueue/dequeue totals, oldest wait and queued ads per family.",
+		schema:      noArgs,
+		method:      http.MethodGet, path: "/v1/stats", readOnly: true,
+	},
+	{
+		name:        "get_scores",
+		description: "Anti-starvation score of the oldest ad of each priority level.",
+		schema:      noArgs,
+		method:      http.MethodGet, path: "/v1/debug/scores", readOnly: true,
+	},
+	{
+		name:        "list_waiting",
+		description: "List ads waiting longer than age.",
+		schema:      object([]string{"age"}, map[string]schema{"age": str("Duration such as \"5m\".")}),
+		method:      http.MethodGet, path: "/v1/waiting", query: []string{"age"}, readOnly: true,
+	},
+	{
+		name:        "search_ads",
+		description: "Page through queued ads, oldest first, by text and/or filter expression.",
+		schema: object(nil, map[string]schema{
+			"search": str("Text to find in the ad ID, title or family, ignoring case."),
+			"filter": filterExpr,
+			"offset": integer("Matches to skip.", 0),
+			"limit":  integer("Page size, default 100, at most 1000.", 1),
+		}),
+		method: http.MethodGet, path: "/v1/ads", query: []string{"search", "filter", "offset", "limit"}, readOnly: true,
+	},
+	{
+		name:        "get_ad_position",
+		description: "Where a queued ad stands in dequeue order, with the recent dequeue rate and an estimated start time.",
+		schema: object([]string{"adId"}, map[string]schema{
+			"adId": schema{"type": "string", "description": "Ad ID.", "minLength": 1},
+		}),
+		method: http.MethodGet, path: "/v1/ads/{adId}/position", readOnly: true,
+	},
+	{
+		name:        "reprioritize_family",
+		description: "Change the priority of every ad in a game family.",
+		schema: object([]string{"family"}, map[string]schema{
+			"family":       str("Game family, e.g. \"RPG-Fantasy\"."),
+			"newPriority":  newPriority,
+			"delta":        delta,
+			"confirmToken": confirm,
+		}),
+		method: http.MethodPost, path: "/v1/reprioritize/family",
+	},
+	{
+		name:        "reprioritize_age",
+		description: "Change the priority of every ad that has waited longer than age.",
+		schema: object([]string{"age"}, map[string]schema{
+			"age":          str("Duration such as \"10m\"."),
+			"newPriority":  newPriority,
+			"delta":        delta,
+			"confirmToken": confirm,
+		}),
+		method: http.MethodPost, path: "/v1/reprioritize/age",
+	},
+	{
+		name:        "bulk_reprioritize",
+		description: "Change the priority of every ad matching a filter expression.",
+		schema: object([]string{"filter"}, map[string]schema{
+			"filter":       filterExpr,
+			"newPriority":  newPriority,
+			"delta":        delta,
+			"dryRun":       dryRun,
+			"confirmToken": confirm,
+		}),
+		method: http.MethodPost, path: "/v1/bulk/reprioritize",
+	},
+	{
+		name:        "undo_reprioritize",
+		description: "Move the ads of the last reprioritize back to the priorities they had before it.",
+		schema:      noArgs,
+		method:      http.MethodPost, path: "/v1/bulk/undo",
+	},
+	{
+		name:        "bulk_remove",
+		description: "Remove every ad matching a filter expression.",
+		schema:      object([]string{"filter"}, map[string]schema{"filter": filterExpr, "dryRun": dryRun, "confirmToken": confirm}),
+		method:      http.MethodPost, path: "/v1/bulk/remove",
+		destructive: true,
+	},
+	{
+		name:        "create_boost",
+		description: "Move a family or the ads matching a filter by delta levels for a while, then restore them.",
+		schema: object([]string{"delta", "duration"}, map[string]schema{
+			"family":       str("Game family to boost; give this or filter."),
+			"filter":       filterExpr,
+			"delta":        delta,
+			"duration":     str("How long the boost lasts, e.g. \"30m\"."),
+			"confirmToken": confirm,
+		}),
+		method: http.MethodPost, path: "/v1/boosts",
+	},
+	{
+		name:        "list_boosts",
+		description: "List active boosts.",
+		schema:      noArgs,
+		method:      http.MethodGet, path: "/v1/boosts", readOnly: true,
+	},
+	{
+		name:        "cancel_boost",
+		description: "End a boost early and restore the original priorities.",
+		schema:      boostID,
+		method:      http.MethodDelete, path: "/v1/boosts/{id}",
+	},
+	{
+		name:        "get_settings",
+		description: "Current anti-starvation flag, maximum wait and number of priority levels.",
+		schema:      noArgs,
+		method:      http.MethodGet, path: "/v1/settings", readOnly: true,
+	},
+	{
+		name:        "set_anti_starvation",
+		description: "Turn anti-starvation on or off. \"Starvation mode\" means anti-starvation off.",
+		schema:      object([]string{"enable"}, map[string]schema{"enable": boolean("True turns anti-starvation on.")}),
+		method:      http.MethodPost, path: "/v1/settings/antiStarvation",
+	},
+	{
+		name:        "set_maximum_wait",
+		description: "Set the cap, in seconds, on any ad's maximum wait time.",
+		schema:      object([]string{"maximumWait"}, map[string]schema{"maximumWait": integer("Seconds.", 1)}),
+		method:      http.MethodPost, path: "/v1/settings/maximumWait",
+	},
+	{
+		name:        "set_priority_levels",
+		description: "Grow or shrink the number of priority levels. Shrinking remaps ads on removed levels.",
+		schema: object([]string{"totalPriority"}, map[string]schema{
+			"totalPriority": integer("New number of levels.", 1),
+			"strategy":      enum("How to remap when shrinking: clamp to the top level (default) or proportionally.", "clamp", "proportional"),
+		}),
+		method: http.MethodPost, path: "/v1/settings/priorities",
+	},
+	{
+		name:        "pause",
+		description: "Stop dequeues for everything, one priority level or one family. Enqueues continue.",
+		schema:      pauseScope,
+		method:      http.MethodPost, path: "/v1/admin/pause",
+	},
+	{
+		name:        "resume",
+		description: "Resume a paused scope; scope all clears every pause.",
+		schema:      pauseScope,
+		method:      http.MethodPost, path: "/v1/admin/resume",
+	},
+	{
+		name:        "get_pause_state",
+		description: "List paused priority levels and families.",
+		schema:      noArgs,
+		method:      http.MethodGet, path: "/v1/admin/pause", readOnly: true,
+	},
+	{
+		name:        "set_drain",
+		description: "Turn drain mode on or off. While draining, new enqueues are rejected.",
+		schema:      object([]string{"enable"}, map[string]schema{"enable": boolean("True starts draining.")}),
+		method:      http.MethodPost, path: "/v1/admin/drain",
+	},
+	{
+		name:        "get_drain_status",
+		description: "Whether the queue is draining and how many ads remain.",
+		schema:      noArgs,
+		method:      http.MethodGet, path: "/v1/admin/drain", readOnly: true,
+	},
+	{
+		name:        "purge",
+		description: "Export, then remove ads by priority, family, age or filter; all given conditions must match. Give at least one condition, or all=true alone to purge everything.",
+		schema: object(nil, map[string]schema{
+			"all":          boolean("Purge the whole queue; only without other conditions."),
+			"priority":     priority("Only this level or class."),
+			"family":       str("Only this game family."),
+			"olderThan":    str("Only ads older than this duration, e.g. \"1h\"."),
+			"filter":       filterExpr,
+			"export":       enum("Write the export to the server's purge directory (file, default) or return it (response).", "file", "response"),
+			"confirmToken": confirm,
+		}),
+		method: http.MethodPost, path: "/v1/admin/purge",
+		destructive: true,
+	},
+	{
+		name:        "export_queue",
+		description: "Every queued ad as JSON lines, in a form import_queue accepts.",
+		schema:      noArgs,
+		method:      http.MethodGet, path: "/v1/admin/export", readOnly: true,
+	},
+	{
+		name:        "import_queue",
+		description: "Load ads from an export_queue or purge export.",
+		schema: object([]string{"jsonl"}, map[string]schema{
+			"jsonl":          str("The export, one JSON record per line."),
+			"keepSeq":        boolean("Keep the exported sequence numbers so the old order is reproduced."),
+			"skipDuplicates": boolean("Skip ads already queued instead of failing."),
+		}),
+		method: http.MethodPost, path: "/v1/admin/import", query: []string{"keepSeq", "skipDuplicates"}, rawBody: "jsonl",
+	},
+}
+
+func findTool(name string) *tool {
+	for i := range tools {
+		if tools[i].name == name {
+			return &tools[i]
+		}
+	}
+	return nil
+}
+
+type toolInfo struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema schema          `json:"inputSchema"`
+	Annotations toolAnnotations `json:"annotations"`
+}
+
+type toolAnnotations struct {
+	ReadOnlyHint    bool `json:"readOnlyHint"`
+	DestructiveHint bool `json:"destructiveHint"`
+}
+
+func toolList() []toolInfo {
+	out := make([]toolInfo, len(tools))
+	for i, t := range tools {
+		out[i] = toolInfo{
+			Name:        t.name,
+			Description: t.description,
+			InputSchema: t.schema,
+			Annotations: toolAnnotations{ReadOnlyHint: t.readOnly, DestructiveHint: t.destructive},
+		}
+	}
+	return out
+}
+
+type content struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type toolResult struct {
+	Content           []content      `json:"content"`
+	StructuredContent map[string]any `json:"structuredContent,omitempty"`
+	IsError           bool           `json:"isError,omitempty"`
+}
+
+func toolError(msg string) toolResult {
+	return toolResult{Content: []content{{Type: "text", Text: msg}}, IsError: true}
+}
+
+// callTool runs a tools/call. Bad arguments and refusals from the API are
+// tool errors, which the model sees and can correct; only an unknown tool
+// is a protocol error.
+func (s *Server) callTool(ctx context.Context, params json.RawMessage, creds http.Header) (any, *rpcError) {
+	var p struct {
+		Name      string         `json:"name"`
+		Arguments map[string]any `json:"arguments"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(params))
+	dec.UseNumber()
+	if err := dec.Decode(&p); err != nil {
+		return nil, errorf(codeInvalidParams, "invalid tools/call params: "+err.Error())
+	}
+	t := findTool(p.Name)
+	if t == nil {
+		return nil, errorf(codeInvalidParams, "unknown tool: "+p.Name)
+	}
+	if p.Arguments == nil {
+		p.Arguments = map[string]any{}
+	}
+	if err := validate(t.schema, p.Arguments, ""); err != nil {
+		return toolError(err.Error()), nil
+	}
+
+	req, err := t.request(ctx, p.Arguments)
+	if err != nil {
+		return toolError(err.Error()), nil
+	}
+	status, body := s.do(req, creds)
+	if status >= 300 {
+		return toolError(apiError(status, body)), nil
+	}
+	if status == http.StatusNoContent {
+		return toolResult{Content: []content{{Type: "text", Text: "queue empty"}}}, nil
+	}
+	res := toolResult{Content: []content{{Type: "text", Text: strings.TrimSpace(string(body))}}}
+	var obj map[string]any
+	if json.Unmarshal(body, &obj) == nil {
+		res.StructuredContent = obj
+	}
+	return res, nil
+}
+
+// request builds the REST request for a call with validated args.
+func (t *tool) request(ctx context.Context, args map[string]any) (*http.Request, error) {
+	path := t.path
+	query := url.Values{}
+	fields := map[string]any{}
+	var body io.Reader
+	for name, v := range args {
+		switch {
+		case strings.Contains(path, "{"+name+"}"):
+			path = strings.Replace(path, "{"+name+"}", url.PathEscape(fmt.Sprint(v)), 1)
+		case slices.Contains(t.query, name):
+			query.Set(name, fmt.Sprint(v))
+		case name == t.rawBody:
+			body = strings.NewReader(v.(string))
+		default:
+			fields[name] = v
+		}
+	}
+	if body == nil && t.method != http.MethodGet {
+		b, err := json.Marshal(fields)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, t.method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
@@ -0,0 +1,140 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// ServeHTTP implements the streamable HTTP transport. Each POST carries one
+// JSON-RPC message and gets its answer as a JSON body; the server never
+// starts streams of its own, so GET is not allowed. The request's
+// Authorization or X-API-Key header authenticates every call it makes.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "MCP messages are sent with POST", http.StatusMethodNotAllowed)
+		return
+	}
+	// Browsers send Origin; refuse pages on other hosts (DNS rebinding).
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			http.Error(w, "cross-origin MCP requests are not allowed", http.StatusForbidden)
+			return
+		}
+	}
+	if v := r.Header.Get("MCP-Protocol-Version"); v != "" && !slices.Contains(protocolVersions, v) {
+		http.Error(w, "unsupported MCP-Protocol-Version "+v, http.StatusBadRequest)
+		return
+	}
+
+	msg, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 16<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	resp := s.handle(r.Context(), msg, r.Header)
+	if resp == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	code := http.StatusOK
+	if resp.Error != nil && (resp.Error.Code == codeParseError || resp.Error.Code == codeInvalidRequest) {
+		code = http.StatusBadRequest
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// ServeStdio implements the stdio transport: newline-delimited JSON-RPC
+// messages on in, answers on out, until in ends. Calls are made with
+// apiKey, if set, as a bearer token.
+func (s *Server) ServeStdio(ctx context.Context, in io.Reader, out io.Writer, apiKey string) error {
+	creds := http.Header{}
+	if apiKey != "" {
+		creds.Set("Authorization", "Bearer "+apiKey)
+	}
+	sc := bufio.NewScanner(in)
+	sc.Buffer(nil, 16<<20)
+	enc := json.NewEncoder(out)
+	for sc.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		msg := bytes.TrimSpace(sc.Bytes())
+		if len(msg) == 0 {
+			continue
+		}
+		if resp := s.handle(ctx, msg, creds); resp != nil {
+			if err := enc.Encode(resp); err != nil {
+				return err
+			}
+		}
+	}
+	return sc.Err()
+}
+
+// do serves req with the API as the caller identified by creds and returns
+// the status and body.
+func (s *Server) do(req *http.Request, creds http.Header) (int, []byte) {
+	for _, h := range []string{"Authorization", "X-API-Key"} {
+		if v := creds.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	rec := &recorder{header: http.Header{}, status: http.StatusOK}
+	s.API.ServeHTTP(rec, req)
+	return rec.status, rec.body.Bytes()
+}
+
+// recorder captures a response from the API.
+type recorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header { return r.header }
+
+func (r *recorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = code, true
+	}
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.body.Write(b)
+}
+
+// apiError turns an API error envelope into a message like
+// "403 Forbidden (FORBIDDEN): requires the admin role".
+func apiError(status int, body []byte) string {
+	msg := strings.TrimSpace(string(body))
+	var e struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Details struct {
+			ConfirmToken string `json:"confirmToken"`
+		} `json:"details"`
+	}
+	if json.Unmarshal(body, &e) == nil && e.Message != "" {
+		msg = e.Message
+	}
+	if e.Details.ConfirmToken != "" {
+		msg += " (confirmToken " + e.Details.ConfirmToken + ")"
+	}
+	if e.Code != "" {
+		return fmt.Sprintf("%d %s (%s): %s", status, http.StatusText(status), e.Code, msg)
+	}
+	return fmt.Sprintf("%d %s: %s", status, http.StatusText(status), msg)
+}
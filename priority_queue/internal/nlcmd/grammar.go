@@ -0,0 +1,404 @@
+package nlcmd
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A pattern is written as words, with
+//
+//	(a b|c)   one of several word sequences
+//	[...]     an optional part
+//	{slot}    a value: priority, family, age, seconds, n or delta
+//
+// and matches the whole input, ignoring case.
+type elem struct {
+	alts [][]string // literal: one of these word sequences
+	opt  []elem     // optional group
+	slot string
+}
+
+type rule struct {
+	pattern []elem
+	build   func(v values) Command
+}
+
+// values holds what the slots captured.
+type values struct {
+	priority string
+	family   string
+	age      time.Duration
+	seconds  int
+	n        int
+	delta    int
+}
+
+func on(build func(v values) Command, patterns ...string) []rule {
+	out := make([]rule, len(patterns))
+	for i, p := range patterns {
+		out[i] = rule{pattern: compile(p), build: build}
+	}
+	return out
+}
+
+func setPriority(action Action) func(v values) Command {
+	return func(v values) Command {
+		return Command{Action: action, Family: v.family, Age: formatAge(v.age), NewPriority: v.priority}
+	}
+}
+
+func adjust(action Action, sign int) func(v values) Command {
+	return func(v values) Command {
+		return Command{Action: action, Family: v.family, Age: formatAge(v.age), Delta: sign * v.delta}
+	}
+}
+
+func antiStarvation(enable bool) func(v values) Command {
+	return func(v values) Command { return Command{Action: SetAntiStarvation, Enable: &enable} }
+}
+
+const (
+	change     = "(change|set|update|move)"
+	raise      = "(raise|increase|bump|boost)"
+	lower      = "(lower|decrease|reduce|drop)"
+	inFamily   = "in [the] ({family} family|family {family})"
+	olderThan  = "(older than|waiting longer than|waiting more than|waiting over|that have waited longer than|that have been waiting longer than)"
+	byLevels   = "by {delta} [(level|levels)]"
+	antiStarve = "(anti-starvation|anti starvation|antistarvation)"
+)
+
+var rules = concat(
+	on(setPriority(ReprioritizeFamily),
+		change+" [the] priority to {priority} for [all] [the] ads "+inFamily,
+		change+" [the] priority of [all] [the] ads "+inFamily+" to {priority}",
+		change+" [the] priority of [the] ({family} family|family {family}) to {priority}",
+		"(move|put) [all] [the] ads "+inFamily+" to priority {priority}",
+		"(move|put) [the] ({family} family|family {family}) to priority {priority}",
+	),
+	on(adjust(ReprioritizeFamily, 1),
+		raise+" [the] priority of [all] [the] ads "+inFamily+" "+byLevels,
+		raise+" [the] priority of [the] ({family} family|family {family}) "+byLevels,
+		raise+" [the] ({family} family|family {family}) "+byLevels,
+	),
+	on(adjust(ReprioritizeFamily, -1),
+		lower+" [the] priority of [all] [the] ads "+inFamily+" "+byLevels,
+		lower+" [the] priority of [the] ({family} family|family {family}) "+byLevels,
+		lower+" [the] ({family} family|family {family}) "+byLevels,
+	),
+	on(setPriority(ReprioritizeAge),
+		change+" [the] priority to {priority} for [all] [the] ads "+olderThan+" {age}",
+		change+" [the] priority of [all] [the] ads "+olderThan+" {age} to {priority}",
+		"(move|put) [all] [the] ads "+olderThan+" {age} to priority {priority}",
+	),
+	on(adjust(ReprioritizeAge, 1),
+		raise+" [the] priority of [all] [the] ads "+olderThan+" {age} "+byLevels,
+	),
+	on(adjust(ReprioritizeAge, -1),
+		lower+" [the] priority of [all] [the] ads "+olderThan+" {age} "+byLevels,
+	),
+	// "Starvation mode" is anti-starvation turned off.
+	on(antiStarvation(false),
+		"(enable|activate|start|enter|turn on|switch on) starvation mode",
+		"turn starvation mode on",
+		"(disable|deactivate|stop|turn off|switch off) "+antiStarve,
+		"turn "+antiStarve+" off",
+	),
+	on(antiStarvation(true),
+		"(disable|deactivate|stop|exit|leave|turn off|switch off) starvation mode",
+		"turn starvation mode off",
+		"(enable|activate|start|turn on|switch on) "+antiStarve,
+		"turn "+antiStarve+" on",
+	),
+	on(func(v values) Command { return Command{Action: SetMaximumWait, MaximumWait: v.seconds} },
+		"(set|change|update) [the] (maximum|max) wait [time] to {seconds}",
+	),
+	on(func(v values) Command { return Command{Action: Peek, N: max(v.n, 1)} },
+		"[(show|list|display|peek|get|give)] [me] [the] next {n} ads [(to be processed|to process|in the queue|in line)]",
+		"[(show|list|display|peek|get|give)] [me] [the] next ad [(to be processed|to process|in the queue|in line)]",
+		"(what are|which are) [the] next {n} ads [(to be processed|to process|in the queue|in line)]",
+		"(what is|what's|whats) [the] next ad [(to be processed|to process|in the queue|in line)]",
+	),
+	on(func(v values) Command { return Command{Action: Waiting, Age: formatAge(v.age)} },
+		"(list|show|find|get) [me] [all] [the] ads "+olderThan+" {age}",
+		"(which|what) ads (are|have been) (waiting longer than|waiting more than|waiting over|older than) {age}",
+	),
+	on(func(v values) Command { return Command{Action: Distribution} },
+		"[(what is|what's|whats|show|show me|get|display)] [the] [current] [queue] [priority] distribution [by priority]",
+		"how are [the] ads distributed [by priority]",
+	),
+)
+
+func concat(groups ...[]rule) []rule {
+	var out []rule
+	for _, g := range groups {
+		out = append(out, g...)
+	}
+	return out
+}
+
+// compile parses a pattern; patterns are fixed, so a bad one panics.
+func compile(pattern string) []elem {
+	elems, rest := compileSeq(pattern, 0)
+	if rest != "" {
+		panic("nlcmd: unbalanced pattern " + pattern)
+	}
+	return elems
+}
+
+// compileSeq parses elements until the closing bracket of an enclosing
+// group (depth > 0) or the end, returning what follows.
+func compileSeq(s string, depth int) ([]elem, string) {
+	var elems []elem
+	for {
+		s = strings.TrimLeft(s, " ")
+		switch {
+		case s == "":
+			return elems, ""
+		case s[0] == ']':
+			if depth == 0 {
+				return elems, s
+			}
+			return elems, s[1:]
+		case s[0] == '[':
+			opt, rest := compileSeq(s[1:], depth+1)
+			elems = append(elems, elem{opt: opt})
+			s = rest
+		case s[0] == '(':
+			end := strings.IndexByte(s, ')')
+			body := s[1:end]
+			if strings.ContainsAny(body, "{[") {
+				elems = append(elems, alternativesWithSlots(body)...)
+			} else {
+				var alts [][]string
+				for _, alt := range strings.Split(body, "|") {
+					alts = append(alts, strings.Fields(alt))
+				}
+				elems = append(elems, elem{alts: alts})
+			}
+			s = s[end+1:]
+		case s[0] == '{':
+			end := strings.IndexByte(s, '}')
+			elems = append(elems, elem{slot: s[1:end]})
+			s = s[end+1:]
+		default:
+			end := strings.IndexAny(s, " [(]{")
+			if end < 0 {
+				end = len(s)
+			}
+			elems = append(elems, elem{alts: [][]string{{s[:end]}}})
+			s = s[end:]
+		}
+	}
+}
+
+// alternativesWithSlots compiles "({family} family|family {family})", whose
+// alternatives are more than words, into a choice: one element holding each
+// alternative as a sequence, exactly one of which must match.
+func alternativesWithSlots(body string) []elem {
+	var choices []elem
+	for _, alt := range strings.Split(body, "|") {
+		seq, _ := compileSeq(alt, 0)
+		choices = append(choices, elem{opt: seq})
+	}
+	return []elem{{slot: choiceSlot, opt: choices}}
+}
+
+// choiceSlot marks a choice element.
+const choiceSlot = "|"
+
+// match reports whether pattern consumes all of toks, returning the slot
+// values captured along the way. It backtracks over optional parts and
+// over how many words a slot takes.
+func match(pattern []elem, toks []token, v values) (values, bool) {
+	if len(pattern) == 0 {
+		return v, len(toks) == 0
+	}
+	e, rest := pattern[0], pattern[1:]
+	switch {
+	case e.slot == choiceSlot:
+		for _, c := range e.opt {
+			if out, ok := match(concatElems(c.opt, rest), toks, v); ok {
+				return out, true
+			}
+		}
+		return v, false
+	case e.slot != "":
+		for n := 1; n <= len(toks) && n <= slotWidth(e.slot); n++ {
+			if nv, ok := fill(e.slot, toks[:n], v); ok {
+				if out, ok := match(rest, toks[n:], nv); ok {
+					return out, true
+				}
+			}
+		}
+		return v, false
+	case e.opt != nil:
+		if out, ok := match(concatElems(e.opt, rest), toks, v); ok {
+			return out, true
+		}
+		return match(rest, toks, v)
+	}
+	for _, alt := range e.alts {
+		if hasWords(toks, alt) {
+			if out, ok := match(rest, toks[len(alt):], v); ok {
+				return out, true
+			}
+		}
+	}
+	return v, false
+}
+
+func concatElems(a, b []elem) []elem {
+	out := make([]elem, 0, len(a)+len(b))
+	return append(append(out, a...), b...)
+}
+
+func hasWords(toks []token, words []string) bool {
+	if len(toks) < len(words) {
+		return false
+	}
+	for i, w := range words {
+		if toks[i].low != w {
+			return false
+		}
+	}
+	return true
+}
+
+// slotWidth is the most words a slot may take.
+func slotWidth(slot string) int {
+	switch slot {
+	case "family":
+		return 4
+	case "age", "seconds":
+		return 2
+	}
+	return 1
+}
+
+// fill parses toks as the value of slot.
+func fill(slot string, toks []token, v values) (values, bool) {
+	switch slot {
+	case "priority":
+		t := toks[0]
+		if n, err := strconv.Atoi(t.low); err == nil {
+			if n <= 0 {
+				return v, false
+			}
+			v.priority = t.low
+			return v, true
+		}
+		if !isName(t.low) || reserved[t.low] {
+			return v, false
+		}
+		v.priority = t.raw
+	case "family":
+		words := make([]string, len(toks))
+		for i, t := range toks {
+			if reserved[t.low] {
+				return v, false
+			}
+			words[i] = t.raw
+		}
+		v.family = strings.Join(words, " ")
+	case "age":
+		d, ok := parseDuration(toks, time.Minute)
+		if !ok {
+			return v, false
+		}
+		v.age = d
+	case "seconds":
+		d, ok := parseDuration(toks, time.Second)
+		if !ok || d < time.Second {
+			return v, false
+		}
+		v.seconds = int(d / time.Second)
+	case "n":
+		n, ok := number(toks[0].low)
+		if !ok {
+			return v, false
+		}
+		v.n = n
+	case "delta":
+		n, ok := number(toks[0].low)
+		if !ok {
+			return v, false
+		}
+		v.delta = n
+	default:
+		return v, false
+	}
+	return v, true
+}
+
+// reserved words never start or form a name, so "the" or "to" are not
+// taken for a family.
+var reserved = map[string]bool{
+	"the": true, "to": true, "for": true, "all": true, "ads": true, "in": true,
+	"family": true, "priority": true, "of": true, "by": true,
+}
+
+func isName(s string) bool {
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+var numberWords = map[string]int{
+	"a": 1, "an": 1, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5,
+	"six": 6, "seven": 7, "eight": 8, "nine": 9, "ten": 10, "eleven": 11,
+	"twelve": 12, "fifteen": 15, "twenty": 20, "thirty": 30,
+}
+
+// number parses a positive count in digits or words.
+func number(s string) (int, bool) {
+	if n, ok := numberWords[s]; ok {
+		return n, true
+	}
+	n, err := strconv.Atoi(s)
+	return n, err == nil && n > 0
+}
+
+var units = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "secs": time.Second, "second": time.Second, "seconds": time.Second,
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+}
+
+// parseDuration reads "10m" or "1h30m", "10 minutes" or "an hour", or with
+// one word a bare number counted in bare.
+func parseDuration(toks []token, bare time.Duration) (time.Duration, bool) {
+	if len(toks) == 2 {
+		n, ok := number(toks[0].low)
+		unit, known := units[toks[1].low]
+		return time.Duration(n) * unit, ok && known
+	}
+	s := toks[0].low
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, d > 0
+	}
+	if n, err := strconv.Atoi(s); err == nil && n > 0 {
+		return time.Duration(n) * bare, true
+	}
+	return 0, false
+}
+
+// formatAge writes d as the REST API expects, without zero trailing units:
+// "10m" rather than "10m0s".
+func formatAge(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
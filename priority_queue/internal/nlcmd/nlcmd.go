@@ -0,0 +1,151 @@
+// Package nlcmd turns plain-English queue commands such as
+//
+//	Change priority to 5 for all ads in the RPG-Fantasy family
+//	Set priority to 1 for ads older than 10 minutes
+//	Show the next 5 ads
+//
+// into typed commands, without an LLM. The grammar is a fixed list of
+// phrasings; anything else is ErrNotUnderstood, which callers can hand to an
+// LLM agent instead.
+package nlcmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNotUnderstood means the text matches none of the known phrasings.
+var ErrNotUnderstood = errors.New("not understood")
+
+type Action string
+
+const (
+	ReprioritizeFamily Action = "reprioritizeFamily"
+	ReprioritizeAge    Action = "reprioritizeAge"
+	SetAntiStarvation  Action = "setAntiStarvation"
+	SetMaximumWait     Action = "setMaximumWait"
+	Peek               Action = "peek"
+	Waiting            Action = "waiting"
+	Distribution       Action = "distribution"
+)
+
+// Command is a parsed command. Fields not used by the action are zero; the
+// names match the REST request fields.
+type Command struct {
+	Action Action `json:"action"`
+	Family string `json:"family,omitempty"`
+	Age    string `json:"age,omitempty"` // duration like "10m"
+	// NewPriority is a level number or a class name; Delta moves by levels
+	// instead.
+	NewPriority string `json:"newPriority,omitempty"`
+	Delta       int    `json:"delta,omitempty"`
+	Enable      *bool  `json:"enable,omitempty"`
+	MaximumWait int    `json:"maximumWait,omitempty"` // seconds
+	N           int    `json:"n,omitempty"`
+}
+
+// ReadOnly reports whether the command only looks at the queue.
+func (c Command) ReadOnly() bool {
+	switch c.Action {
+	case Peek, Waiting, Distribution:
+		return true
+	}
+	return false
+}
+
+// AgeDuration returns Age parsed; Parse only produces valid ages.
+func (c Command) AgeDuration() time.Duration {
+	d, _ := time.ParseDuration(c.Age)
+	return d
+}
+
+// String describes the command in a sentence, for confirmation prompts.
+func (c Command) String() string {
+	switch c.Action {
+	case ReprioritizeFamily:
+		return "Move all ads in the " + c.Family + " family " + c.move() + "."
+	case ReprioritizeAge:
+		return "Move all ads waiting longer than " + c.Age + " " + c.move() + "."
+	case SetAntiStarvation:
+		if *c.Enable {
+			return "Turn anti-starvation on."
+		}
+		return "Turn anti-starvation off (starvation mode)."
+	case SetMaximumWait:
+		return fmt.Sprintf("Set the maximum wait time to %d seconds.", c.MaximumWait)
+	case Peek:
+		if c.N == 1 {
+			return "Show the next ad."
+		}
+		return fmt.Sprintf("Show the next %d ads.", c.N)
+	case Waiting:
+		return "List the ads waiting longer than " + c.Age + "."
+	case Distribution:
+		return "Show the queue distribution by priority."
+	}
+	return string(c.Action)
+}
+
+func (c Command) move() string {
+	if c.Delta == 0 {
+		return "to priority " + c.NewPriority
+	}
+	dir, n := "up", c.Delta
+	if n < 0 {
+		dir, n = "down", -n
+	}
+	if n == 1 {
+		return dir + " 1 level"
+	}
+	return fmt.Sprintf("%s %d levels", dir, n)
+}
+
+// Examples are phrasings Parse understands, for help and error messages.
+var Examples = []string{
+	"Change priority to 5 for all ads in the RPG-Fantasy family",
+	"Raise the priority of the Puzzle family by 1",
+	"Set priority to 1 for ads older than 10 minutes",
+	"Enable starvation mode",
+	"Turn on anti-starvation",
+	"Set maximum wait time to 600 seconds",
+	"Show the next 5 ads to be processed",
+	"List all ads waiting longer than 5 minutes",
+	"What's the current queue distribution by priority?",
+}
+
+// Parse maps text to a Command, or returns ErrNotUnderstood.
+func Parse(text string) (Command, error) {
+	toks := tokenize(text)
+	for _, r := range rules {
+		if v, ok := match(r.pattern, toks, values{}); ok {
+			return r.build(v), nil
+		}
+	}
+	return Command{}, ErrNotUnderstood
+}
+
+// tokenize splits text into words, dropping surrounding punctuation and a
+// leading or trailing "please".
+func tokenize(text string) []token {
+	var toks []token
+	for _, f := range strings.Fields(text) {
+		f = strings.Trim(f, `.,!?;:"'`)
+		if f != "" {
+			toks = append(toks, token{raw: f, low: strings.ToLower(f)})
+		}
+	}
+	if len(toks) > 0 && toks[0].low == "please" {
+		toks = toks[1:]
+	}
+	if len(toks) > 0 && toks[len(toks)-1].low == "please" {
+		toks = toks[:len(toks)-1]
+	}
+	return toks
+}
+
+type token struct {
+	raw string // as typed, for family and class names
+	low string
+}
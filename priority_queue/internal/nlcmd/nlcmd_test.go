@@ -0,0 +1,83 @@
+package nlcmd
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	on, off := true, false
+	tests := []struct {
+		text string
+		want Command
+	}{
+		// The README phrases.
+		{"Change priority to 5 for all ads in the RPG-Fantasy family", Command{Action: ReprioritizeFamily, Family: "RPG-Fantasy", NewPriority: "5"}},
+		{"Set priority to 1 for ads older than 10 minutes", Command{Action: ReprioritizeAge, Age: "10m", NewPriority: "1"}},
+		{"Enable starvation mode", Command{Action: SetAntiStarvation, Enable: &off}},
+		{"Set maximum wait time to 600 seconds", Command{Action: SetMaximumWait, MaximumWait: 600}},
+		{"Show the next 5 ads to be processed", Command{Action: Peek, N: 5}},
+		{"List all ads waiting longer than 5 minutes", Command{Action: Waiting, Age: "5m"}},
+		{"What's the current queue distribution by priority?", Command{Action: Distribution}},
+
+		// Variations.
+		{"please move the family Puzzle Games to priority urgent.", Command{Action: ReprioritizeFamily, Family: "Puzzle Games", NewPriority: "urgent"}},
+		{"change the priority of all ads in the RPG family to 2", Command{Action: ReprioritizeFamily, Family: "RPG", NewPriority: "2"}},
+		{"Raise the priority of the Puzzle family by one level", Command{Action: ReprioritizeFamily, Family: "Puzzle", Delta: 1}},
+		{"lower RPG family by 2 levels", Command{Action: ReprioritizeFamily, Family: "RPG", Delta: -2}},
+		{"move all ads waiting over 1h30m to priority 3", Command{Action: ReprioritizeAge, Age: "1h30m", NewPriority: "3"}},
+		{"bump the priority of ads older than an hour by 1", Command{Action: ReprioritizeAge, Age: "1h", Delta: 1}},
+		{"Disable starvation mode", Command{Action: SetAntiStarvation, Enable: &on}},
+		{"turn anti-starvation off", Command{Action: SetAntiStarvation, Enable: &off}},
+		{"set max wait to 2 minutes", Command{Action: SetMaximumWait, MaximumWait: 120}},
+		{"next ad", Command{Action: Peek, N: 1}},
+		{"what are the next three ads in the queue", Command{Action: Peek, N: 3}},
+		{"which ads have been waiting longer than 30s?", Command{Action: Waiting, Age: "30s"}},
+		{"distribution", Command{Action: Distribution}},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.text)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) = %+v, %v; want %+v", tt.text, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseNotUnderstood(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"delete everything",
+		"change priority to 0 for all ads in the RPG family", // levels start at 1
+		"change priority to 5 for all ads in the family",     // no family name
+		"set priority to 1 for ads older than ten parsecs",   // not a duration
+		"show the next 5 ads and then dequeue them",          // trailing words
+		"what's the weather like",
+	} {
+		if got, err := Parse(text); !errors.Is(err, ErrNotUnderstood) {
+			t.Errorf("Parse(%q) = %+v, %v; want ErrNotUnderstood", text, got, err)
+		}
+	}
+}
+
+func TestExamplesParse(t *testing.T) {
+	for _, ex := range Examples {
+		if _, err := Parse(ex); err != nil {
+			t.Errorf("example %q: %v", ex, err)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	for text, want := range map[string]string{
+		"Change priority to 5 for all ads in the RPG-Fantasy family": "Move all ads in the RPG-Fantasy family to priority 5.",
+		"lower the priority of ads older than 10m by 2":              "Move all ads waiting longer than 10m down 2 levels.",
+		"enable starvation mode":                                     "Turn anti-starvation off (starvation mode).",
+		"show the next 5 ads":                                        "Show the next 5 ads.",
+	} {
+		c, err := Parse(text)
+		if err != nil || c.String() != want {
+			t.Errorf("Parse(%q).String() = %q, %v; want %q", text, c.String(), err, want)
+		}
+	}
+}
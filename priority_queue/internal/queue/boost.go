@@ -0,0 +1,173 @@
+package queue
+
+import (
+	"sort"
+	"time"
+)
+
+// boost is a temporary relative priority change. Items remember the priority
+// they had before their first boost and return to it when the boost ends.
+type boost struct {
+	id        int64
+	filter    Filter
+	delta     int
+	expiresAt time.Time
+	items     map[*QueueItem]struct{}
+	timer     Timer
+}
+
+// BoostInfo describes an active boost.
+type BoostInfo struct {
+	ID        int64     `json:"id"`
+	Filter    string    `json:"filter"`
+	Delta     int       `json:"delta"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	AdIDs     []string  `json:"adIds"`
+	// Skipped lists matching ads left alone because the level they would
+	// move to is at its class capacity; only set when the boost starts.
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// Boost moves every item matching f by delta levels for duration d and then
+// restores the original priorities. Items enqueued after the call are not
+// affected. An item already under another boost is taken over by this one but
+// keeps its original priority. Items whose new level is at its class
+// capacity are not boosted.
+func (q *VideoProcessingQueue) Boost(f Filter, delta int, d time.Duration) BoostInfo {
+	res, _ := q.Submit(Command{Op: OpBoost, Filter: f, Delta: delta, Duration: d})
+	return res.Boost
+}
+
+func (q *VideoProcessingQueue) boost(f Filter, delta int, d time.Duration, now time.Time) BoostInfo {
+	q.nextBoostID++
+	b := &boost{
+		id:        q.nextBoostID,
+		filter:    f,
+		delta:     delta,
+		expiresAt: now.Add(d),
+		items:     make(map[*QueueItem]struct{}),
+	}
+	items, skipped := q.boostTargets(f, delta, now)
+	for _, item := range items {
+		if item.boost == nil {
+			item.origPriority = item.Ad.Priority
+		} else {
+			delete(item.boost.items, item)
+		}
+		item.boost = b
+		b.items[item] = struct{}{}
+		q.moveToPriority(item, q.normalizePriority(item.Ad.Priority+delta))
+	}
+	q.boosts[b.id] = b
+	q.armBoost(b, now)
+	info := b.info()
+	info.Skipped = skipped
+	return info
+}
+
+// boostTargets returns the items matching f that a boost by delta takes
+// over, in enqueue order, and the AdIDs of those it skips for capacity.
+func (q *VideoProcessingQueue) boostTargets(f Filter, delta int, now time.Time) ([]*QueueItem, []string) {
+	room := q.levelRoom()
+	var items []*QueueItem
+	var skipped []string
+	for _, item := range q.matching(f, now) {
+		if !room.take(item, q.normalizePriority(item.Ad.Priority+delta)) {
+			skipped = append(skipped, item.Ad.AdID)
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, skipped
+}
+
+// armBoost schedules the expiry of b. Replicas do not run timers; they
+// receive the leader's cancel command instead.
+func (q *VideoProcessingQueue) armBoost(b *boost, now time.Time) {
+	if q.replica {
+		return
+	}
+	id := b.id
+	b.timer = q.clock.AfterFunc(b.expiresAt.Sub(now), func() { q.CancelBoost(id) })
+}
+
+// ActiveBoosts lists boosts that have not yet expired, oldest first.
+func (q *VideoProcessingQueue) ActiveBoosts() []BoostInfo {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	out := make([]BoostInfo, 0, len(q.boosts))
+	for _, b := range q.boosts {
+		out = append(out, b.info())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// CancelBoost ends a boost early, restoring its items' original priorities.
+// It reports whether the boost was active. Items whose original level is at
+// its class capacity keep their boosted priority.
+func (q *VideoProcessingQueue) CancelBoost(id int64) bool {
+	res, _ := q.Submit(Command{Op: OpCancelBoost, BoostID: id})
+	return res.OK
+}
+
+// cancelBoost also returns the AdIDs left at their boosted priority.
+func (q *VideoProcessingQueue) cancelBoost(id int64) (bool, []string) {
+	b, ok := q.boosts[id]
+	if !ok {
+		return false, nil
+	}
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	delete(q.boosts, id)
+
+	// Revert in enqueue order so the oldest items get the room left at a
+	// capped level and replicas keep the same items in place.
+	room := q.levelRoom()
+	var skipped []string
+	for _, item := range sortedItems(b.items) {
+		item.boost = nil
+		orig := q.normalizePriority(item.origPriority)
+		if !room.take(item, orig) {
+			skipped = append(skipped, item.Ad.AdID)
+			continue
+		}
+		q.moveToPriority(item, orig)
+	}
+	return true, skipped
+}
+
+// clearBoost detaches item from its boost so that a later revert leaves it alone.
+func (q *VideoProcessingQueue) clearBoost(item *QueueItem) {
+	if item.boost != nil {
+		delete(item.boost.items, item)
+		item.boost = nil
+	}
+}
+
+// sortedItems returns the items of set in enqueue order.
+func sortedItems(set map[*QueueItem]struct{}) []*QueueItem {
+	items := make([]*QueueItem, 0, len(set))
+	for item := range set {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].before(items[j]) })
+	return items
+}
+
+func (b *boost) info() BoostInfo {
+	items := sortedItems(b.items)
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.Ad.AdID)
+	}
+	return BoostInfo{
+		ID:        b.id,
+		Filter:    b.filter.String(),
+		Delta:     b.delta,
+		ExpiresAt: b.expiresAt,
+		AdIDs:     ids,
+	}
+}
@@ -0,0 +1,117 @@
+package queue
+
+import (
+	"time"
+
+	"github.com/google/btree"
+)
+
+// matching returns the items matching f in ascending enqueue order.
+func (q *VideoProcessingQueue) matching(f Filter, now time.Time) []*QueueItem {
+	var out []*QueueItem
+	q.timeIndex.Ascend(func(it btree.Item) bool {
+		ti := it.(timeIndexItem)
+		if f.match(ti.item, now) {
+			out = append(out, ti.item)
+		}
+		return true
+	})
+	return out
+}
+
+// BulkReprioritize moves every item matching f to newPriority and returns the
+// AdIDs that changed priority. Items whose new level is at its class capacity
+// stay where they are. With dryRun the queue is left untouched.
+func (q *VideoProcessingQueue) BulkReprioritize(f Filter, newPriority int, dryRun bool) []string {
+	res, _ := q.Submit(Command{Op: OpBulkReprioritize, Filter: f, Priority: newPriority, DryRun: dryRun})
+	return res.AdIDs
+}
+
+// bulkReprioritize returns the moves and the AdIDs skipped for capacity.
+func (q *VideoProcessingQueue) bulkReprioritize(f Filter, newPriority int, dryRun bool, now time.Time) ([]PriorityChange, []string) {
+	targetPriority := q.normalizePriority(newPriority)
+
+	room := q.levelRoom()
+	changes := make([]PriorityChange, 0)
+	var skipped []string
+	var toMove []*QueueItem
+	for _, item := range q.matching(f, now) {
+		if item.Ad.Priority != targetPriority {
+			if !room.take(item, targetPriority) {
+				skipped = append(skipped, item.Ad.AdID)
+				continue
+			}
+			changes = append(changes, changeOf(item, targetPriority))
+		}
+		toMove = append(toMove, item)
+	}
+	if dryRun {
+		return changes, skipped
+	}
+
+	// Ascending enqueue order keeps FIFO among moved items. An explicit
+	// priority also pins the item, so a running boost will not revert it.
+	for _, item := range toMove {
+		q.clearBoost(item)
+		q.moveToPriority(item, targetPriority)
+	}
+	return changes, skipped
+}
+
+// BulkAdjustPriority moves every item matching f by delta levels, clamped to
+// the valid range, and returns the AdIDs that changed priority. Items whose
+// new level is at its class capacity stay where they are.
+func (q *VideoProcessingQueue) BulkAdjustPriority(f Filter, delta int, dryRun bool) []string {
+	res, _ := q.Submit(Command{Op: OpBulkAdjust, Filter: f, Delta: delta, DryRun: dryRun})
+	return res.AdIDs
+}
+
+// bulkAdjustPriority returns the moves and the AdIDs skipped for capacity.
+func (q *VideoProcessingQueue) bulkAdjustPriority(f Filter, delta int, dryRun bool, now time.Time) ([]PriorityChange, []string) {
+	room := q.levelRoom()
+	changes := make([]PriorityChange, 0)
+	var skipped []string
+	toMove := make([]*QueueItem, 0, 64)
+	for _, item := range q.matching(f, now) {
+		to := q.normalizePriority(item.Ad.Priority + delta)
+		if to == item.Ad.Priority {
+			continue
+		}
+		if !room.take(item, to) {
+			skipped = append(skipped, item.Ad.AdID)
+			continue
+		}
+		toMove = append(toMove, item)
+		changes = append(changes, changeOf(item, to))
+	}
+	if dryRun {
+		return changes, skipped
+	}
+	for _, item := range toMove {
+		q.clearBoost(item)
+		q.moveToPriority(item, q.normalizePriority(item.Ad.Priority+delta))
+	}
+	return changes, skipped
+}
+
+// BulkRemove deletes every item matching f from the queue and all indices and
+// returns their AdIDs. With dryRun the queue is left untouched.
+func (q *VideoProcessingQueue) BulkRemove(f Filter, dryRun bool) []string {
+	res, _ := q.Submit(Command{Op: OpBulkRemove, Filter: f, DryRun: dryRun})
+	return res.AdIDs
+}
+
+func (q *VideoProcessingQueue) bulkRemove(f Filter, dryRun bool, now time.Time) []string {
+	items := q.matching(f, now)
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.Ad.AdID)
+	}
+	if dryRun {
+		return ids
+	}
+	for _, item := range items {
+		q.removeItem(item)
+	}
+	return ids
+}
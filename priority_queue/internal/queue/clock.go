@@ -0,0 +1,110 @@
+package queue
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the queue's source of time. Every time-dependent path (command
+// timestamps, aging, age cutoffs and timers such as boost expiry) goes
+// through it, so a FakeClock makes the queue fully deterministic.
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine (RealClock) or from Advance
+	// (FakeClock) once d has elapsed.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a pending AfterFunc call.
+type Timer interface {
+	// Stop cancels the call and reports whether it was still pending.
+	Stop() bool
+}
+
+// RealClock is the wall clock.
+type RealClock struct{}
+
+func (RealClock) Now() time.Time { return time.Now() }
+
+func (RealClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+// FakeClock is a manually advanced clock for tests and simulations. Time
+// only moves in Advance, which also runs due timers in order.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer // sorted by at
+}
+
+type fakeTimer struct {
+	c  *FakeClock
+	at time.Time
+	f  func()
+}
+
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{c: c, at: c.now.Add(d), f: f}
+	// Keep timers with the same deadline in creation order.
+	i := sort.Search(len(c.timers), func(i int) bool { return c.timers[i].at.After(t.at) })
+	c.timers = append(c.timers, nil)
+	copy(c.timers[i+1:], c.timers[i:])
+	c.timers[i] = t
+	return t
+}
+
+// Advance moves the clock forward by d. Each timer due by then runs in
+// deadline order, synchronously and without the clock's lock, with Now
+// reporting its deadline; timers it creates run too if they fall due.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	target := c.now.Add(d)
+	for len(c.timers) > 0 && !c.timers[0].at.After(target) {
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		if t.at.After(c.now) {
+			c.now = t.at
+		}
+		c.mu.Unlock()
+		t.f()
+		c.mu.Lock()
+	}
+	if target.After(c.now) {
+		c.now = target
+	}
+	c.mu.Unlock()
+}
+
+// Pending returns the number of timers that have not fired or been stopped.
+func (c *FakeClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	for i, other := range t.c.timers {
+		if other == t {
+			t.c.timers = append(t.c.timers[:i], t.c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Now returns the current time of the queue's clock.
+func (q *VideoProcessingQueue) Now() time.Time { return q.clock.Now() }
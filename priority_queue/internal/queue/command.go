@@ -0,0 +1,282 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"icetea/priority_queue/internal/ads"
+	"time"
+)
+
+// Op names a queue mutation.
+type Op string
+
+const (
+	OpEnqueue            Op = "enqueue"
+	OpDequeue            Op = "dequeue"
+	OpReprioritizeFamily Op = "reprioritizeFamily"
+	OpReprioritizeAge    Op = "reprioritizeAge"
+	OpBulkReprioritize   Op = "bulkReprioritize"
+	OpBulkAdjust         Op = "bulkAdjust"
+	OpBulkRemove         Op = "bulkRemove"
+	OpBoost              Op = "boost"
+	OpCancelBoost        Op = "cancelBoost"
+	OpAck                Op = "ack"
+	OpNack               Op = "nack"
+	OpSetAntiStarvation  Op = "setAntiStarvation"
+	OpSetMaximumWait     Op = "setMaximumWait"
+	OpSetTotalPriority   Op = "setTotalPriority"
+	OpPause              Op = "pause"
+	OpResume             Op = "resume"
+	OpSetDraining        Op = "setDraining"
+	OpPurge              Op = "purge"
+	OpImport             Op = "import"
+	OpRestorePriorities  Op = "restorePriorities"
+)
+
+var (
+	ErrUnknownOp = errors.New("unknown queue command")
+	// ErrUnavailable is wrapped by proposers when a command could not be
+	// replicated, e.g. because the cluster has no quorum.
+	ErrUnavailable = errors.New("queue unavailable")
+)
+
+// Command is a serializable queue mutation. Every mutating method runs
+// through Apply, and execution depends only on the queue state and the
+// command (including At), so applying the same commands in the same order to
+// identically configured queues yields identical queues.
+type Command struct {
+	Op Op        `json:"op"`
+	At time.Time `json:"at"` // "now" for the command; set by Apply if zero
+
+	Ad        *ads.Ad          `json:"ad,omitempty"`
+	EnqueueAt *time.Time       `json:"enqueueAt,omitempty"`
+	Family    string           `json:"family,omitempty"`
+	Age       time.Duration    `json:"age,omitempty"`
+	Filter    Filter           `json:"filter"`
+	Priority  int              `json:"priority,omitempty"`
+	Seq       int64            `json:"seq,omitempty"` // dequeue: only this item
+	Delta     int              `json:"delta,omitempty"`
+	DryRun    bool             `json:"dryRun,omitempty"`
+	Duration  time.Duration    `json:"duration,omitempty"` // boost length; dequeue: lease length
+	BoostID   int64            `json:"boostId,omitempty"`
+	LeaseID   int64            `json:"leaseId,omitempty"`
+	Enable    bool             `json:"enable,omitempty"`
+	Value     int              `json:"value,omitempty"`
+	Strategy  RemapStrategy    `json:"strategy,omitempty"`
+	Scope     *PauseScope      `json:"scope,omitempty"`
+	Records   []ItemRecord     `json:"records,omitempty"` // import payload; limits a purge to these items
+	Import    *ImportOptions   `json:"import,omitempty"`
+	Changes   []PriorityChange `json:"changes,omitempty"` // restorePriorities: the moves to undo
+	Limit     *Limit           `json:"limit,omitempty"`   // refuse the command if it would affect more
+
+	// Export receives purged records before they are removed. It only runs
+	// where the command is first applied and is not replicated.
+	Export func([]ItemRecord) error `json:"-"`
+}
+
+// Result carries whatever the applied command returns.
+type Result struct {
+	Ad      *ads.Ad
+	Seq     int64 // enqueue: the seq the ad was given
+	AdIDs   []string
+	Changes []PriorityChange // reprioritize: each item moved, with its old priority
+	Skipped []string         // AdIDs not moved because their level is at its class capacity
+	Boost   BoostInfo
+	Lease   LeaseInfo
+	Records []ItemRecord
+	Import  ImportResult
+	OK      bool
+}
+
+// Apply executes cmd under the queue lock. Successful mutations are counted
+// and passed to the command hook, if one is set.
+func (q *VideoProcessingQueue) Apply(cmd Command) (Result, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if cmd.At.IsZero() {
+		cmd.At = q.clock.Now()
+	}
+	if cmd.Limit != nil && !cmd.DryRun {
+		count, err := q.affected(cmd)
+		if err != nil {
+			return Result{}, err
+		}
+		if total := q.timeIndex.Len(); cmd.Limit.Exceeded(count, total) {
+			return Result{}, &LimitError{Count: count, Total: total}
+		}
+	}
+	return q.applyLocked(cmd)
+}
+
+// applyLocked is Apply without the lock and the limit check. The caller
+// holds q.mu and has set cmd.At.
+func (q *VideoProcessingQueue) applyLocked(cmd Command) (Result, error) {
+	logged := cmd
+	if cmd.Ad != nil {
+		ad := *cmd.Ad // the queued ad changes later; log what was submitted
+		logged.Ad = &ad
+	}
+
+	res, err := q.execute(cmd)
+	if err != nil || cmd.DryRun {
+		return res, err
+	}
+	q.applied++
+	if q.onCommand != nil {
+		q.onCommand(q.applied, logged)
+	}
+	return res, nil
+}
+
+// Submit runs a mutation. Without a proposer this is Apply. With one
+// (cluster mode) the command is stamped with the local time and handed to the
+// proposer, which must apply it on every node and return this node's result.
+// Dry runs never leave the node.
+func (q *VideoProcessingQueue) Submit(cmd Command) (Result, error) {
+	q.mu.RLock()
+	propose := q.proposer
+	q.mu.RUnlock()
+
+	if propose == nil || cmd.DryRun {
+		return q.Apply(cmd)
+	}
+	if cmd.At.IsZero() {
+		cmd.At = q.clock.Now()
+	}
+	return propose(cmd)
+}
+
+// SetProposer routes every public mutation through fn instead of applying it
+// directly. fn is called without the queue lock.
+func (q *VideoProcessingQueue) SetProposer(fn func(Command) (Result, error)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.proposer = fn
+}
+
+// SetCommandHook registers fn to receive every applied mutation with its
+// sequence number. fn runs under the queue lock and must not block or call
+// back into the queue.
+func (q *VideoProcessingQueue) SetCommandHook(fn func(index uint64, cmd Command)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.onCommand = fn
+}
+
+// Applied returns the number of mutations applied so far, including the
+// index restored from a snapshot.
+func (q *VideoProcessingQueue) Applied() uint64 {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.applied
+}
+
+// SetReplica switches timer handling. A replica never expires boosts or
+// leases on its own and relies on replicated cancel and nack commands;
+// turning replica mode off (e.g. on promotion) arms timers for every active
+// boost and lease.
+func (q *VideoProcessingQueue) SetReplica(replica bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.replica == replica {
+		return
+	}
+	q.replica = replica
+	now := q.clock.Now()
+	for _, b := range q.boosts {
+		if replica {
+			if b.timer != nil {
+				b.timer.Stop()
+				b.timer = nil
+			}
+		} else {
+			q.armBoost(b, now)
+		}
+	}
+	for _, l := range q.leases {
+		if replica {
+			if l.timer != nil {
+				l.timer.Stop()
+				l.timer = nil
+			}
+		} else {
+			q.armLease(l, now)
+		}
+	}
+}
+
+func (q *VideoProcessingQueue) execute(cmd Command) (Result, error) {
+	var res Result
+	var err error
+	switch cmd.Op {
+	case OpEnqueue:
+		if cmd.Ad == nil {
+			return res, errors.New("enqueue without ad")
+		}
+		err = q.enqueue(cmd.Ad, cmd.EnqueueAt, cmd.At)
+		res.Ad, res.Seq = cmd.Ad, q.nextSeq
+	case OpDequeue:
+		res.Ad, res.Lease = q.dequeue(cmd.At, cmd.Priority, cmd.Seq, cmd.Duration)
+	case OpReprioritizeFamily:
+		res.Changes, res.Skipped = q.reprioritizeByGameFamily(cmd.Family, cmd.Priority)
+		res.AdIDs = changedAdIDs(res.Changes)
+	case OpReprioritizeAge:
+		res.Changes, res.Skipped = q.reprioritizeByAgeOlderThan(cmd.Age, cmd.Priority, cmd.At)
+		res.AdIDs = changedAdIDs(res.Changes)
+	case OpBulkReprioritize:
+		res.Changes, res.Skipped = q.bulkReprioritize(cmd.Filter, cmd.Priority, cmd.DryRun, cmd.At)
+		res.AdIDs = changedAdIDs(res.Changes)
+	case OpBulkAdjust:
+		res.Changes, res.Skipped = q.bulkAdjustPriority(cmd.Filter, cmd.Delta, cmd.DryRun, cmd.At)
+		res.AdIDs = changedAdIDs(res.Changes)
+	case OpBulkRemove:
+		res.AdIDs = q.bulkRemove(cmd.Filter, cmd.DryRun, cmd.At)
+	case OpBoost:
+		if cmd.DryRun {
+			var items []*QueueItem
+			items, res.Skipped = q.boostTargets(cmd.Filter, cmd.Delta, cmd.At) // the ads it would take over
+			for _, item := range items {
+				res.AdIDs = append(res.AdIDs, item.Ad.AdID)
+			}
+			break
+		}
+		res.Boost = q.boost(cmd.Filter, cmd.Delta, cmd.Duration, cmd.At)
+		res.Skipped = res.Boost.Skipped
+	case OpCancelBoost:
+		res.OK, res.Skipped = q.cancelBoost(cmd.BoostID)
+	case OpAck, OpNack:
+		res.OK = q.endLease(cmd.LeaseID, cmd.Op == OpNack)
+	case OpSetAntiStarvation:
+		q.enableAntiStarvation.Store(cmd.Enable)
+	case OpSetMaximumWait:
+		q.setMaximumWaitTime(cmd.Value)
+	case OpSetTotalPriority:
+		err = q.setTotalPriority(cmd.Value, cmd.Strategy)
+	case OpPause, OpResume:
+		if cmd.Scope == nil {
+			return res, fmt.Errorf("%s without scope", cmd.Op)
+		}
+		if cmd.Op == OpPause {
+			q.pause(*cmd.Scope)
+		} else {
+			q.resume(*cmd.Scope)
+		}
+	case OpSetDraining:
+		q.setDraining(cmd.Enable)
+	case OpPurge:
+		res.Records, err = q.purge(cmd.Filter, cmd.Records, cmd.Export, cmd.DryRun, cmd.At)
+	case OpImport:
+		var opts ImportOptions
+		if cmd.Import != nil {
+			opts = *cmd.Import
+		}
+		res.Import, err = q.importRecords(cmd.Records, opts)
+	case OpRestorePriorities:
+		res.AdIDs, res.Skipped = q.restorePriorities(cmd.Changes, cmd.DryRun)
+	default:
+		err = fmt.Errorf("%w %q", ErrUnknownOp, cmd.Op)
+	}
+	return res, err
+}
@@ -0,0 +1,124 @@
+package queue
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"icetea/priority_queue/config"
+)
+
+var concurrencyConfig = config.Config{
+	TotalPriority:        5,
+	EnableAntiStarvation: true,
+	MaximumWaitSeconds:   600,
+	BTreeDegree:          16,
+	TimeBoost:            2,
+}
+
+// TestConcurrentReadsDuringWrites is meant for -race: dashboard-style reads
+// run against enqueues, dequeues, reprioritizes and settings changes, and no
+// ad is lost or delivered twice.
+func TestConcurrentReadsDuringWrites(t *testing.T) {
+	q := NewFromConfig(concurrencyConfig)
+	var enqueued, dequeued atomic.Int64
+	var wg sync.WaitGroup
+
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				family := fmt.Sprintf("F%d", i%7)
+				if q.Enqueue(newAd(fmt.Sprintf("W%d-%d", w, i), family, i%5+1, 600)) == nil {
+					enqueued.Add(1)
+				}
+				switch i % 6 {
+				case 0, 3:
+					if q.Dequeue() != nil {
+						dequeued.Add(1)
+					}
+				case 1:
+					q.ReprioritizeByGameFamily(family, i%5+1)
+				case 2:
+					q.SetEnableAntiStarvation(i%4 == 2)
+				case 4:
+					q.SetMaximumWaitTime(300 + i)
+				case 5:
+					q.ReprioritizeByAgeOlderThan(time.Millisecond, 3)
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				q.PeekNext(10)
+				q.DistributionByPriority()
+				q.ListWaitingLongerThan(time.Millisecond)
+				q.HeadScores()
+				q.IsEnableAntiStarvation()
+				q.TotalPriority()
+				q.DrainStatus()
+			}
+		}()
+	}
+	wg.Wait()
+
+	_, remaining := q.DistributionByPriority()
+	if got := dequeued.Load() + int64(remaining); got != enqueued.Load() {
+		t.Fatalf("dequeued %d + remaining %d != enqueued %d", dequeued.Load(), remaining, enqueued.Load())
+	}
+	seen := make(map[string]bool)
+	for ad := q.Dequeue(); ad != nil; ad = q.Dequeue() {
+		if seen[ad.AdID] {
+			t.Fatalf("%s delivered twice", ad.AdID)
+		}
+		seen[ad.AdID] = true
+	}
+	if len(seen) != remaining {
+		t.Fatalf("drained %d ads, distribution said %d", len(seen), remaining)
+	}
+}
+
+// BenchmarkMixedLoad measures queue throughput while a share of the
+// goroutines poll like dashboards (PeekNext, DistributionByPriority,
+// ListWaitingLongerThan, IsEnableAntiStarvation). Reads only take the read
+// lock, so they no longer queue up behind each other; run with -cpu 1,4,8.
+func BenchmarkMixedLoad(b *testing.B) {
+	for _, readPct := range []int{10, 50, 90} {
+		b.Run(fmt.Sprintf("reads=%d%%", readPct), func(b *testing.B) {
+			q := NewFromConfig(concurrencyConfig)
+			for i := 0; i < 10000; i++ {
+				_ = q.Enqueue(newAd(fmt.Sprintf("P%d", i), fmt.Sprintf("F%d", i%64), i%5+1, 600))
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					if i%100 < readPct {
+						switch i % 4 {
+						case 0:
+							q.PeekNext(10)
+						case 1:
+							q.DistributionByPriority()
+						case 2:
+							q.ListWaitingLongerThan(time.Hour)
+						case 3:
+							q.IsEnableAntiStarvation()
+						}
+						continue
+					}
+					if i%2 == 0 {
+						_ = q.Enqueue(newAd("B", "F1", i%5+1, 600))
+					} else {
+						q.Dequeue()
+					}
+				}
+			})
+		})
+	}
+}
@@ -2,46 +2,44 @@ package queue
 
 import (
 	"icetea/priority_queue/internal/ads"
-	"math"
 	"time"
 )
 
 func (q *VideoProcessingQueue) Dequeue() *ads.Ad {
-	q.mu.Lock()
-	defer q.mu.Unlock()
-
-	now := time.Now()
-	selected := -1
-	bestScore := math.Inf(-1)
+	res, _ := q.Submit(Command{Op: OpDequeue})
+	return res.Ad
+}
 
-	for _, p := range q.priorities {
-		queue := q.queueMap[p]
-		if queue == nil || queue.Size == 0 {
-			continue
-		}
-		if selected == -1 {
-			selected = p
-			if !q.enableAntiStarvation {
-				break
-			}
-		}
-		head := queue.Front()
-		waited := now.Sub(head.EnqueueAt)
-		if waited >= time.Duration(head.Ad.MaxWaitTime)*time.Second {
-			score := float64(p) + waited.Seconds()/float64(head.Ad.MaxWaitTime)*q.timeBoost
-			if score > bestScore {
-				bestScore = score
-				selected = p
-			}
-		}
+// dequeue removes the head chosen by the scheduling policy. A non-zero level
+// takes the head of that level instead, and a non-zero seq only takes it if it
+// is still the item with that seq; ShardedQueue uses this after choosing a
+// head across shards. A positive leaseFor takes the item on lease.
+func (q *VideoProcessingQueue) dequeue(now time.Time, level int, seq int64, leaseFor time.Duration) (*ads.Ad, LeaseInfo) {
+	selected := level
+	if selected == 0 {
+		selected = q.selectLevel(q.headOf, now)
 	}
-
 	if selected == -1 {
-		return nil
+		return nil, LeaseInfo{}
 	}
 
-	item := q.queueMap[selected].PopFront()
-	q.removeFromFamilyIndex(item)
-	q.removeFromTimeIndex(item)
-	return item.Ad
+	// The servable head is not always the list head when families are paused.
+	item := q.headOf(selected)
+	if item == nil || (seq != 0 && item.seq != seq) {
+		return nil, LeaseInfo{}
+	}
+	priority := item.Ad.Priority
+	if item.boost != nil {
+		priority = item.origPriority
+	}
+	item.attempts++
+	// Lease first so a drain does not complete while the item is out.
+	var info LeaseInfo
+	if leaseFor > 0 {
+		info = q.lease(item, priority, leaseFor, now)
+	}
+	q.removeItem(item)
+	q.dequeued++
+	q.dequeueTimes.add(now)
+	return item.Ad, info
 }
@@ -2,8 +2,8 @@ package queue
 
 // DistributionByPriority returns distribution (ordered by q.priorities) and total count.
 func (q *VideoProcessingQueue) DistributionByPriority() ([]PriorityDist, int) {
-	q.mu.Lock()
-	defer q.mu.Unlock()
+	q.mu.RLock()
+	defer q.mu.RUnlock()
 
 	dist := make([]PriorityDist, 0, len(q.priorities))
 	total := 0
@@ -24,7 +24,7 @@ func (q *VideoProcessingQueue) DistributionByPriority() ([]PriorityDist, int) {
 		if total > 0 {
 			pct = float64(c) * 100.0 / float64(total)
 		}
-		dist = append(dist, PriorityDist{Priority: p, Count: c, Percent: pct})
+		dist = append(dist, PriorityDist{Priority: p, Name: q.classes[p].Name, Count: c, Percent: pct})
 	}
 	return dist, total
 }
@@ -5,57 +5,45 @@ import (
 	"time"
 )
 
-func (q *VideoProcessingQueue) EnqueueWithTime(ad *ads.Ad, enqueuedAt time.Time) {
-	q.mu.Lock()
-	defer q.mu.Unlock()
-
-	ad.Priority = q.normalizePriority(ad.Priority)
-	if ad.MaxWaitTime > q.maximumWaitTime {
-		ad.MaxWaitTime = q.maximumWaitTime
-	}
-
-	q.nextSeq++
-	item := &QueueItem{
-		Ad:        ad,
-		EnqueueAt: enqueuedAt,
-		seq:       q.nextSeq, // IMPORTANT: set seq before indexing
-	}
-	q.insertIntoPriorityByTime(item, ad.Priority)
-
-	if _, ok := q.gameFamilyIndex[ad.GameFamily]; !ok {
-		q.gameFamilyIndex[ad.GameFamily] = make(map[*QueueItem]struct{})
-	}
-	q.gameFamilyIndex[ad.GameFamily][item] = struct{}{}
-
-	q.timeIndex.ReplaceOrInsert(timeIndexItem{when: item.EnqueueAt, seq: item.seq, item: item})
+func (q *VideoProcessingQueue) EnqueueWithTime(ad *ads.Ad, enqueuedAt time.Time) error {
+	_, err := q.Submit(Command{Op: OpEnqueue, Ad: ad, EnqueueAt: &enqueuedAt})
+	return err
 }
 
-func (q *VideoProcessingQueue) Enqueue(ad *ads.Ad) {
-	q.mu.Lock()
-	defer q.mu.Unlock()
+func (q *VideoProcessingQueue) Enqueue(ad *ads.Ad) error {
+	_, err := q.Submit(Command{Op: OpEnqueue, Ad: ad})
+	return err
+}
 
-	ad.Priority = q.normalizePriority(ad.Priority)
-	if ad.MaxWaitTime > q.maximumWaitTime {
-		ad.MaxWaitTime = q.maximumWaitTime
+// enqueue links ad in. With a nil enqueuedAt the ad is appended at now;
+// otherwise it is placed by enqueuedAt among the existing items.
+func (q *VideoProcessingQueue) enqueue(ad *ads.Ad, enqueuedAt *time.Time, now time.Time) error {
+	if q.draining {
+		return ErrDraining
 	}
-	queue, ok := q.queueMap[ad.Priority]
-	if !ok {
-		queue = &DList{}
-		q.queueMap[ad.Priority] = queue
+	if err := q.admit(ad); err != nil {
+		return err
 	}
 
 	q.nextSeq++
 	item := &QueueItem{
 		Ad:        ad,
-		EnqueueAt: time.Now(),
+		EnqueueAt: now,
 		seq:       q.nextSeq, // IMPORTANT: set seq before indexing
 	}
-	queue.PushBack(item)
-
-	if _, ok := q.gameFamilyIndex[ad.GameFamily]; !ok {
-		q.gameFamilyIndex[ad.GameFamily] = make(map[*QueueItem]struct{})
+	if enqueuedAt != nil {
+		item.EnqueueAt = *enqueuedAt
+		q.insertIntoPriorityByTime(item, ad.Priority)
+	} else {
+		queue, ok := q.queueMap[ad.Priority]
+		if !ok {
+			queue = &DList{}
+			q.queueMap[ad.Priority] = queue
+		}
+		queue.PushBack(item)
+		q.linked(item, ad.Priority)
 	}
-	q.gameFamilyIndex[ad.GameFamily][item] = struct{}{}
-
-	q.timeIndex.ReplaceOrInsert(timeIndexItem{when: item.EnqueueAt, seq: item.seq, item: item})
+	q.addToIndices(item)
+	q.enqueued++
+	return nil
 }
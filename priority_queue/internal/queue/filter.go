@@ -0,0 +1,243 @@
+package queue
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Filter is a parsed filter expression used by the bulk operations, e.g.
+//
+//	family = RPG and age > 10m and priority = 1
+//
+// Clauses are joined with "and" (or "&&") and must all match. Supported
+// fields are family, adId (string; = and !=), priority (int) and age
+// (duration since EnqueueAt). The empty expression matches every item.
+type Filter struct {
+	clauses []filterClause
+}
+
+type filterClause struct {
+	field string
+	op    string
+	str   string
+	num   int
+	dur   time.Duration
+}
+
+// ParseFilter compiles a filter expression.
+func ParseFilter(expr string) (Filter, error) {
+	var f Filter
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return f, err
+	}
+	for i := 0; i < len(tokens); {
+		if i > 0 {
+			if t := strings.ToLower(tokens[i]); t != "and" && t != "&&" {
+				return f, fmt.Errorf("expected 'and' before %q", tokens[i])
+			}
+			i++
+		}
+		if i+3 > len(tokens) {
+			return f, fmt.Errorf("incomplete clause at end of %q", expr)
+		}
+		c, err := parseFilterClause(tokens[i], tokens[i+1], tokens[i+2])
+		if err != nil {
+			return f, err
+		}
+		f.clauses = append(f.clauses, c)
+		i += 3
+	}
+	return f, nil
+}
+
+// FamilyFilter matches every item of one game family.
+func FamilyFilter(family string) Filter {
+	return Filter{clauses: []filterClause{{field: "family", op: "=", str: family}}}
+}
+
+// OlderThanFilter matches every item that has waited longer than age.
+func OlderThanFilter(age time.Duration) Filter {
+	return Filter{clauses: []filterClause{{field: "age", op: ">", dur: age}}}
+}
+
+// PriorityFilter matches every item at level p.
+func PriorityFilter(p int) Filter {
+	return Filter{clauses: []filterClause{{field: "priority", op: "=", num: p}}}
+}
+
+// And returns a filter matching items that match both f and other.
+func (f Filter) And(other Filter) Filter {
+	clauses := make([]filterClause, 0, len(f.clauses)+len(other.clauses))
+	clauses = append(clauses, f.clauses...)
+	clauses = append(clauses, other.clauses...)
+	return Filter{clauses: clauses}
+}
+
+// String renders the filter back into expression form.
+func (f Filter) String() string {
+	parts := make([]string, 0, len(f.clauses))
+	for _, c := range f.clauses {
+		var v string
+		switch c.field {
+		case "priority":
+			v = strconv.Itoa(c.num)
+		case "age":
+			v = c.dur.String()
+		default:
+			v = strconv.Quote(c.str)
+		}
+		parts = append(parts, c.field+" "+c.op+" "+v)
+	}
+	return strings.Join(parts, " and ")
+}
+
+// MarshalText encodes the filter as its expression, so commands carrying a
+// filter can be serialized.
+func (f Filter) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+func (f *Filter) UnmarshalText(b []byte) error {
+	parsed, err := ParseFilter(string(b))
+	if err != nil {
+		return err
+	}
+	*f = parsed
+	return nil
+}
+
+func parseFilterClause(field, op, value string) (filterClause, error) {
+	c := filterClause{op: op}
+	switch op {
+	case "=", "==":
+		c.op = "="
+	case "!=", "<", "<=", ">", ">=":
+	default:
+		return c, fmt.Errorf("unknown operator %q", op)
+	}
+
+	switch strings.ToLower(field) {
+	case "family", "gamefamily":
+		c.field = "family"
+	case "adid", "id":
+		c.field = "adId"
+	case "priority":
+		c.field = "priority"
+	case "age":
+		c.field = "age"
+	default:
+		return c, fmt.Errorf("unknown field %q", field)
+	}
+
+	switch c.field {
+	case "family", "adId":
+		if c.op != "=" && c.op != "!=" {
+			return c, fmt.Errorf("operator %q not supported for %s", op, c.field)
+		}
+		c.str = value
+	case "priority":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return c, fmt.Errorf("invalid priority %q", value)
+		}
+		c.num = n
+	case "age":
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return c, fmt.Errorf("invalid age %q: %v", value, err)
+		}
+		c.dur = d
+	}
+	return c, nil
+}
+
+// tokenizeFilter splits on whitespace and operators; double-quoted values may
+// contain spaces and Go escapes.
+func tokenizeFilter(expr string) ([]string, error) {
+	var tokens []string
+	rs := []rune(expr)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"':
+			j := i + 1
+			for j < len(rs) && rs[j] != '"' {
+				if rs[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("unterminated quote in %q", expr)
+			}
+			v, err := strconv.Unquote(string(rs[i : j+1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted value in %q", expr)
+			}
+			tokens = append(tokens, v)
+			i = j + 1
+		case strings.ContainsRune("=!<>&", r):
+			j := i + 1
+			for j < len(rs) && strings.ContainsRune("=&", rs[j]) {
+				j++
+			}
+			tokens = append(tokens, string(rs[i:j]))
+			i = j
+		default:
+			j := i
+			for j < len(rs) && !unicode.IsSpace(rs[j]) && !strings.ContainsRune("=!<>&\"", rs[j]) {
+				j++
+			}
+			tokens = append(tokens, string(rs[i:j]))
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+func (f Filter) match(item *QueueItem, now time.Time) bool {
+	for _, c := range f.clauses {
+		if !c.match(item, now) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c filterClause) match(item *QueueItem, now time.Time) bool {
+	switch c.field {
+	case "family":
+		return (item.Ad.GameFamily == c.str) == (c.op == "=")
+	case "adId":
+		return (item.Ad.AdID == c.str) == (c.op == "=")
+	case "priority":
+		return compareInt(int64(item.Ad.Priority), c.op, int64(c.num))
+	case "age":
+		return compareInt(int64(now.Sub(item.EnqueueAt)), c.op, int64(c.dur))
+	}
+	return false
+}
+
+func compareInt(a int64, op string, b int64) bool {
+	switch op {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
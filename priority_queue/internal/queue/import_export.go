@@ -0,0 +1,104 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/google/btree"
+)
+
+// ImportOptions controls how Import treats incoming records.
+type ImportOptions struct {
+	// KeepSeq reuses the recorded seq. A seq already taken by a queued or
+	// leased item is reassigned, since seqs must stay unique.
+	KeepSeq bool
+	// SkipDuplicates ignores records whose AdID is already queued.
+	SkipDuplicates bool
+}
+
+type ImportResult struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`  // duplicates
+	Rejected int `json:"rejected"` // refused by admission, e.g. class capacity
+}
+
+// Export returns every queued item in ascending (EnqueueAt, seq) order, which
+// is the order Import needs to rebuild the same per-priority FIFO lists.
+func (q *VideoProcessingQueue) Export() []ItemRecord {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	records := make([]ItemRecord, 0, q.timeIndex.Len())
+	q.timeIndex.Ascend(func(it btree.Item) bool {
+		records = append(records, it.(timeIndexItem).item.record())
+		return true
+	})
+	return records
+}
+
+// Import loads records with EnqueueWithTime semantics: priorities are
+// normalized, MaxWaitTime is capped and items are placed by EnqueueAt.
+// Records should be given in Export order so ties keep their seq order.
+func (q *VideoProcessingQueue) Import(records []ItemRecord, opts ImportOptions) (ImportResult, error) {
+	res, err := q.Submit(Command{Op: OpImport, Records: records, Import: &opts})
+	return res.Import, err
+}
+
+func (q *VideoProcessingQueue) importRecords(records []ItemRecord, opts ImportOptions) (ImportResult, error) {
+	var res ImportResult
+	if q.draining {
+		return res, ErrDraining
+	}
+	leased := make(map[int64]bool, len(q.leases))
+	for _, l := range q.leases {
+		leased[l.rec.Seq] = true
+	}
+	for _, rec := range records {
+		if rec.Ad == nil {
+			res.Rejected++
+			continue
+		}
+		if _, dup := q.adIndex[rec.Ad.AdID]; dup && opts.SkipDuplicates {
+			res.Skipped++
+			continue
+		}
+		ad := *rec.Ad
+		if err := q.admit(&ad); err != nil {
+			res.Rejected++
+			continue
+		}
+
+		seq := rec.Seq
+		if _, queued := q.bySeq[seq]; !opts.KeepSeq || seq <= 0 || queued || leased[seq] {
+			q.nextSeq++
+			seq = q.nextSeq
+		} else if seq > q.nextSeq {
+			q.nextSeq = seq
+		}
+
+		rec.Ad = &ad
+		item := itemOf(rec, seq)
+		q.insertIntoPriorityByTime(item, item.Ad.Priority)
+		q.addToIndices(item)
+		res.Imported++
+	}
+	return res, nil
+}
+
+// ReadRecords decodes a JSONL stream written by WriteRecords.
+func ReadRecords(r io.Reader) ([]ItemRecord, error) {
+	dec := json.NewDecoder(r)
+	var records []ItemRecord
+	for {
+		var rec ItemRecord
+		err := dec.Decode(&rec)
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+}
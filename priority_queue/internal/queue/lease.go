@@ -0,0 +1,143 @@
+package queue
+
+import (
+	"icetea/priority_queue/internal/ads"
+	"sort"
+	"time"
+)
+
+// lease holds a dequeued item until its consumer acks it. A nack or an
+// expired lease puts the item back where it was.
+type lease struct {
+	id        int64
+	rec       ItemRecord // the ad at its unboosted priority, EnqueueAt and seq
+	expiresAt time.Time
+	timer     Timer
+}
+
+// LeaseInfo describes an outstanding lease.
+type LeaseInfo struct {
+	ID        int64     `json:"leaseId"`
+	AdID      string    `json:"adId"`
+	ExpiresAt time.Time `json:"leaseExpiresAt"`
+}
+
+// DequeueWithLease dequeues like Dequeue, but the ad only leaves the queue
+// for good once it is acked. If it is nacked or not acked within d, it goes
+// back to its old position.
+func (q *VideoProcessingQueue) DequeueWithLease(d time.Duration) (*ads.Ad, LeaseInfo) {
+	res, _ := q.Submit(Command{Op: OpDequeue, Duration: d})
+	return res.Ad, res.Lease
+}
+
+// lease takes item, already removed from the queue, on lease for d. An item
+// under a boost is returned at the priority it had before the boost.
+func (q *VideoProcessingQueue) lease(item *QueueItem, priority int, d time.Duration, now time.Time) LeaseInfo {
+	q.nextLeaseID++
+	rec := item.record()
+	rec.Ad.Priority = priority
+	l := &lease{id: q.nextLeaseID, rec: rec, expiresAt: now.Add(d)}
+	q.leases[l.id] = l
+	q.armLease(l, now)
+	return l.info()
+}
+
+// armLease schedules the expiry of l. As with boosts, replicas wait for the
+// leader's nack instead.
+func (q *VideoProcessingQueue) armLease(l *lease, now time.Time) {
+	if q.replica {
+		return
+	}
+	id := l.id
+	l.timer = q.clock.AfterFunc(l.expiresAt.Sub(now), func() { q.Nack(id) })
+}
+
+// Ack completes a lease; the ad is gone for good. It reports whether the
+// lease was outstanding or was just acked, so a retried ack succeeds.
+func (q *VideoProcessingQueue) Ack(id int64) bool {
+	res, _ := q.Submit(Command{Op: OpAck, LeaseID: id})
+	return res.OK
+}
+
+// Nack ends a lease and returns the ad to the queue at its old position. It
+// reports whether the lease was outstanding or was just nacked (or expired).
+func (q *VideoProcessingQueue) Nack(id int64) bool {
+	res, _ := q.Submit(Command{Op: OpNack, LeaseID: id})
+	return res.OK
+}
+
+func (q *VideoProcessingQueue) endLease(id int64, requeue bool) bool {
+	l, ok := q.leases[id]
+	if !ok {
+		requeued, ended := q.endedLeases.requeued[id]
+		return ended && requeued == requeue
+	}
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	delete(q.leases, id)
+	q.endedLeases.add(id, requeue)
+	if requeue {
+		// The item was admitted once; it returns even past class capacity
+		// or while draining.
+		item := itemOf(l.rec, l.rec.Seq)
+		item.Ad.Priority = q.normalizePriority(item.Ad.Priority)
+		q.insertIntoPriorityByTime(item, item.Ad.Priority)
+		q.addToIndices(item)
+	}
+	q.checkDrained()
+	return true
+}
+
+// endedLeaseMemory is how many ended leases are remembered.
+const endedLeaseMemory = 1024
+
+// endedLeases remembers whether the last endedLeaseMemory leases were acked
+// or nacked. Acking an acked lease again is then a no-op that succeeds, as a
+// client retrying after a lost response needs, while acking a lease that
+// expired still fails.
+type endedLeases struct {
+	requeued map[int64]bool // lease ID -> ended by a nack or expiry
+	order    [endedLeaseMemory]int64
+	next     int
+}
+
+func (e *endedLeases) add(id int64, requeue bool) {
+	if e.requeued == nil {
+		e.requeued = make(map[int64]bool)
+	}
+	if old := e.order[e.next]; old != 0 {
+		delete(e.requeued, old)
+	}
+	e.order[e.next] = id
+	e.next = (e.next + 1) % endedLeaseMemory
+	e.requeued[id] = requeue
+}
+
+// list returns the remembered leases, oldest first.
+func (e *endedLeases) list() []EndedLease {
+	var out []EndedLease
+	for i := range endedLeaseMemory {
+		if id := e.order[(e.next+i)%endedLeaseMemory]; id != 0 {
+			out = append(out, EndedLease{ID: id, Requeued: e.requeued[id]})
+		}
+	}
+	return out
+}
+
+// Leases lists outstanding leases, oldest first.
+func (q *VideoProcessingQueue) Leases() []LeaseInfo {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	out := make([]LeaseInfo, 0, len(q.leases))
+	for _, l := range q.leases {
+		out = append(out, l.info())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func (l *lease) info() LeaseInfo {
+	return LeaseInfo{ID: l.id, AdID: l.rec.Ad.AdID, ExpiresAt: l.expiresAt}
+}
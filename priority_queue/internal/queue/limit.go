@@ -0,0 +1,67 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrOverLimit is matched by the LimitError of a command that would affect
+// more items than its Limit allows.
+var ErrOverLimit = errors.New("command over its limit")
+
+// Limit caps how many items a reprioritize, bulk, boost or purge command may
+// affect. It is checked under the queue lock right before the command runs,
+// so nothing that changes the queue between a preview and the command can
+// push it over. Zero fields do not limit.
+type Limit struct {
+	MaxItems   int     `json:"maxItems,omitempty"`
+	MaxPercent float64 `json:"maxPercent,omitempty"` // of the queued ads
+}
+
+// Exceeded reports whether affecting count of total queued ads is over l.
+func (l Limit) Exceeded(count, total int) bool {
+	return (l.MaxItems > 0 && count > l.MaxItems) ||
+		(l.MaxPercent > 0 && total > 0 && float64(count)*100 > l.MaxPercent*float64(total))
+}
+
+// LimitError reports a command refused by its Limit; nothing was changed.
+type LimitError struct {
+	Count, Total int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("would affect %d of %d queued ads", e.Count, e.Total)
+}
+
+func (e *LimitError) Is(target error) bool { return target == ErrOverLimit }
+
+// affected dry-runs cmd to count the items it would affect, for checking
+// cmd.Limit. The caller holds q.mu.
+func (q *VideoProcessingQueue) affected(cmd Command) (int, error) {
+	var count int
+	switch cmd.Op {
+	case OpReprioritizeFamily:
+		changes, _ := q.bulkReprioritize(FamilyFilter(cmd.Family), cmd.Priority, true, cmd.At)
+		count = len(changes)
+	case OpReprioritizeAge:
+		changes, _ := q.bulkReprioritize(OlderThanFilter(cmd.Age), cmd.Priority, true, cmd.At)
+		count = len(changes)
+	case OpBulkReprioritize:
+		changes, _ := q.bulkReprioritize(cmd.Filter, cmd.Priority, true, cmd.At)
+		count = len(changes)
+	case OpBulkAdjust:
+		changes, _ := q.bulkAdjustPriority(cmd.Filter, cmd.Delta, true, cmd.At)
+		count = len(changes)
+	case OpBulkRemove:
+		count = len(q.bulkRemove(cmd.Filter, true, cmd.At))
+	case OpBoost:
+		items, _ := q.boostTargets(cmd.Filter, cmd.Delta, cmd.At) // a boost takes over every target
+		count = len(items)
+	case OpPurge:
+		records, _ := q.purge(cmd.Filter, cmd.Records, nil, true, cmd.At)
+		count = len(records)
+	default:
+		return 0, fmt.Errorf("%s does not take a limit", cmd.Op)
+	}
+	return count, nil
+}
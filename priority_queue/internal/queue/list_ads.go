@@ -0,0 +1,46 @@
+package queue
+
+import (
+	"strings"
+
+	"github.com/google/btree"
+)
+
+// ListAds pages through the queued ads matching f whose AdID, title or game
+// family contains search (ignoring case), oldest first. It returns up to
+// limit records after skipping offset, and the number of matches.
+func (q *VideoProcessingQueue) ListAds(f Filter, search string, offset, limit int) ([]ItemRecord, int) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	now := q.clock.Now()
+	search = strings.ToLower(search)
+	out := make([]ItemRecord, 0, min(max(limit, 0), q.timeIndex.Len()))
+	total := 0
+	q.timeIndex.Ascend(func(it btree.Item) bool {
+		item := it.(timeIndexItem).item
+		if !f.match(item, now) || !item.contains(search) {
+			return true
+		}
+		if total >= offset && len(out) < limit {
+			out = append(out, item.record())
+		}
+		total++
+		return true
+	})
+	return out, total
+}
+
+// contains reports whether lowered occurs in the item's AdID, title or
+// family. lowered must already be lower case.
+func (item *QueueItem) contains(lowered string) bool {
+	if lowered == "" {
+		return true
+	}
+	for _, s := range []string{item.Ad.AdID, item.Ad.Title, item.Ad.GameFamily} {
+		if strings.Contains(strings.ToLower(s), lowered) {
+			return true
+		}
+	}
+	return false
+}
@@ -9,18 +9,24 @@ import (
 
 // ListWaitingLongerThan: O(logN + K) using the time btree (ascending).
 func (q *VideoProcessingQueue) ListWaitingLongerThan(age time.Duration) []*ads.Ad {
-	q.mu.Lock()
-	defer q.mu.Unlock()
+	q.mu.RLock()
+	defer q.mu.RUnlock()
 
+	var out []*ads.Ad
+	for _, item := range q.enqueuedBefore(q.clock.Now().Add(-age)) {
+		out = append(out, item.Ad)
+	}
+	return out
+}
+
+// enqueuedBefore returns the items enqueued before cutoff, oldest first.
+func (q *VideoProcessingQueue) enqueuedBefore(cutoff time.Time) []*QueueItem {
 	if q.timeIndex == nil {
 		return nil
 	}
-
-	cutoff := time.Now().Add(-age)
-	var out []*ads.Ad
+	var out []*QueueItem
 	q.timeIndex.AscendLessThan(timeIndexItem{when: cutoff, seq: 1 << 62}, func(it btree.Item) bool {
-		ti := it.(timeIndexItem)
-		out = append(out, ti.item.Ad)
+		out = append(out, it.(timeIndexItem).item)
 		return true
 	})
 	return out
@@ -0,0 +1,227 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+var ErrDraining = errors.New("queue is draining")
+
+const (
+	ScopeAll      = "all"
+	ScopePriority = "priority"
+	ScopeFamily   = "family"
+)
+
+// PauseScope selects what Pause and Resume apply to. Priority is used with
+// ScopePriority and Family with ScopeFamily.
+type PauseScope struct {
+	Kind     string `json:"kind"`
+	Priority int    `json:"priority,omitempty"`
+	Family   string `json:"family,omitempty"`
+}
+
+// PauseState lists what is currently paused.
+type PauseState struct {
+	All        bool     `json:"all"`
+	Priorities []int    `json:"priorities"`
+	Families   []string `json:"families"`
+}
+
+// Pause stops Dequeue (and PeekNext) from serving the scope. Enqueues keep
+// arriving; paused items keep their position.
+func (q *VideoProcessingQueue) Pause(scope PauseScope) {
+	q.Submit(Command{Op: OpPause, Scope: &scope})
+}
+
+func (q *VideoProcessingQueue) pause(scope PauseScope) {
+	switch scope.Kind {
+	case ScopeAll:
+		q.pausedAll = true
+	case ScopePriority:
+		q.pausedLevels[scope.Priority] = true
+	case ScopeFamily:
+		if q.pausedFamilies[scope.Family] {
+			return
+		}
+		q.pausedFamilies[scope.Family] = true
+		for p, head := range q.servable {
+			if head.Ad.GameFamily == scope.Family {
+				q.setServable(p, head.Next)
+			}
+		}
+	}
+}
+
+// Resume undoes Pause for the scope. Resuming ScopeAll clears every pause.
+func (q *VideoProcessingQueue) Resume(scope PauseScope) {
+	q.Submit(Command{Op: OpResume, Scope: &scope})
+}
+
+func (q *VideoProcessingQueue) resume(scope PauseScope) {
+	switch scope.Kind {
+	case ScopeAll:
+		q.pausedAll = false
+		q.pausedLevels = make(map[int]bool)
+		q.pausedFamilies = make(map[string]bool)
+		for p, list := range q.queueMap {
+			q.setServable(p, list.Front())
+		}
+	case ScopePriority:
+		delete(q.pausedLevels, scope.Priority)
+	case ScopeFamily:
+		if !q.pausedFamilies[scope.Family] {
+			return
+		}
+		delete(q.pausedFamilies, scope.Family)
+		for item := range q.gameFamilyIndex[scope.Family] {
+			q.linked(item, item.Ad.Priority)
+		}
+	}
+}
+
+func (q *VideoProcessingQueue) Paused() PauseState {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	st := PauseState{All: q.pausedAll, Priorities: []int{}, Families: []string{}}
+	for p := range q.pausedLevels {
+		st.Priorities = append(st.Priorities, p)
+	}
+	for f := range q.pausedFamilies {
+		st.Families = append(st.Families, f)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(st.Priorities)))
+	sort.Strings(st.Families)
+	return st
+}
+
+// nextServable returns the first item at or after from at level p that is not
+// paused, or nil.
+func (q *VideoProcessingQueue) nextServable(p int, from *QueueItem) *QueueItem {
+	if q.pausedAll || q.pausedLevels[p] {
+		return nil
+	}
+	return q.firstUnpaused(from)
+}
+
+func (q *VideoProcessingQueue) firstUnpaused(from *QueueItem) *QueueItem {
+	for item := from; item != nil; item = item.Next {
+		if !q.pausedFamilies[item.Ad.GameFamily] {
+			return item
+		}
+	}
+	return nil
+}
+
+// setServable points the servable head of level p at the first item from
+// from on whose family is not paused.
+//
+// q.servable keeps that head for each level so Dequeue does not walk past
+// paused items to find it. The head only moves forward as items are taken,
+// so each paused item is passed once per pause rather than once per dequeue.
+// Level and global pauses are checked on top of it in headOf.
+func (q *VideoProcessingQueue) setServable(p int, from *QueueItem) {
+	if item := q.firstUnpaused(from); item != nil {
+		q.servable[p] = item
+	} else {
+		delete(q.servable, p)
+	}
+}
+
+// linked updates the servable head of level p for item, just linked into it.
+func (q *VideoProcessingQueue) linked(item *QueueItem, p int) {
+	if q.pausedFamilies[item.Ad.GameFamily] {
+		return
+	}
+	if head := q.servable[p]; head == nil || item.before(head) {
+		q.servable[p] = item
+	}
+}
+
+// unlink removes item from its level, moving the level's servable head past
+// it first.
+func (q *VideoProcessingQueue) unlink(item *QueueItem) {
+	p := item.Ad.Priority
+	list := q.queueMap[p]
+	if list == nil {
+		return
+	}
+	if q.servable[p] == item {
+		q.setServable(p, item.Next)
+	}
+	list.Remove(item)
+}
+
+// SetDraining switches drain mode. While draining, Enqueue returns
+// ErrDraining and Drained is closed once the queue becomes empty.
+func (q *VideoProcessingQueue) SetDraining(enable bool) {
+	q.Submit(Command{Op: OpSetDraining, Enable: enable})
+}
+
+func (q *VideoProcessingQueue) setDraining(enable bool) {
+	if enable == q.draining {
+		return
+	}
+	q.draining = enable
+	if enable {
+		q.drained, q.drainStopped = make(chan struct{}), make(chan struct{})
+		q.checkDrained()
+		return
+	}
+	q.stopDrain()
+}
+
+// stopDrain wakes WaitDrained callers of the drain in progress, if any.
+func (q *VideoProcessingQueue) stopDrain() {
+	if q.drainStopped != nil {
+		close(q.drainStopped)
+	}
+	q.drained, q.drainStopped = nil, nil
+}
+
+// DrainStatus reports whether drain mode is on and how many ads remain,
+// counting leased ads that could still be nacked back.
+func (q *VideoProcessingQueue) DrainStatus() (draining bool, remaining int) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.draining, q.timeIndex.Len() + len(q.leases)
+}
+
+// WaitDrained blocks until a drain started with SetDraining(true) has emptied
+// the queue and every lease has ended, or ctx is done. It fails if drain mode
+// is off or is turned off before the queue empties.
+func (q *VideoProcessingQueue) WaitDrained(ctx context.Context) error {
+	q.mu.RLock()
+	ch, stopped := q.drained, q.drainStopped
+	q.mu.RUnlock()
+	if ch == nil {
+		return errors.New("queue is not draining")
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-stopped:
+		select {
+		case <-ch:
+			return nil // drained before it was turned off
+		default:
+			return errors.New("drain mode was turned off")
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// checkDrained closes the drained channel once a draining queue is empty.
+func (q *VideoProcessingQueue) checkDrained() {
+	if !q.draining || q.timeIndex.Len() > 0 || len(q.leases) > 0 {
+		return
+	}
+	select {
+	case <-q.drained:
+	default:
+		close(q.drained)
+	}
+}
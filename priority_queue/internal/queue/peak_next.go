@@ -7,57 +7,59 @@ import (
 
 // PeekNext returns the next n ads in the exact order Dequeue would pick, without mutation.
 func (q *VideoProcessingQueue) PeekNext(n int) []*ads.Ad {
-	q.mu.Lock()
-	defer q.mu.Unlock()
+	q.mu.RLock()
+	defer q.mu.RUnlock()
 
 	if n <= 0 {
 		return nil
 	}
-	now := time.Now()
-	result := make([]*ads.Ad, 0, n)
+	items := q.peekItems(n, q.clock.Now())
+	result := make([]*ads.Ad, len(items))
+	for i, item := range items {
+		result[i] = item.Ad
+	}
+	return result
+}
 
-	type cursor struct {
-		node *QueueItem
-		size int
+// peekItems returns the next n items in dequeue order as of now. The caller
+// holds q.mu.
+func (q *VideoProcessingQueue) peekItems(n int, now time.Time) []*QueueItem {
+	result := make([]*QueueItem, 0, n)
+	if n <= 0 {
+		return result
 	}
-	cursors := make(map[int]cursor, len(q.priorities))
+	q.walk(now, func(item *QueueItem) bool {
+		result = append(result, item)
+		return len(result) < n
+	})
+	return result
+}
+
+// walk calls fn with each servable item in dequeue order as of now, until
+// fn returns false. The caller holds q.mu.
+func (q *VideoProcessingQueue) walk(now time.Time, fn func(*QueueItem) bool) {
+	// cursors holds the next servable node of each level.
+	cursors := make(map[int]*QueueItem, len(q.priorities))
 	for _, p := range q.priorities {
-		if dq := q.queueMap[p]; dq != nil && dq.Size > 0 {
-			cursors[p] = cursor{node: dq.Head, size: dq.Size}
+		if head := q.headOf(p); head != nil {
+			cursors[p] = head
 		}
 	}
+	headOf := func(p int) *QueueItem { return cursors[p] }
 
-	for len(result) < n {
-		selected := -1
-		for _, p := range q.priorities {
-			cur, ok := cursors[p]
-			if !ok || cur.size == 0 || cur.node == nil {
-				continue
-			}
-			if selected == -1 {
-				selected = p
-				if !q.enableAntiStarvation {
-					break
-				}
-			}
-			waited := now.Sub(cur.node.EnqueueAt)
-			if waited >= time.Duration(cur.node.Ad.MaxWaitTime)*time.Second {
-				selected = p
-				break
-			}
-		}
+	for {
+		selected := q.selectLevel(headOf, now)
 		if selected == -1 {
-			break
+			return
 		}
-		cur := cursors[selected]
-		result = append(result, cur.node.Ad)
-		cur.node = cur.node.Next
-		cur.size--
-		if cur.size <= 0 || cur.node == nil {
-			delete(cursors, selected)
+		node := cursors[selected]
+		if !fn(node) {
+			return
+		}
+		if next := q.nextServable(selected, node.Next); next != nil {
+			cursors[selected] = next
 		} else {
-			cursors[selected] = cur
+			delete(cursors, selected)
 		}
 	}
-	return result
 }
@@ -0,0 +1,168 @@
+package queue
+
+import (
+	"icetea/priority_queue/internal/ads"
+	"time"
+)
+
+// Position is where a queued item stands in dequeue order. It is an estimate:
+// higher priority arrivals, aging, boosts and pauses all move it. PositionOf
+// computes it exactly for the moment; EstimatePosition more cheaply.
+type Position struct {
+	Seq       int64     `json:"seq"`
+	EnqueueAt time.Time `json:"enqueueAt"`
+	// Position is 1 for the item Dequeue would take next, or 0 while the
+	// item is paused and not being served.
+	Position int  `json:"position"`
+	Paused   bool `json:"paused,omitempty"`
+	Queued   int  `json:"queued"` // ads in the queue, this one included
+	// DequeueRate is dequeues per second over the last few minutes.
+	DequeueRate float64 `json:"dequeueRate"`
+	// EstimatedStartAt is when the item should be dequeued at that rate;
+	// absent while paused or when nothing was dequeued recently.
+	EstimatedStartAt *time.Time `json:"estimatedStartAt,omitempty"`
+}
+
+// PositionOf reports where the queued item with adID stands. A non-zero seq
+// picks that item; otherwise it is whichever item with adID is served
+// first. ok is false if no such item is queued, e.g. once it is dequeued or
+// while it is leased. This walks the queue in dequeue order up to the item.
+func (q *VideoProcessingQueue) PositionOf(adID string, seq int64) (ad *ads.Ad, pos Position, ok bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	var target *QueueItem
+	for item := range q.adIndex[adID] {
+		if seq == 0 || item.seq == seq {
+			target = item
+			break
+		}
+	}
+	if target == nil {
+		return nil, Position{}, false
+	}
+
+	now := q.clock.Now()
+	pos.Queued = q.timeIndex.Len()
+	pos.DequeueRate = q.dequeueTimes.rate(now)
+	var found *QueueItem
+	q.walk(now, func(item *QueueItem) bool {
+		pos.Position++
+		if item.Ad.AdID == adID && (seq == 0 || item.seq == seq) {
+			found = item
+		}
+		return found == nil
+	})
+	if found == nil {
+		found, pos.Position, pos.Paused = target, 0, true
+	}
+	pos.finish(found, now)
+	return found.Ad, pos, true
+}
+
+// EstimatePosition is a cheap PositionOf for the item with seq, for enqueue
+// responses. It ignores anti-starvation and counts the items ahead of it under
+// strict priority: every item at a higher level plus those before it in its
+// own. That takes the level sizes and, for an item not at the tail of its
+// level (one enqueued with an earlier time), a walk back to the head.
+func (q *VideoProcessingQueue) EstimatePosition(seq int64) (Position, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	item, ok := q.bySeq[seq]
+	if !ok {
+		return Position{}, false
+	}
+	return q.estimate(item, q.clock.Now()), true
+}
+
+// estimate is EstimatePosition for a queued item. The caller holds q.mu.
+func (q *VideoProcessingQueue) estimate(item *QueueItem, now time.Time) Position {
+	p := item.Ad.Priority
+	pos := Position{Queued: q.timeIndex.Len(), DequeueRate: q.dequeueTimes.rate(now)}
+	if q.pausedAll || q.pausedLevels[p] || q.pausedFamilies[item.Ad.GameFamily] {
+		pos.Paused = true
+		pos.finish(item, now)
+		return pos
+	}
+	for _, level := range q.priorities {
+		if level <= p {
+			break
+		}
+		if list := q.queueMap[level]; list != nil {
+			pos.Position += list.Size
+		}
+	}
+	if list := q.queueMap[p]; list.Tail == item {
+		pos.Position += list.Size
+	} else {
+		for it := item; it != nil; it = it.Prev {
+			pos.Position++
+		}
+	}
+	pos.finish(item, now)
+	return pos
+}
+
+// finish fills in the item's seq and enqueue time and, unless it is paused,
+// the estimated start.
+func (pos *Position) finish(item *QueueItem, now time.Time) {
+	pos.Seq, pos.EnqueueAt = item.seq, item.EnqueueAt
+	pos.estimateStart(now)
+}
+
+// estimateStart sets EstimatedStartAt from Position and DequeueRate.
+func (pos *Position) estimateStart(now time.Time) {
+	pos.EstimatedStartAt = nil
+	if !pos.Paused && pos.DequeueRate > 0 {
+		at := now.Add(time.Duration(float64(pos.Position) / pos.DequeueRate * float64(time.Second)))
+		pos.EstimatedStartAt = &at
+	}
+}
+
+const (
+	rateSamples = 64              // dequeue times kept for the rate
+	rateWindow  = 5 * time.Minute // older ones do not count
+)
+
+// dequeueTimes remembers when the last rateSamples dequeues happened.
+type dequeueTimes struct {
+	at   [rateSamples]time.Time
+	next int
+}
+
+func (d *dequeueTimes) add(t time.Time) {
+	d.at[d.next] = t
+	d.next = (d.next + 1) % rateSamples
+}
+
+// list returns the remembered times, oldest first.
+func (d *dequeueTimes) list() []time.Time {
+	var out []time.Time
+	for i := range rateSamples {
+		if t := d.at[(d.next+i)%rateSamples]; !t.IsZero() {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// rate is the dequeues per second since the oldest remembered dequeue
+// within rateWindow, so it decays once dequeues stop.
+func (d *dequeueTimes) rate(now time.Time) float64 {
+	var n int
+	var oldest time.Time
+	for _, t := range d.at {
+		if t.IsZero() || now.Sub(t) > rateWindow {
+			continue
+		}
+		n++
+		if oldest.IsZero() || t.Before(oldest) {
+			oldest = t
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return float64(n) / max(now.Sub(oldest), time.Second).Seconds()
+}
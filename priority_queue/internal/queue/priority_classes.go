@@ -0,0 +1,102 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"icetea/priority_queue/config"
+	"icetea/priority_queue/internal/ads"
+	"strconv"
+)
+
+var (
+	ErrCapacityExceeded = errors.New("priority level at capacity")
+	ErrUnknownPriority  = errors.New("unknown priority class")
+	ErrClassOutOfRange  = errors.New("priority class level outside range")
+)
+
+// SetPriorityClasses replaces the named classes. Classes are expected to be
+// validated with config.ValidateClasses.
+func (q *VideoProcessingQueue) SetPriorityClasses(classes []config.PriorityClass) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.classes = make(map[int]config.PriorityClass, len(classes))
+	q.classLevels = make(map[string]int, len(classes))
+	for _, c := range classes {
+		q.classes[c.Level] = c
+		if c.Name != "" {
+			q.classLevels[c.Name] = c.Level
+		}
+	}
+}
+
+// ResolvePriority maps a class name or a numeric string to a priority level.
+// Numbers are returned as-is and normalized later by the queue operation.
+func (q *VideoProcessingQueue) ResolvePriority(s string) (int, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, nil
+	}
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if level, ok := q.classLevels[s]; ok {
+		return level, nil
+	}
+	return 0, fmt.Errorf("%w %q", ErrUnknownPriority, s)
+}
+
+// admit normalizes ad for insertion and enforces class capacity.
+func (q *VideoProcessingQueue) admit(ad *ads.Ad) error {
+	ad.Priority = q.normalizePriority(ad.Priority)
+	class, hasClass := q.classes[ad.Priority]
+	if ad.MaxWaitTime <= 0 && hasClass && class.MaxWaitSeconds > 0 {
+		ad.MaxWaitTime = class.MaxWaitSeconds
+	}
+	if maxWait := int(q.maximumWaitTime.Load()); ad.MaxWaitTime > maxWait {
+		ad.MaxWaitTime = maxWait
+	}
+	if hasClass && class.Capacity > 0 {
+		if queue := q.queueMap[ad.Priority]; queue != nil && queue.Size >= class.Capacity {
+			return fmt.Errorf("%w: %s", ErrCapacityExceeded, class.Name)
+		}
+	}
+	return nil
+}
+
+// levelRoom tracks how full the capacity-limited levels are while a command
+// moves items between levels, so a dry run skips exactly the items the real
+// run would. Moves are booked in the order they are made, which is ascending
+// enqueue order everywhere, so replicas skip the same items.
+type levelRoom struct {
+	q     *VideoProcessingQueue
+	sizes map[int]int // levels touched so far
+}
+
+func (q *VideoProcessingQueue) levelRoom() *levelRoom {
+	return &levelRoom{q: q, sizes: make(map[int]int)}
+}
+
+func (r *levelRoom) size(p int) int {
+	if n, ok := r.sizes[p]; ok {
+		return n
+	}
+	if list := r.q.queueMap[p]; list != nil {
+		return list.Size
+	}
+	return 0
+}
+
+// take books moving item to level to and reports whether to has room for
+// it. An item that does not change level always fits.
+func (r *levelRoom) take(item *QueueItem, to int) bool {
+	from := item.Ad.Priority
+	if from == to {
+		return true
+	}
+	if c, ok := r.q.classes[to]; ok && c.Capacity > 0 && r.size(to) >= c.Capacity {
+		return false
+	}
+	r.sizes[from] = r.size(from) - 1
+	r.sizes[to] = r.size(to) + 1
+	return true
+}
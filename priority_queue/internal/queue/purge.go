@@ -0,0 +1,84 @@
+package queue
+
+import (
+	"encoding/json"
+	"icetea/priority_queue/internal/ads"
+	"io"
+	"time"
+)
+
+// ItemRecord is the JSONL form of a queued item. EnqueueAt and Seq are what
+// is needed to put the ad back at the same position.
+type ItemRecord struct {
+	Ad        *ads.Ad   `json:"ad"`
+	EnqueueAt time.Time `json:"enqueueAt"`
+	Seq       int64     `json:"seq"`
+	Attempts  int       `json:"attempts,omitempty"` // earlier dequeues or leases of the ad
+}
+
+func (item *QueueItem) record() ItemRecord {
+	ad := *item.Ad
+	return ItemRecord{Ad: &ad, EnqueueAt: item.EnqueueAt, Seq: item.seq, Attempts: item.attempts}
+}
+
+// itemOf is the inverse of record: a new, unlinked item for rec with the
+// given seq.
+func itemOf(rec ItemRecord, seq int64) *QueueItem {
+	ad := *rec.Ad
+	return &QueueItem{Ad: &ad, EnqueueAt: rec.EnqueueAt, seq: seq, attempts: rec.Attempts}
+}
+
+// WriteRecords encodes records as JSON lines.
+func WriteRecords(w io.Writer, records []ItemRecord) error {
+	enc := json.NewEncoder(w)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Purge removes every item matching f from the queue and all indices. The
+// matching items are handed to export first, in enqueue order, while the
+// queue is locked; if export fails nothing is removed.
+func (q *VideoProcessingQueue) Purge(f Filter, export func([]ItemRecord) error) ([]ItemRecord, error) {
+	res, err := q.Submit(Command{Op: OpPurge, Filter: f, Export: export})
+	return res.Records, err
+}
+
+// purge removes the items matching f. When only is non-nil, items not listed
+// in it (by seq) are kept; a dry run returns the records without exporting or
+// removing anything.
+func (q *VideoProcessingQueue) purge(f Filter, only []ItemRecord, export func([]ItemRecord) error, dryRun bool, now time.Time) ([]ItemRecord, error) {
+	items := q.matching(f, now)
+	if only != nil {
+		listed := make(map[int64]bool, len(only))
+		for _, r := range only {
+			listed[r.Seq] = true
+		}
+		kept := items[:0]
+		for _, item := range items {
+			if listed[item.seq] {
+				kept = append(kept, item)
+			}
+		}
+		items = kept
+	}
+	records := make([]ItemRecord, 0, len(items))
+	for _, item := range items {
+		records = append(records, item.record())
+	}
+	if dryRun {
+		return records, nil
+	}
+	if export != nil {
+		if err := export(records); err != nil {
+			return nil, err
+		}
+	}
+	for _, item := range items {
+		q.removeItem(item)
+	}
+	return records, nil
+}
@@ -1,10 +1,11 @@
 package queue
 
 import (
+	"context"
 	"icetea/priority_queue/config"
 	"icetea/priority_queue/internal/ads"
-	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/btree"
@@ -17,34 +18,105 @@ type QueueItem struct {
 	Next      *QueueItem
 	Prev      *QueueItem
 	seq       int64 // unique per enqueue for stable ordering/deletes
+	attempts  int   // times handed out by dequeue; a nack brings it back with them
+
+	boost        *boost // active boost holding this item, if any
+	origPriority int    // priority to restore when boost ends
+}
+
+// before reports whether item comes before other in enqueue order.
+func (item *QueueItem) before(other *QueueItem) bool {
+	return timeIndexItem{when: item.EnqueueAt, seq: item.seq}.Less(timeIndexItem{when: other.EnqueueAt, seq: other.seq})
 }
 
 type PriorityDist struct {
 	Priority int
+	Name     string `json:",omitempty"` // class name, if the level has one
 	Count    int
 	Percent  float64 // 0..100
 }
 
 type VideoProcessingQueue struct {
-	mu                   sync.Mutex
+	// mu guards everything below except the settings, which are atomics so
+	// they can be read without it. Settings are only written under mu.
+	mu                   sync.RWMutex
 	queueMap             map[int]*DList // priority -> queue
 	priorities           []int          // descending: highest first
-	totalPriority        int
-	enableAntiStarvation bool
-	maximumWaitTime      int
+	totalPriority        atomic.Int64
+	enableAntiStarvation atomic.Bool
+	maximumWaitTime      atomic.Int64
 	gameFamilyIndex      map[string]map[*QueueItem]struct{}
-	timeIndex            *btree.BTree // ordered by EnqueueAt
+	adIndex              map[string]map[*QueueItem]struct{} // AdID -> items
+	bySeq                map[int64]*QueueItem               // seq -> queued item
+	timeIndex            *btree.BTree                       // ordered by EnqueueAt
 	nextSeq              int64
 	timeBoost            float64
+	boosts               map[int64]*boost
+	nextBoostID          int64
+	leases               map[int64]*lease
+	endedLeases          endedLeases // how recent leases ended, for retried acks
+	nextLeaseID          int64
+	classes              map[int]config.PriorityClass // level -> class
+	classLevels          map[string]int               // class name -> level
+	pausedAll            bool
+	pausedLevels         map[int]bool
+	pausedFamilies       map[string]bool
+	servable             map[int]*QueueItem // level -> first item whose family is not paused
+	draining             bool
+	drained              chan struct{} // closed when a draining queue empties
+	drainStopped         chan struct{} // closed when drain mode is turned off
+	applied              uint64        // mutations applied, see Apply
+	enqueued, dequeued   uint64        // totals for Stats
+	dequeueTimes         dequeueTimes  // recent dequeues, for PositionOf
+	onCommand            func(index uint64, cmd Command)
+	replica              bool // boosts and leases expire via replicated commands only
+	proposer             func(Command) (Result, error)
+	clock                Clock
+}
+
+// Queue is what the API serves: a VideoProcessingQueue, or a ShardedQueue
+// spreading the ads over several. Every mutation goes through Submit.
+type Queue interface {
+	Submit(cmd Command) (Result, error)
+	Now() time.Time
+
+	PeekNext(n int) []*ads.Ad
+	PeekScored(n int) []ScoredAd
+	HeadScores() []HeadScore
+	DistributionByPriority() ([]PriorityDist, int)
+	ListWaitingLongerThan(age time.Duration) []*ads.Ad
+	ListAds(f Filter, search string, offset, limit int) ([]ItemRecord, int)
+	Export() []ItemRecord
+	Stats() Stats
+	PositionOf(adID string, seq int64) (*ads.Ad, Position, bool)
+	EstimatePosition(seq int64) (Position, bool)
+	Leases() []LeaseInfo
+	ActiveBoosts() []BoostInfo
+
+	Paused() PauseState
+	SetDraining(enable bool)
+	DrainStatus() (draining bool, remaining int)
+	WaitDrained(ctx context.Context) error
+	IsEnableAntiStarvation() bool
+	MaximumWaitTime() int
+	TotalPriority() int
+	ResolvePriority(s string) (int, error)
 }
 
-// New creates a new queue. maximumWait caps per-ad MaxWaitTime.
+var (
+	_ Queue = (*VideoProcessingQueue)(nil)
+	_ Queue = (*ShardedQueue)(nil)
+)
+
+// New creates a new queue. maximumWait caps per-ad MaxWaitTime. A nil clock
+// means RealClock.
 func New(
 	totalPriority int,
 	enableStarvation bool,
 	maximumWait int,
 	btreeDegree int,
 	timeBoost float64,
+	clock Clock,
 ) *VideoProcessingQueue {
 	if totalPriority <= 0 {
 		totalPriority = 2
@@ -55,42 +127,90 @@ func New(
 	if timeBoost <= 0 {
 		timeBoost = 1
 	}
+	if clock == nil {
+		clock = RealClock{}
+	}
 	priorities := make([]int, totalPriority)
 	for i := 0; i < totalPriority; i++ {
 		priorities[i] = totalPriority - i // Descending
 	}
-	return &VideoProcessingQueue{
-		queueMap:             make(map[int]*DList),
-		priorities:           priorities,
-		totalPriority:        totalPriority,
-		enableAntiStarvation: enableStarvation,
-		maximumWaitTime:      maximumWait,
-		gameFamilyIndex:      make(map[string]map[*QueueItem]struct{}),
-		timeIndex:            btree.New(btreeDegree),
-		timeBoost:            timeBoost,
+	q := &VideoProcessingQueue{
+		queueMap:        make(map[int]*DList),
+		priorities:      priorities,
+		gameFamilyIndex: make(map[string]map[*QueueItem]struct{}),
+		adIndex:         make(map[string]map[*QueueItem]struct{}),
+		bySeq:           make(map[int64]*QueueItem),
+		timeIndex:       btree.New(btreeDegree),
+		timeBoost:       timeBoost,
+		boosts:          make(map[int64]*boost),
+		leases:          make(map[int64]*lease),
+		classes:         make(map[int]config.PriorityClass),
+		classLevels:     make(map[string]int),
+		pausedLevels:    make(map[int]bool),
+		pausedFamilies:  make(map[string]bool),
+		servable:        make(map[int]*QueueItem),
+		clock:           clock,
 	}
+	q.totalPriority.Store(int64(totalPriority))
+	q.enableAntiStarvation.Store(enableStarvation)
+	q.maximumWaitTime.Store(int64(maximumWait))
+	return q
 }
 
 func NewFromConfig(cfg config.Config) *VideoProcessingQueue {
-	return New(
+	return NewFromConfigWithClock(cfg, RealClock{})
+}
+
+func NewFromConfigWithClock(cfg config.Config, clock Clock) *VideoProcessingQueue {
+	q := New(
 		cfg.TotalPriority,
 		cfg.EnableAntiStarvation,
 		cfg.MaximumWaitSeconds,
 		cfg.BTreeDegree,
 		cfg.TimeBoost,
+		clock,
 	)
+	q.SetPriorityClasses(cfg.PriorityClasses)
+	return q
 }
 
 func (q *VideoProcessingQueue) normalizePriority(p int) int {
 	if p < 1 {
 		return 1
 	}
-	if p > q.totalPriority {
-		return q.totalPriority
+	if total := int(q.totalPriority.Load()); p > total {
+		return total
 	}
 	return p
 }
 
+// addToIndices registers a newly linked item in the family, ad, seq and time
+// indices.
+func (q *VideoProcessingQueue) addToIndices(item *QueueItem) {
+	ad := item.Ad
+	if _, ok := q.gameFamilyIndex[ad.GameFamily]; !ok {
+		q.gameFamilyIndex[ad.GameFamily] = make(map[*QueueItem]struct{})
+	}
+	q.gameFamilyIndex[ad.GameFamily][item] = struct{}{}
+
+	if _, ok := q.adIndex[ad.AdID]; !ok {
+		q.adIndex[ad.AdID] = make(map[*QueueItem]struct{})
+	}
+	q.adIndex[ad.AdID][item] = struct{}{}
+	q.bySeq[item.seq] = item
+
+	q.timeIndex.ReplaceOrInsert(timeIndexItem{when: item.EnqueueAt, seq: item.seq, item: item})
+}
+
+func (q *VideoProcessingQueue) removeFromAdIndex(item *QueueItem) {
+	if adItems, ok := q.adIndex[item.Ad.AdID]; ok {
+		delete(adItems, item)
+		if len(adItems) == 0 {
+			delete(q.adIndex, item.Ad.AdID)
+		}
+	}
+}
+
 func (q *VideoProcessingQueue) removeFromFamilyIndex(item *QueueItem) {
 	if familyItems, ok := q.gameFamilyIndex[item.Ad.GameFamily]; ok {
 		delete(familyItems, item)
@@ -107,6 +227,27 @@ func (q *VideoProcessingQueue) removeFromTimeIndex(item *QueueItem) {
 	q.timeIndex.Delete(timeIndexItem{when: item.EnqueueAt, seq: item.seq, item: item})
 }
 
+// removeItem unlinks item from its priority list and every index.
+func (q *VideoProcessingQueue) removeItem(item *QueueItem) {
+	q.clearBoost(item)
+	q.unlink(item)
+	q.removeFromFamilyIndex(item)
+	q.removeFromAdIndex(item)
+	delete(q.bySeq, item.seq)
+	q.removeFromTimeIndex(item)
+	q.checkDrained()
+}
+
+// moveToPriority relinks item into priority p, keeping its time position.
+func (q *VideoProcessingQueue) moveToPriority(item *QueueItem, p int) {
+	if item.Ad.Priority == p {
+		return
+	}
+	q.unlink(item)
+	item.Ad.Priority = p
+	q.insertIntoPriorityByTime(item, p)
+}
+
 // insertIntoPriorityByTime inserts 'item' into the DList for priority 'p'
 // keeping ascending EnqueueAt order. It uses the global timeIndex to find the
 // nearest same-priority neighbor, so insertion is O(log N) + O(1) splice.
@@ -125,15 +266,16 @@ func (q *VideoProcessingQueue) insertIntoPriorityByTime(item *QueueItem, p int)
 		queue.Head = item
 		queue.Tail = item
 		queue.Size = 1
+		q.linked(item, p)
 		return
 	}
 
 	// Find nearest same-priority neighbor via timeIndex.
-	var prevItem *QueueItem // greatest (time,seq) <= (item.time, +inf) with same priority p
+	var prevItem *QueueItem // greatest (time,seq) <= (item.time, item.seq) with same priority p
 
 	// predecessor (older or equal)
 	q.timeIndex.DescendLessOrEqual(
-		timeIndexItem{when: item.EnqueueAt, seq: math.MaxInt64},
+		timeIndexItem{when: item.EnqueueAt, seq: item.seq},
 		func(it btree.Item) bool {
 			ti := it.(timeIndexItem)
 			if item != ti.item && ti.item.Ad.Priority == p {
@@ -161,4 +303,5 @@ func (q *VideoProcessingQueue) insertIntoPriorityByTime(item *QueueItem, p int)
 		}
 	}
 	queue.Size++
+	q.linked(item, p)
 }
@@ -1,6 +1,13 @@
 package queue
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -352,3 +359,1031 @@ func TestDistributionByPriority(t *testing.T) {
 		}
 	}
 }
+
+// === 11) Bulk reprioritize by filter: dry run is read-only, apply keeps time order ===
+func TestBulkReprioritize_DryRunAndStableOrder(t *testing.T) {
+	queueConfig := config.Config{
+		TotalPriority:        3,
+		EnableAntiStarvation: true,
+		MaximumWaitSeconds:   3600,
+		BTreeDegree:          16,
+		TimeBoost:            2,
+	}
+	q := NewFromConfig(queueConfig)
+	now := time.Now()
+
+	q.EnqueueWithTime(newAd("R1", "RPG", 1, 3600), now.Add(-30*time.Minute))
+	q.EnqueueWithTime(newAd("S1", "Shooter", 1, 3600), now.Add(-25*time.Minute))
+	q.EnqueueWithTime(newAd("R2", "RPG", 1, 3600), now.Add(-20*time.Minute))
+	q.EnqueueWithTime(newAd("R3", "RPG", 2, 3600), now.Add(-15*time.Minute))
+	q.EnqueueWithTime(newAd("R4", "RPG", 1, 3600), now.Add(-1*time.Minute))
+	q.EnqueueWithTime(newAd("T1", "Other", 3, 3600), now.Add(-10*time.Minute))
+
+	f, err := ParseFilter(`family = RPG and age > 10m and priority = 1`)
+	if err != nil {
+		t.Fatalf("ParseFilter: %v", err)
+	}
+
+	ids := q.BulkReprioritize(f, 3, true)
+	if len(ids) != 2 || ids[0] != "R1" || ids[1] != "R2" {
+		t.Fatalf("dry run ids = %v, want [R1 R2]", ids)
+	}
+	dist, _ := q.DistributionByPriority()
+	if dist[0].Count != 1 {
+		t.Fatalf("dry run mutated the queue: %+v", dist)
+	}
+
+	q.BulkReprioritize(f, 3, false)
+	got := takeDequeue(q, 3)
+	want := []string{"R1", "R2", "T1"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("after bulk reprioritize got %v, want %v", got, want)
+		}
+	}
+}
+
+// === 12) Bulk remove drops items from every index ===
+func TestBulkRemove(t *testing.T) {
+	queueConfig := config.Config{
+		TotalPriority:        3,
+		EnableAntiStarvation: true,
+		MaximumWaitSeconds:   600,
+		BTreeDegree:          16,
+		TimeBoost:            2,
+	}
+	q := NewFromConfig(queueConfig)
+	now := time.Now()
+
+	q.EnqueueWithTime(newAd("A", "RPG", 2, 600), now.Add(-10*time.Minute))
+	q.EnqueueWithTime(newAd("B", "Puzzle", 2, 600), now.Add(-9*time.Minute))
+
+	f, _ := ParseFilter(`family = "RPG"`)
+	if ids := q.BulkRemove(f, false); len(ids) != 1 || ids[0] != "A" {
+		t.Fatalf("removed %v, want [A]", ids)
+	}
+	if got := q.ListWaitingLongerThan(time.Minute); len(got) != 1 || got[0].AdID != "B" {
+		t.Fatalf("time index still exposes removed item: %v", got)
+	}
+	if _, ok := q.gameFamilyIndex["RPG"]; ok {
+		t.Fatalf("family index still holds removed item")
+	}
+	if got := takeDequeue(q, 2); len(got) != 1 || got[0] != "B" {
+		t.Fatalf("dequeue after remove = %v, want [B]", got)
+	}
+}
+
+func TestParseFilter_Errors(t *testing.T) {
+	for _, expr := range []string{
+		"family",
+		"family > RPG",
+		"colour = red",
+		"age > soon",
+		"priority = 1 or age > 1m",
+		`family = "RPG`,
+	} {
+		if _, err := ParseFilter(expr); err == nil {
+			t.Fatalf("ParseFilter(%q) succeeded, want error", expr)
+		}
+	}
+}
+
+// === 13) Relative adjustments are clamped to the valid range ===
+func TestBulkAdjustPriority_Clamped(t *testing.T) {
+	queueConfig := config.Config{
+		TotalPriority:        3,
+		EnableAntiStarvation: true,
+		MaximumWaitSeconds:   600,
+		BTreeDegree:          16,
+		TimeBoost:            2,
+	}
+	q := NewFromConfig(queueConfig)
+	now := time.Now()
+
+	top := newAd("TOP", "F", 3, 600)
+	mid := newAd("MID", "F", 2, 600)
+	q.EnqueueWithTime(top, now.Add(-2*time.Minute))
+	q.EnqueueWithTime(mid, now.Add(-1*time.Minute))
+
+	ids := q.BulkAdjustPriority(FamilyFilter("F"), +1, false)
+	if len(ids) != 1 || ids[0] != "MID" {
+		t.Fatalf("adjusted %v, want [MID]", ids)
+	}
+	if top.Priority != 3 || mid.Priority != 3 {
+		t.Fatalf("priorities = %d,%d, want 3,3", top.Priority, mid.Priority)
+	}
+	q.BulkAdjustPriority(FamilyFilter("F"), -5, false)
+	if top.Priority != 1 || mid.Priority != 1 {
+		t.Fatalf("priorities = %d,%d, want 1,1", top.Priority, mid.Priority)
+	}
+}
+
+// === 14) Boost raises a family and restores original priorities in FIFO position ===
+func TestBoost_RevertRestoresPriorityAndOrder(t *testing.T) {
+	queueConfig := config.Config{
+		TotalPriority:        3,
+		EnableAntiStarvation: false,
+		MaximumWaitSeconds:   600,
+		BTreeDegree:          16,
+		TimeBoost:            2,
+	}
+	q := NewFromConfig(queueConfig)
+	base := time.Now().Add(-time.Hour)
+
+	q.EnqueueWithTime(newAd("A", "Other", 1, 600), base)
+	q.EnqueueWithTime(newAd("X1", "X", 1, 600), base.Add(time.Minute))
+	q.EnqueueWithTime(newAd("B", "Other", 1, 600), base.Add(2*time.Minute))
+	q.EnqueueWithTime(newAd("X2", "X", 1, 600), base.Add(3*time.Minute))
+
+	b := q.Boost(FamilyFilter("X"), 1, time.Hour)
+	if got := peekIDs(q, 4); got[0] != "X1" || got[1] != "X2" {
+		t.Fatalf("boosted order = %v, want X1 X2 first", got)
+	}
+	if active := q.ActiveBoosts(); len(active) != 1 || len(active[0].AdIDs) != 2 {
+		t.Fatalf("active boosts = %+v", active)
+	}
+
+	if !q.CancelBoost(b.ID) {
+		t.Fatalf("CancelBoost(%d) = false", b.ID)
+	}
+	got := peekIDs(q, 4)
+	want := []string{"A", "X1", "B", "X2"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("after revert got %v, want %v", got, want)
+		}
+	}
+	if len(q.ActiveBoosts()) != 0 {
+		t.Fatalf("boost still listed after cancel")
+	}
+}
+
+// === 15) Boost expires on its own; explicit reprioritize pins the item ===
+func TestBoost_ExpiresAndExplicitReprioritizePins(t *testing.T) {
+	queueConfig := config.Config{
+		TotalPriority:        3,
+		EnableAntiStarvation: false,
+		MaximumWaitSeconds:   600,
+		BTreeDegree:          16,
+		TimeBoost:            2,
+	}
+	clock := NewFakeClock(time.Now())
+	q := NewFromConfigWithClock(queueConfig, clock)
+
+	a := newAd("A", "X", 1, 600)
+	p := newAd("P", "Y", 1, 600)
+	q.Enqueue(a)
+	q.Enqueue(p)
+
+	q.Boost(FamilyFilter("X"), 1, 20*time.Millisecond)
+	q.Boost(FamilyFilter("Y"), 1, 20*time.Millisecond)
+	q.ReprioritizeByGameFamily("Y", 3)
+
+	clock.Advance(19 * time.Millisecond)
+	if len(q.ActiveBoosts()) != 2 {
+		t.Fatalf("boosts expired early: %+v", q.ActiveBoosts())
+	}
+	clock.Advance(time.Millisecond)
+	if n := len(q.ActiveBoosts()); n != 0 {
+		t.Fatalf("%d boosts still active after expiry", n)
+	}
+	if a.Priority != 1 {
+		t.Fatalf("boosted ad priority = %d after expiry, want 1", a.Priority)
+	}
+	if p.Priority != 3 {
+		t.Fatalf("pinned ad priority = %d after expiry, want 3", p.Priority)
+	}
+}
+
+// === 16) Resizing priority levels at runtime ===
+func TestSetTotalPriority_GrowAndShrink(t *testing.T) {
+	queueConfig := config.Config{
+		TotalPriority:        5,
+		EnableAntiStarvation: false,
+		MaximumWaitSeconds:   600,
+		BTreeDegree:          16,
+		TimeBoost:            2,
+	}
+	base := time.Now().Add(-time.Hour)
+	fill := func() (*VideoProcessingQueue, []*ads.Ad) {
+		q := NewFromConfig(queueConfig)
+		var all []*ads.Ad
+		for i, p := range []int{5, 4, 3, 2, 1, 5} {
+			ad := newAd(string(rune('A'+i)), "F", p, 600)
+			q.EnqueueWithTime(ad, base.Add(time.Duration(i)*time.Minute))
+			all = append(all, ad)
+		}
+		return q, all
+	}
+
+	q, all := fill()
+	q.SetTotalPriority(3, RemapClamp)
+	if got := []int{all[0].Priority, all[1].Priority, all[2].Priority, all[3].Priority, all[4].Priority}; got[0] != 3 || got[1] != 3 || got[2] != 3 || got[3] != 2 || got[4] != 1 {
+		t.Fatalf("clamp priorities = %v, want [3 3 3 2 1]", got)
+	}
+	if got := peekIDs(q, 4); got[0] != "A" || got[1] != "B" || got[2] != "C" || got[3] != "F" {
+		t.Fatalf("clamp order = %v, want A B C F first", got)
+	}
+
+	q, all = fill()
+	q.SetTotalPriority(3, RemapProportional)
+	if got := []int{all[0].Priority, all[1].Priority, all[2].Priority, all[3].Priority, all[4].Priority}; got[0] != 3 || got[1] != 3 || got[2] != 2 || got[3] != 2 || got[4] != 1 {
+		t.Fatalf("proportional priorities = %v, want [3 3 2 2 1]", got)
+	}
+	dist, total := q.DistributionByPriority()
+	if len(dist) != 3 || total != 6 {
+		t.Fatalf("distribution after shrink = %+v total=%d", dist, total)
+	}
+
+	q.SetTotalPriority(6, RemapClamp)
+	q.EnqueueWithTime(newAd("NEW", "F", 6, 600), time.Now())
+	if got := peekIDs(q, 1); got[0] != "NEW" {
+		t.Fatalf("new top tier not served first: %v", got)
+	}
+}
+
+// === 17) Named priority classes: resolution, defaults, capacity and names ===
+func TestPriorityClasses(t *testing.T) {
+	queueConfig := config.Config{
+		TotalPriority:        3,
+		EnableAntiStarvation: true,
+		MaximumWaitSeconds:   600,
+		BTreeDegree:          16,
+		TimeBoost:            2,
+		PriorityClasses: []config.PriorityClass{
+			{Name: "urgent", Level: 3, MaxWaitSeconds: 30, Capacity: 1},
+			{Name: "backfill", Level: 1, MaxWaitSeconds: 3600},
+		},
+	}
+	q := NewFromConfig(queueConfig)
+
+	p, err := q.ResolvePriority("urgent")
+	if err != nil || p != 3 {
+		t.Fatalf("ResolvePriority(urgent) = %d, %v", p, err)
+	}
+	if p, _ := q.ResolvePriority("2"); p != 2 {
+		t.Fatalf("ResolvePriority(2) = %d", p)
+	}
+	if _, err := q.ResolvePriority("nope"); !errors.Is(err, ErrUnknownPriority) {
+		t.Fatalf("ResolvePriority(nope) err = %v", err)
+	}
+
+	u := newAd("U1", "F", 3, 0)
+	if err := q.Enqueue(u); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if u.MaxWaitTime != 30 {
+		t.Fatalf("class default MaxWaitTime = %d, want 30", u.MaxWaitTime)
+	}
+	b := newAd("B1", "F", 1, 0)
+	q.Enqueue(b)
+	if b.MaxWaitTime != 600 {
+		t.Fatalf("class default not capped by maximumWait: %d", b.MaxWaitTime)
+	}
+	if err := q.Enqueue(newAd("U2", "F", 3, 0)); !errors.Is(err, ErrCapacityExceeded) {
+		t.Fatalf("over-capacity enqueue err = %v", err)
+	}
+
+	dist, total := q.DistributionByPriority()
+	if total != 2 || dist[0].Name != "urgent" || dist[1].Name != "" || dist[2].Name != "backfill" {
+		t.Fatalf("distribution names = %+v", dist)
+	}
+	if err := q.SetTotalPriority(2, RemapClamp); !errors.Is(err, ErrClassOutOfRange) {
+		t.Fatalf("shrink below class level err = %v", err)
+	}
+}
+
+// Every path that moves ads between levels leaves ads in place rather than
+// overfill a class, oldest first, and reports the ones it skipped.
+func TestClassCapacityOnMoves(t *testing.T) {
+	q := NewFromConfig(config.Config{
+		TotalPriority:      4,
+		MaximumWaitSeconds: 600,
+		BTreeDegree:        16,
+		PriorityClasses:    []config.PriorityClass{{Name: "urgent", Level: 3, Capacity: 2}},
+	})
+	res, _ := q.Submit(Command{Op: OpEnqueue, Ad: newAd("A", "F", 1, 0)})
+	seqA := res.Seq
+	for _, ad := range []*ads.Ad{newAd("B", "F", 1, 0), newAd("C", "F", 1, 0), newAd("D", "G", 2, 0), newAd("X", "H", 4, 0)} {
+		if err := q.Enqueue(ad); err != nil {
+			t.Fatal(err)
+		}
+	}
+	level := func(id string) int {
+		for item := range q.adIndex[id] {
+			return item.Ad.Priority
+		}
+		return 0
+	}
+
+	res, _ = q.Submit(Command{Op: OpReprioritizeFamily, Family: "F", Priority: 3})
+	if !slices.Equal(res.AdIDs, []string{"A", "B"}) || !slices.Equal(res.Skipped, []string{"C"}) {
+		t.Fatalf("family to a capped level moved %v, skipped %v", res.AdIDs, res.Skipped)
+	}
+
+	dry, _ := q.Submit(Command{Op: OpBoost, Filter: FamilyFilter("G"), Delta: 1, Duration: time.Minute, DryRun: true})
+	info := q.Boost(FamilyFilter("G"), 1, time.Minute)
+	if len(dry.AdIDs) != 0 || !slices.Equal(dry.Skipped, []string{"D"}) || len(info.AdIDs) != 0 || !slices.Equal(info.Skipped, []string{"D"}) || level("D") != 2 {
+		t.Fatalf("boost into a full level: dry run %+v, boost %+v, D at %d", dry, info, level("D"))
+	}
+
+	if err := q.SetTotalPriority(3, RemapClamp); !errors.Is(err, ErrCapacityExceeded) || q.TotalPriority() != 4 || level("X") != 4 {
+		t.Fatalf("shrink overfilling urgent: err %v, %d levels, X at %d", err, q.TotalPriority(), level("X"))
+	}
+
+	// Ads boosted out of urgent cannot return once it has filled up again.
+	down := q.Boost(FamilyFilter("F"), -1, time.Minute)
+	q.Enqueue(newAd("E1", "E", 3, 0))
+	q.Enqueue(newAd("E2", "E", 3, 0))
+	res, _ = q.Submit(Command{Op: OpCancelBoost, BoostID: down.ID})
+	if !res.OK || !slices.Equal(res.Skipped, []string{"A", "B"}) || level("A") != 2 || level("C") != 1 {
+		t.Fatalf("cancel into a full level = %+v, A at %d, C at %d", res, level("A"), level("C"))
+	}
+	res, _ = q.Submit(Command{Op: OpRestorePriorities, Changes: []PriorityChange{{AdID: "A", Seq: seqA, From: 3, To: 2}}})
+	if len(res.AdIDs) != 0 || !slices.Equal(res.Skipped, []string{"A"}) || level("A") != 2 {
+		t.Fatalf("restore into a full level = %+v, A at %d", res, level("A"))
+	}
+
+	dry, _ = q.Submit(Command{Op: OpBulkAdjust, Filter: FamilyFilter("F"), Delta: 1, DryRun: true})
+	res, _ = q.Submit(Command{Op: OpBulkAdjust, Filter: FamilyFilter("F"), Delta: 1})
+	if !slices.Equal(res.AdIDs, []string{"C"}) || !slices.Equal(res.Skipped, []string{"A", "B"}) ||
+		!slices.Equal(dry.AdIDs, res.AdIDs) || !slices.Equal(dry.Skipped, res.Skipped) {
+		t.Fatalf("bulk adjust: dry run %v/%v, real %v/%v", dry.AdIDs, dry.Skipped, res.AdIDs, res.Skipped)
+	}
+}
+
+// === 18) Per-priority aging: min wait, curve shapes, PeekNext agrees with Dequeue ===
+func TestAgingCurvesAndMinWait(t *testing.T) {
+	queueConfig := config.Config{
+		TotalPriority:        3,
+		EnableAntiStarvation: true,
+		MaximumWaitSeconds:   600,
+		BTreeDegree:          16,
+		TimeBoost:            1,
+		PriorityClasses: []config.PriorityClass{
+			{Name: "backfill", Level: 1, MinWaitSeconds: 3600},
+			{Level: 2, Curve: config.CurveExponential, TimeBoost: 1},
+		},
+	}
+	q := NewFromConfig(queueConfig)
+	now := time.Now()
+
+	// Backfill is overdue on maxWaitTime but below its level's minimum wait.
+	q.EnqueueWithTime(newAd("BF", "F", 1, 10), now.Add(-10*time.Minute))
+	// Level 2 waited 4x maxWaitTime: exponential gives 2 + (2^4-1) = 17.
+	q.EnqueueWithTime(newAd("S", "F", 2, 60), now.Add(-4*time.Minute))
+	// Level 3 waited 2x maxWaitTime: linear gives 3 + 2 = 5.
+	q.EnqueueWithTime(newAd("U", "F", 3, 60), now.Add(-2*time.Minute))
+
+	scores := q.HeadScores()
+	if len(scores) != 3 {
+		t.Fatalf("scores = %+v", scores)
+	}
+	byLevel := map[int]HeadScore{}
+	for _, s := range scores {
+		byLevel[s.Priority] = s
+	}
+	if byLevel[1].Eligible {
+		t.Fatalf("backfill aged before minWait: %+v", byLevel[1])
+	}
+	if s := byLevel[2].Score; s < 16.9 || s > 17.1 || !byLevel[2].Selected {
+		t.Fatalf("exponential score = %+v, want ~17 and selected", byLevel[2])
+	}
+	if s := byLevel[3].Score; s < 4.9 || s > 5.1 {
+		t.Fatalf("linear score = %v, want ~5", s)
+	}
+
+	peek := peekIDs(q, 3)
+	deq := takeDequeue(q, 3)
+	want := []string{"S", "U", "BF"}
+	for i := range want {
+		if peek[i] != want[i] || deq[i] != want[i] {
+			t.Fatalf("peek=%v dequeue=%v, want %v", peek, deq, want)
+		}
+	}
+}
+
+// === 19) Pause by family and priority is honoured by Dequeue and PeekNext ===
+func TestPauseResume(t *testing.T) {
+	queueConfig := config.Config{
+		TotalPriority:        3,
+		EnableAntiStarvation: false,
+		MaximumWaitSeconds:   600,
+		BTreeDegree:          16,
+		TimeBoost:            2,
+	}
+	q := NewFromConfig(queueConfig)
+	base := time.Now().Add(-time.Minute)
+
+	q.EnqueueWithTime(newAd("R1", "RPG", 3, 600), base)
+	q.EnqueueWithTime(newAd("S1", "Shooter", 3, 600), base.Add(time.Second))
+	q.EnqueueWithTime(newAd("L1", "RPG", 1, 600), base.Add(2*time.Second))
+
+	q.Pause(PauseScope{Kind: ScopeFamily, Family: "RPG"})
+	if got := peekIDs(q, 3); len(got) != 1 || got[0] != "S1" {
+		t.Fatalf("peek with RPG paused = %v, want [S1]", got)
+	}
+	if got := takeDequeue(q, 3); len(got) != 1 || got[0] != "S1" {
+		t.Fatalf("dequeue with RPG paused = %v, want [S1]", got)
+	}
+
+	q.Resume(PauseScope{Kind: ScopeFamily, Family: "RPG"})
+	q.Pause(PauseScope{Kind: ScopePriority, Priority: 3})
+	if got := peekIDs(q, 3); len(got) != 1 || got[0] != "L1" {
+		t.Fatalf("peek with P3 paused = %v, want [L1]", got)
+	}
+
+	q.Pause(PauseScope{Kind: ScopeAll})
+	if ad := q.Dequeue(); ad != nil {
+		t.Fatalf("dequeue while paused returned %s", ad.AdID)
+	}
+	q.Resume(PauseScope{Kind: ScopeAll})
+	if got := takeDequeue(q, 3); len(got) != 2 || got[0] != "R1" || got[1] != "L1" {
+		t.Fatalf("dequeue after resume = %v, want [R1 L1]", got)
+	}
+}
+
+func itemID(item *QueueItem) string {
+	if item == nil {
+		return "none"
+	}
+	return item.Ad.AdID
+}
+
+// The servable head of each level, kept up as items come and go, matches a
+// walk from the level's front past paused families.
+func TestPauseServableHeads(t *testing.T) {
+	q := NewFromConfig(config.Config{TotalPriority: 3, MaximumWaitSeconds: 600, BTreeDegree: 16})
+	base := time.Now().Add(-time.Hour)
+	families := []string{"RPG", "Puzzle", "Shooter"}
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	check := func(step int) {
+		t.Helper()
+		for _, p := range q.priorities {
+			var want *QueueItem
+			if list := q.queueMap[p]; list != nil {
+				want = q.nextServable(p, list.Front())
+			}
+			if got := q.headOf(p); got != want {
+				t.Fatalf("step %d: level %d head = %s, want %s", step, p, itemID(got), itemID(want))
+			}
+		}
+	}
+	for step := range 2000 {
+		family := families[rng.IntN(len(families))]
+		switch rng.IntN(8) {
+		case 0, 1, 2:
+			at := base.Add(time.Duration(rng.IntN(3600)) * time.Second)
+			q.EnqueueWithTime(newAd(fmt.Sprint("ad", step), family, 1+rng.IntN(3), 600), at)
+		case 3:
+			q.Dequeue()
+		case 4:
+			q.Pause(PauseScope{Kind: ScopeFamily, Family: family})
+		case 5:
+			q.Resume(PauseScope{Kind: ScopeFamily, Family: family})
+		case 6:
+			q.BulkAdjustPriority(FamilyFilter(family), rng.IntN(3)-1, false)
+		case 7:
+			if rng.IntN(10) == 0 {
+				q.Resume(PauseScope{Kind: ScopeAll})
+			} else {
+				q.ReprioritizeByGameFamily(family, 1+rng.IntN(3))
+			}
+		}
+		check(step)
+	}
+}
+
+// === 20) Drain rejects enqueues and signals once empty ===
+func TestDrain(t *testing.T) {
+	queueConfig := config.Config{
+		TotalPriority:        3,
+		EnableAntiStarvation: true,
+		MaximumWaitSeconds:   600,
+		BTreeDegree:          16,
+		TimeBoost:            2,
+	}
+	q := NewFromConfig(queueConfig)
+	q.Enqueue(newAd("A", "F", 2, 600))
+
+	q.SetDraining(true)
+	if err := q.Enqueue(newAd("B", "F", 2, 600)); !errors.Is(err, ErrDraining) {
+		t.Fatalf("enqueue while draining err = %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- q.WaitDrained(context.Background()) }()
+	select {
+	case <-done:
+		t.Fatalf("WaitDrained returned before the queue was empty")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	q.Dequeue()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("WaitDrained: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("WaitDrained did not return after the queue emptied")
+	}
+
+	// Turning drain off wakes waiters with an error and later calls fail fast.
+	q.SetDraining(false)
+	if err := q.WaitDrained(context.Background()); err == nil {
+		t.Fatal("WaitDrained with drain off: nil error")
+	}
+	q.Enqueue(newAd("C", "F", 2, 600))
+	q.SetDraining(true)
+	go func() { done <- q.WaitDrained(context.Background()) }()
+	time.Sleep(10 * time.Millisecond)
+	q.SetDraining(false)
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("WaitDrained when drain was turned off: nil error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("WaitDrained still blocked after drain was turned off")
+	}
+}
+
+// === 21) Purge exports first and removes nothing if the export fails ===
+func TestPurge_ExportBeforeRemove(t *testing.T) {
+	queueConfig := config.Config{
+		TotalPriority:        3,
+		EnableAntiStarvation: true,
+		MaximumWaitSeconds:   600,
+		BTreeDegree:          16,
+		TimeBoost:            2,
+	}
+	q := NewFromConfig(queueConfig)
+	now := time.Now()
+	q.EnqueueWithTime(newAd("A", "RPG", 1, 600), now.Add(-20*time.Minute))
+	q.EnqueueWithTime(newAd("B", "RPG", 2, 600), now.Add(-15*time.Minute))
+	q.EnqueueWithTime(newAd("C", "Puzzle", 1, 600), now.Add(-10*time.Minute))
+
+	f := FamilyFilter("RPG")
+	if _, err := q.Purge(f, func([]ItemRecord) error { return errors.New("disk full") }); err == nil {
+		t.Fatalf("Purge succeeded despite export error")
+	}
+	if _, total := q.DistributionByPriority(); total != 3 {
+		t.Fatalf("failed purge removed items, total=%d", total)
+	}
+
+	var buf bytes.Buffer
+	recs, err := q.Purge(f, func(recs []ItemRecord) error { return WriteRecords(&buf, recs) })
+	if err != nil || len(recs) != 2 || recs[0].Ad.AdID != "A" || recs[1].Ad.AdID != "B" {
+		t.Fatalf("Purge = %+v, %v", recs, err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 2 {
+		t.Fatalf("export has %d lines, want 2", lines)
+	}
+	if got := peekIDs(q, 3); len(got) != 1 || got[0] != "C" {
+		t.Fatalf("after purge peek = %v, want [C]", got)
+	}
+	if _, ok := q.gameFamilyIndex["RPG"]; ok {
+		t.Fatalf("family index still holds purged items")
+	}
+}
+
+// === 22) Export/Import round trip reproduces PeekNext order ===
+func TestExportImport_RoundTrip(t *testing.T) {
+	queueConfig := config.Config{
+		TotalPriority:        3,
+		EnableAntiStarvation: true,
+		MaximumWaitSeconds:   600,
+		BTreeDegree:          16,
+		TimeBoost:            2,
+	}
+	src := NewFromConfig(queueConfig)
+	base := time.Now().Add(-30 * time.Minute)
+	for i, p := range []int{1, 3, 2, 3, 1, 2, 3} {
+		at := base.Add(time.Duration(i/2) * time.Minute) // pairs share a timestamp
+		src.EnqueueWithTime(newAd(string(rune('A'+i)), "F", p, 120*(i+1)), at)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteRecords(&buf, src.Export()); err != nil {
+		t.Fatalf("WriteRecords: %v", err)
+	}
+	records, err := ReadRecords(&buf)
+	if err != nil {
+		t.Fatalf("ReadRecords: %v", err)
+	}
+
+	dst := NewFromConfig(queueConfig)
+	res, err := dst.Import(records, ImportOptions{KeepSeq: true})
+	if err != nil || res.Imported != 7 {
+		t.Fatalf("Import = %+v, %v", res, err)
+	}
+	want, got := peekIDs(src, 7), peekIDs(dst, 7)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("imported order %v, want %v", got, want)
+		}
+	}
+
+	res, _ = dst.Import(records, ImportOptions{SkipDuplicates: true})
+	if res.Imported != 0 || res.Skipped != 7 {
+		t.Fatalf("re-import with SkipDuplicates = %+v", res)
+	}
+}
+
+// KeepSeq never reuses the seq of a queued or leased item, whatever its
+// EnqueueAt.
+func TestImport_KeepSeqReassignsTakenSeq(t *testing.T) {
+	clock := NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	q := NewFromConfigWithClock(config.Config{TotalPriority: 3, MaximumWaitSeconds: 600}, clock)
+	q.Enqueue(newAd("L", "F", 1, 0))
+	q.Enqueue(newAd("A", "F", 1, 0))
+	seqL, seqA := q.Export()[0].Seq, q.Export()[1].Seq
+	_, lease := q.DequeueWithLease(time.Minute) // L
+	earlier := clock.Now().Add(-time.Hour)
+	res, err := q.Import([]ItemRecord{
+		{Ad: newAd("B", "F", 1, 0), EnqueueAt: earlier, Seq: seqA},
+		{Ad: newAd("C", "F", 1, 0), EnqueueAt: earlier, Seq: seqL},
+	}, ImportOptions{KeepSeq: true})
+	if err != nil || res.Imported != 2 {
+		t.Fatalf("Import = %+v, %v", res, err)
+	}
+	q.Nack(lease.ID)
+
+	seen := map[int64]string{}
+	for _, rec := range q.Export() {
+		if other, dup := seen[rec.Seq]; dup {
+			t.Fatalf("%s and %s share seq %d", other, rec.Ad.AdID, rec.Seq)
+		}
+		seen[rec.Seq] = rec.Ad.AdID
+	}
+	if len(seen) != 4 || seen[seqA] != "A" || seen[seqL] != "L" {
+		t.Fatalf("seqs after import and nack = %v", seen)
+	}
+}
+
+// Attempts count hand-outs and survive nacks, snapshots and export/import.
+func TestAttempts(t *testing.T) {
+	clock := NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	cfg := config.Config{TotalPriority: 3, MaximumWaitSeconds: 600}
+	q := NewFromConfigWithClock(cfg, clock)
+	q.Enqueue(newAd("A", "F", 1, 0))
+	for i := 0; i < 2; i++ {
+		_, l := q.DequeueWithLease(time.Minute)
+		q.Nack(l.ID)
+	}
+	if recs := q.Export(); len(recs) != 1 || recs[0].Attempts != 2 {
+		t.Fatalf("Export after two nacks = %+v", recs)
+	}
+
+	_, l := q.DequeueWithLease(time.Minute)
+	restored := NewFromConfigWithClock(cfg, clock)
+	restored.Restore(q.Snapshot())
+	restored.Nack(l.ID)
+	recs := restored.Export()
+	if len(recs) != 1 || recs[0].Attempts != 3 {
+		t.Fatalf("Export after restore and nack = %+v", recs)
+	}
+
+	imported := NewFromConfigWithClock(cfg, clock)
+	imported.Import(recs, ImportOptions{})
+	if recs := imported.Export(); len(recs) != 1 || recs[0].Attempts != 3 {
+		t.Fatalf("Export after import = %+v", recs)
+	}
+}
+
+// === 23) Fake clock: aging and age cutoffs follow the injected clock ===
+func TestFakeClock_AntiStarvationWithoutSleeps(t *testing.T) {
+	queueConfig := config.Config{
+		TotalPriority:        3,
+		EnableAntiStarvation: true,
+		MaximumWaitSeconds:   600,
+		BTreeDegree:          16,
+		TimeBoost:            2,
+	}
+	clock := NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	q := NewFromConfigWithClock(queueConfig, clock)
+
+	q.Enqueue(newAd("L", "G", 1, 60))
+	clock.Advance(30 * time.Second)
+	q.Enqueue(newAd("H1", "G", 3, 600))
+	q.Enqueue(newAd("H2", "G", 3, 600))
+
+	// L has waited 30s of its 60s: priority order still wins.
+	if got := takeDequeue(q, 1); got[0] != "H1" {
+		t.Fatalf("before MaxWaitTime got %v, want H1", got)
+	}
+	if got := q.ListWaitingLongerThan(time.Minute); len(got) != 0 {
+		t.Fatalf("nothing has waited a minute yet, got %d ads", len(got))
+	}
+
+	// At 61s L is past its MaxWaitTime and preempts the remaining H2.
+	clock.Advance(31 * time.Second)
+	if got := peekIDs(q, 2); got[0] != "L" || got[1] != "H2" {
+		t.Fatalf("PeekNext after MaxWaitTime = %v, want [L H2]", got)
+	}
+	if got := q.ListWaitingLongerThan(time.Minute); len(got) != 1 || got[0].AdID != "L" {
+		t.Fatalf("ListWaitingLongerThan(1m) = %v, want [L]", got)
+	}
+	q.ReprioritizeByAgeOlderThan(time.Minute, 2)
+	if got := takeDequeue(q, 2); got[0] != "L" || got[1] != "H2" {
+		t.Fatalf("dequeue after MaxWaitTime = %v, want [L H2]", got)
+	}
+}
+
+// === 24) Leases: ack removes, nack and expiry restore the old position ===
+func TestLease_AckNackAndExpiry(t *testing.T) {
+	queueConfig := config.Config{
+		TotalPriority:        3,
+		EnableAntiStarvation: false,
+		MaximumWaitSeconds:   600,
+		BTreeDegree:          16,
+		TimeBoost:            2,
+	}
+	clock := NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	q := NewFromConfigWithClock(queueConfig, clock)
+	for _, id := range []string{"A", "B", "C"} {
+		q.Enqueue(newAd(id, "G", 2, 600))
+		clock.Advance(time.Second)
+	}
+	q.Boost(FamilyFilter("G"), 1, time.Hour)
+
+	a, la := q.DequeueWithLease(time.Minute)
+	b, lb := q.DequeueWithLease(time.Minute)
+	if a.AdID != "A" || b.AdID != "B" || la.ID == lb.ID {
+		t.Fatalf("leased %s/%d and %s/%d", a.AdID, la.ID, b.AdID, lb.ID)
+	}
+	if _, remaining := q.DrainStatus(); remaining != 3 {
+		t.Fatalf("remaining = %d, want 3 counting leased ads", remaining)
+	}
+
+	// Repeating an ack or nack succeeds without effect; the other one fails.
+	if !q.Ack(la.ID) || !q.Ack(la.ID) || q.Nack(la.ID) {
+		t.Fatal("ack should succeed, again on a retry, and block a nack")
+	}
+	// B returns at its unboosted priority, behind the boosted C.
+	if !q.Nack(lb.ID) || !q.Nack(lb.ID) || q.Ack(lb.ID) {
+		t.Fatal("nack should succeed, again on a retry, and block an ack")
+	}
+	if q.Ack(999) {
+		t.Fatal("ack of an unknown lease succeeded")
+	}
+	if got := peekIDs(q, 2); got[0] != "C" || got[1] != "B" {
+		t.Fatalf("after nack PeekNext = %v, want [C B]", got)
+	}
+	if dist, _ := q.DistributionByPriority(); dist[0].Count != 1 || dist[1].Count != 1 {
+		t.Fatalf("distribution after nack = %v, want B at 2 and boosted C at 3", dist)
+	}
+
+	// C's lease runs out; it too comes back unboosted, behind B again.
+	c, lc := q.DequeueWithLease(10 * time.Second)
+	if c.AdID != "C" {
+		t.Fatalf("leased %s, want C", c.AdID)
+	}
+	snap := q.Snapshot()
+	clock.Advance(11 * time.Second)
+	if len(q.Leases()) != 0 {
+		t.Fatalf("lease %d did not expire", lc.ID)
+	}
+	if got := peekIDs(q, 2); got[0] != "B" || got[1] != "C" {
+		t.Fatalf("after expiry PeekNext = %v, want [B C]", got)
+	}
+	if q.Ack(lc.ID) {
+		t.Fatal("ack of an expired lease succeeded")
+	}
+
+	// A restored replica keeps the lease without expiring it.
+	replica := NewFromConfigWithClock(queueConfig, clock)
+	replica.SetReplica(true)
+	replica.Restore(snap)
+	clock.Advance(time.Minute)
+	if got := replica.Leases(); len(got) != 1 || got[0].AdID != "C" {
+		t.Fatalf("replica leases = %v, want C", got)
+	}
+}
+
+// === 25) Stats and PeekScored ===
+func TestStatsAndPeekScored(t *testing.T) {
+	queueConfig := config.Config{
+		TotalPriority:        3,
+		EnableAntiStarvation: true,
+		MaximumWaitSeconds:   600,
+		BTreeDegree:          16,
+		TimeBoost:            2,
+	}
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	q := NewFromConfigWithClock(queueConfig, clock)
+	q.Enqueue(newAd("old", "RPG", 1, 60))
+	clock.Advance(30 * time.Second)
+	q.Enqueue(newAd("top", "Puzzle", 3, 600))
+	q.Enqueue(newAd("mid", "RPG", 2, 600))
+	clock.Advance(60 * time.Second)
+	// "old" is past its 60s deadline: 1 + 2*1.5 beats "top" at 3.
+	if ad := q.Dequeue(); ad.AdID != "old" {
+		t.Fatalf("dequeued %s, want the aged old", ad.AdID)
+	}
+
+	s := q.Stats()
+	if s.Queued != 2 || s.Enqueued != 3 || s.Dequeued != 1 {
+		t.Fatalf("stats = %+v, want 2 queued, 3 enqueued, 1 dequeued", s)
+	}
+	if s.Families["RPG"] != 1 || s.Families["Puzzle"] != 1 || s.OldestWaitSeconds != 60 {
+		t.Fatalf("stats = %+v, want one ad per family, oldest 60s", s)
+	}
+
+	next := q.PeekScored(5)
+	if len(next) != 2 || next[0].Ad.AdID != "top" || next[1].Ad.AdID != "mid" {
+		t.Fatalf("PeekScored = %+v, want [top mid]", next)
+	}
+	if a := next[1]; a.Eligible || a.Score != 2 || a.WaitedSeconds != 60 || !a.Deadline.Equal(start.Add(630*time.Second)) {
+		t.Fatalf("mid = %+v, want score 2 after 60s with a deadline at 10m30s", a)
+	}
+}
+
+// === 26) ListAds searches and pages oldest first ===
+func TestListAds(t *testing.T) {
+	queueConfig := config.Config{
+		TotalPriority:      3,
+		MaximumWaitSeconds: 600,
+		BTreeDegree:        16,
+	}
+	clock := NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	q := NewFromConfigWithClock(queueConfig, clock)
+	for i, id := range []string{"dragon-1", "puzzle-1", "dragon-2", "dragon-3"} {
+		family := "RPG-Fantasy"
+		if id == "puzzle-1" {
+			family = "Puzzle"
+		}
+		q.Enqueue(newAd(id, family, 1+i%3, 600))
+		clock.Advance(time.Minute)
+	}
+
+	ids := func(recs []ItemRecord) []string {
+		var out []string
+		for _, r := range recs {
+			out = append(out, r.Ad.AdID)
+		}
+		return out
+	}
+	all, _ := ParseFilter("")
+	if recs, total := q.ListAds(all, "DRAGON", 1, 1); total != 3 || !slices.Equal(ids(recs), []string{"dragon-2"}) {
+		t.Fatalf("ListAds(DRAGON, 1, 1) = %v, %d; want [dragon-2] of 3", ids(recs), total)
+	}
+	if recs, total := q.ListAds(all, "fantasy", 0, 10); total != 3 || len(recs) != 3 {
+		t.Fatalf("search by family matched %v, %d; want 3", ids(recs), total)
+	}
+	f, err := ParseFilter("priority >= 2 and age > 2m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recs, total := q.ListAds(f, "", 0, 10); total != 1 || !slices.Equal(ids(recs), []string{"puzzle-1"}) {
+		t.Fatalf("filtered ListAds = %v, %d; want [puzzle-1]", ids(recs), total)
+	}
+	if recs, total := q.ListAds(all, "", 10, 10); total != 4 || len(recs) != 0 {
+		t.Fatalf("past the end = %v, %d; want none of 4", ids(recs), total)
+	}
+}
+
+// === 27) Reprioritize results record old priorities; RestorePriorities undoes them ===
+func TestRestorePriorities(t *testing.T) {
+	queueConfig := config.Config{
+		TotalPriority:      3,
+		MaximumWaitSeconds: 600,
+		BTreeDegree:        16,
+	}
+	clock := NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	q := NewFromConfigWithClock(queueConfig, clock)
+	q.Enqueue(newAd("r1", "RPG", 1, 600))
+	q.Enqueue(newAd("r2", "RPG", 2, 600))
+	q.Enqueue(newAd("p1", "Puzzle", 1, 600))
+
+	res, err := q.Submit(Command{Op: OpReprioritizeFamily, Family: "RPG", Priority: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PriorityChange{{AdID: "r1", Seq: 1, From: 1, To: 3}, {AdID: "r2", Seq: 2, From: 2, To: 3}}
+	if !slices.Equal(res.Changes, want) {
+		t.Fatalf("changes = %+v, want %+v", res.Changes, want)
+	}
+
+	// A dequeued item is not restored, not even when an ad with its ID is
+	// queued again.
+	if ad := q.Dequeue(); ad == nil || ad.AdID != "r1" {
+		t.Fatalf("dequeued %+v, want r1", ad)
+	}
+	q.Enqueue(newAd("r1", "RPG", 3, 600))
+	q.Enqueue(newAd("r3", "RPG", 1, 600))
+	more, _ := q.Submit(Command{Op: OpBulkAdjust, Filter: FamilyFilter("RPG"), Delta: 1})
+	if got := q.RestorePriorities(more.Changes); !slices.Equal(got, []string{"r3"}) {
+		t.Fatalf("restored %v, want [r3]", got)
+	}
+	if got := q.RestorePriorities(res.Changes); !slices.Equal(got, []string{"r2"}) {
+		t.Fatalf("restored %v, want [r2]", got)
+	}
+	if dist, _ := q.DistributionByPriority(); dist[0].Count != 1 || dist[1].Count != 1 || dist[2].Count != 2 {
+		t.Fatalf("distribution = %+v, want the new r1 at 3, r2 at 2, p1 and r3 at 1", dist)
+	}
+}
+
+// A command over its limit changes nothing; within it, it runs.
+func TestCommandLimit(t *testing.T) {
+	q := New(3, false, 600, 16, 1, nil)
+	q.Enqueue(newAd("r1", "RPG", 1, 600))
+	q.Enqueue(newAd("r2", "RPG", 1, 600))
+	q.Enqueue(newAd("p1", "Puzzle", 1, 600))
+
+	// Confirmed for one RPG ad, but another arrived since.
+	_, err := q.Submit(Command{Op: OpReprioritizeFamily, Family: "RPG", Priority: 3, Limit: &Limit{MaxItems: 1}})
+	var le *LimitError
+	if !errors.Is(err, ErrOverLimit) || !errors.As(err, &le) || le.Count != 2 || le.Total != 3 {
+		t.Fatalf("over limit = %v, want 2 of 3 over the limit", err)
+	}
+	if dist, _ := q.DistributionByPriority(); dist[2].Count != 3 {
+		t.Fatalf("refused command moved ads: %+v", dist)
+	}
+	if _, err := q.Submit(Command{Op: OpPurge, Filter: FamilyFilter("RPG"), Limit: &Limit{MaxPercent: 50}}); !errors.Is(err, ErrOverLimit) {
+		t.Fatalf("purge over limit = %v, want ErrOverLimit", err)
+	}
+	if _, total := q.DistributionByPriority(); total != 3 {
+		t.Fatalf("refused purge removed ads, %d left", total)
+	}
+	if _, err := q.Submit(Command{Op: OpBoost, Filter: FamilyFilter("RPG"), Delta: 1, Duration: time.Minute, Limit: &Limit{MaxItems: 1}}); !errors.Is(err, ErrOverLimit) {
+		t.Fatalf("boost over limit = %v, want ErrOverLimit", err)
+	}
+	if len(q.ActiveBoosts()) != 0 {
+		t.Fatalf("refused boost is active: %+v", q.ActiveBoosts())
+	}
+
+	res, err := q.Submit(Command{Op: OpBulkRemove, Filter: FamilyFilter("Puzzle"), Limit: &Limit{MaxItems: 1, MaxPercent: 50}})
+	if err != nil || !slices.Equal(res.AdIDs, []string{"p1"}) {
+		t.Fatalf("within limit = %+v, %v; want p1 removed", res.AdIDs, err)
+	}
+}
+
+func TestPositionOf(t *testing.T) {
+	clock := NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	q := NewFromConfigWithClock(config.Config{TotalPriority: 3, MaximumWaitSeconds: 600}, clock)
+	for i, id := range []string{"a", "b", "c"} {
+		q.Enqueue(newAd(id, "F", 1, 0))
+		if i == 0 {
+			q.Enqueue(newAd("high", "G", 3, 0))
+		}
+	}
+	res, err := q.Submit(Command{Op: OpEnqueue, Ad: newAd("big", "F", 9, 10_000)})
+	if err != nil || res.Seq != 5 || res.Ad.Priority != 3 || res.Ad.MaxWaitTime != 600 {
+		t.Fatalf("enqueue = %+v (seq %d), %v; want seq 5, priority 3, max wait 600", res.Ad, res.Seq, err)
+	}
+
+	// Under strict priority the cheap estimate matches: big is behind high.
+	if est, ok := q.EstimatePosition(res.Seq); !ok || est.Position != 2 || est.Seq != 5 || est.Queued != 5 {
+		t.Fatalf("EstimatePosition(big) = %+v, %v", est, ok)
+	}
+	if _, exact, _ := q.PositionOf("big", 0); exact.Position != 2 {
+		t.Fatalf("PositionOf(big) = %+v", exact)
+	}
+
+	// Order is high, big, a, b, c. No dequeues yet, so no estimate.
+	_, pos, ok := q.PositionOf("b", 0)
+	if !ok || pos.Position != 4 || pos.Queued != 5 || pos.DequeueRate != 0 || pos.EstimatedStartAt != nil {
+		t.Fatalf("PositionOf(b) = %+v, %v", pos, ok)
+	}
+
+	// Two dequeues over 10s: 0.2/s, so b (now 2nd) starts in 10s.
+	q.Dequeue()
+	clock.Advance(10 * time.Second)
+	q.Dequeue()
+	_, pos, _ = q.PositionOf("b", 0)
+	if pos.Position != 2 || pos.DequeueRate != 0.2 || !pos.EstimatedStartAt.Equal(clock.Now().Add(10*time.Second)) {
+		t.Fatalf("PositionOf(b) after dequeues = %+v", pos)
+	}
+
+	// Stats totals and the rate survive a snapshot.
+	restored := NewFromConfigWithClock(config.Config{TotalPriority: 3}, clock)
+	restored.Restore(q.Snapshot())
+	if s := restored.Stats(); s.Enqueued != 5 || s.Dequeued != 2 {
+		t.Fatalf("restored Stats = %+v", s)
+	}
+	if _, rpos, _ := restored.PositionOf("b", 0); rpos.DequeueRate != pos.DequeueRate || !rpos.EstimatedStartAt.Equal(*pos.EstimatedStartAt) {
+		t.Fatalf("restored PositionOf(b) = %+v, want %+v", rpos, pos)
+	}
+
+	q.Pause(PauseScope{Kind: ScopeFamily, Family: "F"})
+	if _, pos, ok = q.PositionOf("b", 0); !ok || !pos.Paused || pos.Position != 0 || pos.EstimatedStartAt != nil {
+		t.Fatalf("PositionOf(b) while paused = %+v, %v", pos, ok)
+	}
+	if _, _, ok = q.PositionOf("big", 0); ok {
+		t.Fatal("PositionOf(big) after its dequeue: ok")
+	}
+	if _, _, ok = q.PositionOf("a", 99); ok {
+		t.Fatal("PositionOf(a, wrong seq): ok")
+	}
+
+	// An ad enqueued with an earlier time counts from the head of its level.
+	q.Resume(PauseScope{Kind: ScopeFamily, Family: "F"})
+	early, _ := q.Submit(Command{Op: OpEnqueue, Ad: newAd("early", "F", 1, 0), EnqueueAt: &time.Time{}})
+	if est, _ := q.EstimatePosition(early.Seq); est.Position != 1 {
+		t.Fatalf("EstimatePosition(early) = %+v, want 1", est)
+	}
+}
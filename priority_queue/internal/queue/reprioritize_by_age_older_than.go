@@ -7,24 +7,38 @@ import (
 )
 
 func (q *VideoProcessingQueue) ReprioritizeByAgeOlderThan(age time.Duration, newPriority int) {
-	q.mu.Lock()
-	defer q.mu.Unlock()
+	q.Submit(Command{Op: OpReprioritizeAge, Age: age, Priority: newPriority})
+}
 
+// reprioritizeByAgeOlderThan returns the moves and the AdIDs skipped because
+// the target level is at its class capacity.
+func (q *VideoProcessingQueue) reprioritizeByAgeOlderThan(age time.Duration, newPriority int, now time.Time) ([]PriorityChange, []string) {
 	if q.timeIndex == nil {
-		return
+		return nil, nil
 	}
 
 	targetPriority := q.normalizePriority(newPriority)
 
-	cutoff := time.Now().Add(-age)
+	cutoff := now.Add(-age)
 
-	// Collect first to avoid mutating lists while walking the B-Tree.
+	// Collect first to avoid mutating lists while walking the B-Tree. The
+	// oldest items get the room left at a capped level.
+	room := q.levelRoom()
 	toMove := make([]*QueueItem, 0, 64)
+	var skipped []string
 	q.timeIndex.AscendLessThan(
 		timeIndexItem{when: cutoff, seq: 1 << 62}, // iterate all items older than cutoff
 		func(it btree.Item) bool {
 			ti := it.(timeIndexItem)
-			if ti.item != nil && ti.item.Ad.Priority != targetPriority {
+			if ti.item == nil {
+				return true
+			}
+			if !room.take(ti.item, targetPriority) {
+				skipped = append(skipped, ti.item.Ad.AdID)
+				return true
+			}
+			q.clearBoost(ti.item)
+			if ti.item.Ad.Priority != targetPriority {
 				toMove = append(toMove, ti.item)
 			}
 			return true
@@ -32,13 +46,16 @@ func (q *VideoProcessingQueue) ReprioritizeByAgeOlderThan(age time.Duration, new
 	)
 
 	// Move in ascending enqueue order (preserves global FIFO among moved items).
+	changes := make([]PriorityChange, 0, len(toMove))
 	for _, item := range toMove {
 		src := q.queueMap[item.Ad.Priority]
 		if src == nil || src.Size == 0 {
 			continue
 		}
-		src.Remove(item)
+		changes = append(changes, changeOf(item, targetPriority))
+		q.unlink(item)
 		item.Ad.Priority = targetPriority
 		q.insertIntoPriorityByTime(item, targetPriority)
 	}
+	return changes, skipped
 }
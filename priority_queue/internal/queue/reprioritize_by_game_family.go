@@ -1,23 +1,37 @@
 package queue
 
 func (q *VideoProcessingQueue) ReprioritizeByGameFamily(family string, newPriority int) {
-	q.mu.Lock()
-	defer q.mu.Unlock()
+	q.Submit(Command{Op: OpReprioritizeFamily, Family: family, Priority: newPriority})
+}
 
+// reprioritizeByGameFamily returns the moves and the AdIDs skipped because
+// the target level is at its class capacity.
+func (q *VideoProcessingQueue) reprioritizeByGameFamily(family string, newPriority int) ([]PriorityChange, []string) {
 	targetPriority := q.normalizePriority(newPriority)
 
 	items, found := q.gameFamilyIndex[family]
 	if !found {
-		return
+		return nil, nil
 	}
 
-	// Preserve enqueue order by appending in the order we walk old queues.
-	for item := range items {
+	// Move in ascending enqueue order, so the oldest items get the room
+	// left at a capped level and replicas skip the same items.
+	room := q.levelRoom()
+	var changes []PriorityChange
+	var skipped []string
+	for _, item := range sortedItems(items) {
+		if !room.take(item, targetPriority) {
+			skipped = append(skipped, item.Ad.AdID)
+			continue
+		}
+		q.clearBoost(item)
 		if item.Ad.Priority == targetPriority {
 			continue
 		}
-		q.queueMap[item.Ad.Priority].Remove(item)
+		changes = append(changes, changeOf(item, targetPriority))
+		q.unlink(item)
 		item.Ad.Priority = targetPriority
 		q.insertIntoPriorityByTime(item, targetPriority)
 	}
+	return changes, skipped
 }
@@ -0,0 +1,143 @@
+package queue
+
+import (
+	"icetea/priority_queue/config"
+	"math"
+	"time"
+)
+
+// HeadScore is the anti-starvation view of one priority level's head.
+type HeadScore struct {
+	Priority       int     `json:"priority"`
+	Name           string  `json:"name,omitempty"`
+	AdID           string  `json:"adId"`
+	WaitedSeconds  float64 `json:"waitedSeconds"`
+	MaxWaitSeconds int     `json:"maxWaitSeconds"`
+	Curve          string  `json:"curve"`
+	Eligible       bool    `json:"eligible"` // aging has started
+	Score          float64 `json:"score"`
+	Selected       bool    `json:"selected"` // Dequeue would serve this level next
+}
+
+func (q *VideoProcessingQueue) timeBoostFor(p int) float64 {
+	if c, ok := q.classes[p]; ok && c.TimeBoost > 0 {
+		return c.TimeBoost
+	}
+	return q.timeBoost
+}
+
+// headScore returns p plus the aging boost of head, and whether head has
+// waited long enough to start aging:
+//
+//	linear:      timeBoost * r
+//	exponential: timeBoost * (2^r - 1)
+//	step:        timeBoost * floor(r)
+//
+// where r = waited / maxWaitTime. All curves give timeBoost at r = 1.
+func (q *VideoProcessingQueue) headScore(p int, head *QueueItem, now time.Time) (float64, bool) {
+	class := q.classes[p]
+	waited := now.Sub(head.EnqueueAt)
+	maxWait := time.Duration(head.Ad.MaxWaitTime) * time.Second
+	if waited < maxWait || waited < time.Duration(class.MinWaitSeconds)*time.Second {
+		return float64(p), false
+	}
+	if maxWait <= 0 {
+		maxWait = time.Second
+	}
+	r := waited.Seconds() / maxWait.Seconds()
+	var aging float64
+	switch class.Curve {
+	case config.CurveExponential:
+		aging = math.Exp2(r) - 1
+	case config.CurveStep:
+		aging = math.Floor(r)
+	default:
+		aging = r
+	}
+	return float64(p) + aging*q.timeBoostFor(p), true
+}
+
+// selectLevel picks the level Dequeue serves next. headOf returns the head
+// candidate of a level, or nil when the level has nothing to serve.
+func (q *VideoProcessingQueue) selectLevel(headOf func(p int) *QueueItem, now time.Time) int {
+	return pickLevel(q.priorities, q.enableAntiStarvation.Load(), func(p int) (float64, bool, bool) {
+		head := headOf(p)
+		if head == nil {
+			return 0, false, false
+		}
+		score, eligible := q.headScore(p, head, now)
+		return score, eligible, true
+	})
+}
+
+// pickLevel is the scheduling policy shared by every dequeue path. score
+// reports a level's head score, whether the head is eligible for aging, and
+// whether the level has anything to serve. Without anti-starvation the
+// highest non-empty level wins; with it, the eligible head with the best
+// score wins.
+func pickLevel(priorities []int, antiStarvation bool, score func(p int) (float64, bool, bool)) int {
+	selected := -1
+	bestScore := math.Inf(-1)
+	for _, p := range priorities {
+		s, eligible, ok := score(p)
+		if !ok {
+			continue
+		}
+		if selected == -1 {
+			selected = p
+			if !antiStarvation {
+				break
+			}
+		}
+		if eligible && s > bestScore {
+			bestScore = s
+			selected = p
+		}
+	}
+	return selected
+}
+
+// headOf returns the first servable item of level p.
+func (q *VideoProcessingQueue) headOf(p int) *QueueItem {
+	if q.pausedAll || q.pausedLevels[p] {
+		return nil
+	}
+	return q.servable[p]
+}
+
+// HeadScores reports the current score of every non-empty level's head.
+func (q *VideoProcessingQueue) HeadScores() []HeadScore {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.headScores(q.headOf, q.clock.Now())
+}
+
+// headScores scores the heads headOf returns with q's settings. The caller
+// holds q.mu.
+func (q *VideoProcessingQueue) headScores(headOf func(p int) *QueueItem, now time.Time) []HeadScore {
+	selected := q.selectLevel(headOf, now)
+	out := make([]HeadScore, 0, len(q.priorities))
+	for _, p := range q.priorities {
+		head := headOf(p)
+		if head == nil {
+			continue
+		}
+		score, eligible := q.headScore(p, head, now)
+		curve := q.classes[p].Curve
+		if curve == "" {
+			curve = config.CurveLinear
+		}
+		out = append(out, HeadScore{
+			Priority:       p,
+			Name:           q.classes[p].Name,
+			AdID:           head.Ad.AdID,
+			WaitedSeconds:  now.Sub(head.EnqueueAt).Seconds(),
+			MaxWaitSeconds: head.Ad.MaxWaitTime,
+			Curve:          curve,
+			Eligible:       eligible,
+			Score:          score,
+			Selected:       p == selected,
+		})
+	}
+	return out
+}
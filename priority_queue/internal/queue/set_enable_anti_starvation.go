@@ -1,11 +1,10 @@
 package queue
 
 func (q *VideoProcessingQueue) SetEnableAntiStarvation(enable bool) {
-	q.mu.Lock()
-	q.enableAntiStarvation = enable
-	q.mu.Unlock()
+	q.Submit(Command{Op: OpSetAntiStarvation, Enable: enable})
 }
 
+// IsEnableAntiStarvation reads the setting without taking the queue lock.
 func (q *VideoProcessingQueue) IsEnableAntiStarvation() bool {
-	return q.enableAntiStarvation
+	return q.enableAntiStarvation.Load()
 }
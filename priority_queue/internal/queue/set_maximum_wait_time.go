@@ -1,10 +1,11 @@
 package queue
 
 func (q *VideoProcessingQueue) SetMaximumWaitTime(maxWait int) {
-	q.mu.Lock()
-	defer q.mu.Unlock()
+	q.Submit(Command{Op: OpSetMaximumWait, Value: maxWait})
+}
 
-	q.maximumWaitTime = maxWait
+func (q *VideoProcessingQueue) setMaximumWaitTime(maxWait int) {
+	q.maximumWaitTime.Store(int64(maxWait))
 	for _, queue := range q.queueMap {
 		for item := queue.Head; item != nil; item = item.Next {
 			if item.Ad.MaxWaitTime > maxWait {
@@ -13,3 +14,8 @@ func (q *VideoProcessingQueue) SetMaximumWaitTime(maxWait int) {
 		}
 	}
 }
+
+// MaximumWaitTime reads the cap on MaxWaitTime without taking the queue lock.
+func (q *VideoProcessingQueue) MaximumWaitTime() int {
+	return int(q.maximumWaitTime.Load())
+}
@@ -0,0 +1,114 @@
+package queue
+
+import (
+	"fmt"
+
+	"github.com/google/btree"
+)
+
+// RemapStrategy decides where items land when the number of levels shrinks.
+type RemapStrategy string
+
+const (
+	// RemapClamp moves items above the new top level onto the top level.
+	RemapClamp RemapStrategy = "clamp"
+	// RemapProportional scales every level onto the new range, keeping
+	// relative ordering between levels (p -> ceil(p*new/old)).
+	RemapProportional RemapStrategy = "proportional"
+)
+
+// SetTotalPriority grows or shrinks the number of priority levels at runtime.
+// Growing adds new top levels and leaves items where they are. Shrinking
+// remaps items using strategy; moved items keep their FIFO position.
+// Shrinking below the level of a named class, or so that a class would hold
+// more items than its capacity, is rejected.
+func (q *VideoProcessingQueue) SetTotalPriority(total int, strategy RemapStrategy) error {
+	_, err := q.Submit(Command{Op: OpSetTotalPriority, Value: total, Strategy: strategy})
+	return err
+}
+
+// checkTotalPriority reports why resizing to total with strategy would be
+// rejected, if it would be. The caller holds q.mu.
+func (q *VideoProcessingQueue) checkTotalPriority(total int, strategy RemapStrategy) error {
+	if total <= 0 || total == int(q.totalPriority.Load()) {
+		return nil
+	}
+	for level, c := range q.classes {
+		if level > total {
+			return fmt.Errorf("%w: %s is level %d", ErrClassOutOfRange, c.Name, level)
+		}
+	}
+	remap := q.remapTo(total, strategy)
+	sizes := make(map[int]int)
+	for p, list := range q.queueMap {
+		sizes[remap(p)] += list.Size
+	}
+	for level, c := range q.classes {
+		// A level already over capacity may stay so, but must not grow.
+		if current := q.queueMap[level]; c.Capacity > 0 && sizes[level] > c.Capacity &&
+			(current == nil || sizes[level] > current.Size) {
+			return fmt.Errorf("%w: %s would hold %d of %d", ErrCapacityExceeded, c.Name, sizes[level], c.Capacity)
+		}
+	}
+	return nil
+}
+
+// remapTo returns where an item at level p lands after resizing to total.
+func (q *VideoProcessingQueue) remapTo(total int, strategy RemapStrategy) func(int) int {
+	old := int(q.totalPriority.Load())
+	return func(p int) int {
+		if total >= old {
+			return p
+		}
+		if strategy == RemapProportional {
+			p = (p*total + old - 1) / old
+		}
+		if p > total {
+			p = total
+		}
+		if p < 1 {
+			p = 1
+		}
+		return p
+	}
+}
+
+func (q *VideoProcessingQueue) setTotalPriority(total int, strategy RemapStrategy) error {
+	if total <= 0 || total == int(q.totalPriority.Load()) {
+		return nil
+	}
+	if err := q.checkTotalPriority(total, strategy); err != nil {
+		return err
+	}
+	remap := q.remapTo(total, strategy)
+
+	// Walk in ascending enqueue order so moved items stay stable.
+	toMove := make([]*QueueItem, 0, 64)
+	q.timeIndex.Ascend(func(it btree.Item) bool {
+		toMove = append(toMove, it.(timeIndexItem).item)
+		return true
+	})
+	for _, item := range toMove {
+		if item.boost != nil {
+			item.origPriority = remap(item.origPriority)
+		}
+		q.moveToPriority(item, remap(item.Ad.Priority))
+	}
+
+	q.totalPriority.Store(int64(total))
+	q.priorities = make([]int, total)
+	for i := 0; i < total; i++ {
+		q.priorities[i] = total - i // Descending
+	}
+	for p := range q.queueMap {
+		if p > total {
+			delete(q.queueMap, p)
+		}
+	}
+	return nil
+}
+
+// TotalPriority returns the current number of priority levels.
+func (q *VideoProcessingQueue) TotalPriority() int {
+	return int(q.totalPriority.Load())
+}
@@ -0,0 +1,446 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"icetea/priority_queue/config"
+	"icetea/priority_queue/internal/ads"
+	"slices"
+	"sort"
+	"time"
+)
+
+// virtualNodes is the number of ring points per shard; more points spread
+// families more evenly.
+const virtualNodes = 128
+
+// ShardedQueue partitions ads across independent queues by GameFamily, so
+// operations on different families do not contend on one lock. Dequeue and
+// PeekNext still follow the global scheduling policy: the shards' level heads
+// are merged as if they were one queue.
+//
+// Enqueue, dequeue, ack and nack touch one shard. Every other command is
+// applied to all shards with all of them locked, so it is atomic across the
+// queue; settings are applied to every shard. Class capacity is enforced per
+// shard.
+//
+// Seqs and lease IDs are per shard. Outside the queue, shard i's id n is
+// n*shards+i, which keeps them unique and leaves them as they are with one
+// shard. Boost IDs match on every shard, since boosts are created on all of
+// them at once.
+type ShardedQueue struct {
+	shards []*VideoProcessingQueue
+	ring   []ringPoint // sorted by hash
+	clock  Clock
+}
+
+type ringPoint struct {
+	hash  uint32
+	shard int
+}
+
+// NewSharded creates n shards configured from cfg.
+func NewSharded(cfg config.Config, n int) *ShardedQueue {
+	return NewShardedWithClock(cfg, n, RealClock{})
+}
+
+// NewShardedWithClock creates n shards that share clock.
+func NewShardedWithClock(cfg config.Config, n int, clock Clock) *ShardedQueue {
+	if n <= 0 {
+		n = 1
+	}
+	if clock == nil {
+		clock = RealClock{}
+	}
+	s := &ShardedQueue{shards: make([]*VideoProcessingQueue, n), clock: clock}
+	for i := range s.shards {
+		s.shards[i] = NewFromConfigWithClock(cfg, clock)
+		for v := 0; v < virtualNodes; v++ {
+			s.ring = append(s.ring, ringPoint{hash: hash32(fmt.Sprintf("shard-%d-%d", i, v)), shard: i})
+		}
+	}
+	sort.Slice(s.ring, func(i, j int) bool { return s.ring[i].hash < s.ring[j].hash })
+	return s
+}
+
+// hash32 is FNV-1a with a murmur3 finalizer; plain FNV clusters on keys that
+// differ only in their last characters, such as "family-1" and "family-2".
+func hash32(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
+
+// ShardFor returns the index of the shard that holds family: the first ring
+// point at or after the family's hash.
+func (s *ShardedQueue) ShardFor(family string) int {
+	h := hash32(family)
+	i := sort.Search(len(s.ring), func(i int) bool { return s.ring[i].hash >= h })
+	if i == len(s.ring) {
+		i = 0
+	}
+	return s.ring[i].shard
+}
+
+// Shard returns shard i, e.g. for per-shard stats.
+func (s *ShardedQueue) Shard(i int) *VideoProcessingQueue { return s.shards[i] }
+
+func (s *ShardedQueue) Shards() int { return len(s.shards) }
+
+func (s *ShardedQueue) shardOf(family string) *VideoProcessingQueue {
+	return s.shards[s.ShardFor(family)]
+}
+
+// globalID is shard's seq or lease id as seen outside the queue.
+func (s *ShardedQueue) globalID(shard int, id int64) int64 {
+	return id*int64(len(s.shards)) + int64(shard)
+}
+
+// localID is the inverse of globalID. Ids below 1 belong to no item and map
+// to shard 0 unchanged.
+func (s *ShardedQueue) localID(id int64) (shard int, local int64) {
+	if id <= 0 {
+		return 0, id
+	}
+	n := int64(len(s.shards))
+	return int(id % n), id / n
+}
+
+// globalRecords rewrites the seqs of shard's records in place.
+func (s *ShardedQueue) globalRecords(shard int, recs []ItemRecord) []ItemRecord {
+	for i := range recs {
+		recs[i].Seq = s.globalID(shard, recs[i].Seq)
+	}
+	return recs
+}
+
+// lock write-locks every shard, in order, and returns the unlock.
+func (s *ShardedQueue) lock() func() {
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+	}
+	return func() {
+		for _, shard := range s.shards {
+			shard.mu.Unlock()
+		}
+	}
+}
+
+// rlock read-locks every shard, in order, for a consistent view across them,
+// and returns the unlock.
+func (s *ShardedQueue) rlock() func() {
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+	}
+	return func() {
+		for _, shard := range s.shards {
+			shard.mu.RUnlock()
+		}
+	}
+}
+
+func (s *ShardedQueue) Now() time.Time { return s.clock.Now() }
+
+// Submit runs a mutation on the shards it concerns, translating seqs and
+// lease IDs.
+func (s *ShardedQueue) Submit(cmd Command) (Result, error) {
+	switch cmd.Op {
+	case OpEnqueue:
+		if cmd.Ad == nil {
+			return Result{}, errors.New("enqueue without ad")
+		}
+		i := s.ShardFor(cmd.Ad.GameFamily)
+		res, err := s.shards[i].Submit(cmd)
+		res.Seq = s.globalID(i, res.Seq)
+		return res, err
+	case OpDequeue:
+		return s.dequeue(cmd.At, cmd.Duration)
+	case OpAck, OpNack:
+		i, id := s.localID(cmd.LeaseID)
+		cmd.LeaseID = id
+		return s.shards[i].Submit(cmd)
+	}
+	return s.applyAll(cmd)
+}
+
+// applyAll applies cmd to every shard with all of them locked. A limit counts
+// the whole queue, and a purge exports everything it removes in one call.
+// Shards share their settings, so a command one shard refuses the first shard
+// refuses, before anything has changed. Class capacity is per shard, so a
+// resize is checked on every shard first.
+func (s *ShardedQueue) applyAll(cmd Command) (Result, error) {
+	if cmd.At.IsZero() {
+		cmd.At = s.clock.Now()
+	}
+	unlock := s.lock()
+	defer unlock()
+
+	cmds := make([]Command, len(s.shards))
+	for i := range s.shards {
+		cmds[i] = s.forShard(i, cmd)
+	}
+	if cmd.Limit != nil && !cmd.DryRun {
+		var count, total int
+		for i, shard := range s.shards {
+			n, err := shard.affected(cmds[i])
+			if err != nil {
+				return Result{}, err
+			}
+			count += n
+			total += shard.timeIndex.Len()
+		}
+		if cmd.Limit.Exceeded(count, total) {
+			return Result{}, &LimitError{Count: count, Total: total}
+		}
+	}
+	if cmd.Op == OpSetTotalPriority {
+		for _, shard := range s.shards {
+			if err := shard.checkTotalPriority(cmd.Value, cmd.Strategy); err != nil {
+				return Result{}, err
+			}
+		}
+	}
+	if cmd.Op == OpPurge && !cmd.DryRun && cmd.Export != nil {
+		// Export once, then have each shard purge exactly what was exported.
+		var all []ItemRecord
+		for i, shard := range s.shards {
+			recs, _ := shard.purge(cmds[i].Filter, cmds[i].Records, nil, true, cmd.At)
+			cmds[i].Records, cmds[i].Export = recs, nil
+			all = append(all, s.globalRecords(i, slices.Clone(recs))...)
+		}
+		sortRecords(all)
+		if err := cmd.Export(all); err != nil {
+			return Result{}, err
+		}
+	}
+
+	var res Result
+	for i, shard := range s.shards {
+		r, err := shard.applyLocked(cmds[i])
+		if err != nil {
+			return res, err
+		}
+		res.AdIDs = append(res.AdIDs, r.AdIDs...)
+		for _, c := range r.Changes {
+			c.Seq = s.globalID(i, c.Seq)
+			res.Changes = append(res.Changes, c)
+		}
+		res.Records = append(res.Records, s.globalRecords(i, r.Records)...)
+		res.Skipped = append(res.Skipped, r.Skipped...)
+		ids := append(res.Boost.AdIDs, r.Boost.AdIDs...)
+		skipped := append(res.Boost.Skipped, r.Boost.Skipped...)
+		res.Boost = r.Boost
+		res.Boost.AdIDs, res.Boost.Skipped = ids, skipped
+		res.Import.Imported += r.Import.Imported
+		res.Import.Skipped += r.Import.Skipped
+		res.Import.Rejected += r.Import.Rejected
+		res.OK = res.OK || r.OK
+	}
+	sortRecords(res.Records)
+	return res, nil
+}
+
+// forShard is the part of cmd that concerns shard i: the items it lists that
+// live there, with their seqs translated.
+func (s *ShardedQueue) forShard(i int, cmd Command) Command {
+	switch cmd.Op {
+	case OpRestorePriorities:
+		var changes []PriorityChange
+		for _, c := range cmd.Changes {
+			if shard, seq := s.localID(c.Seq); shard == i && seq > 0 {
+				c.Seq = seq
+				changes = append(changes, c)
+			}
+		}
+		cmd.Changes = changes
+	case OpPurge:
+		if cmd.Records != nil {
+			only := []ItemRecord{}
+			for _, r := range cmd.Records {
+				if shard, seq := s.localID(r.Seq); shard == i {
+					r.Seq = seq
+					only = append(only, r)
+				}
+			}
+			cmd.Records = only
+		}
+	case OpImport:
+		// Records go to their family's shard. A kept seq must also belong
+		// to that shard; any other is reassigned.
+		var recs []ItemRecord
+		for _, r := range cmd.Records {
+			if r.Ad == nil {
+				if i == 0 {
+					recs = append(recs, r) // rejected once
+				}
+				continue
+			}
+			if s.ShardFor(r.Ad.GameFamily) != i {
+				continue
+			}
+			if shard, seq := s.localID(r.Seq); shard == i && seq > 0 {
+				r.Seq = seq
+			} else {
+				r.Seq = 0
+			}
+			recs = append(recs, r)
+		}
+		cmd.Records = recs
+	}
+	return cmd
+}
+
+// sortRecords puts records from several shards in (EnqueueAt, seq) order.
+func sortRecords(recs []ItemRecord) {
+	slices.SortStableFunc(recs, func(a, b ItemRecord) int {
+		if c := a.EnqueueAt.Compare(b.EnqueueAt); c != 0 {
+			return c
+		}
+		return int(a.Seq - b.Seq)
+	})
+}
+
+func (s *ShardedQueue) Enqueue(ad *ads.Ad) error {
+	return s.shardOf(ad.GameFamily).Enqueue(ad)
+}
+
+func (s *ShardedQueue) EnqueueWithTime(ad *ads.Ad, enqueuedAt time.Time) error {
+	return s.shardOf(ad.GameFamily).EnqueueWithTime(ad, enqueuedAt)
+}
+
+// shardHead is the servable head of one level in one shard. A zero seq means
+// the level has nothing to serve.
+type shardHead struct {
+	shard    int
+	seq      int64
+	at       time.Time
+	score    float64
+	eligible bool
+}
+
+// mergeHeads scores the servable head of every level at now and keeps it in
+// best, indexed by level, where it is older than the head already there. It
+// returns best, grown if q has more levels.
+func (q *VideoProcessingQueue) mergeHeads(now time.Time, shard int, best []shardHead) (priorities []int, antiStarvation bool, _ []shardHead) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	for _, p := range q.priorities {
+		head := q.headOf(p)
+		if head == nil {
+			continue
+		}
+		if p >= len(best) {
+			best = append(best, make([]shardHead, p+1-len(best))...)
+		}
+		if cur := best[p]; cur.seq == 0 || head.EnqueueAt.Before(cur.at) {
+			score, eligible := q.headScore(p, head, now)
+			best[p] = shardHead{shard: shard, seq: head.seq, at: head.EnqueueAt, score: score, eligible: eligible}
+		}
+	}
+	return q.priorities, q.enableAntiStarvation.Load(), best
+}
+
+// Dequeue removes the ad a single queue holding every shard's ads would
+// serve next.
+func (s *ShardedQueue) Dequeue() *ads.Ad {
+	res, _ := s.dequeue(time.Time{}, 0)
+	return res.Ad
+}
+
+// dequeueRetries caps how often dequeue rescans after a concurrent dequeue
+// took the head it chose.
+const dequeueRetries = 8
+
+// dequeue dequeues as of at (now if zero), on lease for leaseFor if that is
+// positive. Shards are scanned without holding each other's locks; if the
+// chosen head was taken in the meantime, the scan is repeated up to
+// dequeueRetries times.
+func (s *ShardedQueue) dequeue(at time.Time, leaseFor time.Duration) (Result, error) {
+	for range dequeueRetries {
+		now := at
+		if now.IsZero() {
+			now = s.clock.Now()
+		}
+		var priorities []int
+		var antiStarvation bool
+		var best []shardHead // oldest head per level
+		for i, shard := range s.shards {
+			var ps []int
+			var anti bool
+			ps, anti, best = shard.mergeHeads(now, i, best)
+			if i == 0 {
+				priorities, antiStarvation = ps, anti
+			}
+		}
+		level := pickLevel(priorities, antiStarvation, func(p int) (float64, bool, bool) {
+			if p >= len(best) || best[p].seq == 0 {
+				return 0, false, false
+			}
+			return best[p].score, best[p].eligible, true
+		})
+		if level == -1 {
+			return Result{}, nil
+		}
+		h := best[level]
+		res, err := s.shards[h.shard].Submit(Command{Op: OpDequeue, At: now, Priority: level, Seq: h.seq, Duration: leaseFor})
+		if err != nil {
+			return Result{}, err
+		}
+		if res.Ad != nil {
+			if res.Lease.ID != 0 {
+				res.Lease.ID = s.globalID(h.shard, res.Lease.ID)
+			}
+			return res, nil
+		}
+	}
+	return Result{}, fmt.Errorf("%w: dequeue lost the head to concurrent dequeues %d times", ErrUnavailable, dequeueRetries)
+}
+
+func (s *ShardedQueue) ReprioritizeByGameFamily(family string, newPriority int) {
+	s.shardOf(family).ReprioritizeByGameFamily(family, newPriority)
+}
+
+// ReprioritizeByAgeOlderThan applies the same cutoff on every shard.
+func (s *ShardedQueue) ReprioritizeByAgeOlderThan(age time.Duration, newPriority int) {
+	s.Submit(Command{Op: OpReprioritizeAge, Age: age, Priority: newPriority})
+}
+
+func (s *ShardedQueue) SetEnableAntiStarvation(enable bool) {
+	s.Submit(Command{Op: OpSetAntiStarvation, Enable: enable})
+}
+
+func (s *ShardedQueue) SetMaximumWaitTime(maxWait int) {
+	s.Submit(Command{Op: OpSetMaximumWait, Value: maxWait})
+}
+
+func (s *ShardedQueue) Pause(scope PauseScope) {
+	s.Submit(Command{Op: OpPause, Scope: &scope})
+}
+
+func (s *ShardedQueue) Resume(scope PauseScope) {
+	s.Submit(Command{Op: OpResume, Scope: &scope})
+}
+
+func (s *ShardedQueue) SetDraining(enable bool) {
+	s.Submit(Command{Op: OpSetDraining, Enable: enable})
+}
+
+// WaitDrained waits for every shard to drain. A drained shard stays empty:
+// it takes no enqueues and has no leases left to nack.
+func (s *ShardedQueue) WaitDrained(ctx context.Context) error {
+	for _, shard := range s.shards {
+		if err := shard.WaitDrained(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
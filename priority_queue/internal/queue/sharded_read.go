@@ -0,0 +1,326 @@
+package queue
+
+import (
+	"icetea/priority_queue/internal/ads"
+	"maps"
+	"slices"
+	"sort"
+	"time"
+)
+
+// walk is VideoProcessingQueue.walk across the shards: fn gets each servable
+// item, with its shard, in the order Dequeue would serve them. Level heads
+// from different shards are served oldest first. The caller holds every
+// shard's lock.
+func (s *ShardedQueue) walk(now time.Time, fn func(shard int, item *QueueItem) bool) {
+	// cursors[i] holds the next servable node of each level in shard i.
+	cursors := make([]map[int]*QueueItem, len(s.shards))
+	for i, shard := range s.shards {
+		cursors[i] = make(map[int]*QueueItem)
+		for _, p := range shard.priorities {
+			if head := shard.headOf(p); head != nil {
+				cursors[i][p] = head
+			}
+		}
+	}
+	// oldest returns the shard whose level-p cursor is oldest, or -1.
+	oldest := func(p int) int {
+		from := -1
+		for i := range cursors {
+			if c := cursors[i][p]; c != nil && (from == -1 || c.EnqueueAt.Before(cursors[from][p].EnqueueAt)) {
+				from = i
+			}
+		}
+		return from
+	}
+	headOf := func(p int) *QueueItem {
+		if i := oldest(p); i != -1 {
+			return cursors[i][p]
+		}
+		return nil
+	}
+
+	// All shards share the policy settings; the first one scores for all.
+	policy := s.shards[0]
+	for {
+		selected := policy.selectLevel(headOf, now)
+		if selected == -1 {
+			return
+		}
+		i := oldest(selected)
+		node := cursors[i][selected]
+		if !fn(i, node) {
+			return
+		}
+		if next := s.shards[i].nextServable(selected, node.Next); next != nil {
+			cursors[i][selected] = next
+		} else {
+			delete(cursors[i], selected)
+		}
+	}
+}
+
+// PeekNext returns the next n ads in the order Dequeue would serve them.
+func (s *ShardedQueue) PeekNext(n int) []*ads.Ad {
+	if n <= 0 {
+		return nil
+	}
+	unlock := s.rlock()
+	defer unlock()
+
+	result := make([]*ads.Ad, 0, n)
+	s.walk(s.clock.Now(), func(_ int, item *QueueItem) bool {
+		result = append(result, item.Ad)
+		return len(result) < n
+	})
+	return result
+}
+
+// PeekScored is PeekNext with each ad's wait, deadline and score.
+func (s *ShardedQueue) PeekScored(n int) []ScoredAd {
+	if n <= 0 {
+		return nil
+	}
+	unlock := s.rlock()
+	defer unlock()
+
+	now := s.clock.Now()
+	out := make([]ScoredAd, 0, n)
+	s.walk(now, func(i int, item *QueueItem) bool {
+		out = append(out, s.shards[i].scored(item, now))
+		return len(out) < n
+	})
+	return out
+}
+
+// HeadScores scores the oldest head of each level across the shards.
+func (s *ShardedQueue) HeadScores() []HeadScore {
+	unlock := s.rlock()
+	defer unlock()
+
+	return s.shards[0].headScores(func(p int) *QueueItem {
+		var head *QueueItem
+		for _, shard := range s.shards {
+			if h := shard.headOf(p); h != nil && (head == nil || h.EnqueueAt.Before(head.EnqueueAt)) {
+				head = h
+			}
+		}
+		return head
+	}, s.clock.Now())
+}
+
+// DistributionByPriority sums the shards' distributions.
+func (s *ShardedQueue) DistributionByPriority() ([]PriorityDist, int) {
+	var dist []PriorityDist
+	total := 0
+	for i, shard := range s.shards {
+		d, t := shard.DistributionByPriority()
+		if i == 0 {
+			dist = d
+		} else {
+			for j := range dist {
+				dist[j].Count += d[j].Count
+			}
+		}
+		total += t
+	}
+	for j := range dist {
+		dist[j].Percent = 0
+		if total > 0 {
+			dist[j].Percent = float64(dist[j].Count) * 100.0 / float64(total)
+		}
+	}
+	return dist, total
+}
+
+// ListWaitingLongerThan merges the shards' results, oldest first.
+func (s *ShardedQueue) ListWaitingLongerThan(age time.Duration) []*ads.Ad {
+	cutoff := s.clock.Now().Add(-age)
+	var items []*QueueItem
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		items = append(items, shard.enqueuedBefore(cutoff)...)
+		shard.mu.RUnlock()
+	}
+	slices.SortStableFunc(items, func(a, b *QueueItem) int { return a.EnqueueAt.Compare(b.EnqueueAt) })
+	out := make([]*ads.Ad, len(items))
+	for i, item := range items {
+		out[i] = item.Ad
+	}
+	return out
+}
+
+// ListAds pages through the matching ads of every shard, oldest first.
+func (s *ShardedQueue) ListAds(f Filter, search string, offset, limit int) ([]ItemRecord, int) {
+	var all []ItemRecord
+	for i, shard := range s.shards {
+		recs, _ := shard.ListAds(f, search, 0, int(^uint(0)>>1))
+		all = append(all, s.globalRecords(i, recs)...)
+	}
+	sortRecords(all)
+	total := len(all)
+	all = all[min(max(offset, 0), total):]
+	return all[:min(max(limit, 0), len(all))], total
+}
+
+// Export returns every shard's items in (EnqueueAt, seq) order.
+func (s *ShardedQueue) Export() []ItemRecord {
+	var all []ItemRecord
+	for i, shard := range s.shards {
+		all = append(all, s.globalRecords(i, shard.Export())...)
+	}
+	sortRecords(all)
+	return all
+}
+
+// Stats adds up the shards' stats.
+func (s *ShardedQueue) Stats() Stats {
+	out := Stats{Families: make(map[string]int)}
+	for _, shard := range s.shards {
+		st := shard.Stats()
+		out.Queued += st.Queued
+		out.Leased += st.Leased
+		out.Enqueued += st.Enqueued
+		out.Dequeued += st.Dequeued
+		if st.OldestEnqueueAt != nil && (out.OldestEnqueueAt == nil || st.OldestEnqueueAt.Before(*out.OldestEnqueueAt)) {
+			out.OldestEnqueueAt = st.OldestEnqueueAt
+		}
+		maps.Copy(out.Families, st.Families) // a family lives on one shard
+	}
+	if out.OldestEnqueueAt != nil {
+		out.OldestWaitSeconds = s.clock.Now().Sub(*out.OldestEnqueueAt).Seconds()
+	}
+	return out
+}
+
+// PositionOf is VideoProcessingQueue.PositionOf across the shards.
+func (s *ShardedQueue) PositionOf(adID string, seq int64) (*ads.Ad, Position, bool) {
+	unlock := s.rlock()
+	defer unlock()
+
+	want := func(i int, item *QueueItem) bool {
+		return item.Ad.AdID == adID && (seq == 0 || s.globalID(i, item.seq) == seq)
+	}
+	target, targetShard := (*QueueItem)(nil), -1
+	for i, shard := range s.shards {
+		for item := range shard.adIndex[adID] {
+			if want(i, item) {
+				target, targetShard = item, i
+				break
+			}
+		}
+		if target != nil {
+			break
+		}
+	}
+	if target == nil {
+		return nil, Position{}, false
+	}
+
+	now := s.clock.Now()
+	var pos Position
+	for _, shard := range s.shards {
+		pos.Queued += shard.timeIndex.Len()
+		pos.DequeueRate += shard.dequeueTimes.rate(now)
+	}
+	found, foundShard := (*QueueItem)(nil), -1
+	s.walk(now, func(i int, item *QueueItem) bool {
+		pos.Position++
+		if want(i, item) {
+			found, foundShard = item, i
+		}
+		return found == nil
+	})
+	if found == nil {
+		found, foundShard, pos.Position, pos.Paused = target, targetShard, 0, true
+	}
+	pos.finish(found, now)
+	pos.Seq = s.globalID(foundShard, found.seq)
+	return found.Ad, pos, true
+}
+
+// EstimatePosition is VideoProcessingQueue.EstimatePosition across the
+// shards. Items at the ad's level on other shards all count as ahead of it,
+// which is exact for an ad just enqueued.
+func (s *ShardedQueue) EstimatePosition(seq int64) (Position, bool) {
+	i, local := s.localID(seq)
+	unlock := s.rlock()
+	defer unlock()
+
+	item, ok := s.shards[i].bySeq[local]
+	if !ok {
+		return Position{}, false
+	}
+	now := s.clock.Now()
+	pos := s.shards[i].estimate(item, now)
+	pos.Seq = seq
+	for j, other := range s.shards {
+		if j == i {
+			continue
+		}
+		pos.Queued += other.timeIndex.Len()
+		pos.DequeueRate += other.dequeueTimes.rate(now)
+		if pos.Paused {
+			continue
+		}
+		for level, list := range other.queueMap {
+			if level >= item.Ad.Priority {
+				pos.Position += list.Size
+			}
+		}
+	}
+	pos.estimateStart(now)
+	return pos, true
+}
+
+// Leases lists every shard's outstanding leases.
+func (s *ShardedQueue) Leases() []LeaseInfo {
+	var out []LeaseInfo
+	for i, shard := range s.shards {
+		for _, l := range shard.Leases() {
+			l.ID = s.globalID(i, l.ID)
+			out = append(out, l)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// ActiveBoosts joins each boost's items from every shard.
+func (s *ShardedQueue) ActiveBoosts() []BoostInfo {
+	at := make(map[int64]int) // boost ID -> index in out
+	var out []BoostInfo
+	for _, shard := range s.shards {
+		for _, b := range shard.ActiveBoosts() {
+			if i, ok := at[b.ID]; ok {
+				out[i].AdIDs = append(out[i].AdIDs, b.AdIDs...)
+				continue
+			}
+			at[b.ID] = len(out)
+			out = append(out, b)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Settings are the same on every shard.
+
+func (s *ShardedQueue) Paused() PauseState           { return s.shards[0].Paused() }
+func (s *ShardedQueue) IsEnableAntiStarvation() bool { return s.shards[0].IsEnableAntiStarvation() }
+func (s *ShardedQueue) MaximumWaitTime() int         { return s.shards[0].MaximumWaitTime() }
+func (s *ShardedQueue) TotalPriority() int           { return s.shards[0].TotalPriority() }
+
+func (s *ShardedQueue) ResolvePriority(name string) (int, error) {
+	return s.shards[0].ResolvePriority(name)
+}
+
+// DrainStatus reports drain mode and what is left on every shard.
+func (s *ShardedQueue) DrainStatus() (draining bool, remaining int) {
+	for _, shard := range s.shards {
+		d, r := shard.DrainStatus()
+		draining = draining || d
+		remaining += r
+	}
+	return draining, remaining
+}
@@ -0,0 +1,202 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"icetea/priority_queue/config"
+	"icetea/priority_queue/internal/ads"
+)
+
+var shardedConfig = config.Config{
+	TotalPriority:        3,
+	EnableAntiStarvation: true,
+	MaximumWaitSeconds:   600,
+	BTreeDegree:          16,
+	TimeBoost:            2,
+}
+
+func idsOf(list []*ads.Ad) []string {
+	ids := make([]string, 0, len(list))
+	for _, ad := range list {
+		ids = append(ids, ad.AdID)
+	}
+	return ids
+}
+
+// fill enqueues the same ads into every queue: 12 families across 3 levels,
+// backdated so some low-priority ads are past their MaxWaitTime.
+func fill(t *testing.T, enqueue ...func(id, family string, prio, maxWait int, at time.Time) error) {
+	t.Helper()
+	base := time.Now().Add(-time.Hour)
+	for i := 0; i < 60; i++ {
+		id := fmt.Sprintf("A%02d", i)
+		family := fmt.Sprintf("F%d", i%12)
+		prio := i%3 + 1
+		maxWait := 600
+		if prio == 1 && i < 20 {
+			maxWait = 60 // long overdue: anti-starvation serves these early
+		}
+		for _, fn := range enqueue {
+			if err := fn(id, family, prio, maxWait, base.Add(time.Duration(i)*time.Second)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func newPair(t *testing.T, shards int) (*VideoProcessingQueue, *ShardedQueue) {
+	q := NewFromConfig(shardedConfig)
+	s := NewSharded(shardedConfig, shards)
+	fill(t,
+		func(id, family string, prio, maxWait int, at time.Time) error {
+			return q.EnqueueWithTime(newAd(id, family, prio, maxWait), at)
+		},
+		func(id, family string, prio, maxWait int, at time.Time) error {
+			return s.EnqueueWithTime(newAd(id, family, prio, maxWait), at)
+		},
+	)
+	return q, s
+}
+
+func TestShardedQueue_FamilyStaysOnOneShard(t *testing.T) {
+	s := NewSharded(shardedConfig, 8)
+	used := make(map[int]bool)
+	for i := 0; i < 200; i++ {
+		family := fmt.Sprintf("family-%d", i)
+		shard := s.ShardFor(family)
+		if s.ShardFor(family) != shard {
+			t.Fatalf("%s moved between shards", family)
+		}
+		used[shard] = true
+	}
+	if len(used) != 8 {
+		t.Fatalf("200 families landed on %d of 8 shards", len(used))
+	}
+}
+
+func TestShardedQueue_MatchesSingleQueue(t *testing.T) {
+	for _, anti := range []bool{true, false} {
+		t.Run(fmt.Sprintf("antiStarvation=%v", anti), func(t *testing.T) {
+			q, s := newPair(t, 4)
+			q.SetEnableAntiStarvation(anti)
+			s.SetEnableAntiStarvation(anti)
+
+			q.ReprioritizeByGameFamily("F4", 3)
+			s.ReprioritizeByGameFamily("F4", 3)
+			q.ReprioritizeByAgeOlderThan(3590*time.Second, 2)
+			s.ReprioritizeByAgeOlderThan(3590*time.Second, 2)
+			q.Pause(PauseScope{Kind: ScopeFamily, Family: "F7"})
+			s.Pause(PauseScope{Kind: ScopeFamily, Family: "F7"})
+
+			wantDist, wantTotal := q.DistributionByPriority()
+			gotDist, gotTotal := s.DistributionByPriority()
+			if gotTotal != wantTotal || !slices.Equal(gotDist, wantDist) {
+				t.Fatalf("distribution: got %v/%d, want %v/%d", gotDist, gotTotal, wantDist, wantTotal)
+			}
+			wantWaiting := idsOf(q.ListWaitingLongerThan(3570 * time.Second))
+			if got := idsOf(s.ListWaitingLongerThan(3570 * time.Second)); !slices.Equal(got, wantWaiting) {
+				t.Fatalf("waiting: got %v, want %v", got, wantWaiting)
+			}
+
+			want := peekIDs(q, 100)
+			if got := idsOf(s.PeekNext(100)); !slices.Equal(got, want) {
+				t.Fatalf("peek:\n got %v\nwant %v", got, want)
+			}
+			var got []string
+			for ad := s.Dequeue(); ad != nil; ad = s.Dequeue() {
+				got = append(got, ad.AdID)
+			}
+			if !slices.Equal(got, want) {
+				t.Fatalf("dequeue:\n got %v\nwant %v", got, want)
+			}
+		})
+	}
+}
+
+func TestShardedQueue_ConcurrentDequeueDeliversOnce(t *testing.T) {
+	_, s := newPair(t, 4)
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				_ = s.Enqueue(newAd(fmt.Sprintf("W%d-%d", w, i), fmt.Sprintf("F%d", i%5), i%3+1, 600))
+				if ad := s.Dequeue(); ad != nil {
+					mu.Lock()
+					seen[ad.AdID]++
+					mu.Unlock()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	for ad := s.Dequeue(); ad != nil; ad = s.Dequeue() {
+		seen[ad.AdID]++
+	}
+	if len(seen) != 60+8*20 {
+		t.Fatalf("delivered %d distinct ads, want %d", len(seen), 60+8*20)
+	}
+	for id, n := range seen {
+		if n != 1 {
+			t.Fatalf("%s delivered %d times", id, n)
+		}
+	}
+}
+
+func TestShardedQueue_DequeueReturnsShardError(t *testing.T) {
+	_, s := newPair(t, 4)
+	down := errors.New("shard down")
+	for _, shard := range s.shards {
+		shard.SetProposer(func(Command) (Result, error) { return Result{}, down })
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Submit(Command{Op: OpDequeue})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, down) {
+			t.Fatalf("dequeue error = %v, want %v", err, down)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("dequeue kept retrying a failing shard")
+	}
+}
+
+// BenchmarkShardedQueue measures lock contention. Each op enqueues one ad and
+// dequeues one, so the queue stays at its starting 10,000 ads over 64
+// families and the per-op work does not grow with b.N. Run with -cpu to see
+// throughput grow with the shard count, e.g. -cpu 1,4,8.
+func BenchmarkShardedQueue(b *testing.B) {
+	families := make([]string, 64)
+	for i := range families {
+		families[i] = fmt.Sprintf("F%d", i)
+	}
+	for _, shards := range []int{1, 2, 4, 8, 16} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			s := NewSharded(shardedConfig, shards)
+			for i := 0; i < 10000; i++ {
+				_ = s.Enqueue(newAd(fmt.Sprintf("P%d", i), families[i%64], i%3+1, 600))
+			}
+			var workers atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				w := int(workers.Add(1))
+				for i := 0; pb.Next(); i++ {
+					_ = s.Enqueue(newAd("B", families[(w*7+i)%64], i%3+1, 600))
+					s.Dequeue()
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package queue
+
+import (
+	"time"
+
+	"github.com/google/btree"
+)
+
+// Snapshot is the full replicable state of a queue. Priority classes and the
+// B-tree degree come from configuration and are not included.
+type Snapshot struct {
+	Index                uint64          `json:"index"` // Applied() at snapshot time
+	TotalPriority        int             `json:"totalPriority"`
+	EnableAntiStarvation bool            `json:"enableAntiStarvation"`
+	MaximumWaitTime      int             `json:"maximumWaitTime"`
+	NextSeq              int64           `json:"nextSeq"`
+	NextBoostID          int64           `json:"nextBoostId"`
+	NextLeaseID          int64           `json:"nextLeaseId,omitempty"`
+	Paused               PauseState      `json:"paused"`
+	Draining             bool            `json:"draining"`
+	Records              []ItemRecord    `json:"records"`
+	Boosts               []BoostSnapshot `json:"boosts"`
+	Leases               []LeaseSnapshot `json:"leases,omitempty"`
+	EndedLeases          []EndedLease    `json:"endedLeases,omitempty"`
+	// Enqueued and Dequeued are the Stats totals, and RecentDequeues the
+	// times PositionOf takes the dequeue rate from.
+	Enqueued       uint64      `json:"enqueued,omitempty"`
+	Dequeued       uint64      `json:"dequeued,omitempty"`
+	RecentDequeues []time.Time `json:"recentDequeues,omitempty"`
+}
+
+// LeaseSnapshot is an outstanding lease with the item it returns on nack.
+type LeaseSnapshot struct {
+	ID        int64      `json:"id"`
+	Record    ItemRecord `json:"record"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+}
+
+// EndedLease is a recently ended lease and whether it was nacked or expired
+// rather than acked.
+type EndedLease struct {
+	ID       int64 `json:"id"`
+	Requeued bool  `json:"requeued,omitempty"`
+}
+
+// BoostSnapshot is an active boost with its members' original priorities,
+// keyed by item seq.
+type BoostSnapshot struct {
+	ID        int64         `json:"id"`
+	Filter    Filter        `json:"filter"`
+	Delta     int           `json:"delta"`
+	ExpiresAt time.Time     `json:"expiresAt"`
+	Items     map[int64]int `json:"items"`
+}
+
+// Snapshot captures the queue state.
+func (q *VideoProcessingQueue) Snapshot() Snapshot {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	s := Snapshot{
+		Index:                q.applied,
+		TotalPriority:        int(q.totalPriority.Load()),
+		EnableAntiStarvation: q.enableAntiStarvation.Load(),
+		MaximumWaitTime:      int(q.maximumWaitTime.Load()),
+		NextSeq:              q.nextSeq,
+		NextBoostID:          q.nextBoostID,
+		NextLeaseID:          q.nextLeaseID,
+		Paused:               PauseState{All: q.pausedAll},
+		Draining:             q.draining,
+		Records:              make([]ItemRecord, 0, q.timeIndex.Len()),
+		Enqueued:             q.enqueued,
+		Dequeued:             q.dequeued,
+		RecentDequeues:       q.dequeueTimes.list(),
+		EndedLeases:          q.endedLeases.list(),
+	}
+	for p := range q.pausedLevels {
+		s.Paused.Priorities = append(s.Paused.Priorities, p)
+	}
+	for f := range q.pausedFamilies {
+		s.Paused.Families = append(s.Paused.Families, f)
+	}
+	q.timeIndex.Ascend(func(it btree.Item) bool {
+		s.Records = append(s.Records, it.(timeIndexItem).item.record())
+		return true
+	})
+	for _, b := range q.boosts {
+		bs := BoostSnapshot{ID: b.id, Filter: b.filter, Delta: b.delta, ExpiresAt: b.expiresAt, Items: make(map[int64]int, len(b.items))}
+		for item := range b.items {
+			bs.Items[item.seq] = item.origPriority
+		}
+		s.Boosts = append(s.Boosts, bs)
+	}
+	for _, l := range q.leases {
+		s.Leases = append(s.Leases, LeaseSnapshot{ID: l.id, Record: l.rec, ExpiresAt: l.expiresAt})
+	}
+	return s
+}
+
+// Restore replaces the queue state with s. The command hook and replica mode
+// are kept.
+func (q *VideoProcessingQueue) Restore(s Snapshot) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, b := range q.boosts {
+		if b.timer != nil {
+			b.timer.Stop()
+		}
+	}
+	for _, l := range q.leases {
+		if l.timer != nil {
+			l.timer.Stop()
+		}
+	}
+	q.queueMap = make(map[int]*DList)
+	q.servable = make(map[int]*QueueItem)
+	q.gameFamilyIndex = make(map[string]map[*QueueItem]struct{})
+	q.adIndex = make(map[string]map[*QueueItem]struct{})
+	q.bySeq = make(map[int64]*QueueItem)
+	q.timeIndex.Clear(false)
+	q.boosts = make(map[int64]*boost)
+	q.leases = make(map[int64]*lease)
+	q.endedLeases = endedLeases{}
+
+	q.applied = s.Index
+	q.totalPriority.Store(int64(s.TotalPriority))
+	q.priorities = make([]int, s.TotalPriority)
+	for i := 0; i < s.TotalPriority; i++ {
+		q.priorities[i] = s.TotalPriority - i // Descending
+	}
+	q.enableAntiStarvation.Store(s.EnableAntiStarvation)
+	q.maximumWaitTime.Store(int64(s.MaximumWaitTime))
+	q.nextSeq = s.NextSeq
+	q.nextBoostID = s.NextBoostID
+	q.nextLeaseID = s.NextLeaseID
+	q.enqueued, q.dequeued = s.Enqueued, s.Dequeued
+	q.dequeueTimes = dequeueTimes{}
+	for _, t := range s.RecentDequeues {
+		q.dequeueTimes.add(t)
+	}
+	q.pausedAll = s.Paused.All
+	q.pausedLevels = make(map[int]bool)
+	for _, p := range s.Paused.Priorities {
+		q.pausedLevels[p] = true
+	}
+	q.pausedFamilies = make(map[string]bool)
+	for _, f := range s.Paused.Families {
+		q.pausedFamilies[f] = true
+	}
+	q.draining = s.Draining
+	q.stopDrain()
+	if s.Draining {
+		q.drained, q.drainStopped = make(chan struct{}), make(chan struct{})
+	}
+
+	for _, rec := range s.Records {
+		item := itemOf(rec, rec.Seq)
+		q.insertIntoPriorityByTime(item, item.Ad.Priority)
+		q.addToIndices(item)
+	}
+
+	now := q.clock.Now()
+	for _, bs := range s.Boosts {
+		b := &boost{id: bs.ID, filter: bs.Filter, delta: bs.Delta, expiresAt: bs.ExpiresAt, items: make(map[*QueueItem]struct{})}
+		for seq, orig := range bs.Items {
+			if item, ok := q.bySeq[seq]; ok {
+				item.boost = b
+				item.origPriority = orig
+				b.items[item] = struct{}{}
+			}
+		}
+		q.boosts[b.id] = b
+		q.armBoost(b, now)
+	}
+	for _, e := range s.EndedLeases {
+		q.endedLeases.add(e.ID, e.Requeued)
+	}
+	for _, ls := range s.Leases {
+		l := &lease{id: ls.ID, rec: ls.Record, expiresAt: ls.ExpiresAt}
+		q.leases[l.id] = l
+		q.armLease(l, now)
+	}
+	q.checkDrained()
+}
@@ -0,0 +1,83 @@
+package queue
+
+import (
+	"icetea/priority_queue/internal/ads"
+	"time"
+)
+
+// Stats summarizes the queue for dashboards.
+type Stats struct {
+	Queued int `json:"queued"`
+	Leased int `json:"leased"`
+	// Enqueued and Dequeued count successful operations since the queue was
+	// created; rates come from the difference between two calls.
+	Enqueued          uint64         `json:"enqueued"`
+	Dequeued          uint64         `json:"dequeued"`
+	OldestEnqueueAt   *time.Time     `json:"oldestEnqueueAt,omitempty"`
+	OldestWaitSeconds float64        `json:"oldestWaitSeconds"`
+	Families          map[string]int `json:"families"` // family -> queued ads
+}
+
+func (q *VideoProcessingQueue) Stats() Stats {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	s := Stats{
+		Queued:   q.timeIndex.Len(),
+		Leased:   len(q.leases),
+		Enqueued: q.enqueued,
+		Dequeued: q.dequeued,
+		Families: make(map[string]int, len(q.gameFamilyIndex)),
+	}
+	if oldest := q.timeIndex.Min(); oldest != nil {
+		at := oldest.(timeIndexItem).when
+		s.OldestEnqueueAt = &at
+		s.OldestWaitSeconds = q.clock.Now().Sub(at).Seconds()
+	}
+	for family, items := range q.gameFamilyIndex {
+		if len(items) > 0 {
+			s.Families[family] = len(items)
+		}
+	}
+	return s
+}
+
+// ScoredAd is a queued ad with its scheduling state, as listed by PeekScored.
+type ScoredAd struct {
+	Ad            *ads.Ad   `json:"ad"`
+	EnqueueAt     time.Time `json:"enqueueAt"`
+	WaitedSeconds float64   `json:"waitedSeconds"`
+	Deadline      time.Time `json:"deadline"` // EnqueueAt + MaxWaitTime
+	Eligible      bool      `json:"eligible"` // aging has started
+	Score         float64   `json:"score"`    // its anti-starvation score were it the head of its level
+}
+
+// PeekScored is PeekNext with each ad's wait, deadline and score.
+func (q *VideoProcessingQueue) PeekScored(n int) []ScoredAd {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if n <= 0 {
+		return nil
+	}
+	now := q.clock.Now()
+	items := q.peekItems(n, now)
+	out := make([]ScoredAd, len(items))
+	for i, item := range items {
+		out[i] = q.scored(item, now)
+	}
+	return out
+}
+
+// scored is item as PeekScored lists it. The caller holds q.mu.
+func (q *VideoProcessingQueue) scored(item *QueueItem, now time.Time) ScoredAd {
+	score, eligible := q.headScore(item.Ad.Priority, item, now)
+	return ScoredAd{
+		Ad:            item.Ad,
+		EnqueueAt:     item.EnqueueAt,
+		WaitedSeconds: now.Sub(item.EnqueueAt).Seconds(),
+		Deadline:      item.EnqueueAt.Add(time.Duration(item.Ad.MaxWaitTime) * time.Second),
+		Eligible:      eligible,
+		Score:         score,
+	}
+}
@@ -0,0 +1,57 @@
+package queue
+
+// PriorityChange records one item moved by a reprioritize, so the move can
+// be undone.
+type PriorityChange struct {
+	AdID string `json:"adId"`
+	Seq  int64  `json:"seq"`
+	From int    `json:"from"`
+	To   int    `json:"to"`
+}
+
+func changeOf(item *QueueItem, to int) PriorityChange {
+	return PriorityChange{AdID: item.Ad.AdID, Seq: item.seq, From: item.Ad.Priority, To: to}
+}
+
+func changedAdIDs(changes []PriorityChange) []string {
+	ids := make([]string, 0, len(changes))
+	for _, c := range changes {
+		ids = append(ids, c.AdID)
+	}
+	return ids
+}
+
+// RestorePriorities moves the items of changes back to their From priority
+// and returns the AdIDs it moved. Items that have left the queue or have
+// been moved again since are skipped, as are items whose From level is at
+// its class capacity.
+func (q *VideoProcessingQueue) RestorePriorities(changes []PriorityChange) []string {
+	res, _ := q.Submit(Command{Op: OpRestorePriorities, Changes: changes})
+	return res.AdIDs
+}
+
+// restorePriorities returns the AdIDs moved back and those left in place
+// because their From level is full.
+func (q *VideoProcessingQueue) restorePriorities(changes []PriorityChange, dryRun bool) ([]string, []string) {
+	room := q.levelRoom()
+	ids := make([]string, 0, len(changes))
+	var skipped []string
+	for _, c := range changes {
+		for item := range q.adIndex[c.AdID] {
+			if item.seq != c.Seq || item.Ad.Priority != c.To {
+				continue
+			}
+			from := q.normalizePriority(c.From)
+			if !room.take(item, from) {
+				skipped = append(skipped, c.AdID)
+				continue
+			}
+			ids = append(ids, c.AdID)
+			if !dryRun {
+				q.clearBoost(item)
+				q.moveToPriority(item, from)
+			}
+		}
+	}
+	return ids, skipped
+}
@@ -0,0 +1,751 @@
+// Package raft implements the Raft consensus algorithm: leader election, log
+// replication, snapshots and single-server membership changes. A
+// StateMachine receives committed entries in the same order on every node.
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotLeader = errors.New("raft: not the leader")
+	ErrNoLeader  = errors.New("raft: no leader available")
+	// ErrLeadershipLost means the entry was appended but the leader stepped
+	// down before it committed. It may still commit under the next leader.
+	ErrLeadershipLost      = errors.New("raft: leadership lost, outcome unknown")
+	ErrStopped             = errors.New("raft: node stopped")
+	ErrConfigChangePending = errors.New("raft: membership change already in progress")
+	ErrUnknownServer       = errors.New("raft: unknown server")
+	ErrServerExists        = errors.New("raft: server already a member")
+)
+
+type Role string
+
+const (
+	Follower  Role = "follower"
+	Candidate Role = "candidate"
+	Leader    Role = "leader"
+)
+
+// Server is a cluster member. Addr is what the Transport dials.
+type Server struct {
+	ID   string `json:"id"`
+	Addr string `json:"addr"`
+}
+
+type EntryType uint8
+
+const (
+	EntryCommand EntryType = iota
+	EntryConfig            // Data is the JSON list of servers
+	EntryNoop              // appended by new leaders and barriers
+)
+
+type Entry struct {
+	Index uint64    `json:"index"`
+	Term  uint64    `json:"term"`
+	Type  EntryType `json:"type,omitempty"`
+	Data  []byte    `json:"data,omitempty"`
+}
+
+// Snapshot is the state machine as of Index, with the membership at that
+// point.
+type Snapshot struct {
+	Index   uint64   `json:"index"`
+	Term    uint64   `json:"term"`
+	Servers []Server `json:"servers"`
+	Data    []byte   `json:"data"`
+}
+
+// StateMachine is the replicated application. Apply must be deterministic;
+// its result is returned to whoever proposed the entry.
+type StateMachine interface {
+	Apply(data []byte) []byte
+	Snapshot() ([]byte, error)
+	Restore(data []byte) error
+}
+
+type Config struct {
+	ID           string
+	Transport    Transport
+	Storage      Storage // defaults to a MemoryStorage
+	StateMachine StateMachine
+
+	// A follower that hears nothing from a leader for ElectionTimeout (plus
+	// up to the same again, randomized) starts an election.
+	ElectionTimeout   time.Duration // default 300ms
+	HeartbeatInterval time.Duration // default 50ms
+	// SnapshotThreshold is the number of applied entries after which the log
+	// is compacted into a snapshot. Default 10000.
+	SnapshotThreshold uint64
+	MaxAppendEntries  int // default 256
+
+	// OnLeaderChange is called whenever this node gains or loses leadership.
+	OnLeaderChange func(isLeader bool)
+}
+
+type waiter struct {
+	term uint64
+	ch   chan applyResult
+}
+
+type applyResult struct {
+	data []byte
+	err  error
+}
+
+// Node is one Raft member.
+type Node struct {
+	cfg       Config
+	id        string
+	transport Transport
+	storage   Storage
+	fsm       StateMachine
+
+	mu          sync.Mutex
+	applyMu     sync.Mutex // serializes state machine access
+	applyCond   *sync.Cond
+	role        Role
+	term        uint64
+	votedFor    string
+	leaderID    string
+	leaderSeen  time.Time // last AppendEntries from the current leader
+	electionAt  time.Time
+	log         []Entry // log[0] is a sentinel holding the snapshot index and term
+	snapshot    Snapshot
+	commitIndex uint64
+	lastApplied uint64
+	servers     []Server // latest configuration in the log, committed or not
+	configIndex uint64
+
+	// leader state
+	nextIndex   map[string]uint64
+	matchIndex  map[string]uint64
+	lastAck     map[string]time.Time
+	replicators map[string]chan struct{}
+	leaderAt    time.Time
+	pending     map[uint64]*waiter
+
+	roleChanged chan struct{}
+	stopCh      chan struct{}
+	stopped     bool
+	wg          sync.WaitGroup
+}
+
+// NewNode loads persisted state and restores the state machine from the
+// latest snapshot. Call Bootstrap on a brand-new cluster, then Start.
+func NewNode(cfg Config) (*Node, error) {
+	if cfg.Storage == nil {
+		cfg.Storage = NewMemoryStorage()
+	}
+	if cfg.ElectionTimeout <= 0 {
+		cfg.ElectionTimeout = 300 * time.Millisecond
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = 50 * time.Millisecond
+	}
+	if cfg.SnapshotThreshold == 0 {
+		cfg.SnapshotThreshold = 10000
+	}
+	if cfg.MaxAppendEntries <= 0 {
+		cfg.MaxAppendEntries = 256
+	}
+
+	st, err := cfg.Storage.Load()
+	if err != nil {
+		return nil, err
+	}
+	n := &Node{
+		cfg:         cfg,
+		id:          cfg.ID,
+		transport:   cfg.Transport,
+		storage:     cfg.Storage,
+		fsm:         cfg.StateMachine,
+		role:        Follower,
+		term:        st.Term,
+		votedFor:    st.VotedFor,
+		log:         []Entry{{}},
+		pending:     make(map[uint64]*waiter),
+		replicators: make(map[string]chan struct{}),
+		roleChanged: make(chan struct{}, 1),
+		stopCh:      make(chan struct{}),
+	}
+	n.applyCond = sync.NewCond(&n.mu)
+	if st.Snapshot != nil {
+		if err := n.fsm.Restore(st.Snapshot.Data); err != nil {
+			return nil, err
+		}
+		n.snapshot = *st.Snapshot
+		n.log[0] = Entry{Index: st.Snapshot.Index, Term: st.Snapshot.Term}
+		n.commitIndex = st.Snapshot.Index
+		n.lastApplied = st.Snapshot.Index
+	}
+	n.log = append(n.log, st.Entries...)
+	n.servers, n.configIndex = n.latestConfig()
+	return n, nil
+}
+
+// Bootstrap writes the initial configuration. Every founding member must be
+// bootstrapped with the same servers. It does nothing on a node that already
+// has state, so it is safe to call on every start.
+func (n *Node) Bootstrap(servers []Server) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.term > 0 || n.lastIndex() > 0 {
+		return nil
+	}
+	data, err := json.Marshal(servers)
+	if err != nil {
+		return err
+	}
+	n.term = 1
+	n.persistHardState()
+	n.appendEntries([]Entry{{Index: 1, Term: 1, Type: EntryConfig, Data: data}})
+	return nil
+}
+
+// Start runs the election timer and applies committed entries.
+func (n *Node) Start() {
+	n.mu.Lock()
+	n.resetElectionTimer()
+	n.mu.Unlock()
+
+	n.wg.Add(3)
+	go n.tickLoop()
+	go n.applyLoop()
+	go n.notifyLoop()
+}
+
+// Stop shuts the node down. Pending proposals fail with ErrStopped.
+func (n *Node) Stop() {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return
+	}
+	n.stopped = true
+	close(n.stopCh)
+	n.stopReplicators()
+	n.failWaiters(ErrStopped)
+	n.applyCond.Broadcast()
+	n.mu.Unlock()
+	n.wg.Wait()
+}
+
+// Apply replicates data and returns the state machine's result once the entry
+// has been applied on the leader. Followers forward to the leader; while there
+// is no leader Apply retries until ctx is done.
+func (n *Node) Apply(ctx context.Context, data []byte) ([]byte, error) {
+	return n.submit(ctx, &ForwardRequest{Kind: forwardApply, Data: data})
+}
+
+// Barrier returns once every entry committed before the call has been
+// applied on this node, so local reads that follow are linearizable.
+func (n *Node) Barrier(ctx context.Context) error {
+	data, err := n.submit(ctx, &ForwardRequest{Kind: forwardBarrier})
+	if err != nil {
+		return err
+	}
+	index, err := strconv.ParseUint(string(data), 10, 64)
+	if err != nil {
+		return err
+	}
+	for {
+		n.mu.Lock()
+		applied, stopped := n.lastApplied, n.stopped
+		n.mu.Unlock()
+		switch {
+		case applied >= index:
+			return nil
+		case stopped:
+			return ErrStopped
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// AddServer adds a voting member. The new node must be started without
+// Bootstrap; it receives the log or a snapshot from the leader.
+func (n *Node) AddServer(ctx context.Context, s Server) error {
+	_, err := n.submit(ctx, &ForwardRequest{Kind: forwardAddServer, Server: &s})
+	return err
+}
+
+// RemoveServer removes a member. A removed leader steps down once the change
+// commits.
+func (n *Node) RemoveServer(ctx context.Context, id string) error {
+	_, err := n.submit(ctx, &ForwardRequest{Kind: forwardRemoveServer, Server: &Server{ID: id}})
+	return err
+}
+
+func (n *Node) submit(ctx context.Context, req *ForwardRequest) ([]byte, error) {
+	for {
+		data, err := n.handleLocal(ctx, req)
+		if !errors.Is(err, ErrNotLeader) {
+			return data, err
+		}
+		if leader, ok := n.Leader(); ok && leader.ID != n.id {
+			resp, err := n.transport.Forward(ctx, leader, req)
+			if err != nil {
+				// The leader may or may not have received it.
+				return nil, err
+			}
+			if !resp.NotLeader {
+				return resp.Data, forwardedError(resp.Error)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ErrNoLeader
+		case <-n.stopCh:
+			return nil, ErrStopped
+		case <-time.After(n.cfg.HeartbeatInterval):
+		}
+	}
+}
+
+// HandleForward runs a forwarded request if this node is the leader.
+func (n *Node) HandleForward(ctx context.Context, req *ForwardRequest) *ForwardResponse {
+	data, err := n.handleLocal(ctx, req)
+	switch {
+	case errors.Is(err, ErrNotLeader):
+		return &ForwardResponse{NotLeader: true}
+	case err != nil:
+		return &ForwardResponse{Error: err.Error()}
+	}
+	return &ForwardResponse{Data: data}
+}
+
+func forwardedError(msg string) error {
+	if msg == "" {
+		return nil
+	}
+	for _, err := range []error{ErrLeadershipLost, ErrStopped, ErrConfigChangePending, ErrUnknownServer, ErrServerExists, ErrNoLeader} {
+		if msg == err.Error() {
+			return err
+		}
+	}
+	return errors.New(msg)
+}
+
+func (n *Node) handleLocal(ctx context.Context, req *ForwardRequest) ([]byte, error) {
+	switch req.Kind {
+	case forwardApply:
+		return n.propose(ctx, func() (EntryType, []byte, error) { return EntryCommand, req.Data, nil })
+	case forwardBarrier:
+		var index uint64
+		_, err := n.propose(ctx, func() (EntryType, []byte, error) {
+			index = n.lastIndex() + 1
+			return EntryNoop, nil, nil
+		})
+		return []byte(strconv.FormatUint(index, 10)), err
+	case forwardAddServer, forwardRemoveServer:
+		if req.Server == nil {
+			return nil, errors.New("raft: missing server")
+		}
+		return n.propose(ctx, func() (EntryType, []byte, error) {
+			servers, err := n.changedConfig(req.Kind, *req.Server)
+			if err != nil {
+				return 0, nil, err
+			}
+			data, err := json.Marshal(servers)
+			return EntryConfig, data, err
+		})
+	}
+	return nil, errors.New("raft: unknown request " + req.Kind)
+}
+
+// propose appends one entry built by prepare (which runs under the lock) and
+// waits for it to be applied.
+func (n *Node) propose(ctx context.Context, prepare func() (EntryType, []byte, error)) ([]byte, error) {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return nil, ErrStopped
+	}
+	if n.role != Leader {
+		n.mu.Unlock()
+		return nil, ErrNotLeader
+	}
+	typ, data, err := prepare()
+	if err != nil {
+		n.mu.Unlock()
+		return nil, err
+	}
+	e := Entry{Index: n.lastIndex() + 1, Term: n.term, Type: typ, Data: data}
+	w := &waiter{term: e.Term, ch: make(chan applyResult, 1)}
+	n.pending[e.Index] = w
+	n.appendEntries([]Entry{e})
+	n.triggerReplication()
+	n.advanceCommit()
+	n.mu.Unlock()
+
+	select {
+	case r := <-w.ch:
+		return r.data, r.err
+	case <-ctx.Done():
+		n.mu.Lock()
+		delete(n.pending, e.Index)
+		n.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// changedConfig validates a membership change against the current
+// configuration. Only one change may be in flight, and only after the leader
+// has committed an entry of its own term.
+func (n *Node) changedConfig(kind string, s Server) ([]Server, error) {
+	if n.configIndex > n.commitIndex || n.termAt(n.commitIndex) != n.term {
+		return nil, ErrConfigChangePending
+	}
+	servers := make([]Server, 0, len(n.servers)+1)
+	found := false
+	for _, cur := range n.servers {
+		if cur.ID == s.ID {
+			found = true
+			if kind == forwardRemoveServer {
+				continue
+			}
+		}
+		servers = append(servers, cur)
+	}
+	switch {
+	case kind == forwardAddServer && found:
+		return nil, ErrServerExists
+	case kind == forwardAddServer:
+		servers = append(servers, s)
+	case !found:
+		return nil, ErrUnknownServer
+	}
+	return servers, nil
+}
+
+// Status is a point-in-time view of a node.
+type Status struct {
+	ID            string                `json:"id"`
+	Role          Role                  `json:"role"`
+	Term          uint64                `json:"term"`
+	Leader        string                `json:"leader,omitempty"`
+	CommitIndex   uint64                `json:"commitIndex"`
+	AppliedIndex  uint64                `json:"appliedIndex"`
+	LastIndex     uint64                `json:"lastIndex"`
+	SnapshotIndex uint64                `json:"snapshotIndex"`
+	Servers       []Server              `json:"servers"`
+	Peers         map[string]PeerStatus `json:"peers,omitempty"` // leader only
+}
+
+type PeerStatus struct {
+	MatchIndex uint64    `json:"matchIndex"`
+	LastAck    time.Time `json:"lastAck"`
+}
+
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	st := Status{
+		ID:            n.id,
+		Role:          n.role,
+		Term:          n.term,
+		Leader:        n.leaderID,
+		CommitIndex:   n.commitIndex,
+		AppliedIndex:  n.lastApplied,
+		LastIndex:     n.lastIndex(),
+		SnapshotIndex: n.log[0].Index,
+		Servers:       append([]Server(nil), n.servers...),
+	}
+	if n.role == Leader {
+		st.Peers = make(map[string]PeerStatus)
+		for _, s := range n.servers {
+			if s.ID != n.id {
+				st.Peers[s.ID] = PeerStatus{MatchIndex: n.matchIndex[s.ID], LastAck: n.lastAck[s.ID]}
+			}
+		}
+	}
+	return st
+}
+
+func (n *Node) isStopped() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.stopped
+}
+
+func (n *Node) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role == Leader
+}
+
+// Leader returns the current leader as known to this node.
+func (n *Node) Leader() (Server, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.server(n.leaderID)
+}
+
+func (n *Node) server(id string) (Server, bool) {
+	if id == "" {
+		return Server{}, false
+	}
+	for _, s := range n.servers {
+		if s.ID == id {
+			return s, true
+		}
+	}
+	return Server{}, false
+}
+
+func (n *Node) isVoter(id string) bool {
+	_, ok := n.server(id)
+	return ok
+}
+
+func (n *Node) quorum() int { return len(n.servers)/2 + 1 }
+
+// Log helpers. Indices below log[0].Index are compacted into the snapshot.
+
+func (n *Node) firstIndex() uint64 { return n.log[0].Index }
+
+func (n *Node) lastIndex() uint64 { return n.log[len(n.log)-1].Index }
+
+func (n *Node) termAt(index uint64) uint64 {
+	if index < n.firstIndex() || index > n.lastIndex() {
+		return 0
+	}
+	return n.log[index-n.firstIndex()].Term
+}
+
+func (n *Node) entryAt(index uint64) Entry { return n.log[index-n.firstIndex()] }
+
+// latestConfig finds the newest configuration entry in the log, falling back
+// to the snapshot's.
+func (n *Node) latestConfig() ([]Server, uint64) {
+	return n.configAt(n.lastIndex())
+}
+
+func (n *Node) configAt(index uint64) ([]Server, uint64) {
+	for i := index; i > n.firstIndex(); i-- {
+		if e := n.entryAt(i); e.Type == EntryConfig {
+			var servers []Server
+			if err := json.Unmarshal(e.Data, &servers); err != nil {
+				log.Printf("raft: %s: bad config entry %d: %v", n.id, e.Index, err)
+				continue
+			}
+			return servers, e.Index
+		}
+	}
+	return n.snapshot.Servers, n.snapshot.Index
+}
+
+// appendEntries adds entries to the log and storage and picks up any
+// configuration among them.
+func (n *Node) appendEntries(entries []Entry) {
+	n.log = append(n.log, entries...)
+	n.must(n.storage.Append(entries))
+	for _, e := range entries {
+		if e.Type == EntryConfig {
+			n.servers, n.configIndex = n.latestConfig()
+			if n.role == Leader {
+				n.syncReplicators()
+			}
+			break
+		}
+	}
+}
+
+func (n *Node) persistHardState() {
+	n.must(n.storage.SetHardState(n.term, n.votedFor))
+}
+
+// must stops the process on a storage failure: continuing after losing a vote
+// or a log write could break Raft's guarantees.
+func (n *Node) must(err error) {
+	if err != nil {
+		log.Panicf("raft: %s: storage: %v", n.id, err)
+	}
+}
+
+func (n *Node) resetElectionTimer() {
+	et := n.cfg.ElectionTimeout
+	n.electionAt = time.Now().Add(et + time.Duration(rand.Int63n(int64(et))))
+}
+
+func (n *Node) tickLoop() {
+	defer n.wg.Done()
+	tick := n.cfg.HeartbeatInterval / 5
+	if tick <= 0 {
+		tick = time.Millisecond
+	}
+	t := time.NewTicker(tick)
+	defer t.Stop()
+	nextHeartbeat := time.Now()
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case now := <-t.C:
+			n.mu.Lock()
+			switch n.role {
+			case Leader:
+				if !now.Before(nextHeartbeat) {
+					nextHeartbeat = now.Add(n.cfg.HeartbeatInterval)
+					n.triggerReplication()
+				}
+				n.checkQuorum(now)
+			default:
+				if now.After(n.electionAt) {
+					n.startElection()
+				}
+			}
+			n.mu.Unlock()
+		}
+	}
+}
+
+// checkQuorum steps down a leader that has not heard from a majority for an
+// election timeout, so a partitioned leader stops accepting writes.
+func (n *Node) checkQuorum(now time.Time) {
+	if now.Sub(n.leaderAt) < n.cfg.ElectionTimeout {
+		return
+	}
+	acks := 0
+	for _, s := range n.servers {
+		if s.ID == n.id || now.Sub(n.lastAck[s.ID]) < n.cfg.ElectionTimeout {
+			acks++
+		}
+	}
+	if acks < n.quorum() {
+		log.Printf("raft: %s: lost quorum, stepping down in term %d", n.id, n.term)
+		n.becomeFollower(n.term, "")
+	}
+}
+
+func (n *Node) startElection() {
+	n.resetElectionTimer()
+	if !n.isVoter(n.id) {
+		return
+	}
+	n.role = Candidate
+	n.term++
+	n.votedFor = n.id
+	n.leaderID = ""
+	n.persistHardState()
+
+	term := n.term
+	req := &RequestVoteRequest{Term: term, CandidateID: n.id, LastLogIndex: n.lastIndex(), LastLogTerm: n.termAt(n.lastIndex())}
+	votes := 1
+	if votes >= n.quorum() {
+		n.becomeLeader()
+		return
+	}
+	for _, s := range n.servers {
+		if s.ID == n.id {
+			continue
+		}
+		go func(s Server) {
+			ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+			defer cancel()
+			resp, err := n.transport.RequestVote(ctx, s, req)
+			if err != nil {
+				return
+			}
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if n.stopped {
+				return
+			}
+			if resp.Term > n.term {
+				n.becomeFollower(resp.Term, "")
+				return
+			}
+			if n.role != Candidate || n.term != term || !resp.Granted {
+				return
+			}
+			if votes++; votes >= n.quorum() {
+				n.becomeLeader()
+			}
+		}(s)
+	}
+}
+
+func (n *Node) becomeFollower(term uint64, leader string) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		n.persistHardState()
+	}
+	wasLeader := n.role == Leader
+	n.role = Follower
+	n.leaderID = leader
+	if wasLeader {
+		n.stopReplicators()
+		n.failWaiters(ErrLeadershipLost)
+		n.resetElectionTimer()
+		n.signalRoleChange()
+	}
+}
+
+func (n *Node) becomeLeader() {
+	log.Printf("raft: %s: leader for term %d", n.id, n.term)
+	n.role = Leader
+	n.leaderID = n.id
+	n.leaderAt = time.Now()
+	n.nextIndex = make(map[string]uint64)
+	n.matchIndex = make(map[string]uint64)
+	n.lastAck = make(map[string]time.Time)
+	// A no-op in the new term lets earlier entries commit and tells the
+	// leader when its view of the state machine is current.
+	n.appendEntries([]Entry{{Index: n.lastIndex() + 1, Term: n.term, Type: EntryNoop}})
+	n.syncReplicators()
+	n.triggerReplication()
+	n.advanceCommit()
+	n.signalRoleChange()
+}
+
+func (n *Node) failWaiters(err error) {
+	for idx, w := range n.pending {
+		w.ch <- applyResult{err: err}
+		delete(n.pending, idx)
+	}
+}
+
+func (n *Node) signalRoleChange() {
+	select {
+	case n.roleChanged <- struct{}{}:
+	default:
+	}
+}
+
+// notifyLoop reports leadership changes outside the lock, coalescing quick
+// flips into the current state.
+func (n *Node) notifyLoop() {
+	defer n.wg.Done()
+	leader := false
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-n.roleChanged:
+		}
+		n.mu.Lock()
+		now := n.role == Leader
+		n.mu.Unlock()
+		if now != leader {
+			leader = now
+			if n.cfg.OnLeaderChange != nil {
+				n.cfg.OnLeaderChange(leader)
+			}
+		}
+	}
+}
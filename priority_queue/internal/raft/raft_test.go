@@ -0,0 +1,293 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// listFSM records every applied command.
+type listFSM struct {
+	mu    sync.Mutex
+	items []string
+}
+
+func (f *listFSM) Apply(data []byte) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.items = append(f.items, string(data))
+	return []byte(strconv.Itoa(len(f.items)))
+}
+
+func (f *listFSM) Snapshot() ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return json.Marshal(f.items)
+}
+
+func (f *listFSM) Restore(data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.items = nil
+	return json.Unmarshal(data, &f.items)
+}
+
+func (f *listFSM) list() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.items)
+}
+
+type testCluster struct {
+	t        *testing.T
+	net      *Network
+	nodes    map[string]*Node
+	fsms     map[string]*listFSM
+	storages map[string]*MemoryStorage
+	snapshot uint64
+}
+
+func newTestCluster(t *testing.T, size int, snapshotThreshold uint64) *testCluster {
+	c := &testCluster{
+		t:        t,
+		net:      NewNetwork(),
+		nodes:    make(map[string]*Node),
+		fsms:     make(map[string]*listFSM),
+		storages: make(map[string]*MemoryStorage),
+		snapshot: snapshotThreshold,
+	}
+	var servers []Server
+	for i := 1; i <= size; i++ {
+		servers = append(servers, Server{ID: fmt.Sprintf("n%d", i)})
+	}
+	for _, s := range servers {
+		n := c.start(s.ID)
+		if err := n.Bootstrap(servers); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		for _, n := range c.nodes {
+			n.Stop()
+		}
+	})
+	return c
+}
+
+// start creates (or recreates, keeping storage) the node id.
+func (c *testCluster) start(id string) *Node {
+	c.t.Helper()
+	if c.storages[id] == nil {
+		c.storages[id] = NewMemoryStorage()
+	}
+	fsm := &listFSM{}
+	n, err := NewNode(Config{
+		ID:                id,
+		Transport:         c.net.Transport(id),
+		Storage:           c.storages[id],
+		StateMachine:      fsm,
+		ElectionTimeout:   100 * time.Millisecond,
+		HeartbeatInterval: 20 * time.Millisecond,
+		SnapshotThreshold: c.snapshot,
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.nodes[id], c.fsms[id] = n, fsm
+	c.net.Register(id, n)
+	n.Start()
+	return n
+}
+
+// leader waits for exactly one leader among ids.
+func (c *testCluster) leader(ids ...string) string {
+	c.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var leaders []string
+		for _, id := range ids {
+			if c.nodes[id].IsLeader() {
+				leaders = append(leaders, id)
+			}
+		}
+		if len(leaders) == 1 {
+			return leaders[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.t.Fatalf("no single leader among %v", ids)
+	return ""
+}
+
+func (c *testCluster) apply(via, cmd string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err := c.nodes[via].Apply(ctx, []byte(cmd))
+	return err
+}
+
+// converged waits until the FSMs of ids hold want.
+func (c *testCluster) converged(want []string, ids ...string) {
+	c.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, id := range ids {
+		for !slices.Equal(c.fsms[id].list(), want) {
+			if time.Now().After(deadline) {
+				c.t.Fatalf("%s has %v, want %v (%+v)", id, c.fsms[id].list(), want, c.nodes[id].Status())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestRaft_ReplicatesThroughFollowers(t *testing.T) {
+	c := newTestCluster(t, 3, 0)
+	all := []string{"n1", "n2", "n3"}
+	leader := c.leader(all...)
+
+	var want []string
+	for i := 0; i < 10; i++ {
+		via := all[i%3]
+		cmd := fmt.Sprintf("c%d", i)
+		if err := c.apply(via, cmd); err != nil {
+			t.Fatalf("apply via %s: %v", via, err)
+		}
+		want = append(want, cmd)
+	}
+	c.converged(want, all...)
+	if st := c.nodes[leader].Status(); st.CommitIndex != st.LastIndex || len(st.Peers) != 2 {
+		t.Fatalf("leader status: %+v", st)
+	}
+}
+
+func TestRaft_PartitionedLeaderCannotCommit(t *testing.T) {
+	c := newTestCluster(t, 5, 0)
+	all := []string{"n1", "n2", "n3", "n4", "n5"}
+	old := c.leader(all...)
+	if err := c.apply(old, "before"); err != nil {
+		t.Fatal(err)
+	}
+
+	minority := []string{old}
+	var majority []string
+	for _, id := range all {
+		switch {
+		case id == old:
+		case len(minority) < 2:
+			minority = append(minority, id)
+		default:
+			majority = append(majority, id)
+		}
+	}
+	c.net.Partition(minority, majority)
+
+	// The old leader keeps its role for up to an election timeout but can
+	// never commit; the write fails instead of being applied twice later.
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	_, err := c.nodes[old].Apply(ctx, []byte("lost"))
+	cancel()
+	if err == nil {
+		t.Fatal("minority leader committed a write")
+	}
+
+	c.leader(majority...)
+	if err := c.apply(majority[0], "after"); err != nil {
+		t.Fatal(err)
+	}
+	c.converged([]string{"before", "after"}, majority...)
+
+	c.net.Heal()
+	c.leader(all...)
+	c.converged([]string{"before", "after"}, all...)
+}
+
+func TestRaft_MembershipChanges(t *testing.T) {
+	c := newTestCluster(t, 3, 0)
+	leader := c.leader("n1", "n2", "n3")
+	if err := c.apply(leader, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh node joins without bootstrapping and catches up.
+	c.start("n4")
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := c.nodes["n2"].AddServer(ctx, Server{ID: "n4"}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := c.nodes["n3"].AddServer(ctx, Server{ID: "n4"}); err != ErrServerExists {
+		t.Fatalf("duplicate add: %v", err)
+	}
+	if err := c.apply("n4", "b"); err != nil {
+		t.Fatal(err)
+	}
+	c.converged([]string{"a", "b"}, "n1", "n2", "n3", "n4")
+
+	// Removing the leader makes it step down; the rest elect a new one.
+	if err := c.nodes[leader].RemoveServer(ctx, leader); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	var rest []string
+	for _, id := range []string{"n1", "n2", "n3", "n4"} {
+		if id != leader {
+			rest = append(rest, id)
+		}
+	}
+	next := c.leader(rest...)
+	if next == leader {
+		t.Fatal("removed node is still leader")
+	}
+	if err := c.apply(next, "c"); err != nil {
+		t.Fatal(err)
+	}
+	c.converged([]string{"a", "b", "c"}, rest...)
+	if st := c.nodes[next].Status(); len(st.Servers) != 3 {
+		t.Fatalf("servers after removal: %+v", st.Servers)
+	}
+}
+
+func TestRaft_SnapshotCatchUpAndRestart(t *testing.T) {
+	c := newTestCluster(t, 3, 5)
+	all := []string{"n1", "n2", "n3"}
+	leader := c.leader(all...)
+	var lagging string
+	var others []string
+	for _, id := range all {
+		if id != leader && lagging == "" {
+			lagging = id
+		} else {
+			others = append(others, id)
+		}
+	}
+	c.net.Partition(others, []string{lagging})
+
+	var want []string
+	for i := 0; i < 20; i++ {
+		cmd := fmt.Sprintf("c%d", i)
+		if err := c.apply(leader, cmd); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, cmd)
+	}
+	c.converged(want, others...)
+	if st := c.nodes[leader].Status(); st.SnapshotIndex == 0 {
+		t.Fatalf("leader did not compact: %+v", st)
+	}
+
+	// The lagging node's next entry is compacted away: it gets a snapshot.
+	c.net.Heal()
+	c.converged(want, all...)
+
+	// A restarted node rebuilds its state machine from storage.
+	c.nodes[lagging].Stop()
+	c.start(lagging)
+	if err := c.apply(leader, "last"); err != nil {
+		t.Fatal(err)
+	}
+	c.converged(append(want, "last"), all...)
+}
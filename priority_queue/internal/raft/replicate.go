@@ -0,0 +1,385 @@
+package raft
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// HandleRequestVote grants a vote to an up-to-date candidate.
+func (n *Node) HandleRequestVote(req *RequestVoteRequest) *RequestVoteResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.term {
+		return &RequestVoteResponse{Term: n.term}
+	}
+	if req.Term > n.term {
+		// Ignore candidates while a leader is known to be alive, so a node
+		// returning from a partition or a removed server cannot depose it.
+		if n.role == Leader || (n.leaderID != "" && time.Since(n.leaderSeen) < n.cfg.ElectionTimeout) {
+			return &RequestVoteResponse{Term: n.term}
+		}
+		n.becomeFollower(req.Term, "")
+	}
+
+	lastIndex := n.lastIndex()
+	lastTerm := n.termAt(lastIndex)
+	upToDate := req.LastLogTerm > lastTerm || (req.LastLogTerm == lastTerm && req.LastLogIndex >= lastIndex)
+	granted := false
+	if (n.votedFor == "" || n.votedFor == req.CandidateID) && upToDate {
+		n.votedFor = req.CandidateID
+		n.persistHardState()
+		n.resetElectionTimer()
+		granted = true
+	}
+	return &RequestVoteResponse{Term: n.term, Granted: granted}
+}
+
+// HandleAppendEntries accepts entries (or a heartbeat) from the leader.
+func (n *Node) HandleAppendEntries(req *AppendEntriesRequest) *AppendEntriesResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.term {
+		return &AppendEntriesResponse{Term: n.term}
+	}
+	if req.Term > n.term || n.role != Follower {
+		n.becomeFollower(req.Term, req.LeaderID)
+	}
+	n.leaderID = req.LeaderID
+	n.leaderSeen = time.Now()
+	n.resetElectionTimer()
+	resp := &AppendEntriesResponse{Term: n.term}
+
+	prev, prevTerm, entries := req.PrevLogIndex, req.PrevLogTerm, req.Entries
+	if first := n.firstIndex(); prev < first {
+		// The start is already in our snapshot, so it is committed and matches.
+		skip := first - prev
+		if uint64(len(entries)) <= skip {
+			resp.Success = true
+			return resp
+		}
+		entries = entries[skip:]
+		prev, prevTerm = first, n.log[0].Term
+	}
+	if prev > n.lastIndex() {
+		resp.ConflictIndex = n.lastIndex() + 1
+		return resp
+	}
+	if t := n.termAt(prev); t != prevTerm {
+		resp.ConflictTerm = t
+		i := prev
+		for i > n.firstIndex()+1 && n.termAt(i-1) == t {
+			i--
+		}
+		resp.ConflictIndex = i
+		return resp
+	}
+
+	for i, e := range entries {
+		if e.Index <= n.lastIndex() {
+			if n.termAt(e.Index) == e.Term {
+				continue
+			}
+			n.log = n.log[:e.Index-n.firstIndex()]
+			n.must(n.storage.TruncateFrom(e.Index))
+			n.servers, n.configIndex = n.latestConfig()
+		}
+		n.appendEntries(append([]Entry(nil), entries[i:]...))
+		break
+	}
+
+	if lastNew := prev + uint64(len(entries)); req.LeaderCommit > n.commitIndex {
+		n.commitIndex = min(req.LeaderCommit, lastNew)
+		n.applyCond.Broadcast()
+	}
+	resp.Success = true
+	return resp
+}
+
+// HandleInstallSnapshot replaces the follower's state with the leader's
+// snapshot when the follower is too far behind for the log.
+func (n *Node) HandleInstallSnapshot(req *InstallSnapshotRequest) *InstallSnapshotResponse {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.term {
+		return &InstallSnapshotResponse{Term: n.term}
+	}
+	if req.Term > n.term || n.role != Follower {
+		n.becomeFollower(req.Term, req.LeaderID)
+	}
+	n.leaderID = req.LeaderID
+	n.leaderSeen = time.Now()
+	n.resetElectionTimer()
+
+	snap := req.Snapshot
+	if snap.Index <= n.lastApplied {
+		return &InstallSnapshotResponse{Term: n.term}
+	}
+	if err := n.fsm.Restore(snap.Data); err != nil {
+		log.Printf("raft: %s: restore snapshot %d: %v", n.id, snap.Index, err)
+		return &InstallSnapshotResponse{Term: n.term}
+	}
+
+	keep := []Entry{{Index: snap.Index, Term: snap.Term}}
+	if snap.Index <= n.lastIndex() && n.termAt(snap.Index) == snap.Term {
+		keep = append(keep, n.log[snap.Index-n.firstIndex()+1:]...)
+	} else {
+		n.must(n.storage.TruncateFrom(n.firstIndex() + 1))
+	}
+	n.must(n.storage.SaveSnapshot(snap))
+	n.log = keep
+	n.snapshot = snap
+	n.commitIndex = max(n.commitIndex, snap.Index)
+	n.lastApplied = snap.Index
+	n.servers, n.configIndex = n.latestConfig()
+	return &InstallSnapshotResponse{Term: n.term}
+}
+
+// syncReplicators runs one replication goroutine per other member of the
+// current configuration.
+func (n *Node) syncReplicators() {
+	want := make(map[string]Server)
+	for _, s := range n.servers {
+		if s.ID != n.id {
+			want[s.ID] = s
+		}
+	}
+	for id, ch := range n.replicators {
+		if _, ok := want[id]; !ok {
+			delete(n.replicators, id)
+			close(ch)
+		}
+	}
+	for id, s := range want {
+		if _, ok := n.replicators[id]; ok {
+			continue
+		}
+		ch := make(chan struct{}, 1)
+		n.replicators[id] = ch
+		n.nextIndex[id] = n.lastIndex() + 1
+		n.matchIndex[id] = 0
+		n.lastAck[id] = time.Now()
+		n.wg.Add(1)
+		go n.replicate(s, n.term, ch)
+	}
+}
+
+func (n *Node) stopReplicators() {
+	for id, ch := range n.replicators {
+		delete(n.replicators, id)
+		close(ch)
+	}
+}
+
+func (n *Node) triggerReplication() {
+	for _, ch := range n.replicators {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (n *Node) replicate(peer Server, term uint64, trigger chan struct{}) {
+	defer n.wg.Done()
+	for {
+		select {
+		case _, ok := <-trigger:
+			if !ok {
+				return
+			}
+		case <-n.stopCh:
+			return
+		}
+		for n.replicateOnce(peer, term) {
+		}
+	}
+}
+
+// replicateOnce sends one AppendEntries or InstallSnapshot to peer. It
+// reports whether there is more to send right away.
+func (n *Node) replicateOnce(peer Server, term uint64) bool {
+	n.mu.Lock()
+	if n.role != Leader || n.term != term || n.stopped {
+		n.mu.Unlock()
+		return false
+	}
+	next := n.nextIndex[peer.ID]
+	ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+	defer cancel()
+
+	if next <= n.firstIndex() {
+		req := &InstallSnapshotRequest{Term: term, LeaderID: n.id, Snapshot: n.snapshot}
+		n.mu.Unlock()
+		resp, err := n.transport.InstallSnapshot(ctx, peer, req)
+		if err != nil {
+			return false
+		}
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if !n.handleReplyTerm(resp.Term, term) {
+			return false
+		}
+		n.lastAck[peer.ID] = time.Now()
+		n.matchIndex[peer.ID] = max(n.matchIndex[peer.ID], req.Snapshot.Index)
+		n.nextIndex[peer.ID] = n.matchIndex[peer.ID] + 1
+		n.advanceCommit()
+		return n.nextIndex[peer.ID] <= n.lastIndex()
+	}
+
+	prev := next - 1
+	end := min(n.lastIndex(), prev+uint64(n.cfg.MaxAppendEntries))
+	var entries []Entry
+	if end > prev {
+		entries = append([]Entry(nil), n.log[next-n.firstIndex():end-n.firstIndex()+1]...)
+	}
+	req := &AppendEntriesRequest{
+		Term:         term,
+		LeaderID:     n.id,
+		PrevLogIndex: prev,
+		PrevLogTerm:  n.termAt(prev),
+		Entries:      entries,
+		LeaderCommit: n.commitIndex,
+	}
+	n.mu.Unlock()
+
+	resp, err := n.transport.AppendEntries(ctx, peer, req)
+	if err != nil {
+		return false
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.handleReplyTerm(resp.Term, term) {
+		return false
+	}
+	n.lastAck[peer.ID] = time.Now()
+	if resp.Success {
+		if m := prev + uint64(len(entries)); m > n.matchIndex[peer.ID] {
+			n.matchIndex[peer.ID] = m
+			n.nextIndex[peer.ID] = m + 1
+			n.advanceCommit()
+		}
+		return n.nextIndex[peer.ID] <= n.lastIndex()
+	}
+
+	next = resp.ConflictIndex
+	if resp.ConflictTerm > 0 {
+		for i := n.lastIndex(); i > n.firstIndex(); i-- {
+			if n.termAt(i) == resp.ConflictTerm {
+				next = i + 1
+				break
+			}
+		}
+	}
+	n.nextIndex[peer.ID] = max(1, min(next, n.lastIndex()+1))
+	return true
+}
+
+// handleReplyTerm steps down on a newer term and reports whether the reply
+// still belongs to this node's leadership in term.
+func (n *Node) handleReplyTerm(replyTerm, term uint64) bool {
+	if replyTerm > n.term {
+		n.becomeFollower(replyTerm, "")
+		return false
+	}
+	return n.role == Leader && n.term == term && !n.stopped
+}
+
+// advanceCommit commits the newest entry of the current term stored on a
+// majority.
+func (n *Node) advanceCommit() {
+	for idx := n.lastIndex(); idx > n.commitIndex; idx-- {
+		if n.termAt(idx) != n.term {
+			return
+		}
+		count := 0
+		for _, s := range n.servers {
+			if s.ID == n.id || n.matchIndex[s.ID] >= idx {
+				count++
+			}
+		}
+		if count >= n.quorum() {
+			n.commitIndex = idx
+			n.applyCond.Broadcast()
+			return
+		}
+	}
+}
+
+// applyLoop feeds committed entries to the state machine in order, answers
+// waiting proposers and compacts the log.
+func (n *Node) applyLoop() {
+	defer n.wg.Done()
+	for {
+		n.mu.Lock()
+		for n.lastApplied >= n.commitIndex && !n.stopped {
+			n.applyCond.Wait()
+		}
+		stopped := n.stopped
+		n.mu.Unlock()
+		if stopped {
+			return
+		}
+
+		n.applyMu.Lock()
+		n.mu.Lock()
+		start, end := n.lastApplied+1, n.commitIndex
+		var entries []Entry
+		if start <= end {
+			entries = append(entries, n.log[start-n.firstIndex():end-n.firstIndex()+1]...)
+		}
+		n.mu.Unlock()
+
+		results := make([][]byte, len(entries))
+		for i, e := range entries {
+			if e.Type == EntryCommand {
+				results[i] = n.fsm.Apply(e.Data)
+			}
+		}
+
+		n.mu.Lock()
+		for i, e := range entries {
+			if w, ok := n.pending[e.Index]; ok {
+				delete(n.pending, e.Index)
+				if w.term == e.Term {
+					w.ch <- applyResult{data: results[i]}
+				} else {
+					w.ch <- applyResult{err: ErrLeadershipLost}
+				}
+			}
+		}
+		if len(entries) > 0 {
+			n.lastApplied = end
+		}
+		if n.role == Leader && !n.isVoter(n.id) && n.configIndex <= n.lastApplied {
+			log.Printf("raft: %s: removed from the cluster, stepping down", n.id)
+			n.becomeFollower(n.term, "")
+		}
+		if n.lastApplied-n.firstIndex() >= n.cfg.SnapshotThreshold {
+			n.takeSnapshot()
+		}
+		n.mu.Unlock()
+		n.applyMu.Unlock()
+	}
+}
+
+// takeSnapshot compacts the log through lastApplied. Called with both mu and
+// applyMu held, so the state machine is exactly at lastApplied.
+func (n *Node) takeSnapshot() {
+	data, err := n.fsm.Snapshot()
+	if err != nil {
+		log.Printf("raft: %s: snapshot: %v", n.id, err)
+		return
+	}
+	idx := n.lastApplied
+	servers, _ := n.configAt(idx)
+	snap := Snapshot{Index: idx, Term: n.termAt(idx), Servers: servers, Data: data}
+	n.must(n.storage.SaveSnapshot(snap))
+	n.log = append([]Entry{{Index: idx, Term: snap.Term}}, n.log[idx-n.firstIndex()+1:]...)
+	n.snapshot = snap
+}
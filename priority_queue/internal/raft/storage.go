@@ -0,0 +1,280 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// State is everything a node persists: the term and vote, the latest
+// snapshot and the log entries after it.
+type State struct {
+	Term     uint64
+	VotedFor string
+	Snapshot *Snapshot
+	Entries  []Entry
+}
+
+// Storage persists a node's State. Every method must be durable when it
+// returns; a node that forgets its vote or log can break safety.
+type Storage interface {
+	Load() (State, error)
+	SetHardState(term uint64, votedFor string) error
+	Append(entries []Entry) error
+	// TruncateFrom deletes the entries at index and after.
+	TruncateFrom(index uint64) error
+	// SaveSnapshot stores s and deletes the entries it covers.
+	SaveSnapshot(s Snapshot) error
+}
+
+// MemoryStorage keeps state in memory. It survives a node being stopped and
+// recreated, which is enough for tests, but not a process restart.
+type MemoryStorage struct {
+	mu    sync.Mutex
+	state State
+}
+
+func NewMemoryStorage() *MemoryStorage { return &MemoryStorage{} }
+
+func (m *MemoryStorage) Load() (State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.state
+	s.Entries = append([]Entry(nil), m.state.Entries...)
+	return s, nil
+}
+
+func (m *MemoryStorage) SetHardState(term uint64, votedFor string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state.Term, m.state.VotedFor = term, votedFor
+	return nil
+}
+
+func (m *MemoryStorage) Append(entries []Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state.Entries = append(m.state.Entries, entries...)
+	return nil
+}
+
+func (m *MemoryStorage) TruncateFrom(index uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state.Entries = truncateFrom(m.state.Entries, index)
+	return nil
+}
+
+func (m *MemoryStorage) SaveSnapshot(s Snapshot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state.Snapshot = &s
+	m.state.Entries = dropThrough(m.state.Entries, s.Index)
+	return nil
+}
+
+// FileStorage keeps state in dir: state.json (term and vote), snapshot.json
+// and log.jsonl. Appends are fsynced; truncation and compaction rewrite the
+// log file.
+type FileStorage struct {
+	mu      sync.Mutex
+	dir     string
+	entries []Entry
+	log     *os.File
+}
+
+func NewFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileStorage{dir: dir}, nil
+}
+
+func (f *FileStorage) path(name string) string { return filepath.Join(f.dir, name) }
+
+func (f *FileStorage) Load() (State, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var s State
+	var hard struct {
+		Term     uint64 `json:"term"`
+		VotedFor string `json:"votedFor"`
+	}
+	if err := readJSONFile(f.path("state.json"), &hard); err != nil {
+		return s, err
+	}
+	s.Term, s.VotedFor = hard.Term, hard.VotedFor
+
+	var snap Snapshot
+	if err := readJSONFile(f.path("snapshot.json"), &snap); err != nil {
+		return s, err
+	}
+	if snap.Index > 0 {
+		s.Snapshot = &snap
+	}
+
+	f.entries = nil
+	if lf, err := os.Open(f.path("log.jsonl")); err == nil {
+		sc := bufio.NewScanner(lf)
+		sc.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+		for sc.Scan() {
+			var e Entry
+			if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+				// A torn final write; everything before it is intact.
+				break
+			}
+			f.entries = append(f.entries, e)
+		}
+		lf.Close()
+		if err := sc.Err(); err != nil {
+			return s, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return s, err
+	}
+	f.entries = dropThrough(f.entries, snap.Index)
+	if err := f.rewriteLog(); err != nil {
+		return s, err
+	}
+	s.Entries = append([]Entry(nil), f.entries...)
+	return s, nil
+}
+
+func (f *FileStorage) SetHardState(term uint64, votedFor string) error {
+	return writeJSONFile(f.path("state.json"), map[string]any{"term": term, "votedFor": votedFor})
+}
+
+func (f *FileStorage) Append(entries []Entry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.log == nil {
+		if err := f.rewriteLog(); err != nil {
+			return err
+		}
+	}
+	w := bufio.NewWriter(f.log)
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	f.entries = append(f.entries, entries...)
+	return f.log.Sync()
+}
+
+func (f *FileStorage) TruncateFrom(index uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries = truncateFrom(f.entries, index)
+	return f.rewriteLog()
+}
+
+func (f *FileStorage) SaveSnapshot(s Snapshot) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := writeJSONFile(f.path("snapshot.json"), s); err != nil {
+		return err
+	}
+	f.entries = dropThrough(f.entries, s.Index)
+	return f.rewriteLog()
+}
+
+// rewriteLog replaces log.jsonl with f.entries and reopens it for appends.
+func (f *FileStorage) rewriteLog() error {
+	if f.log != nil {
+		f.log.Close()
+		f.log = nil
+	}
+	tmp := f.path("log.jsonl.tmp")
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	for _, e := range f.entries {
+		if err := enc.Encode(e); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	out.Close()
+	if err := os.Rename(tmp, f.path("log.jsonl")); err != nil {
+		return err
+	}
+	f.log, err = os.OpenFile(f.path("log.jsonl"), os.O_APPEND|os.O_WRONLY, 0o644)
+	return err
+}
+
+func readJSONFile(path string, v any) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// writeJSONFile replaces path atomically.
+func writeJSONFile(path string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(b); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	out.Close()
+	return os.Rename(tmp, path)
+}
+
+func truncateFrom(entries []Entry, index uint64) []Entry {
+	for i, e := range entries {
+		if e.Index >= index {
+			return entries[:i:i]
+		}
+	}
+	return entries
+}
+
+func dropThrough(entries []Entry, index uint64) []Entry {
+	for i, e := range entries {
+		if e.Index > index {
+			return append([]Entry(nil), entries[i:]...)
+		}
+	}
+	return nil
+}
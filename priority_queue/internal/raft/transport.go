@@ -0,0 +1,277 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type RequestVoteRequest struct {
+	Term         uint64 `json:"term"`
+	CandidateID  string `json:"candidateId"`
+	LastLogIndex uint64 `json:"lastLogIndex"`
+	LastLogTerm  uint64 `json:"lastLogTerm"`
+}
+
+type RequestVoteResponse struct {
+	Term    uint64 `json:"term"`
+	Granted bool   `json:"granted"`
+}
+
+type AppendEntriesRequest struct {
+	Term         uint64  `json:"term"`
+	LeaderID     string  `json:"leaderId"`
+	PrevLogIndex uint64  `json:"prevLogIndex"`
+	PrevLogTerm  uint64  `json:"prevLogTerm"`
+	Entries      []Entry `json:"entries,omitempty"`
+	LeaderCommit uint64  `json:"leaderCommit"`
+}
+
+// AppendEntriesResponse carries a conflict hint on failure so the leader can
+// skip back a whole term at a time.
+type AppendEntriesResponse struct {
+	Term          uint64 `json:"term"`
+	Success       bool   `json:"success"`
+	ConflictIndex uint64 `json:"conflictIndex,omitempty"`
+	ConflictTerm  uint64 `json:"conflictTerm,omitempty"`
+}
+
+type InstallSnapshotRequest struct {
+	Term     uint64   `json:"term"`
+	LeaderID string   `json:"leaderId"`
+	Snapshot Snapshot `json:"snapshot"`
+}
+
+type InstallSnapshotResponse struct {
+	Term uint64 `json:"term"`
+}
+
+// ForwardRequest carries a client request from a follower to the leader.
+type ForwardRequest struct {
+	Kind   string  `json:"kind"` // apply, barrier, addServer or removeServer
+	Data   []byte  `json:"data,omitempty"`
+	Server *Server `json:"server,omitempty"`
+}
+
+type ForwardResponse struct {
+	Data      []byte `json:"data,omitempty"`
+	Error     string `json:"error,omitempty"`
+	NotLeader bool   `json:"notLeader,omitempty"`
+}
+
+const (
+	forwardApply        = "apply"
+	forwardBarrier      = "barrier"
+	forwardAddServer    = "addServer"
+	forwardRemoveServer = "removeServer"
+)
+
+// Transport sends RPCs to other nodes.
+type Transport interface {
+	RequestVote(ctx context.Context, to Server, req *RequestVoteRequest) (*RequestVoteResponse, error)
+	AppendEntries(ctx context.Context, to Server, req *AppendEntriesRequest) (*AppendEntriesResponse, error)
+	InstallSnapshot(ctx context.Context, to Server, req *InstallSnapshotRequest) (*InstallSnapshotResponse, error)
+	Forward(ctx context.Context, to Server, req *ForwardRequest) (*ForwardResponse, error)
+}
+
+// HTTPTransport sends RPCs as JSON POSTs to <Addr>/raft/<rpc>, served by
+// Node.HTTPHandler.
+type HTTPTransport struct {
+	Client *http.Client
+}
+
+func (t *HTTPTransport) RequestVote(ctx context.Context, to Server, req *RequestVoteRequest) (*RequestVoteResponse, error) {
+	var resp RequestVoteResponse
+	return &resp, t.call(ctx, to, "vote", req, &resp)
+}
+
+func (t *HTTPTransport) AppendEntries(ctx context.Context, to Server, req *AppendEntriesRequest) (*AppendEntriesResponse, error) {
+	var resp AppendEntriesResponse
+	return &resp, t.call(ctx, to, "append", req, &resp)
+}
+
+func (t *HTTPTransport) InstallSnapshot(ctx context.Context, to Server, req *InstallSnapshotRequest) (*InstallSnapshotResponse, error) {
+	var resp InstallSnapshotResponse
+	return &resp, t.call(ctx, to, "snapshot", req, &resp)
+}
+
+func (t *HTTPTransport) Forward(ctx context.Context, to Server, req *ForwardRequest) (*ForwardResponse, error) {
+	var resp ForwardResponse
+	return &resp, t.call(ctx, to, "forward", req, &resp)
+}
+
+func (t *HTTPTransport) call(ctx context.Context, to Server, rpc string, req, resp any) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	url := strings.TrimRight(to.Addr, "/") + "/raft/" + rpc
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	hresp, err := client.Do(hreq)
+	if err != nil {
+		return err
+	}
+	defer hresp.Body.Close()
+	if hresp.StatusCode != http.StatusOK {
+		return fmt.Errorf("raft: %s %s: %s", to.ID, rpc, hresp.Status)
+	}
+	return json.NewDecoder(hresp.Body).Decode(resp)
+}
+
+// HTTPHandler serves the RPCs sent by HTTPTransport.
+func (n *Node) HTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /raft/vote", func(w http.ResponseWriter, r *http.Request) {
+		var req RequestVoteRequest
+		if decodeRPC(w, r, &req) {
+			encodeRPC(w, n.HandleRequestVote(&req))
+		}
+	})
+	mux.HandleFunc("POST /raft/append", func(w http.ResponseWriter, r *http.Request) {
+		var req AppendEntriesRequest
+		if decodeRPC(w, r, &req) {
+			encodeRPC(w, n.HandleAppendEntries(&req))
+		}
+	})
+	mux.HandleFunc("POST /raft/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		var req InstallSnapshotRequest
+		if decodeRPC(w, r, &req) {
+			encodeRPC(w, n.HandleInstallSnapshot(&req))
+		}
+	})
+	mux.HandleFunc("POST /raft/forward", func(w http.ResponseWriter, r *http.Request) {
+		var req ForwardRequest
+		if decodeRPC(w, r, &req) {
+			encodeRPC(w, n.HandleForward(r.Context(), &req))
+		}
+	})
+	return mux
+}
+
+func decodeRPC(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func encodeRPC(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// ErrUnreachable is returned by the in-memory transport for a cut link.
+var ErrUnreachable = errors.New("raft: node unreachable")
+
+// Network connects nodes in one process and can simulate partitions.
+type Network struct {
+	mu    sync.RWMutex
+	nodes map[string]*Node
+	cut   map[[2]string]bool
+}
+
+func NewNetwork() *Network {
+	return &Network{nodes: make(map[string]*Node), cut: make(map[[2]string]bool)}
+}
+
+// Transport returns the transport for the node with the given ID. Targets
+// are addressed by Server.ID.
+func (nw *Network) Transport(from string) Transport {
+	return &inmemTransport{nw: nw, from: from}
+}
+
+// Register makes n reachable under id, replacing any earlier node.
+func (nw *Network) Register(id string, n *Node) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	nw.nodes[id] = n
+}
+
+// Partition splits the network: nodes can only reach nodes in the same
+// group. Nodes in no group are isolated.
+func (nw *Network) Partition(groups ...[]string) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+
+	group := make(map[string]int)
+	for i, g := range groups {
+		for _, id := range g {
+			group[id] = i + 1
+		}
+	}
+	nw.cut = make(map[[2]string]bool)
+	for a := range nw.nodes {
+		for b := range nw.nodes {
+			if a != b && (group[a] == 0 || group[a] != group[b]) {
+				nw.cut[[2]string{a, b}] = true
+			}
+		}
+	}
+}
+
+// Heal reconnects every node.
+func (nw *Network) Heal() {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	nw.cut = make(map[[2]string]bool)
+}
+
+func (nw *Network) route(from, to string) (*Node, error) {
+	nw.mu.RLock()
+	defer nw.mu.RUnlock()
+	n := nw.nodes[to]
+	if n == nil || nw.cut[[2]string{from, to}] || n.isStopped() {
+		return nil, ErrUnreachable
+	}
+	return n, nil
+}
+
+type inmemTransport struct {
+	nw   *Network
+	from string
+}
+
+// deliver runs the RPC on the target and drops the response if the link was
+// cut in the meantime.
+func deliver[T any](t *inmemTransport, to Server, rpc func(*Node) T) (T, error) {
+	var zero T
+	n, err := t.nw.route(t.from, to.ID)
+	if err != nil {
+		return zero, err
+	}
+	resp := rpc(n)
+	if _, err := t.nw.route(to.ID, t.from); err != nil {
+		return zero, err
+	}
+	return resp, nil
+}
+
+func (t *inmemTransport) RequestVote(ctx context.Context, to Server, req *RequestVoteRequest) (*RequestVoteResponse, error) {
+	return deliver(t, to, func(n *Node) *RequestVoteResponse { return n.HandleRequestVote(req) })
+}
+
+func (t *inmemTransport) AppendEntries(ctx context.Context, to Server, req *AppendEntriesRequest) (*AppendEntriesResponse, error) {
+	return deliver(t, to, func(n *Node) *AppendEntriesResponse { return n.HandleAppendEntries(req) })
+}
+
+func (t *inmemTransport) InstallSnapshot(ctx context.Context, to Server, req *InstallSnapshotRequest) (*InstallSnapshotResponse, error) {
+	return deliver(t, to, func(n *Node) *InstallSnapshotResponse { return n.HandleInstallSnapshot(req) })
+}
+
+func (t *inmemTransport) Forward(ctx context.Context, to Server, req *ForwardRequest) (*ForwardResponse, error) {
+	return deliver(t, to, func(n *Node) *ForwardResponse { return n.HandleForward(ctx, req) })
+}
@@ -0,0 +1,100 @@
+package replication
+
+import (
+	"context"
+	"icetea/priority_queue/internal/queue"
+	"sync"
+)
+
+// Entry is one replicated queue command. Index matches queue.Applied() on
+// the leader right after the command ran.
+type Entry struct {
+	Index uint64        `json:"index"`
+	Cmd   queue.Command `json:"cmd"`
+}
+
+// Log keeps the most recent commands applied on the leader. Older entries are
+// dropped once max is exceeded; followers that fall behind resync from a
+// snapshot.
+type Log struct {
+	mu      sync.Mutex
+	base    uint64 // index of the entry just before entries[0]
+	entries []Entry
+	max     int
+	notify  chan struct{} // closed and replaced on every append
+}
+
+func NewLog(base uint64, max int) *Log {
+	if max <= 0 {
+		max = 100_000
+	}
+	return &Log{base: base, max: max, notify: make(chan struct{})}
+}
+
+// Append is installed as the queue command hook.
+func (l *Log) Append(index uint64, cmd queue.Command) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = append(l.entries, Entry{Index: index, Cmd: cmd})
+	if over := len(l.entries) - l.max; over > 0 {
+		l.base = l.entries[over-1].Index
+		l.entries = append([]Entry(nil), l.entries[over:]...)
+	}
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+// LastIndex returns the index of the newest entry.
+func (l *Log) LastIndex() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lastLocked()
+}
+
+func (l *Log) lastLocked() uint64 {
+	if n := len(l.entries); n > 0 {
+		return l.entries[n-1].Index
+	}
+	return l.base
+}
+
+// Since returns up to limit entries starting at index from. ok is false when
+// from has already been dropped from the log.
+func (l *Log) Since(from uint64, limit int) (entries []Entry, last uint64, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	last = l.lastLocked()
+	if from <= l.base {
+		return nil, last, false
+	}
+	start := int(from - l.base - 1)
+	if start >= len(l.entries) {
+		return nil, last, true
+	}
+	end := len(l.entries)
+	if limit > 0 && start+limit < end {
+		end = start + limit
+	}
+	return append([]Entry(nil), l.entries[start:end]...), last, true
+}
+
+// Wait blocks until the log holds an entry with index >= index or ctx ends.
+func (l *Log) Wait(ctx context.Context, index uint64) {
+	for {
+		l.mu.Lock()
+		if l.lastLocked() >= index {
+			l.mu.Unlock()
+			return
+		}
+		ch := l.notify
+		l.mu.Unlock()
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
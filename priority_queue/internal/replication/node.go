@@ -0,0 +1,355 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"icetea/priority_queue/internal/queue"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type Role string
+
+const (
+	RoleLeader   Role = "leader"
+	RoleFollower Role = "follower"
+)
+
+// Node is one member of an asynchronous leader/follower setup. The leader
+// records every command its queue applies; followers pull that log over HTTP
+// and apply it to their own queue.
+type Node struct {
+	Q      *queue.VideoProcessingQueue
+	ID     string
+	Client *http.Client
+
+	mu        sync.Mutex
+	role      Role
+	log       *Log
+	logSize   int
+	leaderURL string
+	cancel    context.CancelFunc
+	done      chan struct{}
+
+	// follower state
+	leaderIndex  uint64
+	lastContact  time.Time
+	caughtUpAt   time.Time
+	lastErr      string
+	needSnapshot bool
+
+	// leader state: last position reported by each follower
+	followers map[string]FollowerStatus
+}
+
+// Status is the replication view of a node, including lag.
+type Status struct {
+	ID           string                    `json:"id,omitempty"`
+	Role         Role                      `json:"role"`
+	AppliedIndex uint64                    `json:"appliedIndex"`
+	LeaderURL    string                    `json:"leaderUrl,omitempty"`
+	LeaderIndex  uint64                    `json:"leaderIndex,omitempty"`
+	LagEntries   uint64                    `json:"lagEntries"`
+	LagSeconds   float64                   `json:"lagSeconds"` // time since last caught up
+	LastContact  *time.Time                `json:"lastContact,omitempty"`
+	LastError    string                    `json:"lastError,omitempty"`
+	Followers    map[string]FollowerStatus `json:"followers,omitempty"`
+}
+
+type FollowerStatus struct {
+	AppliedIndex uint64    `json:"appliedIndex"`
+	LagEntries   uint64    `json:"lagEntries"`
+	LastSeen     time.Time `json:"lastSeen"`
+}
+
+// NewLeader starts recording q's commands, keeping at most logSize entries.
+func NewLeader(q *queue.VideoProcessingQueue, id string, logSize int) *Node {
+	n := &Node{Q: q, ID: id, Client: http.DefaultClient, logSize: logSize}
+	n.becomeLeader()
+	return n
+}
+
+// NewFollower prepares a read-only replica of the leader at leaderURL. Call
+// Run to start replicating.
+func NewFollower(q *queue.VideoProcessingQueue, id, leaderURL string) *Node {
+	q.SetReplica(true)
+	return &Node{
+		Q:            q,
+		ID:           id,
+		Client:       http.DefaultClient,
+		role:         RoleFollower,
+		leaderURL:    leaderURL,
+		needSnapshot: true,
+	}
+}
+
+func (n *Node) Role() Role {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role
+}
+
+func (n *Node) LeaderURL() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leaderURL
+}
+
+// Run replicates from the leader until ctx is done or the node is promoted.
+// It returns immediately on a leader.
+func (n *Node) Run(ctx context.Context) {
+	n.mu.Lock()
+	if n.role != RoleFollower || n.cancel != nil {
+		n.mu.Unlock()
+		return
+	}
+	ctx, n.cancel = context.WithCancel(ctx)
+	n.done = make(chan struct{})
+	done := n.done
+	n.mu.Unlock()
+
+	defer close(done)
+	for ctx.Err() == nil {
+		if err := n.syncOnce(ctx); err != nil && ctx.Err() == nil {
+			n.mu.Lock()
+			n.lastErr = err.Error()
+			n.mu.Unlock()
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+// Promote turns a follower into a leader: replication stops, boost timers are
+// armed locally and a new log starts at the current applied index. Clients
+// and the remaining followers must be pointed at this node separately.
+func (n *Node) Promote() {
+	n.stop()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.role == RoleLeader {
+		return
+	}
+	n.becomeLeaderLocked()
+	log.Printf("replication: %s promoted to leader at index %d", n.ID, n.Q.Applied())
+}
+
+// Follow turns the node into a follower of leaderURL, e.g. to re-point a
+// follower at a newly promoted leader or to demote a former leader. Run must
+// be called again afterwards.
+func (n *Node) Follow(leaderURL string) {
+	n.stop()
+	n.Q.SetCommandHook(nil)
+	n.Q.SetReplica(true)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.role = RoleFollower
+	n.leaderURL = leaderURL
+	n.log = nil
+	n.followers = nil
+	n.needSnapshot = true
+}
+
+func (n *Node) stop() {
+	n.mu.Lock()
+	cancel, done := n.cancel, n.done
+	n.cancel, n.done = nil, nil
+	n.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+func (n *Node) becomeLeader() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.becomeLeaderLocked()
+}
+
+func (n *Node) becomeLeaderLocked() {
+	// Nothing applies commands yet: replication is stopped, writes are
+	// rejected while the role is follower and replica mode has no boost
+	// timers. Install the log before any of that changes.
+	n.log = NewLog(n.Q.Applied(), n.logSize)
+	n.Q.SetCommandHook(n.log.Append)
+	n.Q.SetReplica(false)
+	n.role = RoleLeader
+	n.leaderURL = ""
+	n.followers = make(map[string]FollowerStatus)
+}
+
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	st := Status{ID: n.ID, Role: n.role, AppliedIndex: n.Q.Applied(), LastError: n.lastErr}
+	if n.role == RoleLeader {
+		st.Followers = make(map[string]FollowerStatus, len(n.followers))
+		last := n.log.LastIndex()
+		for id, f := range n.followers {
+			if last > f.AppliedIndex {
+				f.LagEntries = last - f.AppliedIndex
+			}
+			st.Followers[id] = f
+		}
+		return st
+	}
+	st.LeaderURL = n.leaderURL
+	st.LeaderIndex = n.leaderIndex
+	if n.leaderIndex > st.AppliedIndex {
+		st.LagEntries = n.leaderIndex - st.AppliedIndex
+		if !n.caughtUpAt.IsZero() {
+			st.LagSeconds = time.Since(n.caughtUpAt).Seconds()
+		}
+	}
+	if !n.lastContact.IsZero() {
+		t := n.lastContact
+		st.LastContact = &t
+	}
+	return st
+}
+
+// syncOnce fetches a snapshot if needed, then one batch of log entries.
+func (n *Node) syncOnce(ctx context.Context) error {
+	n.mu.Lock()
+	leader, needSnapshot := n.leaderURL, n.needSnapshot
+	n.mu.Unlock()
+
+	if needSnapshot {
+		var snap queue.Snapshot
+		if _, err := n.get(ctx, leader+"/replication/snapshot", &snap); err != nil {
+			return fmt.Errorf("snapshot: %w", err)
+		}
+		n.Q.Restore(snap)
+		n.mu.Lock()
+		n.needSnapshot = false
+		n.mu.Unlock()
+	}
+
+	from := n.Q.Applied() + 1
+	u := fmt.Sprintf("%s/replication/log?from=%d&limit=1000&wait=10s&follower=%s", leader, from, url.QueryEscape(n.ID))
+	var batch LogResponse
+	code, err := n.get(ctx, u, &batch)
+	if code == http.StatusGone {
+		n.mu.Lock()
+		n.needSnapshot = true
+		n.mu.Unlock()
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("log: %w", err)
+	}
+
+	for _, e := range batch.Entries {
+		if e.Index != n.Q.Applied()+1 {
+			break
+		}
+		if _, err := n.Q.Apply(e.Cmd); err != nil || n.Q.Applied() != e.Index {
+			// Diverged from the leader; start over from a snapshot.
+			n.mu.Lock()
+			n.needSnapshot = true
+			n.mu.Unlock()
+			return fmt.Errorf("apply %d (%s): diverged: %v", e.Index, e.Cmd.Op, err)
+		}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.leaderIndex = batch.LastIndex
+	n.lastContact = time.Now()
+	n.lastErr = ""
+	if n.Q.Applied() >= batch.LastIndex {
+		n.caughtUpAt = n.lastContact
+	}
+	return nil
+}
+
+func (n *Node) get(ctx context.Context, u string, out any) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("leader returned %s", resp.Status)
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+}
+
+// LogResponse is the body of GET /replication/log.
+type LogResponse struct {
+	Entries   []Entry `json:"entries"`
+	LastIndex uint64  `json:"lastIndex"`
+}
+
+// ServeLog returns entries from ?from=, waiting up to ?wait= for new ones.
+func (n *Node) ServeLog(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	l, role := n.log, n.role
+	n.mu.Unlock()
+	if role != RoleLeader {
+		http.Error(w, "not the leader", http.StatusServiceUnavailable)
+		return
+	}
+
+	qs := r.URL.Query()
+	from, err := strconv.ParseUint(qs.Get("from"), 10, 64)
+	if err != nil || from == 0 {
+		http.Error(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	limit, _ := strconv.Atoi(qs.Get("limit"))
+	if wait, err := time.ParseDuration(qs.Get("wait")); err == nil && wait > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		l.Wait(ctx, from)
+		cancel()
+	}
+
+	entries, last, ok := l.Since(from, limit)
+	if id := qs.Get("follower"); id != "" {
+		n.mu.Lock()
+		if n.followers != nil {
+			n.followers[id] = FollowerStatus{AppliedIndex: from - 1, LastSeen: time.Now()}
+		}
+		n.mu.Unlock()
+	}
+	if !ok {
+		http.Error(w, "log truncated, fetch a snapshot", http.StatusGone)
+		return
+	}
+	writeJSON(w, LogResponse{Entries: entries, LastIndex: last})
+}
+
+func (n *Node) ServeSnapshot(w http.ResponseWriter, r *http.Request) {
+	if n.Role() != RoleLeader {
+		http.Error(w, "not the leader", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, n.Q.Snapshot())
+}
+
+func (n *Node) ServeStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, n.Status())
+}
+
+func (n *Node) ServePromote(w http.ResponseWriter, r *http.Request) {
+	n.Promote()
+	writeJSON(w, n.Status())
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,205 @@
+package replication_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"icetea/priority_queue/config"
+	"icetea/priority_queue/internal/httpapi"
+	"icetea/priority_queue/internal/queue"
+	"icetea/priority_queue/internal/replication"
+)
+
+// testNode is one server of an in-process cluster.
+type testNode struct {
+	Q    *queue.VideoProcessingQueue
+	Node *replication.Node
+	Srv  *httptest.Server
+}
+
+var testConfig = config.Config{
+	TotalPriority:        3,
+	EnableAntiStarvation: true,
+	MaximumWaitSeconds:   600,
+	BTreeDegree:          16,
+	TimeBoost:            2,
+}
+
+func startNode(t *testing.T, node func(q *queue.VideoProcessingQueue) *replication.Node) *testNode {
+	t.Helper()
+	q := queue.NewFromConfig(testConfig)
+	n := &testNode{Q: q, Node: node(q)}
+	h := &httpapi.Handler{Q: q, Cfg: testConfig, Replication: n.Node}
+	n.Srv = httptest.NewServer(h.Router())
+	t.Cleanup(func() {
+		n.Node.Promote() // stops a running follower loop
+		n.Srv.Close()
+	})
+	return n
+}
+
+func startLeader(t *testing.T, logSize int) *testNode {
+	return startNode(t, func(q *queue.VideoProcessingQueue) *replication.Node {
+		return replication.NewLeader(q, "leader", logSize)
+	})
+}
+
+func startFollower(t *testing.T, id string, leader *testNode) *testNode {
+	n := startNode(t, func(q *queue.VideoProcessingQueue) *replication.Node {
+		return replication.NewFollower(q, id, leader.Srv.URL)
+	})
+	n.run()
+	return n
+}
+
+func (n *testNode) run() {
+	go n.Node.Run(context.Background())
+}
+
+func post(t *testing.T, url string, body any) *http.Response {
+	t.Helper()
+	b, _ := json.Marshal(body)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("POST %s: %v", url, err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func enqueue(t *testing.T, n *testNode, id, family string, prio int) *http.Response {
+	return post(t, n.Srv.URL+"/enqueue", map[string]any{
+		"ad": map[string]any{"adId": id, "title": "t-" + id, "gameFamily": family, "priority": prio, "maxWaitTime": 600},
+	})
+}
+
+func exportJSON(q *queue.VideoProcessingQueue) string {
+	b, _ := json.Marshal(q.Export())
+	return string(b)
+}
+
+// waitCaughtUp waits until every follower has applied everything the leader has.
+func waitCaughtUp(t *testing.T, leader *testNode, followers ...*testNode) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, f := range followers {
+		for f.Q.Applied() != leader.Q.Applied() {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s stuck at %d, leader at %d: %+v", f.Node.ID, f.Q.Applied(), leader.Q.Applied(), f.Node.Status())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+}
+
+func assertSameState(t *testing.T, leader *testNode, followers ...*testNode) {
+	t.Helper()
+	want := exportJSON(leader.Q)
+	for _, f := range followers {
+		if got := exportJSON(f.Q); got != want {
+			t.Fatalf("%s diverged:\n got %s\nwant %s", f.Node.ID, got, want)
+		}
+		if f.Q.IsEnableAntiStarvation() != leader.Q.IsEnableAntiStarvation() {
+			t.Fatalf("%s: anti-starvation setting not replicated", f.Node.ID)
+		}
+		if len(f.Q.ActiveBoosts()) != len(leader.Q.ActiveBoosts()) {
+			t.Fatalf("%s: boosts not replicated", f.Node.ID)
+		}
+	}
+}
+
+func TestFollowersConvergeAndServeReads(t *testing.T) {
+	leader := startLeader(t, 0)
+	// Some state exists before the followers join; they pick it up from a snapshot.
+	enqueue(t, leader, "A1", "RPG", 1)
+	enqueue(t, leader, "A2", "Puzzle", 2)
+
+	f1 := startFollower(t, "f1", leader)
+	f2 := startFollower(t, "f2", leader)
+
+	for i := 0; i < 10; i++ {
+		enqueue(t, leader, fmt.Sprintf("B%d", i), []string{"RPG", "Puzzle"}[i%2], i%3+1)
+	}
+	post(t, leader.Srv.URL+"/dequeue", nil)
+	post(t, leader.Srv.URL+"/reprioritize/family", map[string]any{"family": "RPG", "newPriority": 3})
+	post(t, leader.Srv.URL+"/boosts", map[string]any{"family": "Puzzle", "delta": 1, "duration": "1h"})
+	post(t, leader.Srv.URL+"/settings/antiStarvation", map[string]any{"enable": false})
+
+	waitCaughtUp(t, leader, f1, f2)
+	assertSameState(t, leader, f1, f2)
+
+	resp, err := http.Get(f1.Srv.URL + "/peek?n=3")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("follower /peek: %v %v", resp, err)
+	}
+	resp.Body.Close()
+
+	st := leader.Node.Status()
+	if st.Role != replication.RoleLeader || len(st.Followers) != 2 {
+		t.Fatalf("leader status: %+v", st)
+	}
+	if fs := f1.Node.Status(); fs.LagEntries != 0 || fs.LeaderIndex != leader.Q.Applied() {
+		t.Fatalf("follower status: %+v", fs)
+	}
+}
+
+func TestFollowerRejectsWritesUntilPromoted(t *testing.T) {
+	leader := startLeader(t, 0)
+	f1 := startFollower(t, "f1", leader)
+	f2 := startFollower(t, "f2", leader)
+
+	enqueue(t, leader, "A1", "RPG", 1)
+	waitCaughtUp(t, leader, f1, f2)
+
+	if resp := enqueue(t, f1, "X", "RPG", 1); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("follower accepted a write: %d", resp.StatusCode)
+	}
+	if resp := post(t, f1.Srv.URL+"/dequeue", nil); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("follower accepted a dequeue: %d", resp.StatusCode)
+	}
+
+	// Fail over: the old leader goes away, f1 takes over and f2 follows it.
+	leader.Srv.CloseClientConnections()
+	leader.Srv.Close()
+	if resp := post(t, f1.Srv.URL+"/replication/promote", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("promote: %d", resp.StatusCode)
+	}
+	f2.Node.Follow(f1.Srv.URL)
+	f2.run()
+
+	if resp := enqueue(t, f1, "A2", "Puzzle", 2); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("promoted node rejected a write: %d", resp.StatusCode)
+	}
+	waitCaughtUp(t, f1, f2)
+	assertSameState(t, f1, f2)
+	if got := f2.Q.PeekNext(10); len(got) != 2 {
+		t.Fatalf("f2 has %d ads, want 2", len(got))
+	}
+}
+
+func TestTruncatedLogFallsBackToSnapshot(t *testing.T) {
+	leader := startLeader(t, 2)
+	for i := 0; i < 5; i++ {
+		enqueue(t, leader, fmt.Sprintf("A%d", i), "RPG", 1)
+	}
+
+	resp, err := http.Get(leader.Srv.URL + "/replication/log?from=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGone {
+		t.Fatalf("want 410 for a truncated log, got %d", resp.StatusCode)
+	}
+
+	f1 := startFollower(t, "f1", leader)
+	enqueue(t, leader, "A5", "RPG", 2)
+	waitCaughtUp(t, leader, f1)
+	assertSameState(t, leader, f1)
+}
@@ -0,0 +1,263 @@
+package sim
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Report summarizes one simulated config.
+type Report struct {
+	Name                string          `json:"name"`
+	Arrived             int             `json:"arrived"`
+	Served              int             `json:"served"`
+	Unserved            int             `json:"unserved"` // still queued at the end
+	Rejected            int             `json:"rejected"` // enqueue failed, e.g. class capacity
+	ThroughputPerSecond float64         `json:"throughputPerSecond"`
+	Utilization         float64         `json:"utilization"` // busy share of worker time
+	DeadlineMisses      int             `json:"deadlineMisses"`
+	MissRate            float64         `json:"missRate"`      // misses / (served + unserved)
+	ShareFairness       float64         `json:"shareFairness"` // Jain index of served/arrived per family
+	WaitFairness        float64         `json:"waitFairness"`  // Jain index of mean wait per family
+	Priorities          []PriorityStats `json:"priorities"`
+	Families            []FamilyStats   `json:"families"`
+}
+
+// PriorityStats groups ads by the priority they arrived with.
+type PriorityStats struct {
+	Priority int           `json:"priority"`
+	Arrived  int           `json:"arrived"`
+	Served   int           `json:"served"`
+	Unserved int           `json:"unserved"`
+	Misses   int           `json:"misses"`
+	P50      time.Duration `json:"p50"`
+	P90      time.Duration `json:"p90"`
+	P99      time.Duration `json:"p99"`
+	Max      time.Duration `json:"max"`
+	Mean     time.Duration `json:"mean"`
+}
+
+type FamilyStats struct {
+	Family   string        `json:"family"`
+	Arrived  int           `json:"arrived"`
+	Served   int           `json:"served"`
+	MeanWait time.Duration `json:"meanWait"`
+}
+
+type recorder struct {
+	name     string
+	duration time.Duration
+	workers  int
+	busy     time.Duration
+	rejects  int
+	prio     map[int]*prioAcc
+	family   map[string]*familyAcc
+}
+
+type prioAcc struct {
+	arrived, served, unserved, misses int
+	waits                             []time.Duration
+}
+
+type familyAcc struct {
+	arrived, served int
+	waited          time.Duration
+}
+
+func newRecorder(name string, sc Scenario) *recorder {
+	return &recorder{
+		name:     name,
+		duration: seconds(sc.DurationSeconds),
+		workers:  sc.Workers,
+		prio:     make(map[int]*prioAcc),
+		family:   make(map[string]*familyAcc),
+	}
+}
+
+func (r *recorder) accs(j *job) (*prioAcc, *familyAcc) {
+	p, ok := r.prio[j.priority]
+	if !ok {
+		p = &prioAcc{}
+		r.prio[j.priority] = p
+	}
+	f, ok := r.family[j.family]
+	if !ok {
+		f = &familyAcc{}
+		r.family[j.family] = f
+	}
+	return p, f
+}
+
+func (r *recorder) arrived(j *job) {
+	p, f := r.accs(j)
+	p.arrived++
+	f.arrived++
+}
+
+func (r *recorder) rejected(*job) { r.rejects++ }
+
+// missed reports whether waiting waited breaks maxWait seconds; 0 means the
+// ad has no deadline.
+func missed(waited time.Duration, maxWait int) bool {
+	return maxWait > 0 && waited > time.Duration(maxWait)*time.Second
+}
+
+func (r *recorder) served(j *job, waited time.Duration, maxWait int) {
+	p, f := r.accs(j)
+	p.served++
+	p.waits = append(p.waits, waited)
+	if missed(waited, maxWait) {
+		p.misses++
+	}
+	f.served++
+	f.waited += waited
+}
+
+func (r *recorder) unserved(j *job, waited time.Duration, maxWait int) {
+	p, _ := r.accs(j)
+	p.unserved++
+	if missed(waited, maxWait) {
+		p.misses++
+	}
+}
+
+func (r *recorder) report() Report {
+	rep := Report{Name: r.name, Rejected: r.rejects}
+	levels := make([]int, 0, len(r.prio))
+	for p := range r.prio {
+		levels = append(levels, p)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(levels)))
+	for _, level := range levels {
+		a := r.prio[level]
+		sort.Slice(a.waits, func(i, j int) bool { return a.waits[i] < a.waits[j] })
+		st := PriorityStats{
+			Priority: level,
+			Arrived:  a.arrived,
+			Served:   a.served,
+			Unserved: a.unserved,
+			Misses:   a.misses,
+			P50:      percentile(a.waits, 50),
+			P90:      percentile(a.waits, 90),
+			P99:      percentile(a.waits, 99),
+		}
+		if n := len(a.waits); n > 0 {
+			st.Max = a.waits[n-1]
+			var sum time.Duration
+			for _, w := range a.waits {
+				sum += w
+			}
+			st.Mean = sum / time.Duration(n)
+		}
+		rep.Priorities = append(rep.Priorities, st)
+		rep.Arrived += a.arrived
+		rep.Served += a.served
+		rep.Unserved += a.unserved
+		rep.DeadlineMisses += a.misses
+	}
+
+	families := make([]string, 0, len(r.family))
+	for f := range r.family {
+		families = append(families, f)
+	}
+	sort.Strings(families)
+	var shares, waits []float64
+	for _, name := range families {
+		f := r.family[name]
+		st := FamilyStats{Family: name, Arrived: f.arrived, Served: f.served}
+		if f.served > 0 {
+			st.MeanWait = f.waited / time.Duration(f.served)
+		}
+		rep.Families = append(rep.Families, st)
+		if f.arrived > 0 {
+			shares = append(shares, float64(f.served)/float64(f.arrived))
+		}
+		if f.served > 0 {
+			waits = append(waits, st.MeanWait.Seconds())
+		}
+	}
+	rep.ShareFairness = jain(shares)
+	rep.WaitFairness = jain(waits)
+
+	if r.duration > 0 {
+		rep.ThroughputPerSecond = float64(rep.Served) / r.duration.Seconds()
+		rep.Utilization = r.busy.Seconds() / (r.duration.Seconds() * float64(r.workers))
+	}
+	if n := rep.Served + rep.Unserved; n > 0 {
+		rep.MissRate = float64(rep.DeadlineMisses) / float64(n)
+	}
+	return rep
+}
+
+// WriteTable prints reports side by side, one column per config.
+func WriteTable(w io.Writer, reports []Report) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	row := func(label string, cell func(Report) string) {
+		cells := []string{label}
+		for _, r := range reports {
+			cells = append(cells, cell(r))
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t")+"\t")
+	}
+
+	row("", func(r Report) string { return r.Name })
+	row("arrived", func(r Report) string { return fmt.Sprint(r.Arrived) })
+	row("served", func(r Report) string { return fmt.Sprint(r.Served) })
+	row("unserved", func(r Report) string { return fmt.Sprint(r.Unserved) })
+	row("rejected", func(r Report) string { return fmt.Sprint(r.Rejected) })
+	row("throughput/s", func(r Report) string { return fmt.Sprintf("%.3f", r.ThroughputPerSecond) })
+	row("utilization", func(r Report) string { return fmt.Sprintf("%.1f%%", r.Utilization*100) })
+	row("deadline misses", func(r Report) string {
+		return fmt.Sprintf("%d (%.1f%%)", r.DeadlineMisses, r.MissRate*100)
+	})
+	row("fairness share", func(r Report) string { return fmt.Sprintf("%.3f", r.ShareFairness) })
+	row("fairness wait", func(r Report) string { return fmt.Sprintf("%.3f", r.WaitFairness) })
+
+	var levels []int
+	seen := make(map[int]bool)
+	for _, r := range reports {
+		for _, p := range r.Priorities {
+			if !seen[p.Priority] {
+				seen[p.Priority] = true
+				levels = append(levels, p.Priority)
+			}
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(levels)))
+	for _, level := range levels {
+		stat := func(r Report) (PriorityStats, bool) {
+			for _, p := range r.Priorities {
+				if p.Priority == level {
+					return p, true
+				}
+			}
+			return PriorityStats{}, false
+		}
+		cell := func(f func(PriorityStats) string) func(Report) string {
+			return func(r Report) string {
+				if p, ok := stat(r); ok {
+					return f(p)
+				}
+				return "-"
+			}
+		}
+		prefix := fmt.Sprintf("p%d ", level)
+		row(prefix+"served", cell(func(p PriorityStats) string { return fmt.Sprintf("%d/%d", p.Served, p.Arrived) }))
+		row(prefix+"wait p50/p90/p99", cell(func(p PriorityStats) string {
+			return fmt.Sprintf("%s/%s/%s", round(p.P50), round(p.P90), round(p.P99))
+		}))
+		row(prefix+"wait max", cell(func(p PriorityStats) string { return round(p.Max) }))
+		row(prefix+"misses", cell(func(p PriorityStats) string { return fmt.Sprint(p.Misses) }))
+	}
+	return tw.Flush()
+}
+
+func round(d time.Duration) string {
+	if d >= time.Minute {
+		return d.Round(time.Second).String()
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
@@ -0,0 +1,294 @@
+// Package sim runs a VideoProcessingQueue against a synthetic workload on a
+// virtual clock, so scheduling settings can be compared offline.
+package sim
+
+import (
+	"container/heap"
+	"fmt"
+	"icetea/priority_queue/config"
+	"icetea/priority_queue/internal/ads"
+	"icetea/priority_queue/internal/queue"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario describes the workload: who arrives, how long they take to serve
+// and how many workers serve them.
+type Scenario struct {
+	DurationSeconds float64   `yaml:"durationSeconds"`
+	Seed            int64     `yaml:"seed"`
+	Workers         int       `yaml:"workers"`
+	Service         Service   `yaml:"service"`
+	Arrivals        []Arrival `yaml:"arrivals"`
+	// Configs are the queue settings to compare. Every config sees the same
+	// arrivals and service times.
+	Configs []Variant `yaml:"configs,omitempty"`
+}
+
+// Arrival is a Poisson stream of ads for one family and priority.
+type Arrival struct {
+	Family         string  `yaml:"family"`
+	Priority       int     `yaml:"priority"`
+	RatePerSecond  float64 `yaml:"ratePerSecond"`
+	MaxWaitSeconds int     `yaml:"maxWaitSeconds,omitempty"`
+	// ServiceSeconds overrides Service.MeanSeconds for this stream.
+	ServiceSeconds float64 `yaml:"serviceSeconds,omitempty"`
+}
+
+// Service models processing time per ad.
+type Service struct {
+	Distribution string  `yaml:"distribution"` // exponential (default) or constant
+	MeanSeconds  float64 `yaml:"meanSeconds"`
+}
+
+// Variant is one named queue config.
+type Variant struct {
+	Name   string        `yaml:"name"`
+	Config config.Config `yaml:"config"`
+}
+
+const (
+	Exponential = "exponential"
+	Constant    = "constant"
+)
+
+// LoadScenario reads a scenario from YAML.
+func LoadScenario(path string) (Scenario, error) {
+	var sc Scenario
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return sc, err
+	}
+	if err := yaml.Unmarshal(b, &sc); err != nil {
+		return sc, err
+	}
+	return sc, sc.Validate()
+}
+
+// withDefaults fills settings a config left out: enough levels for every
+// stream and a maximum wait that does not clamp any stream's deadline.
+func (sc Scenario) withDefaults(cfg config.Config) config.Config {
+	maxWait := 0
+	for _, a := range sc.Arrivals {
+		if cfg.TotalPriority < a.Priority {
+			cfg.TotalPriority = a.Priority
+		}
+		if a.MaxWaitSeconds > maxWait {
+			maxWait = a.MaxWaitSeconds
+		}
+	}
+	if cfg.MaximumWaitSeconds <= 0 {
+		cfg.MaximumWaitSeconds = maxWait
+	}
+	return cfg
+}
+
+func (sc Scenario) Validate() error {
+	if sc.DurationSeconds <= 0 {
+		return fmt.Errorf("durationSeconds must be > 0")
+	}
+	if sc.Workers <= 0 {
+		return fmt.Errorf("workers must be > 0")
+	}
+	switch sc.Service.Distribution {
+	case "", Exponential, Constant:
+	default:
+		return fmt.Errorf("service: unknown distribution %q", sc.Service.Distribution)
+	}
+	if len(sc.Arrivals) == 0 {
+		return fmt.Errorf("no arrivals")
+	}
+	for _, v := range sc.Configs {
+		if err := config.ValidateClasses(v.Config.PriorityClasses, sc.withDefaults(v.Config).TotalPriority); err != nil {
+			return fmt.Errorf("config %q: %w", v.Name, err)
+		}
+	}
+	for i, a := range sc.Arrivals {
+		if a.RatePerSecond <= 0 {
+			return fmt.Errorf("arrivals[%d]: ratePerSecond must be > 0", i)
+		}
+		if a.ServiceSeconds <= 0 && sc.Service.MeanSeconds <= 0 {
+			return fmt.Errorf("arrivals[%d]: no service time", i)
+		}
+	}
+	return nil
+}
+
+// job is one generated ad with its arrival and service time.
+type job struct {
+	id       string
+	family   string
+	priority int
+	maxWait  int
+	arrive   time.Duration // since start
+	service  time.Duration
+}
+
+// workload draws every arrival up front so each config sees the same jobs.
+// Each stream has its own generator, so adding a stream leaves the others
+// unchanged.
+func (sc Scenario) workload() []job {
+	horizon := time.Duration(sc.DurationSeconds * float64(time.Second))
+	var jobs []job
+	for i, a := range sc.Arrivals {
+		rng := rand.New(rand.NewSource(sc.Seed + int64(i)))
+		mean := a.ServiceSeconds
+		if mean <= 0 {
+			mean = sc.Service.MeanSeconds
+		}
+		var t time.Duration
+		for n := 0; ; n++ {
+			t += seconds(rng.ExpFloat64() / a.RatePerSecond)
+			if t >= horizon {
+				break
+			}
+			service := seconds(mean)
+			if sc.Service.Distribution != Constant {
+				service = seconds(rng.ExpFloat64() * mean)
+			}
+			jobs = append(jobs, job{
+				// The stream index keeps ids unique when two streams share a
+				// family and priority.
+				id:       a.Family + "-" + strconv.Itoa(a.Priority) + "-" + strconv.Itoa(i) + "-" + strconv.Itoa(n),
+				family:   a.Family,
+				priority: a.Priority,
+				maxWait:  a.MaxWaitSeconds,
+				arrive:   t,
+				service:  service,
+			})
+		}
+	}
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].arrive < jobs[j].arrive })
+	return jobs
+}
+
+func seconds(s float64) time.Duration { return time.Duration(s * float64(time.Second)) }
+
+// start is the virtual epoch; reports only use times relative to it.
+var start = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Run simulates v for the scenario's duration. Ads still queued at the end
+// count as unserved; those already past their MaxWaitTime count as misses.
+func Run(sc Scenario, v Variant) Report {
+	clock := queue.NewFakeClock(start)
+	q := queue.NewFromConfigWithClock(sc.withDefaults(v.Config), clock)
+	horizon := start.Add(time.Duration(sc.DurationSeconds * float64(time.Second)))
+
+	rec := newRecorder(v.Name, sc)
+	jobs := sc.workload()
+	byID := make(map[string]*job, len(jobs))
+	queued := make(map[string]*ads.Ad, len(jobs))
+	idle := sc.Workers
+	var busy completions
+
+	dispatch := func() {
+		for idle > 0 {
+			ad := q.Dequeue()
+			if ad == nil {
+				return
+			}
+			j := byID[ad.AdID]
+			delete(queued, ad.AdID)
+			rec.served(j, clock.Now().Sub(start.Add(j.arrive)), ad.MaxWaitTime)
+			heap.Push(&busy, clock.Now().Add(j.service))
+			rec.busy += j.service
+			idle--
+		}
+	}
+
+	next := 0
+	for {
+		at := horizon
+		arrival := next < len(jobs) && start.Add(jobs[next].arrive).Before(at)
+		if arrival {
+			at = start.Add(jobs[next].arrive)
+		}
+		if len(busy) > 0 && busy[0].Before(at) {
+			at, arrival = busy[0], false
+		} else if !arrival {
+			break
+		}
+		clock.Advance(at.Sub(clock.Now()))
+		if arrival {
+			j := &jobs[next]
+			next++
+			ad := &ads.Ad{AdID: j.id, Title: j.id, GameFamily: j.family, Priority: j.priority, MaxWaitTime: j.maxWait}
+			byID[j.id] = j
+			rec.arrived(j)
+			if err := q.Enqueue(ad); err != nil {
+				rec.rejected(j)
+				continue
+			}
+			queued[j.id] = ad
+		} else {
+			heap.Pop(&busy)
+			idle++
+		}
+		dispatch()
+	}
+	clock.Advance(horizon.Sub(clock.Now()))
+	for id, ad := range queued {
+		j := byID[id]
+		rec.unserved(j, horizon.Sub(start.Add(j.arrive)), ad.MaxWaitTime)
+	}
+	// Work still running at the end only counts up to the horizon.
+	for _, done := range busy {
+		rec.busy -= done.Sub(horizon)
+	}
+	return rec.report()
+}
+
+// RunAll runs every config of the scenario, in order.
+func RunAll(sc Scenario) []Report {
+	out := make([]Report, 0, len(sc.Configs))
+	for _, v := range sc.Configs {
+		out = append(out, Run(sc, v))
+	}
+	return out
+}
+
+// completions is a min-heap of worker finish times.
+type completions []time.Time
+
+func (c completions) Len() int           { return len(c) }
+func (c completions) Less(i, j int) bool { return c[i].Before(c[j]) }
+func (c completions) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c *completions) Push(x any)        { *c = append(*c, x.(time.Time)) }
+func (c *completions) Pop() any {
+	old := *c
+	x := old[len(old)-1]
+	*c = old[:len(old)-1]
+	return x
+}
+
+// jain is Jain's fairness index of xs: 1 when all are equal, 1/n when one
+// takes everything.
+func jain(xs []float64) float64 {
+	var sum, sq float64
+	for _, x := range xs {
+		sum += x
+		sq += x * x
+	}
+	if sq == 0 {
+		return 1
+	}
+	return sum * sum / (float64(len(xs)) * sq)
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,100 @@
+package sim
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"icetea/priority_queue/config"
+)
+
+// overloaded: high priority alone keeps the workers ~80% busy, so low
+// priority only gets through when anti-starvation lets it.
+var overloaded = Scenario{
+	DurationSeconds: 1800,
+	Seed:            7,
+	Workers:         2,
+	Service:         Service{Distribution: Exponential, MeanSeconds: 1},
+	Arrivals: []Arrival{
+		{Family: "RPG", Priority: 2, RatePerSecond: 1.6, MaxWaitSeconds: 30},
+		{Family: "Casual", Priority: 1, RatePerSecond: 0.6, MaxWaitSeconds: 60},
+	},
+	Configs: []Variant{
+		{Name: "strict", Config: config.Config{EnableAntiStarvation: false}},
+		{Name: "aging", Config: config.Config{EnableAntiStarvation: true, TimeBoost: 2}},
+	},
+}
+
+func TestRun_DeterministicAndConsistent(t *testing.T) {
+	first, second := RunAll(overloaded), RunAll(overloaded)
+	if !reflect.DeepEqual(first, second) {
+		t.Fatal("same scenario and seed gave different reports")
+	}
+	for _, r := range first {
+		if r.Arrived != first[0].Arrived {
+			t.Fatalf("%s saw %d arrivals, %s saw %d", r.Name, r.Arrived, first[0].Name, first[0].Arrived)
+		}
+		if r.Served+r.Unserved+r.Rejected != r.Arrived {
+			t.Fatalf("%s: served %d + unserved %d + rejected %d != arrived %d", r.Name, r.Served, r.Unserved, r.Rejected, r.Arrived)
+		}
+		if r.Utilization <= 0 || r.Utilization > 1 {
+			t.Fatalf("%s: utilization %.3f", r.Name, r.Utilization)
+		}
+	}
+}
+
+// Streams with the same family and priority still get distinct job ids.
+func TestWorkload_UniqueIDs(t *testing.T) {
+	sc := Scenario{
+		DurationSeconds: 60,
+		Seed:            1,
+		Service:         Service{Distribution: Constant, MeanSeconds: 1},
+		Arrivals: []Arrival{
+			{Family: "RPG", Priority: 2, RatePerSecond: 2, MaxWaitSeconds: 10},
+			{Family: "RPG", Priority: 2, RatePerSecond: 2, MaxWaitSeconds: 60, ServiceSeconds: 5},
+		},
+	}
+	seen := map[string]bool{}
+	for _, j := range sc.workload() {
+		if seen[j.id] {
+			t.Fatalf("job id %s used twice", j.id)
+		}
+		seen[j.id] = true
+	}
+}
+
+func TestRun_AntiStarvationServesLowPriority(t *testing.T) {
+	reports := RunAll(overloaded)
+	strict, aging := reports[0], reports[1]
+	low := func(r Report) PriorityStats { return r.Priorities[len(r.Priorities)-1] }
+
+	if low(aging).Served <= low(strict).Served {
+		t.Fatalf("low priority served: aging %d, strict %d", low(aging).Served, low(strict).Served)
+	}
+	if low(aging).Max >= low(strict).Max {
+		t.Fatalf("low priority max wait: aging %s, strict %s", low(aging).Max, low(strict).Max)
+	}
+	if aging.ShareFairness < strict.ShareFairness {
+		t.Fatalf("share fairness: aging %.3f < strict %.3f", aging.ShareFairness, strict.ShareFairness)
+	}
+
+	var out bytes.Buffer
+	if err := WriteTable(&out, reports); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"strict", "aging", "p1 wait p50/p90/p99", "deadline misses"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("table lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestJain(t *testing.T) {
+	if got := jain([]float64{1, 1, 1, 1}); got != 1 {
+		t.Fatalf("equal shares: %v", got)
+	}
+	if got := jain([]float64{1, 0, 0, 0}); got != 0.25 {
+		t.Fatalf("one takes all: %v", got)
+	}
+}
@@ -0,0 +1,94 @@
+package trace
+
+// Mismatch is one dequeue that handed out different ads.
+type Mismatch struct {
+	Index int    `json:"index"`
+	A     string `json:"a"`
+	B     string `json:"b"`
+}
+
+// Diff compares two dequeue orders position by position. Because a single
+// early difference shifts everything after it, it also measures how far ads
+// handed out by both moved.
+type Diff struct {
+	Compared   int        `json:"compared"`
+	Differ     int        `json:"differ"`
+	First      int        `json:"first"` // index of the first difference, -1 if none
+	Mismatches []Mismatch `json:"mismatches"`
+	Moved      int        `json:"moved"` // ads dequeued by both at different positions
+	MaxShift   int        `json:"maxShift"`
+	MeanShift  float64    `json:"meanShift"` // over moved ads
+	OnlyA      int        `json:"onlyA"`     // ads only a handed out
+	OnlyB      int        `json:"onlyB"`
+}
+
+// Compare diffs dequeue orders a and b, keeping at most limit mismatches.
+// Empty IDs stand for dequeues that found nothing.
+func Compare(a, b []string, limit int) Diff {
+	d := Diff{First: -1}
+	n := max(len(a), len(b))
+	at := func(s []string, i int) string {
+		if i < len(s) {
+			return s[i]
+		}
+		return ""
+	}
+	for i := 0; i < n; i++ {
+		x, y := at(a, i), at(b, i)
+		d.Compared++
+		if x == y {
+			continue
+		}
+		d.Differ++
+		if d.First < 0 {
+			d.First = i
+		}
+		if len(d.Mismatches) < limit {
+			d.Mismatches = append(d.Mismatches, Mismatch{Index: i, A: x, B: y})
+		}
+	}
+
+	posB := make(map[string]int, len(b))
+	for i, id := range b {
+		if id != "" {
+			posB[id] = i
+		}
+	}
+	seen := make(map[string]bool, len(a))
+	total := 0
+	for i, id := range a {
+		if id == "" {
+			continue
+		}
+		seen[id] = true
+		j, ok := posB[id]
+		if !ok {
+			d.OnlyA++
+			continue
+		}
+		if shift := abs(i - j); shift > 0 {
+			d.Moved++
+			total += shift
+			d.MaxShift = max(d.MaxShift, shift)
+		}
+	}
+	for id := range posB {
+		if !seen[id] {
+			d.OnlyB++
+		}
+	}
+	if d.Moved > 0 {
+		d.MeanShift = float64(total) / float64(d.Moved)
+	}
+	return d
+}
+
+// Equal reports whether both orders were identical.
+func (d Diff) Equal() bool { return d.Differ == 0 }
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,106 @@
+package trace
+
+import (
+	"fmt"
+	"icetea/priority_queue/config"
+	"icetea/priority_queue/internal/queue"
+	"time"
+)
+
+// Options control a replay.
+type Options struct {
+	// Config replaces the recorded config. Its settings also override the
+	// ones captured in the recorded queue state.
+	Config *config.Config
+	// Speed paces the replay against the wall clock: 1 is real time, 2 twice
+	// as fast. 0 replays as fast as possible.
+	Speed float64
+	// Started, if set, is called with the fresh queue before the first event,
+	// e.g. to serve it over HTTP while the replay runs.
+	Started func(*queue.VideoProcessingQueue)
+}
+
+// Outcome is what a replay produced.
+type Outcome struct {
+	Commands int
+	Reads    int
+	// Dequeued holds the ad ID each dequeue handed out, "" when it found
+	// nothing; Recorded holds the same for the recording.
+	Dequeued []string
+	Recorded []string
+	// ErrorMismatches counts commands that failed in the replay but not in
+	// the recording, or the other way round.
+	ErrorMismatches int
+	Span            time.Duration // virtual time covered by the trace
+}
+
+// Replay applies the commands of a trace, in order and at their recorded
+// times, to a fresh queue on a FakeClock. Timers such as boost expiry fire as
+// virtual time passes, just as they did on the server.
+func Replay(events []Event, opts Options) (Outcome, error) {
+	var out Outcome
+	if len(events) == 0 || events[0].Config == nil {
+		return out, fmt.Errorf("trace has no header")
+	}
+	head := events[0]
+	cfg := *head.Config
+	if opts.Config != nil {
+		cfg = *opts.Config
+	}
+	clock := queue.NewFakeClock(head.T)
+	q := queue.NewFromConfigWithClock(cfg, clock)
+	if head.Snapshot != nil {
+		q.Restore(*head.Snapshot)
+		if opts.Config != nil {
+			for _, cmd := range []queue.Command{
+				{Op: queue.OpSetTotalPriority, Value: cfg.TotalPriority, Strategy: queue.RemapClamp},
+				{Op: queue.OpSetAntiStarvation, Enable: cfg.EnableAntiStarvation},
+				{Op: queue.OpSetMaximumWait, Value: cfg.MaximumWaitSeconds},
+			} {
+				if _, err := q.Apply(cmd); err != nil {
+					return out, fmt.Errorf("apply config: %w", err)
+				}
+			}
+		}
+	}
+	if opts.Started != nil {
+		opts.Started(q)
+	}
+
+	wallStart := time.Now()
+	for _, ev := range events[1:] {
+		if opts.Speed > 0 {
+			due := wallStart.Add(time.Duration(float64(ev.T.Sub(head.T)) / opts.Speed))
+			time.Sleep(time.Until(due))
+		}
+		if d := ev.T.Sub(clock.Now()); d > 0 {
+			clock.Advance(d)
+		}
+		out.Span = ev.T.Sub(head.T)
+		if ev.Cmd == nil {
+			out.Reads++
+			continue
+		}
+
+		// Applying may fill in defaults on the ad; keep the events reusable.
+		cmd := *ev.Cmd
+		if cmd.Ad != nil {
+			ad := *cmd.Ad
+			cmd.Ad = &ad
+		}
+		res, err := q.Apply(cmd)
+		out.Commands++
+		if (err != nil) != (ev.Err != "") {
+			out.ErrorMismatches++
+		}
+		if cmd.Op == queue.OpDequeue {
+			id := ""
+			if res.Ad != nil {
+				id = res.Ad.AdID
+			}
+			out.Dequeued = append(out.Dequeued, id)
+			out.Recorded = append(out.Recorded, ev.Result)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,173 @@
+// Package trace records the API calls a server handles as JSON lines and
+// replays them against a fresh queue on a virtual clock.
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"icetea/priority_queue/config"
+	"icetea/priority_queue/internal/queue"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Event is one line of a trace. The first event carries the server config
+// and the queue state when recording started; every later event is either a
+// command with its outcome or a read.
+type Event struct {
+	T        time.Time       `json:"t"`
+	Cmd      *queue.Command  `json:"cmd,omitempty"`
+	Result   string          `json:"result,omitempty"` // dequeue: the ad ID handed out
+	Err      string          `json:"err,omitempty"`
+	Read     string          `json:"read,omitempty"` // method and request URI of a query
+	Config   *config.Config  `json:"config,omitempty"`
+	Snapshot *queue.Snapshot `json:"snapshot,omitempty"`
+}
+
+// Recorder appends events to a trace. It is safe for concurrent use; each
+// event is flushed as it is written so a crash loses at most the call in
+// flight.
+type Recorder struct {
+	order sync.Mutex // serializes Submit so the trace order is the apply order
+
+	mu  sync.Mutex
+	w   *bufio.Writer
+	enc *json.Encoder
+	c   io.Closer
+	err error
+}
+
+// NewRecorder writes the header event for q and cfg to w.
+func NewRecorder(w io.Writer, cfg config.Config, q *queue.VideoProcessingQueue) (*Recorder, error) {
+	bw := bufio.NewWriter(w)
+	r := &Recorder{w: bw, enc: json.NewEncoder(bw)}
+	if c, ok := w.(io.Closer); ok {
+		r.c = c
+	}
+	cfg.APIKeys = nil // keep secrets out of the trace
+	snap := q.Snapshot()
+	r.write(Event{T: q.Now(), Config: &cfg, Snapshot: &snap})
+	return r, r.err
+}
+
+// Create starts a new trace file in dir, named after the current time.
+func Create(dir string, cfg config.Config, q *queue.VideoProcessingQueue) (*Recorder, string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, "", err
+	}
+	path := filepath.Join(dir, "trace-"+time.Now().UTC().Format("20060102T150405Z")+".jsonl")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, "", err
+	}
+	r, err := NewRecorder(f, cfg, q)
+	if err != nil {
+		f.Close()
+		return nil, "", err
+	}
+	return r, path, nil
+}
+
+// Submit stamps cmd with the queue's time, submits it to q and records it
+// with its outcome. Traced commands run one at a time, so concurrent callers
+// are recorded in the order the queue applied them.
+func (r *Recorder) Submit(q queue.Queue, cmd queue.Command) (queue.Result, error) {
+	r.order.Lock()
+	defer r.order.Unlock()
+	if cmd.At.IsZero() {
+		cmd.At = q.Now()
+	}
+	// Enqueue fills in defaults on the ad it is given; record what the
+	// caller sent.
+	rec := cmd
+	if cmd.Ad != nil {
+		ad := *cmd.Ad
+		rec.Ad = &ad
+	}
+	res, err := q.Submit(cmd)
+	r.command(rec, res, err)
+	return res, err
+}
+
+func (r *Recorder) command(cmd queue.Command, res queue.Result, err error) {
+	ev := Event{T: cmd.At, Cmd: &cmd}
+	if cmd.Op == queue.OpDequeue && res.Ad != nil {
+		ev.Result = res.Ad.AdID
+	}
+	if err != nil {
+		ev.Err = err.Error()
+	}
+	r.write(ev)
+}
+
+// Read records a query that did not change the queue.
+func (r *Recorder) Read(at time.Time, method, uri string) {
+	r.write(Event{T: at, Read: method + " " + uri})
+}
+
+func (r *Recorder) write(ev Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	if err := r.enc.Encode(ev); err != nil {
+		r.err = err
+		return
+	}
+	r.err = r.w.Flush()
+}
+
+// Err returns the first write error; recording stops after it.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Close flushes the trace and closes the underlying writer if it has a
+// Close method.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.w.Flush()
+	if r.c != nil {
+		if cerr := r.c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// ReadEvents decodes a trace. The first event must be a header.
+func ReadEvents(r io.Reader) ([]Event, error) {
+	dec := json.NewDecoder(r)
+	var events []Event
+	for {
+		var ev Event
+		if err := dec.Decode(&ev); err == io.EOF {
+			break
+		} else if err != nil {
+			return events, fmt.Errorf("event %d: %w", len(events)+1, err)
+		}
+		events = append(events, ev)
+	}
+	if len(events) == 0 || events[0].Config == nil {
+		return events, fmt.Errorf("trace has no header")
+	}
+	return events, nil
+}
+
+// Load reads the trace file at path.
+func Load(path string) ([]Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadEvents(f)
+}
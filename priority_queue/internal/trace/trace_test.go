@@ -0,0 +1,136 @@
+package trace_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"icetea/priority_queue/config"
+	"icetea/priority_queue/internal/ads"
+	"icetea/priority_queue/internal/httpapi"
+	"icetea/priority_queue/internal/queue"
+	"icetea/priority_queue/internal/trace"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// record drives a traced server on a fake clock: a mix of priorities, a
+// boost that expires by timer, a purge and reads, with dequeues in between.
+func record(t *testing.T, cfg config.Config) ([]trace.Event, []string) {
+	t.Helper()
+	clock := queue.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	q := queue.NewFromConfigWithClock(cfg, clock)
+	var buf bytes.Buffer
+	rec, err := trace.NewRecorder(&buf, cfg, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := (&httpapi.Handler{Q: q, Cfg: cfg, Trace: rec}).Router()
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		if w.Code >= 300 && w.Code != http.StatusNotFound {
+			t.Fatalf("%s %s: %d %s", method, path, w.Code, w.Body)
+		}
+		return w
+	}
+	var served []string
+	dequeue := func() {
+		w := call("POST", "/dequeue", "")
+		if w.Code == http.StatusOK {
+			var ad ads.Ad
+			if err := json.Unmarshal(w.Body.Bytes(), &ad); err != nil {
+				t.Fatal(err)
+			}
+			served = append(served, ad.AdID)
+		}
+	}
+
+	families := []string{"RPG", "Puzzle", "Casual"}
+	for i := 0; i < 30; i++ {
+		call("POST", "/enqueue", fmt.Sprintf(`{"ad":{"adId":"ad-%d","title":"t","gameFamily":%q,"priority":%d,"maxWaitTime":%d}}`,
+			i, families[i%3], 1+i%3, 5+i%4*5))
+		clock.Advance(time.Second)
+		if i%4 == 3 {
+			dequeue()
+		}
+		if i == 10 {
+			call("POST", "/boosts", `{"family":"Casual","delta":2,"duration":"4s"}`)
+		}
+	}
+	call("GET", "/peek?n=3", "")
+	call("POST", "/admin/purge", `{"family":"Puzzle","olderThan":"5s","export":"response"}`)
+	for i := 0; i < 25; i++ {
+		clock.Advance(500 * time.Millisecond)
+		dequeue()
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	events, err := trace.ReadEvents(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events, served
+}
+
+var aging = config.Config{TotalPriority: 3, EnableAntiStarvation: true, MaximumWaitSeconds: 60, BTreeDegree: 4, TimeBoost: 2}
+
+func TestReplay_ReproducesRecordedOrder(t *testing.T) {
+	events, served := record(t, aging)
+	out, err := trace.Replay(events, trace.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Reads != 1 || out.ErrorMismatches != 0 {
+		t.Fatalf("reads %d, error mismatches %d", out.Reads, out.ErrorMismatches)
+	}
+	if d := trace.Compare(out.Recorded, out.Dequeued, 5); !d.Equal() {
+		t.Fatalf("replay diverged from the recording: %+v", d)
+	}
+	var got []string
+	for _, id := range out.Dequeued {
+		if id != "" {
+			got = append(got, id)
+		}
+	}
+	if fmt.Sprint(got) != fmt.Sprint(served) {
+		t.Fatalf("replay served %v\nserver served %v", got, served)
+	}
+}
+
+func TestReplay_AlternativeConfigDiffers(t *testing.T) {
+	events, _ := record(t, aging)
+	strict := aging
+	strict.EnableAntiStarvation = false
+
+	base, err := trace.Replay(events, trace.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alt, err := trace.Replay(events, trace.Options{Config: &strict})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := trace.Compare(base.Dequeued, alt.Dequeued, 5)
+	if d.Equal() || d.Moved == 0 {
+		t.Fatalf("turning off anti-starvation changed nothing: %+v", d)
+	}
+	// Replaying does not consume the trace.
+	again, _ := trace.Replay(events, trace.Options{})
+	if !trace.Compare(base.Dequeued, again.Dequeued, 0).Equal() {
+		t.Fatal("second replay of the same trace differs")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	d := trace.Compare([]string{"a", "b", "c", ""}, []string{"b", "a", "c", "d"}, 1)
+	if d.Differ != 3 || d.First != 0 || len(d.Mismatches) != 1 {
+		t.Fatalf("%+v", d)
+	}
+	if d.Moved != 2 || d.MaxShift != 1 || d.OnlyA != 0 || d.OnlyB != 1 {
+		t.Fatalf("%+v", d)
+	}
+}
@@ -0,0 +1,543 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Health checks that the server is up.
+func (c *Client) Health(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "/healthz", nil, nil, nil)
+}
+
+// Enqueue adds an ad and returns it as queued, with defaults such as
+// MaxWaitTime filled in, and its position.
+func (c *Client) Enqueue(ctx context.Context, req EnqueueRequest) (*Queued, error) {
+	type wireAd struct {
+		Ad
+		Priority any `json:"priority"`
+	}
+	body := struct {
+		Ad        wireAd     `json:"ad"`
+		EnqueueAt *time.Time `json:"enqueueAt,omitempty"`
+	}{Ad: wireAd{Ad: req.Ad, Priority: req.Ad.Priority}, EnqueueAt: req.EnqueueAt}
+	if req.Class != "" {
+		body.Ad.Priority = req.Class
+	}
+	var out Queued
+	if err := c.do(ctx, http.MethodPost, "/v1/enqueue", nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Position reports where a queued ad stands, or ErrNotFound once it is no
+// longer queued.
+func (c *Client) Position(ctx context.Context, adID string) (*Queued, error) {
+	var out Queued
+	if err := c.do(ctx, http.MethodGet, "/v1/ads/"+url.PathEscape(adID)+"/position", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Dequeue removes and returns the next ad, or ErrQueueEmpty.
+func (c *Client) Dequeue(ctx context.Context) (*Ad, error) {
+	var ad Ad
+	if err := c.do(ctx, http.MethodPost, "/v1/dequeue", nil, nil, &ad); err != nil {
+		return nil, emptyQueue(err)
+	}
+	return &ad, nil
+}
+
+// DequeueLease leases the next ad for d, or returns ErrQueueEmpty. The ad
+// goes back to its old position unless Ack is called before the lease ends.
+func (c *Client) DequeueLease(ctx context.Context, d time.Duration) (*Lease, error) {
+	var resp struct {
+		Ad
+		LeaseID        int64     `json:"leaseId"`
+		LeaseExpiresAt time.Time `json:"leaseExpiresAt"`
+	}
+	q := url.Values{"lease": {d.String()}}
+	if err := c.do(ctx, http.MethodPost, "/v1/dequeue", q, nil, &resp); err != nil {
+		return nil, emptyQueue(err)
+	}
+	return &Lease{Ad: resp.Ad, ID: resp.LeaseID, ExpiresAt: resp.LeaseExpiresAt}, nil
+}
+
+// The server answers an empty dequeue with 204.
+func emptyQueue(err error) error {
+	if errors.Is(err, errNoContent) {
+		return ErrQueueEmpty
+	}
+	return err
+}
+
+// Ack completes a lease. It returns ErrNotFound if the lease expired or was
+// nacked.
+func (c *Client) Ack(ctx context.Context, leaseID int64) error {
+	return c.endLease(ctx, leaseID, "ack")
+}
+
+// Nack returns a leased ad to the queue at its old position. It returns
+// ErrNotFound if the lease was acked.
+func (c *Client) Nack(ctx context.Context, leaseID int64) error {
+	return c.endLease(ctx, leaseID, "nack")
+}
+
+func (c *Client) endLease(ctx context.Context, id int64, op string) error {
+	// The server answers a repeated ack or nack of a lease like the first
+	// one, so these are retried like reads.
+	resp, err := c.send(ctx, request{
+		method:     http.MethodPost,
+		path:       "/v1/leases/" + strconv.FormatInt(id, 10) + "/" + op,
+		idempotent: true,
+	})
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (c *Client) Leases(ctx context.Context) ([]LeaseInfo, error) {
+	var out []LeaseInfo
+	return out, c.do(ctx, http.MethodGet, "/v1/leases", nil, nil, &out)
+}
+
+// Peek returns the next n ads in dequeue order without removing them.
+func (c *Client) Peek(ctx context.Context, n int) ([]Ad, error) {
+	var out []Ad
+	return out, c.do(ctx, http.MethodGet, "/v1/peek", url.Values{"n": {strconv.Itoa(n)}}, nil, &out)
+}
+
+func (c *Client) Distribution(ctx context.Context) (*Distribution, error) {
+	var out Distribution
+	if err := c.do(ctx, http.MethodGet, "/v1/distribution", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Waiting lists ads that have waited longer than age.
+func (c *Client) Waiting(ctx context.Context, age time.Duration) ([]Ad, error) {
+	var out []Ad
+	return out, c.do(ctx, http.MethodGet, "/v1/waiting", url.Values{"age": {age.String()}}, nil, &out)
+}
+
+// Scores returns every level head's anti-starvation score.
+func (c *Client) Scores(ctx context.Context) ([]HeadScore, error) {
+	var out []HeadScore
+	return out, c.do(ctx, http.MethodGet, "/v1/debug/scores", nil, nil, &out)
+}
+
+// Next is Peek with each ad's wait, deadline and anti-starvation score.
+func (c *Client) Next(ctx context.Context, n int) ([]ScoredAd, error) {
+	var out []ScoredAd
+	return out, c.do(ctx, http.MethodGet, "/v1/debug/next", url.Values{"n": {strconv.Itoa(n)}}, nil, &out)
+}
+
+func (c *Client) Stats(ctx context.Context) (*Stats, error) {
+	var out Stats
+	if err := c.do(ctx, http.MethodGet, "/v1/stats", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Ads lists queued ads oldest first.
+func (c *Client) Ads(ctx context.Context, q AdsQuery) (*AdsPage, error) {
+	v := url.Values{}
+	if q.Search != "" {
+		v.Set("search", q.Search)
+	}
+	if q.Filter != "" {
+		v.Set("filter", q.Filter)
+	}
+	if q.Offset > 0 {
+		v.Set("offset", strconv.Itoa(q.Offset))
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	var out AdsPage
+	if err := c.do(ctx, http.MethodGet, "/v1/ads", v, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Events calls fn with a DashboardEvent every interval (0 uses the server's
+// default) until ctx is done, fn returns an error, or the stream breaks. It
+// is not retried and returns nil when ctx ends it.
+func (c *Client) Events(ctx context.Context, interval time.Duration, fn func(DashboardEvent) error) error {
+	u := c.base + "/v1/events"
+	if interval > 0 {
+		u += "?" + url.Values{"interval": {interval.String()}}.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if c.key != "" {
+		req.Header.Set("Authorization", "Bearer "+c.key)
+	}
+	// The stream outlives any whole-request timeout.
+	hc := *c.http
+	hc.Timeout = 0
+	resp, err := hc.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return readError(resp, http.MethodGet, "/v1/events")
+	}
+	defer resp.Body.Close()
+
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(nil, 4<<20)
+	for sc.Scan() {
+		data, ok := strings.CutPrefix(sc.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var ev DashboardEvent
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return err
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// ReprioritizeFamily moves a game family and returns the AdIDs left in place
+// because their new level is at its class capacity.
+func (c *Client) ReprioritizeFamily(ctx context.Context, family string, req ReprioritizeRequest) ([]string, error) {
+	body := struct {
+		Family      string   `json:"family"`
+		NewPriority Priority `json:"newPriority,omitempty"`
+		Delta       int      `json:"delta,omitempty"`
+	}{family, req.NewPriority, req.Delta}
+	var out reprioritizeResult
+	err := c.do(ctx, http.MethodPost, "/v1/reprioritize/family", nil, body, &out)
+	return out.Skipped, err
+}
+
+// ReprioritizeAge moves ads that have waited longer than age. Like
+// ReprioritizeFamily it returns the ads it skipped.
+func (c *Client) ReprioritizeAge(ctx context.Context, age time.Duration, req ReprioritizeRequest) ([]string, error) {
+	body := struct {
+		Age         string   `json:"age"`
+		NewPriority Priority `json:"newPriority,omitempty"`
+		Delta       int      `json:"delta,omitempty"`
+	}{age.String(), req.NewPriority, req.Delta}
+	var out reprioritizeResult
+	err := c.do(ctx, http.MethodPost, "/v1/reprioritize/age", nil, body, &out)
+	return out.Skipped, err
+}
+
+type reprioritizeResult struct {
+	Skipped []string `json:"skipped"`
+}
+
+func (c *Client) BulkReprioritize(ctx context.Context, req BulkReprioritizeRequest) (*BulkResult, error) {
+	var out BulkResult
+	if err := c.do(ctx, http.MethodPost, "/v1/bulk/reprioritize", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// BulkRemove removes the ads matching filter, or with dryRun only lists them.
+func (c *Client) BulkRemove(ctx context.Context, filter string, dryRun bool) (*BulkResult, error) {
+	body := struct {
+		Filter string `json:"filter"`
+		DryRun bool   `json:"dryRun"`
+	}{filter, dryRun}
+	var out BulkResult
+	if err := c.do(ctx, http.MethodPost, "/v1/bulk/remove", nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Undo moves the ads of the last reprioritize back to their earlier
+// priorities. It fails with ErrNotFound when there is nothing to undo.
+func (c *Client) Undo(ctx context.Context) (*BulkResult, error) {
+	var out BulkResult
+	if err := c.do(ctx, http.MethodPost, "/v1/bulk/undo", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) CreateBoost(ctx context.Context, req BoostRequest) (*Boost, error) {
+	body := struct {
+		Family   string `json:"family,omitempty"`
+		Filter   string `json:"filter,omitempty"`
+		Delta    int    `json:"delta"`
+		Duration string `json:"duration"`
+	}{req.Family, req.Filter, req.Delta, req.Duration.String()}
+	var out Boost
+	if err := c.do(ctx, http.MethodPost, "/v1/boosts", nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) Boosts(ctx context.Context) ([]Boost, error) {
+	var out []Boost
+	return out, c.do(ctx, http.MethodGet, "/v1/boosts", nil, nil, &out)
+}
+
+// CancelBoost ends a boost early. It returns ErrNotFound if it already ended.
+func (c *Client) CancelBoost(ctx context.Context, id int64) error {
+	return c.do(ctx, http.MethodDelete, "/v1/boosts/"+strconv.FormatInt(id, 10), nil, nil, nil)
+}
+
+// Command runs a plain-English command such as "Show the next 5 ads".
+// Queries run at once; a change is only previewed unless confirm is set.
+func (c *Client) Command(ctx context.Context, text string, confirm bool) (*CommandResult, error) {
+	body := struct {
+		Text    string `json:"text"`
+		Confirm bool   `json:"confirm"`
+	}{text, confirm}
+	var out CommandResult
+	if err := c.do(ctx, http.MethodPost, "/v1/command", nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) Settings(ctx context.Context) (*Settings, error) {
+	var out Settings
+	if err := c.do(ctx, http.MethodGet, "/v1/settings", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) SetAntiStarvation(ctx context.Context, enable bool) error {
+	body := struct {
+		Enable bool `json:"enable"`
+	}{enable}
+	return c.do(ctx, http.MethodPost, "/v1/settings/antiStarvation", nil, body, nil)
+}
+
+// SetMaximumWait sets the cap on MaxWaitTime, in seconds.
+func (c *Client) SetMaximumWait(ctx context.Context, seconds int) error {
+	body := struct {
+		MaximumWait int `json:"maximumWait"`
+	}{seconds}
+	return c.do(ctx, http.MethodPost, "/v1/settings/maximumWait", nil, body, nil)
+}
+
+// SetPriorities changes the number of levels. strategy is "clamp" (or
+// empty) or "proportional" and only matters when shrinking.
+func (c *Client) SetPriorities(ctx context.Context, total int, strategy string) error {
+	body := struct {
+		TotalPriority int    `json:"totalPriority"`
+		Strategy      string `json:"strategy,omitempty"`
+	}{total, strategy}
+	return c.do(ctx, http.MethodPost, "/v1/settings/priorities", nil, body, nil)
+}
+
+func (c *Client) Pause(ctx context.Context, scope PauseScope) (*PauseState, error) {
+	return c.pause(ctx, "/v1/admin/pause", scope)
+}
+
+func (c *Client) Resume(ctx context.Context, scope PauseScope) (*PauseState, error) {
+	return c.pause(ctx, "/v1/admin/resume", scope)
+}
+
+func (c *Client) pause(ctx context.Context, path string, scope PauseScope) (*PauseState, error) {
+	var out PauseState
+	if err := c.do(ctx, http.MethodPost, path, nil, scope, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) PauseState(ctx context.Context) (*PauseState, error) {
+	var out PauseState
+	if err := c.do(ctx, http.MethodGet, "/v1/admin/pause", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SetDrain turns drain mode on or off. While draining, enqueues fail with
+// ErrUnavailable.
+func (c *Client) SetDrain(ctx context.Context, enable bool) error {
+	body := struct {
+		Enable bool `json:"enable"`
+	}{enable}
+	return c.do(ctx, http.MethodPost, "/v1/admin/drain", nil, body, nil)
+}
+
+func (c *Client) DrainStatus(ctx context.Context) (*DrainStatus, error) {
+	var out DrainStatus
+	if err := c.do(ctx, http.MethodGet, "/v1/admin/drain", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Purge removes the selected ads after exporting them. Unless
+// req.ExportToFile is set the purged records are returned.
+func (c *Client) Purge(ctx context.Context, req PurgeRequest) (*PurgeResult, error) {
+	body := struct {
+		Priority  Priority `json:"priority,omitempty"`
+		Family    string   `json:"family,omitempty"`
+		OlderThan string   `json:"olderThan,omitempty"`
+		Filter    string   `json:"filter,omitempty"`
+		All       bool     `json:"all,omitempty"`
+		Export    string   `json:"export"`
+	}{Priority: req.Priority, Family: req.Family, Filter: req.Filter, All: req.All, Export: "response"}
+	if req.OlderThan > 0 {
+		body.OlderThan = req.OlderThan.String()
+	}
+	if req.ExportToFile {
+		body.Export = "file"
+		var out struct {
+			Count      int    `json:"count"`
+			ExportFile string `json:"exportFile"`
+		}
+		if err := c.do(ctx, http.MethodPost, "/v1/admin/purge", nil, body, &out); err != nil {
+			return nil, err
+		}
+		return &PurgeResult{Count: out.Count, ExportFile: out.ExportFile}, nil
+	}
+
+	b, _ := json.Marshal(body)
+	resp, err := c.send(ctx, request{method: http.MethodPost, path: "/v1/admin/purge", body: b, contentType: "application/json"})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	recs, err := readRecords(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &PurgeResult{Count: len(recs), Records: recs}, nil
+}
+
+// Export returns every queued ad in enqueue order.
+func (c *Client) Export(ctx context.Context) ([]Record, error) {
+	resp, err := c.send(ctx, request{method: http.MethodGet, path: "/v1/admin/export"})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return readRecords(resp.Body)
+}
+
+// Import loads records, e.g. from Export or Purge, placing each ad by its
+// EnqueueAt.
+func (c *Client) Import(ctx context.Context, records []Record, opts ImportOptions) (*ImportResult, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return nil, err
+		}
+	}
+	q := url.Values{}
+	if opts.KeepSeq {
+		q.Set("keepSeq", "true")
+	}
+	if opts.SkipDuplicates {
+		q.Set("skipDuplicates", "true")
+	}
+	resp, err := c.send(ctx, request{
+		method: http.MethodPost, path: "/v1/admin/import", query: q,
+		body: buf.Bytes(), contentType: "application/x-ndjson",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var out ImportResult
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func readRecords(r io.Reader) ([]Record, error) {
+	dec := json.NewDecoder(r)
+	var out []Record
+	for {
+		var rec Record
+		if err := dec.Decode(&rec); errors.Is(err, io.EOF) {
+			return out, nil
+		} else if err != nil {
+			return out, err
+		}
+		out = append(out, rec)
+	}
+}
+
+// ClusterStatus is only served by nodes running in Raft mode.
+func (c *Client) ClusterStatus(ctx context.Context) (*ClusterStatus, error) {
+	var out ClusterStatus
+	if err := c.do(ctx, http.MethodGet, "/cluster/status", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// AddMember adds a node to the Raft cluster; send it to the leader.
+func (c *Client) AddMember(ctx context.Context, id, addr string) (*ClusterStatus, error) {
+	var out ClusterStatus
+	if err := c.do(ctx, http.MethodPost, "/cluster/members", nil, ClusterMember{ID: id, Addr: addr}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) RemoveMember(ctx context.Context, id string) (*ClusterStatus, error) {
+	var out ClusterStatus
+	if err := c.do(ctx, http.MethodDelete, "/cluster/members/"+url.PathEscape(id), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ReplicationStatus is only served by nodes with a replication role.
+func (c *Client) ReplicationStatus(ctx context.Context) (*ReplicationStatus, error) {
+	var out ReplicationStatus
+	if err := c.do(ctx, http.MethodGet, "/replication/status", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Promote turns a follower into a leader.
+func (c *Client) Promote(ctx context.Context) (*ReplicationStatus, error) {
+	var out ReplicationStatus
+	if err := c.do(ctx, http.MethodPost, "/replication/promote", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
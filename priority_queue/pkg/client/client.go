@@ -0,0 +1,299 @@
+// Package client is the Go SDK for the priority queue HTTP API.
+//
+//	c := client.New(client.Config{BaseURL: "http://localhost:8080", APIKey: key})
+//	ad, err := c.Dequeue(ctx)
+//	if errors.Is(err, client.ErrQueueEmpty) { ... }
+//
+// Requests are retried with exponential backoff when the server asks for it
+// (429, 502, 503, 504) and, for requests that are safe to repeat, after
+// network errors. WorkerPool runs a handler per dequeued ad on top of leases.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrQueueEmpty   = errors.New("queue empty")
+	ErrUnauthorized = errors.New("missing or invalid API key")        // 401
+	ErrForbidden    = errors.New("API key lacks the required role")   // 403
+	ErrNotFound     = errors.New("not found")                         // 404
+	ErrConflict     = errors.New("conflict")                          // 409
+	ErrRateLimited  = errors.New("rejected by the server, try later") // 429, e.g. class capacity
+	ErrUnavailable  = errors.New("server unavailable")                // 503: draining, follower or no quorum
+	// ErrConfirmRequired (428) means the change is over the server's
+	// guardrails. Repeat it with WithConfirmToken and APIError.ConfirmToken.
+	ErrConfirmRequired = errors.New("confirmation required")
+
+	errNoContent = errors.New("no content") // 204
+)
+
+// APIError is a non-2xx response. It unwraps to the sentinel for its status,
+// so errors.Is(err, ErrConflict) works on it.
+type APIError struct {
+	StatusCode int
+	Method     string
+	Path       string
+	Message    string // the server's error message
+	// Code is the server's machine-readable reason, like "INVALID_DURATION";
+	// RequestID identifies the request in the server's logs.
+	Code      string
+	RequestID string
+	// ConfirmToken is set with ErrConfirmRequired.
+	ConfirmToken string
+	// Fields lists the invalid fields of a rejected request (400).
+	Fields []FieldError
+}
+
+// FieldError is a request field the server rejected and why.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden:
+		return ErrForbidden
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusConflict:
+		return ErrConflict
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusServiceUnavailable:
+		return ErrUnavailable
+	case http.StatusPreconditionRequired:
+		return ErrConfirmRequired
+	}
+	return nil
+}
+
+type confirmKey struct{}
+
+// WithConfirmToken returns a context whose calls carry token, confirming a
+// change the server refused with ErrConfirmRequired:
+//
+//	err := c.BulkRemove(ctx, filter, false)
+//	var apiErr *client.APIError
+//	if errors.As(err, &apiErr) && apiErr.ConfirmToken != "" && askUser() {
+//		_, err = c.BulkRemove(client.WithConfirmToken(ctx, apiErr.ConfirmToken), filter, false)
+//	}
+func WithConfirmToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, confirmKey{}, token)
+}
+
+// Config configures a Client. Only BaseURL is required.
+type Config struct {
+	BaseURL string
+	APIKey  string // sent as a bearer token
+	// HTTPClient defaults to a client with a 10s timeout.
+	HTTPClient *http.Client
+	Retry      RetryPolicy
+}
+
+// RetryPolicy bounds retries. Zero fields take the defaults; MaxAttempts 1
+// disables retries.
+type RetryPolicy struct {
+	MaxAttempts int           // total tries per call, default 4
+	MinBackoff  time.Duration // first delay, default 100ms
+	MaxBackoff  time.Duration // cap on any delay, default 5s
+}
+
+// Client calls one queue server. It is safe for concurrent use.
+type Client struct {
+	base  string
+	key   string
+	http  *http.Client
+	retry RetryPolicy
+}
+
+func New(cfg Config) *Client {
+	c := &Client{
+		base:  strings.TrimRight(cfg.BaseURL, "/"),
+		key:   cfg.APIKey,
+		http:  cfg.HTTPClient,
+		retry: cfg.Retry,
+	}
+	if c.http == nil {
+		c.http = &http.Client{Timeout: 10 * time.Second}
+	}
+	if c.retry.MaxAttempts <= 0 {
+		c.retry.MaxAttempts = 4
+	}
+	if c.retry.MinBackoff <= 0 {
+		c.retry.MinBackoff = 100 * time.Millisecond
+	}
+	if c.retry.MaxBackoff <= 0 {
+		c.retry.MaxBackoff = 5 * time.Second
+	}
+	return c
+}
+
+// request is one API call.
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	body        []byte
+	contentType string
+	// idempotent calls are also retried after network errors, when the
+	// server may or may not have seen them.
+	idempotent bool
+}
+
+// send performs r with retries and returns the successful response, whose
+// body the caller must close.
+func (c *Client) send(ctx context.Context, r request) (*http.Response, error) {
+	u := c.base + r.path
+	if len(r.query) > 0 {
+		u += "?" + r.query.Encode()
+	}
+	idempotent := r.idempotent || r.method == http.MethodGet || r.method == http.MethodDelete
+
+	for attempt := 1; ; attempt++ {
+		var body io.Reader
+		if r.body != nil {
+			body = bytes.NewReader(r.body)
+		}
+		req, err := http.NewRequestWithContext(ctx, r.method, u, body)
+		if err != nil {
+			return nil, err
+		}
+		if r.body != nil {
+			req.Header.Set("Content-Type", r.contentType)
+		}
+		if c.key != "" {
+			req.Header.Set("Authorization", "Bearer "+c.key)
+		}
+		if token, _ := ctx.Value(confirmKey{}).(string); token != "" {
+			req.Header.Set("X-Confirm-Token", token)
+		}
+
+		resp, err := c.http.Do(req)
+		var retryAfter time.Duration
+		switch {
+		case err != nil:
+			if ctx.Err() != nil || !idempotent || attempt >= c.retry.MaxAttempts {
+				return nil, err
+			}
+		case resp.StatusCode < 300:
+			return resp, nil
+		default:
+			apiErr := readError(resp, r.method, r.path)
+			if !retryable(resp.StatusCode) || attempt >= c.retry.MaxAttempts {
+				return nil, apiErr
+			}
+			if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+				retryAfter = time.Duration(s) * time.Second
+			}
+		}
+
+		if err := sleep(ctx, max(retryAfter, c.backoff(attempt))); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff is exponential with full jitter.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.retry.MinBackoff << (attempt - 1)
+	if d <= 0 || d > c.retry.MaxBackoff {
+		d = c.retry.MaxBackoff
+	}
+	return rand.N(d) + 1
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func readError(resp *http.Response, method, path string) *APIError {
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	e := &APIError{StatusCode: resp.StatusCode, Method: method, Path: path}
+	// /v1 errors are envelopes; the details of a validation or guardrail
+	// error are flattened into the older bodies.
+	type details struct {
+		ConfirmToken string       `json:"confirmToken"`
+		Fields       []FieldError `json:"fields"`
+	}
+	var body struct {
+		Code      string  `json:"code"`
+		Message   string  `json:"message"`
+		Details   details `json:"details"`
+		RequestID string  `json:"requestId"`
+		Error     string  `json:"error"`
+		details
+	}
+	switch {
+	case json.Unmarshal(b, &body) != nil:
+		e.Message = strings.TrimSpace(string(b))
+	case body.Message != "":
+		e.Code, e.Message, e.RequestID = body.Code, body.Message, body.RequestID
+		e.ConfirmToken, e.Fields = body.Details.ConfirmToken, body.Details.Fields
+	case body.Error != "":
+		e.Message, e.ConfirmToken, e.Fields = body.Error, body.ConfirmToken, body.Fields
+	default:
+		e.Message = strings.TrimSpace(string(b))
+	}
+	return e
+}
+
+// do sends in as JSON (if non-nil) and decodes the response into out (if
+// non-nil).
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out any) error {
+	r := request{method: method, path: path, query: query}
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		r.body, r.contentType = b, "application/json"
+	}
+	resp, err := c.send(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		return errNoContent
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
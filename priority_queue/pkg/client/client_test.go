@@ -0,0 +1,603 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"icetea/priority_queue/config"
+	"icetea/priority_queue/internal/httpapi"
+	"icetea/priority_queue/internal/queue"
+	"icetea/priority_queue/pkg/client"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testConfig = config.Config{
+	TotalPriority:      3,
+	MaximumWaitSeconds: 600,
+	BTreeDegree:        16,
+	PriorityClasses:    []config.PriorityClass{{Name: "urgent", Level: 3}},
+}
+
+func newServer(t *testing.T, cfg config.Config) (*client.Client, *queue.VideoProcessingQueue) {
+	t.Helper()
+	q := queue.NewFromConfig(cfg)
+	srv := httptest.NewServer((&httpapi.Handler{Q: q, Cfg: cfg}).Router())
+	t.Cleanup(srv.Close)
+	return client.New(client.Config{BaseURL: srv.URL}), q
+}
+
+func ad(id, family string, priority int) client.Ad {
+	return client.Ad{AdID: id, Title: id, GameFamily: family, Priority: priority}
+}
+
+func TestClient_QueueOperations(t *testing.T) {
+	c, _ := newServer(t, testConfig)
+	ctx := context.Background()
+
+	if _, err := c.Enqueue(ctx, client.EnqueueRequest{Ad: ad("low", "RPG", 1)}); err != nil {
+		t.Fatal(err)
+	}
+	var apiErr *client.APIError
+	if _, err := c.Enqueue(ctx, client.EnqueueRequest{Ad: ad("", "RPG", 1)}); !errors.As(err, &apiErr) ||
+		apiErr.StatusCode != http.StatusBadRequest || apiErr.Code != "INVALID_REQUEST" || apiErr.RequestID == "" ||
+		len(apiErr.Fields) != 1 || apiErr.Fields[0].Field != "ad.adId" {
+		t.Fatalf("Enqueue without adId = %v, want a 400 naming ad.adId", err)
+	}
+	got, err := c.Enqueue(ctx, client.EnqueueRequest{Ad: ad("top", "Puzzle", 0), Class: "urgent"})
+	if err != nil || got.Priority != 3 || got.Seq != 2 || got.Position != 1 || got.Queued != 2 {
+		t.Fatalf("Enqueue by class = %+v, %v", got, err)
+	}
+	if pos, err := c.Position(ctx, "low"); err != nil || pos.AdID != "low" || pos.Seq != 1 || pos.Position != 2 {
+		t.Fatalf("Position(low) = %+v, %v", pos, err)
+	}
+	if peek, err := c.Peek(ctx, 5); err != nil || len(peek) != 2 || peek[0].AdID != "top" {
+		t.Fatalf("Peek = %v, %v", peek, err)
+	}
+	if skipped, err := c.ReprioritizeFamily(ctx, "RPG", client.ReprioritizeRequest{NewPriority: "urgent"}); err != nil || len(skipped) != 0 {
+		t.Fatal(skipped, err)
+	}
+	dist, err := c.Distribution(ctx)
+	if err != nil || dist.Total != 2 || dist.Levels[0].Count != 2 || dist.Levels[0].Name != "urgent" {
+		t.Fatalf("Distribution = %+v, %v", dist, err)
+	}
+
+	recs, err := c.Export(ctx)
+	if err != nil || len(recs) != 2 {
+		t.Fatalf("Export = %v, %v", recs, err)
+	}
+	for _, want := range []string{"low", "top"} {
+		if got, err := c.Dequeue(ctx); err != nil || got.AdID != want {
+			t.Fatalf("Dequeue = %+v, %v; want %s", got, err, want)
+		}
+	}
+	if _, err := c.Position(ctx, "low"); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("Position of a dequeued ad = %v, want ErrNotFound", err)
+	}
+	if _, err := c.Dequeue(ctx); !errors.Is(err, client.ErrQueueEmpty) {
+		t.Fatalf("empty Dequeue error = %v, want ErrQueueEmpty", err)
+	}
+	if res, err := c.Import(ctx, recs, client.ImportOptions{KeepSeq: true}); err != nil || res.Imported != 2 {
+		t.Fatalf("Import = %+v, %v", res, err)
+	}
+
+	// Shrinking below the urgent class is a conflict; a bad filter is not.
+	if err := c.SetPriorities(ctx, 2, ""); !errors.Is(err, client.ErrConflict) {
+		t.Fatalf("SetPriorities(2) error = %v, want ErrConflict", err)
+	}
+	if _, err := c.BulkRemove(ctx, "family ~ x", false); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("bad filter error = %v, want a 400 APIError", err)
+	}
+	if err := c.CancelBoost(ctx, 42); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("CancelBoost(42) error = %v, want ErrNotFound", err)
+	}
+
+	purged, err := c.Purge(ctx, client.PurgeRequest{Family: "Puzzle"})
+	if err != nil || purged.Count != 1 || purged.Records[0].Ad.AdID != "top" {
+		t.Fatalf("Purge = %+v, %v", purged, err)
+	}
+}
+
+func TestClient_Auth(t *testing.T) {
+	cfg := testConfig
+	cfg.APIKeys = []config.APIKey{
+		{Name: "dash", Key: "r-key", Role: config.RoleReader},
+		{Name: "svc", Key: "o-key", Role: config.RoleOperator},
+		{Name: "ops", Key: "a-key", Role: config.RoleAdmin},
+	}
+	q := queue.NewFromConfig(cfg)
+	srv := httptest.NewServer((&httpapi.Handler{Q: q, Cfg: cfg}).Router())
+	defer srv.Close()
+	as := func(key string) *client.Client {
+		return client.New(client.Config{BaseURL: srv.URL, APIKey: key})
+	}
+	ctx := context.Background()
+
+	if err := as("").Health(ctx); err != nil {
+		t.Fatalf("health check needs no key: %v", err)
+	}
+	if _, err := as("wrong").Peek(ctx, 1); !errors.Is(err, client.ErrUnauthorized) {
+		t.Fatalf("unknown key error = %v, want ErrUnauthorized", err)
+	}
+	if _, err := as("r-key").Peek(ctx, 1); err != nil {
+		t.Fatalf("reader Peek: %v", err)
+	}
+	if _, err := as("r-key").Enqueue(ctx, client.EnqueueRequest{Ad: ad("a", "F", 1)}); !errors.Is(err, client.ErrForbidden) {
+		t.Fatalf("reader Enqueue error = %v, want ErrForbidden", err)
+	}
+	if _, err := as("o-key").Enqueue(ctx, client.EnqueueRequest{Ad: ad("a", "F", 1)}); err != nil {
+		t.Fatalf("operator Enqueue: %v", err)
+	}
+	if err := as("o-key").SetAntiStarvation(ctx, true); !errors.Is(err, client.ErrForbidden) {
+		t.Fatalf("operator settings error = %v, want ErrForbidden", err)
+	}
+	if _, err := as("o-key").Export(ctx); !errors.Is(err, client.ErrForbidden) {
+		t.Fatalf("operator export error = %v, want ErrForbidden", err)
+	}
+	if err := as("a-key").SetAntiStarvation(ctx, true); err != nil || !q.IsEnableAntiStarvation() {
+		t.Fatalf("admin settings: %v", err)
+	}
+}
+
+func TestClient_AdsAndEvents(t *testing.T) {
+	c, _ := newServer(t, testConfig)
+	ctx := context.Background()
+	for _, a := range []client.Ad{ad("dragon-1", "RPG", 1), ad("puzzle-1", "Puzzle", 2), ad("dragon-2", "RPG", 3)} {
+		if _, err := c.Enqueue(ctx, client.EnqueueRequest{Ad: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page, err := c.Ads(ctx, client.AdsQuery{Search: "Dragon", Limit: 1, Offset: 1})
+	if err != nil || page.Total != 2 || len(page.Ads) != 1 || page.Ads[0].Ad.AdID != "dragon-2" {
+		t.Fatalf("Ads = %+v, %v; want dragon-2 of 2", page, err)
+	}
+	var apiErr *client.APIError
+	if _, err := c.Ads(ctx, client.AdsQuery{Filter: "family ~ x"}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("bad filter error = %v, want a 400 APIError", err)
+	}
+
+	// Two events, then stop by returning an error from fn.
+	stop := errors.New("stop")
+	var events []client.DashboardEvent
+	err = c.Events(ctx, 250*time.Millisecond, func(ev client.DashboardEvent) error {
+		if events = append(events, ev); len(events) == 2 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) {
+		t.Fatalf("Events error = %v, want the error from fn", err)
+	}
+	if ev := events[0]; ev.Total != 3 || ev.Stats.Queued != 3 || len(ev.Distribution) != 3 || len(ev.Heads) != 3 {
+		t.Fatalf("event = %+v, want 3 ads on 3 levels", ev)
+	}
+
+	// Cancelling the context ends the stream cleanly.
+	cctx, cancel := context.WithCancel(ctx)
+	err = c.Events(cctx, 0, func(client.DashboardEvent) error { cancel(); return nil })
+	if err != nil {
+		t.Fatalf("Events after cancel = %v, want nil", err)
+	}
+}
+
+func TestClient_Command(t *testing.T) {
+	cfg := testConfig
+	cfg.EnableAntiStarvation = true
+	cfg.APIKeys = []config.APIKey{
+		{Name: "svc", Key: "o-key", Role: config.RoleOperator},
+		{Name: "ops", Key: "a-key", Role: config.RoleAdmin},
+	}
+	q := queue.NewFromConfig(cfg)
+	srv := httptest.NewServer((&httpapi.Handler{Q: q, Cfg: cfg}).Router())
+	defer srv.Close()
+	op := client.New(client.Config{BaseURL: srv.URL, APIKey: "o-key"})
+	ctx := context.Background()
+	for _, a := range []client.Ad{ad("r1", "RPG-Fantasy", 1), ad("r2", "RPG-Fantasy", 2), ad("p1", "Puzzle", 1)} {
+		if _, err := op.Enqueue(ctx, client.EnqueueRequest{Ad: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A change is previewed first and only runs when confirmed.
+	const text = "Change priority to urgent for all ads in the RPG-Fantasy family"
+	res, err := op.Command(ctx, text, false)
+	if err != nil || !res.Understood || res.Executed || res.Affected == nil || res.Affected.Count != 2 {
+		t.Fatalf("preview = %+v, %v; want 2 ads affected, nothing executed", res, err)
+	}
+	if res.Command.Action != "reprioritizeFamily" || res.Command.Family != "RPG-Fantasy" || res.Summary == "" {
+		t.Fatalf("parsed = %+v %q", res.Command, res.Summary)
+	}
+	if dist, _ := q.DistributionByPriority(); dist[0].Count != 0 {
+		t.Fatalf("preview changed the queue: %+v", dist)
+	}
+	if res, err = op.Command(ctx, text, true); err != nil || !res.Executed || res.Affected.Count != 2 || res.Affected.DryRun {
+		t.Fatalf("confirmed = %+v, %v", res, err)
+	}
+	if dist, _ := q.DistributionByPriority(); dist[0].Count != 2 {
+		t.Fatalf("urgent level = %+v, want the 2 RPG-Fantasy ads", dist[0])
+	}
+
+	// Queries run at once.
+	res, err = op.Command(ctx, "Show the next 2 ads", false)
+	var next []client.Ad
+	if err != nil || !res.Executed || json.Unmarshal(res.Result, &next) != nil || len(next) != 2 || next[0].GameFamily != "RPG-Fantasy" {
+		t.Fatalf("peek = %+v, %v", res, err)
+	}
+
+	if res, err = op.Command(ctx, "make it faster", true); err != nil || res.Understood || res.Executed || len(res.Examples) == 0 {
+		t.Fatalf("not understood = %+v, %v", res, err)
+	}
+
+	// Settings need the admin role, as through /settings.
+	if _, err := op.Command(ctx, "Enable starvation mode", true); !errors.Is(err, client.ErrForbidden) {
+		t.Fatalf("operator settings change error = %v, want ErrForbidden", err)
+	}
+	// Settings holds the values after the change.
+	admin := client.New(client.Config{BaseURL: srv.URL, APIKey: "a-key"})
+	if res, err = admin.Command(ctx, "Enable starvation mode", true); err != nil || !res.Executed || res.Settings.EnableAntiStarvation || q.IsEnableAntiStarvation() {
+		t.Fatalf("admin settings change = %+v, %v", res, err)
+	}
+}
+
+func TestClient_Guardrails(t *testing.T) {
+	cfg := testConfig
+	cfg.EnableAntiStarvation = true
+	cfg.Guardrails = config.Guardrails{MaxPercent: 50, OperatorSettings: []string{config.SettingAntiStarvation}}
+	cfg.APIKeys = []config.APIKey{
+		{Name: "svc", Key: "o-key", Role: config.RoleOperator},
+		{Name: "ops", Key: "a-key", Role: config.RoleAdmin},
+	}
+	q := queue.NewFromConfig(cfg)
+	srv := httptest.NewServer((&httpapi.Handler{Q: q, Cfg: cfg}).Router())
+	defer srv.Close()
+	op := client.New(client.Config{BaseURL: srv.URL, APIKey: "o-key"})
+	ctx := context.Background()
+	for _, a := range []client.Ad{ad("r1", "RPG", 1), ad("r2", "RPG", 2), ad("r3", "RPG", 1), ad("p1", "Puzzle", 1)} {
+		if _, err := op.Enqueue(ctx, client.EnqueueRequest{Ad: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Moving every ad is over 50%: refused with a token, nothing moved.
+	_, err := op.ReprioritizeAge(ctx, 0, client.ReprioritizeRequest{NewPriority: "urgent"})
+	var apiErr *client.APIError
+	if !errors.Is(err, client.ErrConfirmRequired) || !errors.As(err, &apiErr) || apiErr.ConfirmToken == "" {
+		t.Fatalf("large reprioritize error = %v, want ErrConfirmRequired with a token", err)
+	}
+	if dist, _ := q.DistributionByPriority(); dist[1].Count != 1 {
+		t.Fatalf("refused change moved ads: %+v", dist)
+	}
+	// The token only confirms the change it was issued for. Three of four
+	// ads is still over.
+	_, err = op.ReprioritizeFamily(client.WithConfirmToken(ctx, apiErr.ConfirmToken), "RPG", client.ReprioritizeRequest{NewPriority: "urgent"})
+	if !errors.Is(err, client.ErrConfirmRequired) || !errors.As(err, &apiErr) {
+		t.Fatalf("token used for another change = %v, want ErrConfirmRequired", err)
+	}
+	if _, err := op.ReprioritizeFamily(client.WithConfirmToken(ctx, apiErr.ConfirmToken), "RPG", client.ReprioritizeRequest{NewPriority: "urgent"}); err != nil {
+		t.Fatalf("confirmed reprioritize: %v", err)
+	}
+	if dist, _ := q.DistributionByPriority(); dist[0].Count != 3 {
+		t.Fatalf("urgent level = %+v, want the 3 RPG ads", dist[0])
+	}
+	// Small changes and dry runs need no token.
+	if res, err := op.BulkRemove(ctx, "family = Puzzle", true); err != nil || res.Count != 1 {
+		t.Fatalf("dry run = %+v, %v", res, err)
+	}
+
+	// Undo puts the RPG ads back, once.
+	if res, err := op.Undo(ctx); err != nil || res.Count != 3 {
+		t.Fatalf("Undo = %+v, %v; want 3 ads moved back", res, err)
+	}
+	if dist, _ := q.DistributionByPriority(); dist[0].Count != 0 || dist[1].Count != 1 || dist[2].Count != 3 {
+		t.Fatalf("after undo = %+v", dist)
+	}
+	if _, err := op.Undo(ctx); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("second Undo = %v, want ErrNotFound", err)
+	}
+
+	// /command previews hand out the token for the confirm.
+	const text = "Change priority to 3 for all ads in the RPG family"
+	res, err := op.Command(ctx, text, false)
+	if err != nil || res.ConfirmToken == "" || res.Affected.Count != 3 {
+		t.Fatalf("preview = %+v, %v; want 3 ads and a confirm token", res, err)
+	}
+	if _, err := op.Command(ctx, text, true); !errors.Is(err, client.ErrConfirmRequired) {
+		t.Fatalf("confirm without token = %v, want ErrConfirmRequired", err)
+	}
+	if res, err = op.Command(client.WithConfirmToken(ctx, res.ConfirmToken), text, true); err != nil || !res.Executed {
+		t.Fatalf("confirm with token = %+v, %v", res, err)
+	}
+
+	// Operators may turn anti-starvation on but not off.
+	if err := op.SetAntiStarvation(ctx, false); !errors.Is(err, client.ErrForbidden) {
+		t.Fatalf("operator turning anti-starvation off = %v, want ErrForbidden", err)
+	}
+	if err := op.SetAntiStarvation(ctx, true); err != nil {
+		t.Fatalf("operator turning anti-starvation on: %v", err)
+	}
+	if err := op.SetMaximumWait(ctx, 60); !errors.Is(err, client.ErrForbidden) {
+		t.Fatalf("operator maximum wait = %v, want ErrForbidden", err)
+	}
+	admin := client.New(client.Config{BaseURL: srv.URL, APIKey: "a-key"})
+	if err := admin.SetAntiStarvation(ctx, false); err != nil || q.IsEnableAntiStarvation() {
+		t.Fatalf("admin turning anti-starvation off: %v", err)
+	}
+}
+
+// A sharded queue serves the same API: seqs and lease IDs name one ad across
+// the shards and cross-shard changes are confirmed and undone as a whole.
+func TestClient_Sharded(t *testing.T) {
+	cfg := testConfig
+	cfg.Guardrails = config.Guardrails{MaxItems: 4}
+	q := queue.NewSharded(cfg, 4)
+	srv := httptest.NewServer((&httpapi.Handler{Q: q, Cfg: cfg}).Router())
+	defer srv.Close()
+	c := client.New(client.Config{BaseURL: srv.URL})
+	ctx := context.Background()
+
+	// Eight families over three levels; strict priority serves the 3s, then
+	// the 2s, then the 1s, each in enqueue order.
+	var want [3][]string
+	seqs := map[int64]bool{}
+	for i := range 8 {
+		id, prio := fmt.Sprintf("a%d", i), i%3+1
+		got, err := c.Enqueue(ctx, client.EnqueueRequest{Ad: ad(id, fmt.Sprintf("F%d", i), prio)})
+		if err != nil || seqs[got.Seq] {
+			t.Fatalf("Enqueue(%s) = %+v, %v; want a new seq", id, got, err)
+		}
+		seqs[got.Seq] = true
+		want[3-prio] = append(want[3-prio], id)
+	}
+	order := slices.Concat(want[0], want[1], want[2])
+	peek, err := c.Peek(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, a := range peek {
+		if a.AdID != order[i] {
+			t.Fatalf("peek = %v, want %v", peek, order)
+		}
+		if pos, err := c.Position(ctx, a.AdID); err != nil || pos.Position != i+1 || pos.Queued != 8 {
+			t.Fatalf("Position(%s) = %+v, %v; want %d of 8", a.AdID, pos, err, i+1)
+		}
+	}
+
+	// Leases end on the shard that handed them out.
+	l, err := c.DequeueLease(ctx, time.Minute)
+	if err != nil || l.Ad.AdID != order[0] {
+		t.Fatalf("DequeueLease = %+v, %v", l, err)
+	}
+	if leases, _ := c.Leases(ctx); len(leases) != 1 || leases[0].ID != l.ID {
+		t.Fatalf("Leases = %+v, want %d", leases, l.ID)
+	}
+	if err := c.Nack(ctx, l.ID); err != nil {
+		t.Fatalf("Nack: %v", err)
+	}
+	if l, err = c.DequeueLease(ctx, time.Minute); err != nil || l.Ad.AdID != order[0] {
+		t.Fatalf("DequeueLease after nack = %+v, %v", l, err)
+	}
+	if err := c.Ack(ctx, l.ID); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+
+	// Moving all seven is over the guardrails, though no one shard holds
+	// more than four.
+	_, err = c.ReprioritizeAge(ctx, 0, client.ReprioritizeRequest{NewPriority: "urgent"})
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.ConfirmToken == "" {
+		t.Fatalf("large reprioritize = %v, want a confirm token", err)
+	}
+	if _, err := c.ReprioritizeAge(client.WithConfirmToken(ctx, apiErr.ConfirmToken), 0, client.ReprioritizeRequest{NewPriority: "urgent"}); err != nil {
+		t.Fatalf("confirmed reprioritize: %v", err)
+	}
+	if res, err := c.Undo(ctx); err != nil || res.Count != 6 {
+		t.Fatalf("Undo = %+v, %v; want the 6 ads below urgent moved back", res, err)
+	}
+	if dist, _ := q.DistributionByPriority(); dist[0].Count != 1 || dist[1].Count != 3 || dist[2].Count != 3 {
+		t.Fatalf("after undo = %+v", dist)
+	}
+
+	// Purge and import round-trip the queue with its seqs.
+	before, err := c.Export(ctx)
+	if err != nil || len(before) != 7 {
+		t.Fatalf("Export = %d records, %v", len(before), err)
+	}
+	purged, err := c.Purge(ctx, client.PurgeRequest{Filter: "priority >= 1"})
+	if !errors.As(err, &apiErr) || apiErr.ConfirmToken == "" {
+		t.Fatalf("purge = %+v, %v; want a confirm token", purged, err)
+	}
+	sameAds := func(a, b client.Record) bool { return a.Seq == b.Seq && a.Ad.AdID == b.Ad.AdID }
+	if purged, err = c.Purge(client.WithConfirmToken(ctx, apiErr.ConfirmToken), client.PurgeRequest{Filter: "priority >= 1"}); err != nil || !slices.EqualFunc(purged.Records, before, sameAds) {
+		t.Fatalf("purge = %+v, %v; want the exported records", purged, err)
+	}
+	if res, err := c.Import(ctx, purged.Records, client.ImportOptions{KeepSeq: true}); err != nil || res.Imported != 7 {
+		t.Fatalf("Import = %+v, %v", res, err)
+	}
+	if after, _ := c.Export(ctx); !slices.EqualFunc(after, before, sameAds) {
+		t.Fatalf("export after import = %+v, want %+v", after, before)
+	}
+}
+
+// A token confirms the count it was issued for. If the change has grown by
+// the time it is confirmed, the queue refuses it and a new token is issued.
+func TestClient_ConfirmedChangeGrew(t *testing.T) {
+	cfg := testConfig
+	cfg.Guardrails = config.Guardrails{MaxItems: 1}
+	q := queue.NewFromConfig(cfg)
+	srv := httptest.NewServer((&httpapi.Handler{Q: q, Cfg: cfg}).Router())
+	defer srv.Close()
+	c := client.New(client.Config{BaseURL: srv.URL})
+	ctx := context.Background()
+	for _, a := range []client.Ad{ad("r1", "RPG", 1), ad("r2", "RPG", 1)} {
+		if _, err := c.Enqueue(ctx, client.EnqueueRequest{Ad: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var apiErr *client.APIError
+	if _, err := c.BulkRemove(ctx, "family = RPG", false); !errors.As(err, &apiErr) || apiErr.ConfirmToken == "" {
+		t.Fatalf("remove = %v, want a confirm token", err)
+	}
+	if _, err := c.Enqueue(ctx, client.EnqueueRequest{Ad: ad("r3", "RPG", 1)}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.BulkRemove(client.WithConfirmToken(ctx, apiErr.ConfirmToken), "family = RPG", false)
+	if !errors.Is(err, client.ErrConfirmRequired) || !errors.As(err, &apiErr) || apiErr.ConfirmToken == "" {
+		t.Fatalf("confirm after growth = %v, want ErrConfirmRequired with a new token", err)
+	}
+	if _, total := q.DistributionByPriority(); total != 3 {
+		t.Fatalf("refused remove changed the queue: %d left", total)
+	}
+	if res, err := c.BulkRemove(client.WithConfirmToken(ctx, apiErr.ConfirmToken), "family = RPG", false); err != nil || res.Count != 3 {
+		t.Fatalf("confirm with new token = %+v, %v", res, err)
+	}
+}
+
+func TestClient_RetriesWithBackoff(t *testing.T) {
+	var calls, unavailable atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		switch {
+		case r.URL.Path == "/bulk/remove":
+			http.Error(w, `{"error":"invalid filter"}`, http.StatusBadRequest)
+		case unavailable.Add(-1) >= 0:
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, `{"error":"draining"}`, http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	defer srv.Close()
+	retry := client.RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+	c := client.New(client.Config{BaseURL: srv.URL, Retry: retry})
+	ctx := context.Background()
+
+	unavailable.Store(2)
+	if _, err := c.Peek(ctx, 1); err != nil || calls.Load() != 3 {
+		t.Fatalf("Peek after two 503s: %v after %d calls", err, calls.Load())
+	}
+
+	calls.Store(0)
+	if _, err := c.BulkRemove(ctx, "x", false); err == nil || calls.Load() != 1 {
+		t.Fatalf("400 was retried: %d calls", calls.Load())
+	}
+
+	calls.Store(0)
+	unavailable.Store(5)
+	if _, err := c.Peek(ctx, 1); !errors.Is(err, client.ErrUnavailable) || calls.Load() != 3 {
+		t.Fatalf("exhausted retries: %v after %d calls", err, calls.Load())
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.Peek(cancelled, 1); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled context error = %v", err)
+	}
+}
+
+// An ack whose response is lost is retried and still succeeds.
+func TestClient_AckRetryAfterLostResponse(t *testing.T) {
+	q := queue.NewFromConfig(testConfig)
+	router := (&httpapi.Handler{Q: q, Cfg: testConfig}).Router()
+	var dropped atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/ack") && dropped.CompareAndSwap(false, true) {
+			router.ServeHTTP(httptest.NewRecorder(), r)
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		router.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	retry := client.RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+	c := client.New(client.Config{BaseURL: srv.URL, Retry: retry})
+	ctx := context.Background()
+
+	c.Enqueue(ctx, client.EnqueueRequest{Ad: ad("a", "F", 1)})
+	l, err := c.DequeueLease(ctx, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Ack(ctx, l.ID); err != nil || !dropped.Load() {
+		t.Fatalf("Ack after a lost response = %v (dropped %v)", err, dropped.Load())
+	}
+	if err := c.Nack(ctx, l.ID); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("Nack of an acked lease = %v, want ErrNotFound", err)
+	}
+}
+
+func TestWorkerPool_AckNackAndShutdown(t *testing.T) {
+	c, q := newServer(t, testConfig)
+	ctx, stop := context.WithCancel(context.Background())
+	for i := 0; i < 20; i++ {
+		if _, err := c.Enqueue(ctx, client.EnqueueRequest{Ad: ad(fmt.Sprint("ad-", i), "F", 1+i%3)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mu sync.Mutex
+	failOnce := map[string]bool{"ad-1": true, "ad-10": true, "ad-15": true}
+	attempts := make(map[string]int)
+	done := make(map[string]bool)
+	pool := &client.WorkerPool{
+		Client:  c,
+		Workers: 4,
+		Idle:    5 * time.Millisecond,
+		Handler: func(ctx context.Context, ad *client.Ad) error {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts[ad.AdID]++
+			// Failed and panicking handlers nack; the ads must come back.
+			if failOnce[ad.AdID] && attempts[ad.AdID] == 1 {
+				return errors.New("transient")
+			}
+			if ad.AdID == "ad-7" && attempts[ad.AdID] == 1 {
+				panic("boom")
+			}
+			done[ad.AdID] = true
+			if len(done) == 20 {
+				stop()
+			}
+			return nil
+		},
+	}
+	if err := pool.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 20 || len(q.Leases()) != 0 {
+		t.Fatalf("done %d, leases left %v", len(done), q.Leases())
+	}
+	if _, n := q.DistributionByPriority(); n != 0 {
+		t.Fatalf("%d ads still queued", n)
+	}
+	if attempts["ad-7"] != 2 || attempts["ad-10"] != 2 {
+		t.Fatalf("failed ads were not retried: %v", attempts)
+	}
+
+	// Shutdown waits for the handler in flight and acks its ad.
+	c.Enqueue(context.Background(), client.EnqueueRequest{Ad: ad("slow", "F", 1)})
+	ctx, stop = context.WithCancel(context.Background())
+	started := make(chan struct{})
+	pool.Handler = func(hctx context.Context, ad *client.Ad) error {
+		close(started)
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond)
+		return hctx.Err()
+	}
+	go func() { <-started; stop() }()
+	if err := pool.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, n := q.DistributionByPriority(); n != 0 || len(q.Leases()) != 0 {
+		t.Fatalf("in-flight ad was not acked: %d queued, leases %v", n, q.Leases())
+	}
+}
@@ -0,0 +1,328 @@
+package client
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Ad is an ad as the API sends and receives it.
+type Ad struct {
+	AdID           string   `json:"adId"`
+	Title          string   `json:"title"`
+	GameFamily     string   `json:"gameFamily"`
+	TargetAudience []string `json:"targetAudience"`
+	Priority       int      `json:"priority"`
+	CreatedAt      string   `json:"createdAt"`
+	MaxWaitTime    int      `json:"maxWaitTime"` // seconds
+}
+
+// Priority names a level either by number or by configured class name.
+type Priority string
+
+// Level is the Priority for level n.
+func Level(n int) Priority { return Priority(strconv.Itoa(n)) }
+
+type EnqueueRequest struct {
+	Ad Ad
+	// Class, if set, names the priority class and replaces Ad.Priority.
+	Class string
+	// EnqueueAt, if set, is used instead of the server's clock.
+	EnqueueAt *time.Time
+}
+
+// Queued is an ad as the server queued it, with the effective Priority and
+// the capped MaxWaitTime, and where it stands in dequeue order.
+type Queued struct {
+	Ad
+	QueuePosition
+}
+
+// QueuePosition is an estimate: higher priority arrivals, aging, boosts and
+// pauses all move it.
+type QueuePosition struct {
+	Seq       int64     `json:"seq"`
+	EnqueueAt time.Time `json:"enqueueAt"`
+	Position  int       `json:"position"` // 1 is next; 0 while paused
+	Paused    bool      `json:"paused,omitempty"`
+	Queued    int       `json:"queued"`
+	// DequeueRate is dequeues per second over the last few minutes.
+	DequeueRate float64 `json:"dequeueRate"`
+	// EstimatedStartAt is nil while paused or when nothing was dequeued
+	// recently.
+	EstimatedStartAt *time.Time `json:"estimatedStartAt,omitempty"`
+}
+
+// Lease is an ad handed out by DequeueLease. It returns to the queue unless
+// acked before ExpiresAt.
+type Lease struct {
+	Ad        Ad
+	ID        int64
+	ExpiresAt time.Time
+}
+
+// LeaseInfo describes an outstanding lease.
+type LeaseInfo struct {
+	ID        int64     `json:"leaseId"`
+	AdID      string    `json:"adId"`
+	ExpiresAt time.Time `json:"leaseExpiresAt"`
+}
+
+type Distribution struct {
+	Total                int            `json:"total"`
+	Levels               []PriorityDist `json:"distribution"`
+	EnableAntiStarvation bool           `json:"enable_anti_starvation"`
+	TotalPriority        int            `json:"total_priority"`
+}
+
+type PriorityDist struct {
+	Priority int     `json:"Priority"`
+	Name     string  `json:"Name,omitempty"`
+	Count    int     `json:"Count"`
+	Percent  float64 `json:"Percent"`
+}
+
+// HeadScore is one level head's anti-starvation score, from /debug/scores.
+type HeadScore struct {
+	Priority       int     `json:"priority"`
+	Name           string  `json:"name,omitempty"`
+	AdID           string  `json:"adId"`
+	WaitedSeconds  float64 `json:"waitedSeconds"`
+	MaxWaitSeconds int     `json:"maxWaitSeconds"`
+	Curve          string  `json:"curve"`
+	Eligible       bool    `json:"eligible"`
+	Score          float64 `json:"score"`
+	Selected       bool    `json:"selected"`
+}
+
+// ScoredAd is an ad in dequeue order with its scheduling state.
+type ScoredAd struct {
+	Ad            Ad        `json:"ad"`
+	EnqueueAt     time.Time `json:"enqueueAt"`
+	WaitedSeconds float64   `json:"waitedSeconds"`
+	Deadline      time.Time `json:"deadline"`
+	Eligible      bool      `json:"eligible"`
+	Score         float64   `json:"score"`
+}
+
+// Stats is a queue summary. Enqueued and Dequeued are totals since the
+// server started.
+type Stats struct {
+	Queued            int            `json:"queued"`
+	Leased            int            `json:"leased"`
+	Enqueued          uint64         `json:"enqueued"`
+	Dequeued          uint64         `json:"dequeued"`
+	OldestEnqueueAt   *time.Time     `json:"oldestEnqueueAt,omitempty"`
+	OldestWaitSeconds float64        `json:"oldestWaitSeconds"`
+	Families          map[string]int `json:"families"`
+}
+
+// AdsQuery selects a page of queued ads; all fields are optional.
+type AdsQuery struct {
+	Search string // substring of the ID, title or family, ignoring case
+	Filter string // filter expression like `priority >= 2 and age > 5m`
+	Offset int
+	Limit  int // server default 100, at most 1000
+}
+
+type AdsPage struct {
+	Total  int      `json:"total"` // matches, not just this page
+	Offset int      `json:"offset"`
+	Ads    []Record `json:"ads"`
+}
+
+// DashboardEvent is one update from Events.
+type DashboardEvent struct {
+	Time                 time.Time      `json:"time"`
+	Total                int            `json:"total"`
+	Distribution         []PriorityDist `json:"distribution"`
+	EnableAntiStarvation bool           `json:"enableAntiStarvation"`
+	Stats                Stats          `json:"stats"`
+	Heads                []HeadScore    `json:"heads"`
+}
+
+// ReprioritizeRequest moves ads to NewPriority, or by Delta levels when
+// NewPriority is empty.
+type ReprioritizeRequest struct {
+	NewPriority Priority
+	Delta       int
+}
+
+type BulkReprioritizeRequest struct {
+	// Filter expression like `family = RPG and age > 10m and priority = 1`.
+	Filter      string   `json:"filter"`
+	NewPriority Priority `json:"newPriority,omitempty"`
+	Delta       int      `json:"delta,omitempty"`
+	DryRun      bool     `json:"dryRun"`
+}
+
+type BulkResult struct {
+	DryRun bool     `json:"dryRun"`
+	Count  int      `json:"count"`
+	AdIDs  []string `json:"adIds"`
+	// Skipped ads stay where they are: their new level is at its class
+	// capacity.
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+type BoostRequest struct {
+	// Either a family or a filter expression selects the boosted ads.
+	Family   string
+	Filter   string
+	Delta    int
+	Duration time.Duration
+}
+
+type Boost struct {
+	ID        int64     `json:"id"`
+	Filter    string    `json:"filter"`
+	Delta     int       `json:"delta"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	AdIDs     []string  `json:"adIds"`
+	Skipped   []string  `json:"skipped,omitempty"` // as in BulkResult; from CreateBoost only
+}
+
+type Settings struct {
+	EnableAntiStarvation bool `json:"enableAntiStarvation"`
+	MaximumWait          int  `json:"maximumWait"` // seconds
+	TotalPriority        int  `json:"totalPriority"`
+}
+
+// CommandResult is the outcome of Command. When Understood is false the
+// text matched none of the known phrasings and Examples lists some that do.
+type CommandResult struct {
+	Understood bool           `json:"understood"`
+	Text       string         `json:"text"`
+	Command    *ParsedCommand `json:"command,omitempty"`
+	Summary    string         `json:"summary,omitempty"`
+	Executed   bool           `json:"executed"`
+	// Changes: the ads that move (or would, in a preview), or the settings,
+	// as they are in a preview and as changed once executed.
+	Affected *BulkResult `json:"affected,omitempty"`
+	Settings *Settings   `json:"settings,omitempty"`
+	// Set on a preview over the guardrails; confirm with WithConfirmToken.
+	ConfirmToken string `json:"confirmToken,omitempty"`
+	// Queries: what the matching GET route returns.
+	Result   json.RawMessage `json:"result,omitempty"`
+	Message  string          `json:"message,omitempty"`
+	Examples []string        `json:"examples,omitempty"`
+}
+
+// ParsedCommand is what the server understood a command as.
+type ParsedCommand struct {
+	Action      string   `json:"action"`
+	Family      string   `json:"family,omitempty"`
+	Age         string   `json:"age,omitempty"`
+	NewPriority Priority `json:"newPriority,omitempty"`
+	Delta       int      `json:"delta,omitempty"`
+	Enable      *bool    `json:"enable,omitempty"`
+	MaximumWait int      `json:"maximumWait,omitempty"`
+	N           int      `json:"n,omitempty"`
+}
+
+// PauseScope selects what Pause and Resume act on.
+type PauseScope struct {
+	Scope    string   `json:"scope"` // all, priority or family
+	Priority Priority `json:"priority,omitempty"`
+	Family   string   `json:"family,omitempty"`
+}
+
+// PauseAll, PausePriority and PauseFamily build the three scopes.
+func PauseAll() PauseScope                 { return PauseScope{Scope: "all"} }
+func PausePriority(p Priority) PauseScope  { return PauseScope{Scope: "priority", Priority: p} }
+func PauseFamily(family string) PauseScope { return PauseScope{Scope: "family", Family: family} }
+
+type PauseState struct {
+	All        bool     `json:"all"`
+	Priorities []int    `json:"priorities"`
+	Families   []string `json:"families"`
+}
+
+type DrainStatus struct {
+	Draining  bool `json:"draining"`
+	Remaining int  `json:"remaining"`
+	Drained   bool `json:"drained"`
+}
+
+// PurgeRequest selects the ads to purge; all given filters must match. The
+// server refuses a request without filters unless All is set.
+type PurgeRequest struct {
+	Priority  Priority
+	Family    string
+	OlderThan time.Duration
+	Filter    string
+	All       bool // the whole queue; only without filters
+	// ExportToFile leaves the export in the server's purgeExportDir instead
+	// of returning the purged records.
+	ExportToFile bool
+}
+
+type PurgeResult struct {
+	Count      int
+	ExportFile string   // with ExportToFile
+	Records    []Record // otherwise
+}
+
+// Record is a queued ad with what is needed to put it back at the same
+// position, as used by export, import and purge.
+type Record struct {
+	Ad        *Ad       `json:"ad"`
+	EnqueueAt time.Time `json:"enqueueAt"`
+	Seq       int64     `json:"seq"`
+	Attempts  int       `json:"attempts,omitempty"` // earlier dequeues or leases
+}
+
+type ImportOptions struct {
+	KeepSeq        bool // reuse recorded seqs where they do not collide
+	SkipDuplicates bool // skip ads whose AdID is already queued
+}
+
+type ImportResult struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+	Rejected int `json:"rejected"`
+}
+
+// ClusterStatus is a Raft node's view of the cluster.
+type ClusterStatus struct {
+	ID            string                `json:"id"`
+	Role          string                `json:"role"`
+	Term          uint64                `json:"term"`
+	Leader        string                `json:"leader,omitempty"`
+	CommitIndex   uint64                `json:"commitIndex"`
+	AppliedIndex  uint64                `json:"appliedIndex"`
+	LastIndex     uint64                `json:"lastIndex"`
+	SnapshotIndex uint64                `json:"snapshotIndex"`
+	Servers       []ClusterMember       `json:"servers"`
+	Peers         map[string]PeerStatus `json:"peers,omitempty"`
+}
+
+type ClusterMember struct {
+	ID   string `json:"id"`
+	Addr string `json:"addr"`
+}
+
+type PeerStatus struct {
+	MatchIndex uint64    `json:"matchIndex"`
+	LastAck    time.Time `json:"lastAck"`
+}
+
+// ReplicationStatus is a leader's or follower's replication state.
+type ReplicationStatus struct {
+	ID           string                    `json:"id,omitempty"`
+	Role         string                    `json:"role"`
+	AppliedIndex uint64                    `json:"appliedIndex"`
+	LeaderURL    string                    `json:"leaderUrl,omitempty"`
+	LeaderIndex  uint64                    `json:"leaderIndex,omitempty"`
+	LagEntries   uint64                    `json:"lagEntries"`
+	LagSeconds   float64                   `json:"lagSeconds"`
+	LastContact  *time.Time                `json:"lastContact,omitempty"`
+	LastError    string                    `json:"lastError,omitempty"`
+	Followers    map[string]FollowerStatus `json:"followers,omitempty"`
+}
+
+type FollowerStatus struct {
+	AppliedIndex uint64    `json:"appliedIndex"`
+	LagEntries   uint64    `json:"lagEntries"`
+	LastSeen     time.Time `json:"lastSeen"`
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// WorkerPool runs Handler for each dequeued ad. Ads are taken on lease and
+// acked when Handler returns nil; on an error or panic they are nacked and
+// go back to their old position. An ad whose worker dies without either
+// returns when its lease runs out.
+type WorkerPool struct {
+	Client  *Client
+	Handler func(ctx context.Context, ad *Ad) error
+
+	Workers int           // concurrent handlers, default 1
+	Lease   time.Duration // per ad, default 30s; keep it above Handler's run time
+	Idle    time.Duration // wait after an empty queue or a failed dequeue, default 1s
+	// ShutdownTimeout bounds how long handlers already running may take once
+	// Run's context is done; after it their contexts are cancelled. 0 waits
+	// for them.
+	ShutdownTimeout time.Duration
+	// OnError, if set, receives every dequeue, handler, ack and nack error.
+	// ad is nil for dequeue errors.
+	OnError func(ad *Ad, err error)
+}
+
+// Run starts the workers and blocks until ctx is done and every handler in
+// flight has finished and been acked or nacked. It stops taking new ads as
+// soon as ctx is done.
+func (p *WorkerPool) Run(ctx context.Context) error {
+	if p.Client == nil || p.Handler == nil {
+		return errors.New("client: WorkerPool needs Client and Handler")
+	}
+	workers, lease, idle := p.Workers, p.Lease, p.Idle
+	if workers <= 0 {
+		workers = 1
+	}
+	if lease <= 0 {
+		lease = 30 * time.Second
+	}
+	if idle <= 0 {
+		idle = time.Second
+	}
+
+	// Handlers outlive ctx so a shutdown lets them finish.
+	handlerCtx, cancelHandlers := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelHandlers()
+	if p.ShutdownTimeout > 0 {
+		stop := context.AfterFunc(ctx, func() {
+			time.AfterFunc(p.ShutdownTimeout, cancelHandlers)
+		})
+		defer stop()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				l, err := p.Client.DequeueLease(ctx, lease)
+				if err != nil {
+					if !errors.Is(err, ErrQueueEmpty) && ctx.Err() == nil {
+						p.report(nil, err)
+					}
+					_ = sleep(ctx, idle)
+					continue
+				}
+				p.process(handlerCtx, l)
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// process runs the handler for one lease and settles it. Acks and nacks use
+// their own deadline so they still go out during shutdown.
+func (p *WorkerPool) process(ctx context.Context, l *Lease) {
+	err := p.handle(ctx, &l.Ad)
+	settleCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err != nil {
+		p.report(&l.Ad, err)
+		if err := p.Client.Nack(settleCtx, l.ID); err != nil {
+			p.report(&l.Ad, fmt.Errorf("nack lease %d: %w", l.ID, err))
+		}
+		return
+	}
+	if err := p.Client.Ack(settleCtx, l.ID); err != nil {
+		p.report(&l.Ad, fmt.Errorf("ack lease %d: %w", l.ID, err))
+	}
+}
+
+func (p *WorkerPool) handle(ctx context.Context, ad *Ad) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panic: %v", r)
+		}
+	}()
+	return p.Handler(ctx, ad)
+}
+
+func (p *WorkerPool) report(ad *Ad, err error) {
+	if p.OnError != nil {
+		p.OnError(ad, err)
+	}
+}